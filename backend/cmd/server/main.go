@@ -1,18 +1,74 @@
 package main
 
 import (
+	"context"
+	"fishyinhe/backend/internal/adb"
 	"fishyinhe/backend/internal/api" // 确保此导入路径与您的 go.mod 模块名一致
+	"fishyinhe/backend/internal/api/handler"
+	"fishyinhe/backend/internal/apkrepo"
+	"fishyinhe/backend/internal/config"
+	"fishyinhe/backend/internal/thumbnail"
+	"fishyinhe/backend/internal/upload"
 	"log"
 )
 
 func main() {
 	log.Println("Starting backend server on port 5679...")
 
+	cfg, err := config.Load("config.yaml")
+	if err != nil {
+		log.Fatalf("Failed to load config: %v", err)
+	}
+	adb.SetTimeouts(cfg.ADB.Timeouts)
+
+	// 所有设备操作都通过 adb server 的智能套接字协议完成
+	client := adb.NewClient(cfg.ADB.ServerAddr)
+	// 短 shell 命令复用每台设备上的常驻会话
+	pool := adb.NewShellPool(client, cfg.ADB.ShellSessions)
+	// 限制每台设备的并发命令数，避免截图、输入与文件传输同时压垮设备
+	scheduler := adb.NewScheduler(pool, cfg.ADB.Concurrency)
+
+	// 通过 host:track-devices 在后台维护设备注册表
+	tracker := adb.NewTracker(client)
+	go tracker.Run(context.Background())
+	go pool.CloseOnDisconnect(context.Background(), tracker)
+
+	// 重新连接之前记住的无线调试设备
+	wireless, err := adb.LoadWirelessRegistry(cfg.ADB.WirelessStore)
+	if err != nil {
+		log.Fatalf("Failed to load wireless devices: %v", err)
+	}
+	go wireless.ReconnectAll(context.Background(), client)
+
+	// 恢复上次未完成的分块上传，并定期清理长时间没有进展的上传
+	uploads := upload.NewStore(cfg.Files.UploadDir)
+	if err := uploads.Load(); err != nil {
+		log.Fatalf("Failed to load uploads: %v", err)
+	}
+	go uploads.RunCleanup(context.Background(), cfg.Files.UploadExpiry)
+	thumbnails := thumbnail.NewCache(cfg.Files.ThumbnailDir, cfg.Files.ThumbnailCacheMB<<20)
+	if err := thumbnails.Load(); err != nil {
+		log.Printf("Failed to load cached thumbnails: %v", err)
+	}
+	// 读取 APK 库，并按保留规则定期删除旧版本
+	apks := apkrepo.New(cfg.APK.LibraryDir, cfg.APK.Retention)
+	if err := apks.Load(); err != nil {
+		log.Fatalf("Failed to load APK library: %v", err)
+	}
+	go apks.RunPrune(context.Background())
+
 	// 调用 SetupRouter 并将其返回的 Gin 引擎赋值给 router 变量
-	router := api.SetupRouter() // 正确的调用方式
+	router := api.SetupRouter(scheduler, tracker, wireless, handler.Options{
+		ArchiveMaxBytes: cfg.Files.ArchiveMaxSizeMB << 20,
+		TextMaxBytes:    cfg.Files.TextMaxSizeKB << 10,
+		Uploads:         uploads,
+		Thumbnails:      thumbnails,
+		APKLibrary:      apks,
+		SyncRoot:        cfg.Files.SyncRoot,
+	})
 
 	// 使用获取到的 router 启动 HTTP 服务
-	err := router.Run(":5679")
+	err = router.Run(":5679")
 	if err != nil {
 		log.Fatalf("Failed to start server: %v", err)
 	}
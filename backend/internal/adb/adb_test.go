@@ -0,0 +1,40 @@
+package adb_test
+
+import (
+	"bytes"
+	"context"
+	"fishyinhe/backend/internal/adb"
+	"fishyinhe/backend/internal/adb/adbtest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestInstallAPKWithProgress(t *testing.T) {
+	f := adbtest.NewFake()
+	f.AddCannedDevice("emu-1")
+	local := filepath.Join(t.TempDir(), "app.apk")
+	data := bytes.Repeat([]byte("apk!"), 100000)
+	if err := os.WriteFile(local, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var reports [][2]int64
+	_, err := adb.InstallAPKWithProgress(context.Background(), f, "emu-1", local, func(sent int64, total int64) {
+		reports = append(reports, [2]int64{sent, total})
+	})
+	if err != nil {
+		t.Fatalf("InstallAPKWithProgress: %v", err)
+	}
+	if len(reports) == 0 {
+		t.Fatal("no progress was reported")
+	}
+	for i, r := range reports {
+		if r[1] != int64(len(data)) || (i > 0 && r[0] < reports[i-1][0]) {
+			t.Errorf("report %d = %v, want a non-decreasing sent count out of %d", i, r, len(data))
+		}
+	}
+	if last := reports[len(reports)-1]; last[0] != int64(len(data)) {
+		t.Errorf("last report = %v, want all %d bytes sent", last, len(data))
+	}
+}
@@ -0,0 +1,462 @@
+package adbtest
+
+import (
+	"crypto/md5"
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// splitWords 按 sh 的规则拆分 adb.ShellCommand 生成的命令，支持单引号、双引号与反斜杠转义
+// 未加引号的 | 作为单独的词返回，便于识别管道
+func splitWords(command string) []string {
+	var words []string
+	var b strings.Builder
+	inWord := false
+	for i := 0; i < len(command); i++ {
+		c := command[i]
+		switch {
+		case c == '\'':
+			inWord = true
+			end := strings.IndexByte(command[i+1:], '\'')
+			if end < 0 {
+				end = len(command) - i - 1
+			}
+			b.WriteString(command[i+1 : i+1+end])
+			i += end + 1
+		case c == '"':
+			inWord = true
+			for i++; i < len(command) && command[i] != '"'; i++ {
+				if command[i] == '\\' && i+1 < len(command) {
+					i++
+				}
+				b.WriteByte(command[i])
+			}
+		case c == '\\' && i+1 < len(command):
+			inWord = true
+			i++
+			b.WriteByte(command[i])
+		case c == ' ' || c == '\t' || c == '\n' || c == '|':
+			if inWord {
+				words = append(words, b.String())
+				b.Reset()
+				inWord = false
+			}
+			if c == '|' {
+				words = append(words, "|")
+			}
+		default:
+			inWord = true
+			b.WriteByte(c)
+		}
+	}
+	if inWord {
+		words = append(words, b.String())
+	}
+	return words
+}
+
+// builtin 在没有编排响应时模拟少量基于假文件系统的命令:
+//
+//	tail -c +N <path> [| head -c M]   读取文件的一段 (adb.StreamFile 的范围请求)
+//	ls -la [--full-time] <dir>        按 Files 列出目录，目录由文件路径隐含
+//	find <root>/ ... -exec ls -ld ...  按 Files 搜索 (adb.SearchFiles)
+//	mkdir -p、rm [-rf]、mv -f、cp -Rf、chmod [-R]、touch [-d 时间]   修改 Files (adb 包的文件操作)
+//	cat <path>、stat -c '%f %s %Y' <path>、stat -c %s <path>、true
+//	run-as <pkg> [sh -c] <command>    按同样的规则执行 command (可以用 && 连接)，不检查应用是否可调试
+func (s *DeviceSet) builtin(d *Device, command string) (Response, bool) {
+	words := splitWords(command)
+	if len(words) >= 3 && words[0] == "run-as" {
+		inner := strings.Join(words[2:], " ")
+		if len(words) == 5 && words[2] == "sh" && words[3] == "-c" {
+			inner = words[4]
+		}
+		var out Response
+		for _, part := range strings.Split(inner, " && ") {
+			resp := s.Response(d, part)
+			out.Stdout += resp.Stdout
+			out.Stderr += resp.Stderr
+			out.ExitCode = resp.ExitCode
+			if resp.ExitCode != 0 {
+				break
+			}
+		}
+		return out, true
+	}
+	if len(words) == 1 && words[0] == "true" {
+		return Response{}, true
+	}
+	if len(words) == 2 && words[0] == "cat" {
+		f := s.File(d, words[1])
+		if f == nil {
+			return Response{Stderr: "cat: " + words[1] + ": No such file or directory\n", ExitCode: 1}, true
+		}
+		return Response{Stdout: string(f.Data)}, true
+	}
+	if len(words) == 4 && words[0] == "stat" && words[1] == "-c" && words[2] == "%f %s %Y" {
+		f := s.StatFile(d, words[3])
+		if f == nil {
+			return Response{Stderr: "stat: '" + words[3] + "': No such file or directory\n", ExitCode: 1}, true
+		}
+		return Response{Stdout: fmt.Sprintf("%x %d %d\n", f.Mode, len(f.Data), f.ModTime.Unix())}, true
+	}
+	if len(words) == 4 && words[0] == "stat" && words[1] == "-c" && words[2] == "%s" {
+		f := s.StatFile(d, words[3])
+		if f == nil {
+			return Response{Stderr: "stat: '" + words[3] + "': No such file or directory\n", ExitCode: 1}, true
+		}
+		return Response{Stdout: fmt.Sprintf("%d\n", len(f.Data))}, true
+	}
+	if len(words) >= 3 && words[0] == "find" {
+		return s.find(d, words)
+	}
+	if len(words) >= 2 {
+		switch words[0] {
+		case "mkdir", "rm", "mv", "cp", "touch", "chmod":
+			return s.fileOp(d, words), true
+		}
+	}
+	if len(words) >= 2 && words[0] == "md5sum" {
+		return s.md5sum(d, words[1:]), true
+	}
+	if len(words) >= 3 && words[0] == "ls" && words[1] == "-la" {
+		return s.listDir(d, words[len(words)-1]), true
+	}
+	if len(words) >= 4 && words[0] == "tail" && words[1] == "-c" && strings.HasPrefix(words[2], "+") {
+		start, err := strconv.Atoi(words[2][1:])
+		if err != nil || start < 1 {
+			return Response{}, false
+		}
+		f := s.File(d, words[3])
+		if f == nil {
+			return Response{Stderr: "tail: " + words[3] + ": No such file or directory\n", ExitCode: 1}, true
+		}
+		data := f.Data[min(start-1, len(f.Data)):]
+		if len(words) == 8 && words[4] == "|" && words[5] == "head" && words[6] == "-c" {
+			n, err := strconv.Atoi(words[7])
+			if err != nil {
+				return Response{}, false
+			}
+			data = data[:min(n, len(data))]
+		}
+		return Response{Stdout: string(data)}, true
+	}
+	return Response{}, false
+}
+
+// md5sum 按 toybox md5sum 的格式输出文件的 MD5，无法读取的文件在 stderr 中报告
+func (s *DeviceSet) md5sum(d *Device, paths []string) Response {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var resp Response
+	for _, p := range paths {
+		f, ok := d.Files[p]
+		if !ok || f.Mode&0170000 == 0040000 {
+			resp.Stderr += "md5sum: " + p + ": No such file or directory\n"
+			resp.ExitCode = 1
+			continue
+		}
+		resp.Stdout += fmt.Sprintf("%x  %s\n", md5.Sum(f.Data), p)
+	}
+	return resp
+}
+
+// listDir 以 toybox "ls -la --full-time" 的格式列出 dir 下的文件与隐含的子目录
+func (s *DeviceSet) listDir(d *Device, dir string) Response {
+	dir = strings.TrimSuffix(dir, "/") + "/"
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	type entry struct {
+		isDir bool
+		file  *File
+	}
+	entries := make(map[string]entry)
+	for p, f := range d.Files {
+		rest, ok := strings.CutPrefix(p, dir)
+		if !ok || rest == "" {
+			continue
+		}
+		name, _, isDir := strings.Cut(rest, "/")
+		if isDir || f.Mode&0170000 == 0040000 {
+			entries[name] = entry{isDir: true}
+		} else if _, seen := entries[name]; !seen {
+			entries[name] = entry{file: f}
+		}
+	}
+	if len(entries) == 0 {
+		f, ok := d.Files[strings.TrimSuffix(dir, "/")]
+		if ok && f.Mode&0170000 != 0040000 {
+			return Response{Stderr: "ls: " + dir + ": Not a directory\n", ExitCode: 1}
+		}
+		if !ok && dir != "/" {
+			return Response{Stderr: "ls: " + dir + ": No such file or directory\n", ExitCode: 1}
+		}
+	}
+	names := make([]string, 0, len(entries))
+	for name := range entries {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var b strings.Builder
+	fmt.Fprintf(&b, "total %d\n", len(names))
+	for _, name := range names {
+		e := entries[name]
+		b.WriteString(lsLine(name, e.isDir, e.file))
+	}
+	return Response{Stdout: b.String()}
+}
+
+// lsLine 以 "ls -l --full-time" 的格式输出一个条目，隐含的目录没有 File
+func lsLine(name string, isDir bool, f *File) string {
+	const stamp = "2006-01-02 15:04:05.000000000 -0700"
+	if isDir {
+		return fmt.Sprintf("drwxrwx--x 2 root sdcard_rw 3488 %s %s\n", "2024-05-13 10:00:00.000000000 +0000", name)
+	}
+	return fmt.Sprintf("%s 1 root sdcard_rw %d %s %s\n", lsMode(f.Mode), len(f.Data), f.ModTime.Format(stamp), name)
+}
+
+// find 模拟 adb.SearchFiles 使用的 "find <root>/ -mindepth 1 [谓词...] -exec ls -ld --full-time {} +"
+// 支持 -maxdepth、-type f|d、-iname、-size ±Nc 与 -mmin -N，结果按路径排序
+func (s *DeviceSet) find(d *Device, words []string) (Response, bool) {
+	root := strings.TrimSuffix(words[1], "/")
+	maxDepth := -1
+	var matchers []func(p string, isDir bool, f *File) bool
+	i := 2
+	for ; i+1 < len(words) && words[i] != "-exec"; i += 2 {
+		arg := words[i+1]
+		switch words[i] {
+		case "-mindepth":
+		case "-maxdepth":
+			maxDepth, _ = strconv.Atoi(arg)
+		case "-type":
+			matchers = append(matchers, func(p string, isDir bool, f *File) bool { return isDir == (arg == "d") })
+		case "-iname":
+			matchers = append(matchers, func(p string, isDir bool, f *File) bool {
+				ok, _ := path.Match(strings.ToLower(arg), strings.ToLower(path.Base(p)))
+				return ok
+			})
+		case "-size":
+			n, err := strconv.Atoi(strings.TrimSuffix(arg[1:], "c"))
+			if err != nil {
+				return Response{}, false
+			}
+			greater := arg[0] == '+'
+			matchers = append(matchers, func(p string, isDir bool, f *File) bool {
+				size := 0
+				if f != nil {
+					size = len(f.Data)
+				}
+				return greater && size > n || !greater && size < n
+			})
+		case "-mmin":
+			n, err := strconv.Atoi(strings.TrimPrefix(arg, "-"))
+			if err != nil || !strings.HasPrefix(arg, "-") {
+				return Response{}, false
+			}
+			since := time.Now().Add(-time.Duration(n) * time.Minute)
+			matchers = append(matchers, func(p string, isDir bool, f *File) bool {
+				// 隐含的目录使用 lsLine 中 2024 年的固定时间，视为很久以前修改
+				return f != nil && f.ModTime.After(since)
+			})
+		default:
+			return Response{}, false
+		}
+	}
+	if i >= len(words) {
+		return Response{}, false
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entries := make(map[string]*File)
+	for name, f := range d.Files {
+		rest, ok := strings.CutPrefix(name, root+"/")
+		if !ok {
+			continue
+		}
+		entries[name] = f
+		// 文件路径隐含的各级目录
+		for j := strings.LastIndex(rest, "/"); j > 0; j = strings.LastIndex(rest[:j], "/") {
+			if _, seen := entries[root+"/"+rest[:j]]; !seen {
+				entries[root+"/"+rest[:j]] = nil
+			}
+		}
+	}
+	names := make([]string, 0, len(entries))
+	for name := range entries {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var b strings.Builder
+	for _, name := range names {
+		f := entries[name]
+		isDir := f == nil || f.Mode&0170000 == 0040000
+		if maxDepth > 0 && strings.Count(strings.TrimPrefix(name, root+"/"), "/")+1 > maxDepth {
+			continue
+		}
+		matched := true
+		for _, m := range matchers {
+			matched = matched && m(name, isDir, f)
+		}
+		if matched {
+			b.WriteString(lsLine(name, isDir, f))
+		}
+	}
+	return Response{Stdout: b.String()}, true
+}
+
+// lsMode 将 st_mode 的权限位格式化为 ls 的 -rw-r--r-- 形式
+func lsMode(mode uint32) string {
+	const rwx = "rwxrwxrwx"
+	b := []byte("----------")
+	for i := 0; i < 9; i++ {
+		if mode&(1<<uint(8-i)) != 0 {
+			b[i+1] = rwx[i]
+		}
+	}
+	return string(b)
+}
+
+// isDirLocked 报告 p 是否是目录: 显式的目录条目，或者是其他文件路径的前缀
+func isDirLocked(d *Device, p string) bool {
+	if f, ok := d.Files[p]; ok {
+		return f.Mode&0170000 == 0040000
+	}
+	for name := range d.Files {
+		if strings.HasPrefix(name, p+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// treeLocked 返回 p 本身及其下的全部条目
+func treeLocked(d *Device, p string) []string {
+	var names []string
+	for name := range d.Files {
+		if name == p || strings.HasPrefix(name, p+"/") {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// fileOp 按 toybox 的行为在 Files 上执行 mkdir、rm、mv、cp、chmod 与 touch，错误信息的格式与设备一致
+// chmod 只支持八进制权限，符号形式只检查路径是否存在
+func (s *DeviceSet) fileOp(d *Device, words []string) Response {
+	name := words[0]
+	var flags string
+	args := words[1:]
+	for len(args) > 0 && strings.HasPrefix(args[0], "-") {
+		flags += args[0][1:]
+		args = args[1:]
+	}
+	var mode string
+	if name == "chmod" && len(args) > 0 {
+		mode, args = args[0], args[1:]
+	}
+	var stamp time.Time
+	if name == "touch" && strings.Contains(flags, "d") && len(args) > 0 {
+		t, err := time.Parse("2006-01-02T15:04:05Z", args[0])
+		if err != nil {
+			return Response{Stderr: "touch: bad date '" + args[0] + "'\n", ExitCode: 1}
+		}
+		stamp, args = t, args[1:]
+	}
+	if len(args) == 0 {
+		return Response{Stderr: name + ": Needs 1 argument\n", ExitCode: 1}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	fail := func(format string, a ...any) Response {
+		return Response{Stderr: fmt.Sprintf(name+": "+format+"\n", a...), ExitCode: 1}
+	}
+	switch name {
+	case "mkdir":
+		for _, p := range args {
+			if f, ok := d.Files[p]; ok && f.Mode&0170000 != 0040000 {
+				return fail("%s: File exists", p)
+			}
+			if !isDirLocked(d, p) {
+				d.Files[p] = &File{Mode: 0040775, ModTime: now}
+			}
+		}
+	case "rm":
+		for _, p := range args {
+			names := treeLocked(d, p)
+			if len(names) == 0 && strings.Contains(flags, "f") {
+				continue
+			}
+			if len(names) == 0 {
+				return fail("%s: No such file or directory", p)
+			}
+			if isDirLocked(d, p) && !strings.ContainsAny(flags, "rR") {
+				return fail("%s: Is a directory", p)
+			}
+			for _, n := range names {
+				delete(d.Files, n)
+			}
+		}
+	case "mv", "cp":
+		if len(args) != 2 {
+			return fail("Needs 2 arguments")
+		}
+		src, dst := args[0], args[1]
+		names := treeLocked(d, src)
+		if len(names) == 0 {
+			return fail("%s: No such file or directory", src)
+		}
+		if isDirLocked(d, src) && name == "cp" && !strings.ContainsAny(flags, "rR") {
+			return fail("%s: Is a directory", src)
+		}
+		if isDirLocked(d, dst) {
+			dst += "/" + src[strings.LastIndex(src, "/")+1:]
+		}
+		for _, n := range names {
+			f := *d.Files[n]
+			f.Data = append([]byte(nil), f.Data...)
+			if name == "mv" {
+				delete(d.Files, n)
+			} else {
+				f.ModTime = now
+			}
+			d.Files[dst+strings.TrimPrefix(n, src)] = &f
+		}
+	case "chmod":
+		for _, p := range args {
+			names := treeLocked(d, p)
+			if len(names) == 0 {
+				return fail("%s: No such file or directory", p)
+			}
+			perm, err := strconv.ParseUint(mode, 8, 32)
+			if err != nil {
+				continue
+			}
+			if !strings.Contains(flags, "R") {
+				names = []string{p}
+			}
+			for _, n := range names {
+				if f, ok := d.Files[n]; ok {
+					f.Mode = f.Mode&^07777 | uint32(perm)
+				}
+			}
+		}
+	case "touch":
+		if stamp.IsZero() {
+			stamp = now
+		}
+		for _, p := range args {
+			if f, ok := d.Files[p]; ok {
+				f.ModTime = stamp
+			} else if !isDirLocked(d, p) {
+				d.Files[p] = &File{Mode: 0100644, ModTime: stamp}
+			}
+		}
+	}
+	return Response{}
+}
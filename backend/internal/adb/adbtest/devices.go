@@ -0,0 +1,318 @@
+package adbtest
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Response 是假设备对某条 shell/exec 命令的预设响应
+type Response struct {
+	Stdout   string
+	Stderr   string
+	ExitCode int
+	Delay    time.Duration // 模拟命令耗时，用于测试超时与取消
+}
+
+// File 是假设备文件系统中的一个文件
+type File struct {
+	Data    []byte
+	Mode    uint32 // 完整的 st_mode，例如 0100644
+	ModTime time.Time
+}
+
+// Device 是假 server 上挂载的一台设备
+type Device struct {
+	Serial   string
+	State    string // device, offline, unauthorized ...
+	Attrs    string // devices -l 输出中状态之后的部分，例如 "product:sdk model:Pixel"
+	Commands map[string]Response
+	Files    map[string]*File
+	// Handler 在 Commands 中没有精确匹配时被调用，用于编排参数可变的命令 (例如 input tap x y)
+	Handler func(command string) (Response, bool)
+	// WifiIP 是设备在局域网中的地址，执行 tcpip 后可以通过 <WifiIP>:<TCPPort> 连接
+	WifiIP  string
+	TCPPort int
+}
+
+// DeviceSet 保存假设备及其脚本，由 Server 与 Fake 共享
+type DeviceSet struct {
+	mu       sync.Mutex
+	devices  map[string]*Device
+	order    []string
+	watchers map[chan struct{}]struct{}
+	pairings map[string]string
+}
+
+// NewDeviceSet 创建一个空的设备集合
+func NewDeviceSet() *DeviceSet {
+	return &DeviceSet{
+		devices:  make(map[string]*Device),
+		watchers: make(map[chan struct{}]struct{}),
+		pairings: make(map[string]string),
+	}
+}
+
+// Watch 返回一个在设备增减或状态变化时收到通知的通道，调用返回的函数停止监听
+func (s *DeviceSet) Watch() (<-chan struct{}, func()) {
+	ch := make(chan struct{}, 1)
+	s.mu.Lock()
+	s.watchers[ch] = struct{}{}
+	s.mu.Unlock()
+	return ch, func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		delete(s.watchers, ch)
+	}
+}
+
+// notifyLocked 通知所有监听者，调用时必须持有 s.mu
+func (s *DeviceSet) notifyLocked() {
+	for ch := range s.watchers {
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// AddDevice 挂载一台设备并返回它，调用者可以继续设置 Commands、Files 与 Handler
+func (s *DeviceSet) AddDevice(serial string, state string) *Device {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	d := &Device{
+		Serial:   serial,
+		State:    state,
+		Commands: make(map[string]Response),
+		Files:    make(map[string]*File),
+	}
+	if _, ok := s.devices[serial]; !ok {
+		s.order = append(s.order, serial)
+	}
+	s.devices[serial] = d
+	s.notifyLocked()
+	return d
+}
+
+// RemoveDevice 卸载一台设备
+func (s *DeviceSet) RemoveDevice(serial string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.devices, serial)
+	for i, id := range s.order {
+		if id == serial {
+			s.order = append(s.order[:i], s.order[i+1:]...)
+			break
+		}
+	}
+	s.notifyLocked()
+}
+
+// SetState 修改设备状态，例如模拟设备变为 offline
+func (s *DeviceSet) SetState(serial string, state string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if d, ok := s.devices[serial]; ok {
+		d.State = state
+		s.notifyLocked()
+	}
+}
+
+// HandleShell 为设备设置一条命令的预设响应
+func (s *DeviceSet) HandleShell(serial string, command string, resp Response) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if d, ok := s.devices[serial]; ok {
+		d.Commands[command] = resp
+	}
+}
+
+// PutFile 在设备的假文件系统中写入一个普通文件
+func (s *DeviceSet) PutFile(serial string, path string, data []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if d, ok := s.devices[serial]; ok {
+		d.Files[path] = &File{Data: data, Mode: 0100644, ModTime: time.Now()}
+	}
+}
+
+// ReadFile 读取设备假文件系统中的文件内容，通常用于检查 push 的结果
+func (s *DeviceSet) ReadFile(serial string, path string) ([]byte, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	d, ok := s.devices[serial]
+	if !ok {
+		return nil, false
+	}
+	f, ok := d.Files[path]
+	if !ok {
+		return nil, false
+	}
+	return append([]byte(nil), f.Data...), true
+}
+
+// DeviceList 按 "adb devices" / "adb devices -l" 的格式输出设备列表
+func (s *DeviceSet) DeviceList(long bool) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var b strings.Builder
+	for _, serial := range s.order {
+		d := s.devices[serial]
+		if long && d.Attrs != "" {
+			fmt.Fprintf(&b, "%-22s %s %s\n", d.Serial, d.State, d.Attrs)
+		} else {
+			fmt.Fprintf(&b, "%s\t%s\n", d.Serial, d.State)
+		}
+	}
+	return b.String()
+}
+
+// Lookup 按 host:transport 的语义查找可用的设备，失败时返回与真实 adb server 一致的错误信息
+func (s *DeviceSet) Lookup(serial string) (*Device, string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	d, ok := s.devices[serial]
+	if !ok {
+		return nil, fmt.Sprintf("device '%s' not found", serial)
+	}
+	switch d.State {
+	case "device":
+		return d, ""
+	case "unauthorized":
+		return nil, "device unauthorized.\nThis adb server's $ADB_VENDOR_KEYS is not set"
+	default:
+		return nil, "device offline"
+	}
+}
+
+// Response 返回设备对命令的预设响应，依次查找 Commands、Handler 与内置命令，都没有时表现为 "not found"
+func (s *DeviceSet) Response(d *Device, command string) Response {
+	s.mu.Lock()
+	resp, ok := d.Commands[command]
+	handler := d.Handler
+	s.mu.Unlock()
+	if ok {
+		return resp
+	}
+	if handler != nil {
+		if resp, ok := handler(command); ok {
+			return resp
+		}
+	}
+	if resp, ok := s.builtin(d, command); ok {
+		return resp
+	}
+	name := strings.Fields(command + " ")[0]
+	return Response{Stderr: fmt.Sprintf("/system/bin/sh: %s: not found\n", name), ExitCode: 127}
+}
+
+// File 返回设备上的文件，不存在时返回 nil
+func (s *DeviceSet) File(d *Device, path string) *File {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return d.Files[path]
+}
+
+// StatFile 按 sync STAT 的语义返回路径信息: 除了 Files 中的条目，其他文件路径隐含的目录也会返回一个目录条目
+// 路径不存在时返回 nil
+func (s *DeviceSet) StatFile(d *Device, path string) *File {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if f, ok := d.Files[path]; ok {
+		return f
+	}
+	if path == "/" || isDirLocked(d, path) {
+		return &File{Mode: 0040775, ModTime: time.Unix(1715594400, 0)}
+	}
+	return nil
+}
+
+// WriteFile 写入设备上的文件
+func (s *DeviceSet) WriteFile(d *Device, path string, f *File) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	d.Files[path] = f
+}
+
+// Serials 返回按挂载顺序排列的设备序列号
+func (s *DeviceSet) Serials() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.order...)
+}
+
+// ExpectPairing 让 addr 上的配对服务接受配对码 code
+func (s *DeviceSet) ExpectPairing(addr string, code string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.pairings[addr] = code
+}
+
+// Pair 按 host:pair 的语义返回 adb server 的提示信息
+func (s *DeviceSet) Pair(addr string, code string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	expected, ok := s.pairings[addr]
+	switch {
+	case !ok:
+		return "Failed: Unable to start pairing client."
+	case expected != code:
+		return "Failed: Wrong password or connection was dropped."
+	}
+	return fmt.Sprintf("Successfully paired to %s [guid=adb-fake]", addr)
+}
+
+// TCPIP 让设备在 port 上接受无线连接，返回 adbd 的提示信息
+func (s *DeviceSet) TCPIP(d *Device, port int) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	d.TCPPort = port
+	return fmt.Sprintf("restarting in TCP mode port: %d\n", port)
+}
+
+// Connect 按 host:connect 的语义连接 addr，成功时挂载一台以 addr 为序列号、
+// 与原设备共享命令脚本和文件系统的设备
+func (s *DeviceSet) Connect(addr string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.devices[addr]; ok {
+		return "already connected to " + addr
+	}
+	for _, serial := range s.order {
+		d := s.devices[serial]
+		if d.WifiIP == "" || d.TCPPort == 0 || fmt.Sprintf("%s:%d", d.WifiIP, d.TCPPort) != addr {
+			continue
+		}
+		s.devices[addr] = &Device{
+			Serial:   addr,
+			State:    "device",
+			Attrs:    d.Attrs,
+			Commands: d.Commands,
+			Files:    d.Files,
+			Handler:  d.Handler,
+		}
+		s.order = append(s.order, addr)
+		s.notifyLocked()
+		return "connected to " + addr
+	}
+	return fmt.Sprintf("failed to connect to '%s': Connection refused", addr)
+}
+
+// Disconnect 按 host:disconnect 的语义断开 addr，返回提示信息或失败信息
+func (s *DeviceSet) Disconnect(addr string) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.devices[addr]; !ok {
+		return fmt.Sprintf("no such device '%s'", addr), false
+	}
+	delete(s.devices, addr)
+	for i, id := range s.order {
+		if id == addr {
+			s.order = append(s.order[:i], s.order[i+1:]...)
+			break
+		}
+	}
+	s.notifyLocked()
+	return "disconnected " + addr, true
+}
@@ -0,0 +1,378 @@
+package adbtest
+
+import (
+	"bytes"
+	"context"
+	"fishyinhe/backend/internal/adb"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Call 记录一次对 Fake 的调用，便于测试断言实际下发的命令
+type Call struct {
+	Serial  string
+	Method  string // ShellV2, Exec, Stat, Pull, Push, OpenShell, Session, Connect, Disconnect, Pair, TCPIP
+	Command string // shell/exec 命令、远程路径、无线地址或 tcpip 端口
+}
+
+// Fake 是完全在内存中运行的 adb.Runner，按 DeviceSet 中编排的脚本返回结果
+type Fake struct {
+	*DeviceSet
+
+	mu    sync.Mutex
+	calls []Call
+}
+
+var _ adb.Runner = (*Fake)(nil)
+
+// NewFake 创建一个没有任何设备的 Fake
+func NewFake() *Fake {
+	return &Fake{DeviceSet: NewDeviceSet()}
+}
+
+// Calls 返回到目前为止记录的全部调用
+func (f *Fake) Calls() []Call {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]Call(nil), f.calls...)
+}
+
+func (f *Fake) record(serial, method, command string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls = append(f.calls, Call{Serial: serial, Method: method, Command: command})
+}
+
+func (f *Fake) device(ctx context.Context, serial string) (*Device, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	d, failMsg := f.Lookup(serial)
+	if d == nil {
+		return nil, &adb.ServerError{Service: "host:transport:" + serial, Message: failMsg}
+	}
+	return d, nil
+}
+
+// Devices 返回已挂载的设备及其状态
+func (f *Fake) Devices(ctx context.Context) ([]adb.DeviceInfo, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	devices, err := adb.ParseDevices(f.DeviceList(true))
+	if devices == nil {
+		devices = []adb.DeviceInfo{}
+	}
+	return devices, err
+}
+
+// TrackDevices 先推送当前设备列表，之后每次 DeviceSet 变化时再推送一次
+func (f *Fake) TrackDevices(ctx context.Context, fn func([]adb.DeviceInfo)) error {
+	changed, stop := f.Watch()
+	defer stop()
+	for {
+		devices, err := f.Devices(ctx)
+		if err != nil {
+			return err
+		}
+		fn(devices)
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// ShellV2 返回编排的响应
+func (f *Fake) ShellV2(ctx context.Context, serial string, command string) (*adb.ShellResult, error) {
+	f.record(serial, "ShellV2", command)
+	d, err := f.device(ctx, serial)
+	if err != nil {
+		return nil, err
+	}
+	resp := f.Response(d, command)
+	if err := wait(ctx, resp.Delay); err != nil {
+		return nil, err
+	}
+	return &adb.ShellResult{Stdout: []byte(resp.Stdout), Stderr: []byte(resp.Stderr), ExitCode: resp.ExitCode}, nil
+}
+
+// Exec 返回编排响应中的 stdout
+func (f *Fake) Exec(ctx context.Context, serial string, command string) (io.ReadCloser, error) {
+	f.record(serial, "Exec", command)
+	d, err := f.device(ctx, serial)
+	if err != nil {
+		return nil, err
+	}
+	resp := f.Response(d, command)
+	if err := wait(ctx, resp.Delay); err != nil {
+		return nil, err
+	}
+	return io.NopCloser(strings.NewReader(resp.Stdout)), nil
+}
+
+// wait 模拟命令耗时，ctx 先结束时返回 ctx.Err()
+func wait(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Stat 返回假文件系统中的文件信息，文件不存在时与 sync STAT 一样返回全零
+func (f *Fake) Stat(ctx context.Context, serial string, remotePath string) (*adb.RemoteStat, error) {
+	f.record(serial, "Stat", remotePath)
+	d, err := f.device(ctx, serial)
+	if err != nil {
+		return nil, err
+	}
+	file := f.StatFile(d, remotePath)
+	if file == nil {
+		return &adb.RemoteStat{ModTime: time.Unix(0, 0)}, nil
+	}
+	mode := os.FileMode(file.Mode & 0777)
+	if file.Mode&0170000 == 0040000 {
+		mode |= os.ModeDir
+	}
+	return &adb.RemoteStat{Mode: mode, Size: int64(len(file.Data)), ModTime: file.ModTime}, nil
+}
+
+// Pull 将假文件系统中的文件内容写入 w
+func (f *Fake) Pull(ctx context.Context, serial string, remotePath string, w io.Writer) error {
+	f.record(serial, "Pull", remotePath)
+	d, err := f.device(ctx, serial)
+	if err != nil {
+		return err
+	}
+	file := f.File(d, remotePath)
+	if file == nil {
+		return &adb.ServerError{Service: "sync:RECV " + remotePath, Message: "No such file or directory"}
+	}
+	_, err = w.Write(file.Data)
+	return err
+}
+
+// Push 将 r 的内容写入假文件系统
+func (f *Fake) Push(ctx context.Context, serial string, r io.Reader, remotePath string, mode os.FileMode, mtime time.Time) error {
+	f.record(serial, "Push", remotePath)
+	d, err := f.device(ctx, serial)
+	if err != nil {
+		return err
+	}
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	f.WriteFile(d, remotePath, &File{Data: data, Mode: 0100000 | uint32(mode.Perm()), ModTime: mtime})
+	return nil
+}
+
+// OpenShell 返回一个按编排脚本执行命令的会话，会话上的命令以 Method "Session" 记录
+// 设备被移除后会话上的命令返回错误，与真实会话在设备断开后的行为一致
+func (f *Fake) OpenShell(ctx context.Context, serial string) (adb.ShellSession, error) {
+	f.record(serial, "OpenShell", "")
+	if _, err := f.device(ctx, serial); err != nil {
+		return nil, err
+	}
+	return &fakeSession{f: f, serial: serial}, nil
+}
+
+type fakeSession struct {
+	f      *Fake
+	serial string
+	closed bool
+	mu     sync.Mutex
+}
+
+func (s *fakeSession) Run(ctx context.Context, command string) (*adb.ShellResult, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.f.record(s.serial, "Session", command)
+	if s.closed {
+		return nil, io.EOF
+	}
+	d, err := s.f.device(ctx, s.serial)
+	if err != nil {
+		s.closed = true
+		return nil, err
+	}
+	resp := s.f.Response(d, command)
+	if err := wait(ctx, resp.Delay); err != nil {
+		s.closed = true
+		return nil, err
+	}
+	return &adb.ShellResult{Stdout: []byte(resp.Stdout), Stderr: []byte(resp.Stderr), ExitCode: resp.ExitCode}, nil
+}
+
+func (s *fakeSession) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	return nil
+}
+
+// Connect 按 DeviceSet.Connect 的规则连接无线设备
+func (f *Fake) Connect(ctx context.Context, addr string) (string, error) {
+	f.record("", "Connect", addr)
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+	msg := f.DeviceSet.Connect(addr)
+	if !strings.HasPrefix(msg, "connected to") && !strings.HasPrefix(msg, "already connected to") {
+		return msg, fmt.Errorf("%w: %s", adb.ErrConnectFailed, msg)
+	}
+	return msg, nil
+}
+
+// Disconnect 断开无线设备，设备不存在时返回与 adb server 一致的 FAIL
+func (f *Fake) Disconnect(ctx context.Context, addr string) (string, error) {
+	f.record("", "Disconnect", addr)
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+	msg, ok := f.DeviceSet.Disconnect(addr)
+	if !ok {
+		return "", &adb.ServerError{Service: "host:disconnect:" + addr, Message: msg}
+	}
+	return msg, nil
+}
+
+// Pair 只接受通过 ExpectPairing 编排的地址与配对码
+func (f *Fake) Pair(ctx context.Context, addr string, code string) (string, error) {
+	f.record("", "Pair", addr)
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+	msg := f.DeviceSet.Pair(addr, code)
+	if !strings.HasPrefix(msg, "Successfully paired") {
+		return msg, fmt.Errorf("%w: %s", adb.ErrPairFailed, msg)
+	}
+	return msg, nil
+}
+
+// TCPIP 让设备之后可以通过 <WifiIP>:<port> 连接
+func (f *Fake) TCPIP(ctx context.Context, serial string, port int) (string, error) {
+	f.record(serial, "TCPIP", strconv.Itoa(port))
+	d, err := f.device(ctx, serial)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(f.DeviceSet.TCPIP(d, port)), nil
+}
+
+// 预置设备使用的脚本数据
+const (
+	CannedPackages   = "package:com.android.settings\npackage:com.android.chrome\npackage:com.example.app\n"
+	CannedThirdParty = "package:com.example.app\n"
+	CannedLogcat     = "05-13 10:00:00.000  1000  1000 I ActivityManager: Start proc com.example.app\n" +
+		"05-13 10:00:01.000  2000  2000 E AndroidRuntime: FATAL EXCEPTION: main\n"
+)
+
+// CannedSdcardListing 是预置设备 "ls -la --full-time /sdcard/" 的输出
+const CannedSdcardListing = "total 72\n" +
+	"drwxrwx--x 12 root sdcard_rw 3488 2024-05-13 10:00:00.000000000 +0800 .\n" +
+	"drwx--x--x  4 root sdcard_rw 3488 2024-05-13 09:00:00.000000000 +0800 ..\n" +
+	"drwxrws---  2 u0_a178 media_rw 3488 2024-05-01 08:00:00.000000000 +0800 Alarms\n" +
+	"drwxrws---  5 u0_a178 media_rw 3488 2024-05-01 08:00:00.000000000 +0800 Android\n" +
+	"drwxrws---  4 u0_a178 media_rw 3488 2024-05-12 21:30:00.000000000 +0800 DCIM\n" +
+	"drwxrws---  2 u0_a178 media_rw 3488 2024-05-10 12:00:00.000000000 +0800 Download\n" +
+	"drwxrws---  2 u0_a178 media_rw 3488 2024-05-01 08:00:00.000000000 +0800 Movies\n" +
+	"drwxrws---  2 u0_a178 media_rw 3488 2024-05-01 08:00:00.000000000 +0800 Music\n" +
+	"drwxrws---  2 u0_a178 media_rw 3488 2024-05-01 08:00:00.000000000 +0800 Pictures\n" +
+	"-rw-rw----  1 u0_a178 media_rw   18 2024-05-13 10:00:00.000000000 +0800 notes.txt\n"
+
+// CannedRootListing 是预置设备 "ls -la --full-time /" 的输出，/sdcard 是指向目录的符号链接
+const CannedRootListing = "total 40\n" +
+	"drwxr-xr-x  24 root root    0 2024-05-13 09:00:00.000000000 +0800 .\n" +
+	"drwxr-xr-x  24 root root    0 2024-05-13 09:00:00.000000000 +0800 ..\n" +
+	"dr-xr-xr-x  62 root root    0 2024-05-13 09:00:00.000000000 +0800 acct\n" +
+	"drwxrwx--x  49 system system 4096 2024-05-13 09:00:00.000000000 +0800 data\n" +
+	"lrw-r--r--   1 root root   21 2009-01-01 08:00:00.000000000 +0800 sdcard -> /storage/self/primary\n" +
+	"drwx--x---   4 shell everybody 80 2024-05-13 09:00:00.000000000 +0800 storage\n" +
+	"drwxr-xr-x  14 root root 4096 2009-01-01 08:00:00.000000000 +0800 system\n"
+
+// CannedWlan0 是预置设备 "ip addr show wlan0" 的输出，地址与 Device.WifiIP 一致
+const CannedWlan0 = "30: wlan0: <BROADCAST,MULTICAST,UP,LOWER_UP> mtu 1500 qdisc mq state UP group default qlen 3000\n" +
+	"    link/ether 02:00:00:00:00:00 brd ff:ff:ff:ff:ff:ff\n" +
+	"    inet 192.168.1.23/24 brd 192.168.1.255 scope global wlan0\n" +
+	"       valid_lft forever preferred_lft forever\n"
+
+// CannedGetprop 是预置设备 getprop 的输出
+const CannedGetprop = `[ro.build.fingerprint]: [google/panther/panther:14/UQ1A.240205.004/11269751:user/release-keys]
+[ro.build.version.release]: [14]
+[ro.build.version.sdk]: [34]
+[ro.product.brand]: [google]
+[ro.product.cpu.abi]: [arm64-v8a]
+[ro.product.cpu.abilist]: [arm64-v8a,armeabi-v7a,armeabi]
+[ro.product.locale]: [en-US]
+[ro.product.manufacturer]: [Google]
+[ro.product.model]: [Pixel 7]
+[ro.sf.lcd_density]: [420]
+`
+
+// CannedScreenshot 是预置设备 screencap -p 返回的 PNG 数据
+var CannedScreenshot = func() []byte {
+	img := image.NewRGBA(image.Rect(0, 0, 4, 8))
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 4; x++ {
+			img.Set(x, y, color.RGBA{R: 0x33, G: 0x66, B: 0x99, A: 0xff})
+		}
+	}
+	var buf bytes.Buffer
+	png.Encode(&buf, img)
+	return buf.Bytes()
+}()
+
+// AddCannedDevice 挂载一台在线设备，并为 devices、ls -la、pm list packages、
+// screencap、logcat、getprop 以及 input/am/pm 等常用命令编排好典型输出
+func (s *DeviceSet) AddCannedDevice(serial string) *Device {
+	d := s.AddDevice(serial, "device")
+	d.Attrs = "product:sdk_gphone64 model:Pixel_7 device:panther transport_id:1"
+	d.Commands["ls -la --full-time /sdcard/"] = Response{Stdout: CannedSdcardListing}
+	d.Commands["ls -la --full-time /"] = Response{Stdout: CannedRootListing}
+	d.Commands["ls -dpL /sdcard"] = Response{Stdout: "/sdcard/\n"}
+	d.Commands["pm list packages"] = Response{Stdout: CannedPackages}
+	d.Commands["pm list packages -3"] = Response{Stdout: CannedThirdParty}
+	d.Commands["screencap -p"] = Response{Stdout: string(CannedScreenshot)}
+	d.Commands["logcat -d"] = Response{Stdout: CannedLogcat}
+	d.Commands["logcat -c"] = Response{}
+	d.Commands["getprop"] = Response{Stdout: CannedGetprop}
+	d.Commands["wm size"] = Response{Stdout: "Physical size: 1080x2400\n"}
+	d.Commands["ip addr show wlan0"] = Response{Stdout: CannedWlan0}
+	d.WifiIP = "192.168.1.23"
+	d.Files["/sdcard/notes.txt"] = &File{Data: []byte("hello from device\n"), Mode: 0100660, ModTime: time.Unix(1715594400, 0)}
+	d.Handler = func(command string) (Response, bool) {
+		switch {
+		case strings.HasPrefix(command, "input "),
+			strings.HasPrefix(command, "am force-stop "):
+			return Response{}, true
+		case strings.HasPrefix(command, "pm uninstall "),
+			strings.HasPrefix(command, "pm install "),
+			strings.HasPrefix(command, "pm install-commit "),
+			strings.HasPrefix(command, "pm install-abandon "):
+			return Response{Stdout: "Success\n"}, true
+		case strings.HasPrefix(command, "pm install-create "):
+			return Response{Stdout: "Success: created install session [1001]\n"}, true
+		case strings.HasPrefix(command, "pm install-write "):
+			return Response{Stdout: "Success: streamed 0 bytes\n"}, true
+		}
+		return Response{}, false
+	}
+	return d
+}
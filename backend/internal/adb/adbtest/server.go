@@ -0,0 +1,424 @@
+// Package adbtest 提供进程内的假 adb server 与假 adb.Runner，用于在没有真机的情况下测试 adb 包与 HTTP 处理函数
+package adbtest
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Server 实现 adb server 智能套接字协议的一个子集:
+// host:version, host:devices, host:devices-l, host:track-devices, host:transport:<serial>,
+// host:connect, host:disconnect, host:pair，以及设备上的 shell:, shell,v2:, exec:, tcpip: 和 sync: (STAT/STA2/RECV/SEND)
+// shell,v2,raw:sh 与 exec:sh 启动一个常驻 shell，按 adb.ShellSession 的哨兵格式逐条执行编排的命令
+type Server struct {
+	*DeviceSet
+	Version int
+	// DisableSTA2 使 sync STA2 返回 FAIL，模拟 Android 8 之前只支持 STAT 的 adbd
+	DisableSTA2 bool
+	// DisableShellV2 使 shell,v2 服务返回 FAIL，模拟 Android 7 之前只支持 shell: 与 exec: 的 adbd
+	DisableShellV2 bool
+
+	ln     net.Listener
+	wg     sync.WaitGroup
+	closed chan struct{}
+}
+
+// NewServer 在 127.0.0.1 的随机端口上启动一个假 adb server
+func NewServer() *Server {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		panic(fmt.Sprintf("adbtest: failed to listen: %v", err))
+	}
+	s := &Server{DeviceSet: NewDeviceSet(), Version: 41, ln: ln, closed: make(chan struct{})}
+	s.wg.Add(1)
+	go s.serve()
+	return s
+}
+
+// Addr 返回假 server 的监听地址，可直接传给 adb.NewClient
+func (s *Server) Addr() string {
+	return s.ln.Addr().String()
+}
+
+// Close 停止监听并等待已建立的连接处理完毕
+func (s *Server) Close() {
+	close(s.closed)
+	s.ln.Close()
+	s.wg.Wait()
+}
+
+func (s *Server) serve() {
+	defer s.wg.Done()
+	for {
+		conn, err := s.ln.Accept()
+		if err != nil {
+			return
+		}
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			defer conn.Close()
+			s.handle(conn)
+		}()
+	}
+}
+
+func readRequest(r io.Reader) (string, error) {
+	var lenBuf [4]byte
+	if _, err := io.ReadFull(r, lenBuf[:]); err != nil {
+		return "", err
+	}
+	n, err := strconv.ParseUint(string(lenBuf[:]), 16, 32)
+	if err != nil {
+		return "", err
+	}
+	buf := make([]byte, n)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return "", err
+	}
+	return string(buf), nil
+}
+
+func writeOkay(w io.Writer) {
+	io.WriteString(w, "OKAY")
+}
+
+func writeFail(w io.Writer, msg string) {
+	fmt.Fprintf(w, "FAIL%04x%s", len(msg), msg)
+}
+
+func writeHexPrefixed(w io.Writer, payload string) {
+	fmt.Fprintf(w, "%04x%s", len(payload), payload)
+}
+
+func (s *Server) handle(conn net.Conn) {
+	br := bufio.NewReader(conn)
+	var device *Device
+	for {
+		req, err := readRequest(br)
+		if err != nil {
+			return
+		}
+		if device == nil {
+			switch {
+			case req == "host:version":
+				writeOkay(conn)
+				writeHexPrefixed(conn, fmt.Sprintf("%04x", s.Version))
+				return
+			case req == "host:devices" || req == "host:devices-l":
+				writeOkay(conn)
+				writeHexPrefixed(conn, s.DeviceList(req == "host:devices-l"))
+				return
+			case req == "host:track-devices" || req == "host:track-devices-l":
+				writeOkay(conn)
+				s.trackDevices(conn, br, req == "host:track-devices-l")
+				return
+			case strings.HasPrefix(req, "host:connect:"):
+				writeOkay(conn)
+				writeHexPrefixed(conn, s.Connect(strings.TrimPrefix(req, "host:connect:")))
+				return
+			case strings.HasPrefix(req, "host:disconnect:"):
+				msg, ok := s.Disconnect(strings.TrimPrefix(req, "host:disconnect:"))
+				if !ok {
+					writeFail(conn, msg)
+					return
+				}
+				writeOkay(conn)
+				writeHexPrefixed(conn, msg)
+				return
+			case strings.HasPrefix(req, "host:pair:"):
+				// host:pair:<code>:<host>:<port>
+				code, addr, _ := strings.Cut(strings.TrimPrefix(req, "host:pair:"), ":")
+				writeOkay(conn)
+				writeHexPrefixed(conn, s.Pair(addr, code))
+				return
+			case strings.HasPrefix(req, "host:transport:"):
+				d, failMsg := s.Lookup(strings.TrimPrefix(req, "host:transport:"))
+				if d == nil {
+					writeFail(conn, failMsg)
+					return
+				}
+				device = d
+				writeOkay(conn)
+				continue
+			default:
+				writeFail(conn, "unknown host service")
+				return
+			}
+		}
+		s.handleDeviceService(conn, br, device, req)
+		return
+	}
+}
+
+// trackDevices 推送设备列表直到客户端断开或 server 关闭
+func (s *Server) trackDevices(conn net.Conn, br *bufio.Reader, long bool) {
+	changed, stop := s.Watch()
+	defer stop()
+	clientGone := make(chan struct{})
+	go func() {
+		io.Copy(io.Discard, br)
+		close(clientGone)
+	}()
+	for {
+		writeHexPrefixed(conn, s.DeviceList(long))
+		select {
+		case <-changed:
+		case <-clientGone:
+			return
+		case <-s.closed:
+			conn.Close()
+			return
+		}
+	}
+}
+
+func (s *Server) handleDeviceService(conn net.Conn, br *bufio.Reader, d *Device, req string) {
+	switch {
+	case req == "sync:":
+		writeOkay(conn)
+		s.handleSync(conn, br, d)
+	case strings.HasPrefix(req, "tcpip:"):
+		port, err := strconv.Atoi(strings.TrimPrefix(req, "tcpip:"))
+		if err != nil {
+			writeFail(conn, "invalid port")
+			return
+		}
+		writeOkay(conn)
+		io.WriteString(conn, s.TCPIP(d, port))
+	case req == "exec:sh":
+		writeOkay(conn)
+		s.interactiveShell(conn, br, d, false)
+	case strings.HasPrefix(req, "exec:"):
+		writeOkay(conn)
+		resp := s.Response(d, strings.TrimPrefix(req, "exec:"))
+		time.Sleep(resp.Delay)
+		io.WriteString(conn, resp.Stdout)
+	case strings.HasPrefix(req, "shell,"):
+		// shell,v2,raw:<command>
+		idx := strings.Index(req, ":")
+		if idx < 0 || !strings.Contains(req[:idx], "v2") || s.DisableShellV2 {
+			writeFail(conn, "unsupported shell options")
+			return
+		}
+		writeOkay(conn)
+		if req[idx+1:] == "sh" {
+			s.interactiveShell(conn, br, d, true)
+			return
+		}
+		resp := s.Response(d, req[idx+1:])
+		time.Sleep(resp.Delay)
+		writeShellPacket(conn, 1, []byte(resp.Stdout))
+		writeShellPacket(conn, 2, []byte(resp.Stderr))
+		writeShellPacket(conn, 3, []byte{byte(resp.ExitCode)})
+	case strings.HasPrefix(req, "shell:"):
+		writeOkay(conn)
+		resp := s.Response(d, strings.TrimPrefix(req, "shell:"))
+		time.Sleep(resp.Delay)
+		io.WriteString(conn, resp.Stdout+resp.Stderr)
+	default:
+		writeFail(conn, "unknown service")
+	}
+}
+
+var (
+	framedV2     = regexp.MustCompile(`^\((.*?)\n\) </dev/null; echo (\S+)\$\?; echo \S+ >&2\n`)
+	framedMerged = regexp.MustCompile(`^\((.*?)\n\) </dev/null 2>&1; echo (\S+)\$\?\n`)
+)
+
+// interactiveShell 模拟常驻 sh: 从 stdin 读取 adb.ShellSession 写入的命令帧，执行编排的命令后输出哨兵
+// 设备被移除 (或重新挂载为新的 Device) 后连接直接断开，模拟设备掉线时 adbd 关闭会话
+func (s *Server) interactiveShell(conn net.Conn, br *bufio.Reader, d *Device, v2 bool) {
+	// 常驻会话由客户端持有，server 关闭时需要主动断开
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-s.closed:
+			conn.Close()
+		case <-done:
+		}
+	}()
+
+	var pending []byte
+	for {
+		if v2 {
+			var header [5]byte
+			if _, err := io.ReadFull(br, header[:]); err != nil {
+				return
+			}
+			data := make([]byte, binary.LittleEndian.Uint32(header[1:]))
+			if _, err := io.ReadFull(br, data); err != nil {
+				return
+			}
+			if header[0] == 4 { // 关闭 stdin，sh 随之退出
+				writeShellPacket(conn, 3, []byte{0})
+				return
+			}
+			if header[0] != 0 {
+				continue
+			}
+			pending = append(pending, data...)
+		} else {
+			buf := make([]byte, 4096)
+			n, err := br.Read(buf)
+			if err != nil {
+				return
+			}
+			pending = append(pending, buf[:n]...)
+		}
+
+		framed := framedMerged
+		if v2 {
+			framed = framedV2
+		}
+		for {
+			m := framed.FindSubmatchIndex(pending)
+			if m == nil {
+				break
+			}
+			command, marker := string(pending[m[2]:m[3]]), string(pending[m[4]:m[5]])
+			pending = pending[m[1]:]
+
+			if current, _ := s.Lookup(d.Serial); current != d {
+				return
+			}
+			resp := s.Response(d, command)
+			time.Sleep(resp.Delay)
+			trailer := fmt.Sprintf("%s%d\n", marker, resp.ExitCode)
+			if v2 {
+				writeShellPacket(conn, 1, []byte(resp.Stdout+trailer))
+				writeShellPacket(conn, 2, []byte(resp.Stderr+marker+"\n"))
+			} else {
+				io.WriteString(conn, resp.Stdout+resp.Stderr+trailer)
+			}
+		}
+	}
+}
+
+func writeShellPacket(w io.Writer, id byte, data []byte) {
+	if len(data) == 0 && id != 3 {
+		return
+	}
+	header := make([]byte, 5)
+	header[0] = id
+	binary.LittleEndian.PutUint32(header[1:], uint32(len(data)))
+	w.Write(append(header, data...))
+}
+
+func writeSyncPacket(w io.Writer, id string, length uint32, data []byte) {
+	header := make([]byte, 8)
+	copy(header, id)
+	binary.LittleEndian.PutUint32(header[4:], length)
+	w.Write(append(header, data...))
+}
+
+func (s *Server) handleSync(conn net.Conn, br *bufio.Reader, d *Device) {
+	var header [8]byte
+	for {
+		if _, err := io.ReadFull(br, header[:]); err != nil {
+			return
+		}
+		id := string(header[:4])
+		length := binary.LittleEndian.Uint32(header[4:])
+		payload := make([]byte, length)
+		if _, err := io.ReadFull(br, payload); err != nil {
+			return
+		}
+		switch id {
+		case "STAT":
+			f := s.StatFile(d, string(payload))
+			stat := make([]byte, 12)
+			if f != nil {
+				binary.LittleEndian.PutUint32(stat[0:], f.Mode)
+				binary.LittleEndian.PutUint32(stat[4:], uint32(len(f.Data)))
+				binary.LittleEndian.PutUint32(stat[8:], uint32(f.ModTime.Unix()))
+			}
+			conn.Write(append([]byte("STAT"), stat...))
+		case "STA2":
+			if s.DisableSTA2 {
+				msg := "unknown command STA2"
+				writeSyncPacket(conn, "FAIL", uint32(len(msg)), []byte(msg))
+				return
+			}
+			f := s.StatFile(d, string(payload))
+			stat := make([]byte, 72)
+			copy(stat, "STA2")
+			if f == nil {
+				binary.LittleEndian.PutUint32(stat[4:], 2) // ENOENT
+			} else {
+				binary.LittleEndian.PutUint32(stat[24:], f.Mode)
+				binary.LittleEndian.PutUint64(stat[40:], uint64(len(f.Data)))
+				binary.LittleEndian.PutUint64(stat[56:], uint64(f.ModTime.Unix()))
+			}
+			conn.Write(stat)
+		case "RECV":
+			f := s.File(d, string(payload))
+			if f == nil {
+				msg := "No such file or directory"
+				writeSyncPacket(conn, "FAIL", uint32(len(msg)), []byte(msg))
+				continue
+			}
+			for off := 0; off < len(f.Data); off += 64 * 1024 {
+				end := off + 64*1024
+				if end > len(f.Data) {
+					end = len(f.Data)
+				}
+				writeSyncPacket(conn, "DATA", uint32(end-off), f.Data[off:end])
+			}
+			writeSyncPacket(conn, "DONE", 0, nil)
+		case "SEND":
+			target := string(payload)
+			path, mode := target, uint64(0100644)
+			if i := strings.LastIndex(target, ","); i >= 0 {
+				path = target[:i]
+				if m, err := strconv.ParseUint(target[i+1:], 10, 32); err == nil {
+					mode = m
+				}
+			}
+			data, mtime, ok := readSendData(br)
+			if !ok {
+				return
+			}
+			s.WriteFile(d, path, &File{Data: data, Mode: uint32(mode), ModTime: mtime})
+			writeSyncPacket(conn, "OKAY", 0, nil)
+		case "QUIT":
+			return
+		default:
+			msg := "unsupported sync request " + id
+			writeSyncPacket(conn, "FAIL", uint32(len(msg)), []byte(msg))
+		}
+	}
+}
+
+// readSendData 读取 SEND 之后的 DATA 数据块，直到 DONE (携带 mtime)
+func readSendData(r io.Reader) ([]byte, time.Time, bool) {
+	var data []byte
+	var header [8]byte
+	for {
+		if _, err := io.ReadFull(r, header[:]); err != nil {
+			return nil, time.Time{}, false
+		}
+		value := binary.LittleEndian.Uint32(header[4:])
+		switch string(header[:4]) {
+		case "DATA":
+			chunk := make([]byte, value)
+			if _, err := io.ReadFull(r, chunk); err != nil {
+				return nil, time.Time{}, false
+			}
+			data = append(data, chunk...)
+		case "DONE":
+			return data, time.Unix(int64(value), 0), true
+		default:
+			return nil, time.Time{}, false
+		}
+	}
+}
@@ -0,0 +1,339 @@
+package adb
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"path"
+	"strings"
+)
+
+// WalkFunc 在 WalkFiles 遍历到每个条目时被调用，rel 是相对于根目录、以 / 分隔的路径
+// 对目录返回 fs.SkipDir 可以跳过整个子树
+type WalkFunc func(rel string, item AdbFileItem) error
+
+// WalkFiles 以深度优先、按名称排序的顺序遍历 root 下的全部条目
+// 符号链接会传给 fn 但不会被跟随，避免 /sdcard 等链接造成循环
+func WalkFiles(ctx context.Context, r Runner, deviceId string, root string, fn WalkFunc) error {
+	return walkDir(ctx, r, deviceId, root, "", fn)
+}
+
+func walkDir(ctx context.Context, r Runner, deviceId string, root string, rel string, fn WalkFunc) error {
+	files, err := ListFiles(ctx, r, deviceId, path.Join(root, rel))
+	if err != nil {
+		return err
+	}
+	SortFiles(files, "name", false)
+	for _, item := range files {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		childRel := path.Join(rel, item.Name)
+		err := fn(childRel, item)
+		if errors.Is(err, fs.SkipDir) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if item.IsDir && !item.IsLink {
+			if err := walkDir(ctx, r, deviceId, root, childRel, fn); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// ArchiveFormat 是目录打包下载支持的格式
+type ArchiveFormat string
+
+const (
+	ArchiveZip   ArchiveFormat = "zip"
+	ArchiveTarGz ArchiveFormat = "tar.gz"
+)
+
+// ArchiveOptions 控制 WriteArchive 包含哪些文件
+type ArchiveOptions struct {
+	Format ArchiveFormat
+	// Include 非空时只打包文件名或相对路径匹配其中任一 glob 的文件 (例如 *.jpg)
+	Include []string
+	// Exclude 中匹配的文件与目录 (连同其子树) 不会被打包，优先于 Include
+	Exclude []string
+	// MaxBytes 是打包文件的总大小上限，<= 0 表示不限制
+	MaxBytes int64
+}
+
+// ArchiveStats 是一次打包的结果
+type ArchiveStats struct {
+	Files   int
+	Bytes   int64
+	Skipped []string // 无法读取而被跳过的文件
+}
+
+// ArchiveEntry 是 CollectArchive 选中的一个文件或目录
+type ArchiveEntry struct {
+	Path string // 相对于打包根目录
+	Item AdbFileItem
+}
+
+// matchAny 报告 rel 或其文件名是否匹配 patterns 中的任一 glob
+func matchAny(patterns []string, rel string) bool {
+	base := path.Base(rel)
+	for _, p := range patterns {
+		if ok, _ := path.Match(p, base); ok {
+			return true
+		}
+		if ok, _ := path.Match(p, rel); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// validate 检查格式与 glob 语法
+func (o *ArchiveOptions) validate() error {
+	switch o.Format {
+	case ArchiveZip, ArchiveTarGz:
+	default:
+		return fmt.Errorf("%w: unsupported archive format %q", ErrInvalidArgument, o.Format)
+	}
+	for _, p := range append(append([]string(nil), o.Include...), o.Exclude...) {
+		if _, err := path.Match(p, ""); err != nil {
+			return fmt.Errorf("%w: invalid glob %q", ErrInvalidArgument, p)
+		}
+	}
+	return nil
+}
+
+// CollectArchive 遍历 root 并返回将被打包的条目与总大小，超过 MaxBytes 时返回 ErrArchiveTooLarge
+// 在写出任何数据之前调用，使调用者仍然可以返回普通的错误响应
+func CollectArchive(ctx context.Context, r Runner, deviceId string, root string, opts ArchiveOptions) ([]ArchiveEntry, int64, error) {
+	if err := opts.validate(); err != nil {
+		return nil, 0, err
+	}
+	var entries []ArchiveEntry
+	var total int64
+	err := WalkFiles(ctx, r, deviceId, root, func(rel string, item AdbFileItem) error {
+		if matchAny(opts.Exclude, rel) {
+			if item.IsDir {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if item.IsLink {
+			return nil
+		}
+		if !item.IsDir {
+			if len(opts.Include) > 0 && !matchAny(opts.Include, rel) {
+				return nil
+			}
+			if !strings.HasPrefix(item.Mode, "-") {
+				// 设备文件、管道与套接字
+				return nil
+			}
+			total += item.Size
+			if opts.MaxBytes > 0 && total > opts.MaxBytes {
+				return fmt.Errorf("%w: %s exceeds %d bytes", ErrArchiveTooLarge, root, opts.MaxBytes)
+			}
+		}
+		entries = append(entries, ArchiveEntry{Path: rel, Item: item})
+		return nil
+	})
+	return entries, total, err
+}
+
+// WriteArchive 将 CollectArchive 返回的条目打包写入 w，文件内容通过 sync RECV 逐个拉取
+// 无法读取的文件会被跳过并记录在 ArchiveStats.Skipped 中；文件在传输期间变化时按列表中的大小截断或补零
+// 文件已经写入部分数据后拉取失败时，归档中的条目已不完整，因此返回错误中止整个归档
+func WriteArchive(ctx context.Context, r Runner, deviceId string, root string, format ArchiveFormat, entries []ArchiveEntry, w io.Writer) (*ArchiveStats, error) {
+	ctx = WithPriority(ctx, PriorityBulk)
+	var aw archiveWriter
+	switch format {
+	case ArchiveZip:
+		aw = &zipArchive{zw: zip.NewWriter(w)}
+	case ArchiveTarGz:
+		gz := gzip.NewWriter(w)
+		aw = &tarArchive{gz: gz, tw: tar.NewWriter(gz)}
+	default:
+		return nil, fmt.Errorf("%w: unsupported archive format %q", ErrInvalidArgument, format)
+	}
+
+	stats := &ArchiveStats{}
+	for _, e := range entries {
+		if err := ctx.Err(); err != nil {
+			return stats, err
+		}
+		if e.Item.IsDir {
+			if err := aw.addDir(e.Path, e.Item); err != nil {
+				return stats, err
+			}
+			continue
+		}
+		fw := &entryWriter{open: func() (io.Writer, error) { return aw.addFile(e.Path, e.Item) }}
+		err := r.Pull(ctx, deviceId, path.Join(root, e.Path), fw)
+		if fw.writeErr != nil {
+			// 写入客户端失败，通常是客户端已断开
+			return stats, fw.writeErr
+		}
+		if err != nil && ctx.Err() != nil {
+			return stats, ctx.Err()
+		}
+		if err != nil && fw.w == nil {
+			log.Printf("WriteArchive: Skipping unreadable file '%s' on device '%s': %v", e.Path, deviceId, err)
+			stats.Skipped = append(stats.Skipped, e.Path)
+			continue
+		}
+		if err != nil {
+			return stats, fmt.Errorf("pull %s failed after %d bytes: %w", e.Path, fw.n, err)
+		}
+		if fw.w == nil {
+			// 空文件没有任何数据写入
+			if _, err := fw.writer(); err != nil {
+				return stats, err
+			}
+		}
+		stored, err := aw.finishFile(fw.n, e.Item.Size)
+		if err != nil {
+			return stats, err
+		}
+		stats.Files++
+		stats.Bytes += stored
+	}
+	return stats, aw.close()
+}
+
+// entryWriter 在第一次写入时才创建归档条目，拉取在传输数据之前失败 (例如没有读权限) 时可以跳过该文件
+type entryWriter struct {
+	open     func() (io.Writer, error)
+	w        io.Writer
+	n        int64
+	writeErr error
+}
+
+func (e *entryWriter) writer() (io.Writer, error) {
+	if e.w == nil {
+		w, err := e.open()
+		if err != nil {
+			e.writeErr = err
+			return nil, err
+		}
+		e.w = w
+	}
+	return e.w, nil
+}
+
+func (e *entryWriter) Write(p []byte) (int, error) {
+	w, err := e.writer()
+	if err != nil {
+		return 0, err
+	}
+	n, err := w.Write(p)
+	e.n += int64(n)
+	if err != nil {
+		e.writeErr = err
+	}
+	return n, err
+}
+
+type archiveWriter interface {
+	addDir(rel string, item AdbFileItem) error
+	addFile(rel string, item AdbFileItem) (io.Writer, error)
+	// finishFile 在文件内容写完后调用，written 是从设备读取的字节数，size 是头部中声明的大小
+	// 返回归档中实际保存的文件内容字节数 (不含补齐的零)
+	finishFile(written int64, size int64) (int64, error)
+	close() error
+}
+
+// storedExts 是本身已经压缩过的格式，zip 中直接存储以节省 CPU
+var storedExts = map[string]bool{
+	".jpg": true, ".jpeg": true, ".png": true, ".gif": true, ".webp": true, ".heic": true,
+	".mp4": true, ".mkv": true, ".webm": true, ".3gp": true, ".mov": true,
+	".mp3": true, ".aac": true, ".m4a": true, ".ogg": true, ".opus": true,
+	".zip": true, ".apk": true, ".gz": true, ".xz": true, ".7z": true, ".obb": true,
+}
+
+type zipArchive struct {
+	zw *zip.Writer
+}
+
+func (a *zipArchive) addDir(rel string, item AdbFileItem) error {
+	_, err := a.zw.CreateHeader(&zip.FileHeader{Name: rel + "/", Modified: item.ModTime})
+	return err
+}
+
+func (a *zipArchive) addFile(rel string, item AdbFileItem) (io.Writer, error) {
+	method := zip.Deflate
+	if storedExts[strings.ToLower(path.Ext(rel))] {
+		method = zip.Store
+	}
+	return a.zw.CreateHeader(&zip.FileHeader{Name: rel, Method: method, Modified: item.ModTime})
+}
+
+// zip 使用数据描述符记录实际大小，不需要与列表中的大小一致
+func (a *zipArchive) finishFile(written int64, size int64) (int64, error) { return written, nil }
+
+func (a *zipArchive) close() error { return a.zw.Close() }
+
+type tarArchive struct {
+	gz *gzip.Writer
+	tw *tar.Writer
+}
+
+func (a *tarArchive) addDir(rel string, item AdbFileItem) error {
+	return a.tw.WriteHeader(&tar.Header{Typeflag: tar.TypeDir, Name: rel + "/", Mode: 0755, ModTime: item.ModTime})
+}
+
+func (a *tarArchive) addFile(rel string, item AdbFileItem) (io.Writer, error) {
+	if err := a.tw.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: rel, Mode: 0644, Size: item.Size, ModTime: item.ModTime}); err != nil {
+		return nil, err
+	}
+	return &tarLimitWriter{tw: a.tw, remaining: item.Size}, nil
+}
+
+// finishFile 在文件比列表中的大小短时补零，保持 tar 结构完整；超出的部分已被 tarLimitWriter 丢弃
+func (a *tarArchive) finishFile(written int64, size int64) (int64, error) {
+	if written >= size {
+		return size, nil
+	}
+	_, err := io.CopyN(a.tw, zeroReader{}, size-written)
+	return written, err
+}
+
+func (a *tarArchive) close() error {
+	if err := a.tw.Close(); err != nil {
+		return err
+	}
+	return a.gz.Close()
+}
+
+// tarLimitWriter 丢弃超出头部声明大小的数据 (文件在列出后又变大了)
+type tarLimitWriter struct {
+	tw        *tar.Writer
+	remaining int64
+}
+
+func (w *tarLimitWriter) Write(p []byte) (int, error) {
+	n := len(p)
+	if int64(len(p)) > w.remaining {
+		p = p[:w.remaining]
+	}
+	if _, err := w.tw.Write(p); err != nil {
+		return 0, err
+	}
+	w.remaining -= int64(len(p))
+	return n, nil
+}
+
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	clear(p)
+	return len(p), nil
+}
@@ -0,0 +1,89 @@
+package adb_test
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fishyinhe/backend/internal/adb"
+	"fishyinhe/backend/internal/adb/adbtest"
+	"io"
+	"strings"
+	"testing"
+)
+
+func newArchiveFake(t *testing.T) (*adbtest.Fake, []adb.ArchiveEntry) {
+	t.Helper()
+	f := adbtest.NewFake()
+	f.AddCannedDevice("emu-1")
+	f.PutFile("emu-1", "/sdcard/box/a.txt", []byte("aaaa"))
+	f.PutFile("emu-1", "/sdcard/box/b.txt", []byte("bbbbbbbb"))
+	entries, total, err := adb.CollectArchive(context.Background(), f, "emu-1", "/sdcard/box", adb.ArchiveOptions{Format: adb.ArchiveTarGz})
+	if err != nil || len(entries) != 2 || total != 12 {
+		t.Fatalf("CollectArchive = %d entries, %d bytes, %v, want 2 entries and 12 bytes", len(entries), total, err)
+	}
+	return f, entries
+}
+
+// TestWriteArchiveCountsStoredBytes 确认列出后变大的文件只按写入归档的字节计数
+func TestWriteArchiveCountsStoredBytes(t *testing.T) {
+	f, entries := newArchiveFake(t)
+	f.PutFile("emu-1", "/sdcard/box/b.txt", []byte(strings.Repeat("b", 100)))
+
+	var buf bytes.Buffer
+	stats, err := adb.WriteArchive(context.Background(), f, "emu-1", "/sdcard/box", adb.ArchiveTarGz, entries, &buf)
+	if err != nil {
+		t.Fatalf("WriteArchive: %v", err)
+	}
+	if stats.Files != 2 || stats.Bytes != 12 {
+		t.Errorf("stats = %+v, want 2 files and 12 bytes", stats)
+	}
+
+	gz, err := gzip.NewReader(&buf)
+	if err != nil {
+		t.Fatalf("gzip: %v", err)
+	}
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("tar: %v", err)
+		}
+		data, _ := io.ReadAll(tr)
+		if hdr.Name == "b.txt" && string(data) != "bbbbbbbb" {
+			t.Errorf("b.txt = %q, want the listed 8 bytes", data)
+		}
+	}
+}
+
+// failingPull 在写入一部分数据后拉取失败，模拟传输中途设备断开
+type failingPull struct {
+	*adbtest.Fake
+	path string
+}
+
+func (r *failingPull) Pull(ctx context.Context, serial string, remotePath string, w io.Writer) error {
+	if remotePath != r.path {
+		return r.Fake.Pull(ctx, serial, remotePath, w)
+	}
+	w.Write([]byte("bb"))
+	return adb.ErrOffline
+}
+
+func TestWriteArchiveAbortsOnPartialPull(t *testing.T) {
+	for _, format := range []adb.ArchiveFormat{adb.ArchiveZip, adb.ArchiveTarGz} {
+		f, entries := newArchiveFake(t)
+		r := &failingPull{Fake: f, path: "/sdcard/box/b.txt"}
+		stats, err := adb.WriteArchive(context.Background(), r, "emu-1", "/sdcard/box", format, entries, io.Discard)
+		if !errors.Is(err, adb.ErrOffline) {
+			t.Errorf("%s: WriteArchive = %v, want the pull error", format, err)
+		}
+		if stats.Files != 1 || stats.Bytes != 4 || len(stats.Skipped) != 0 {
+			t.Errorf("%s: stats = %+v, want only a.txt", format, stats)
+		}
+	}
+}
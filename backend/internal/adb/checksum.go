@@ -0,0 +1,49 @@
+package adb
+
+import (
+	"context"
+	"log"
+	"regexp"
+	"strings"
+)
+
+// md5Line 匹配 md5sum 输出的一行: 32 位十六进制、两个空格、路径
+var md5Line = regexp.MustCompile(`^([0-9a-f]{32})  (.+)$`)
+
+// maxChecksumArgs 是一条 md5sum 命令的参数总长度上限，超过时分多条命令执行
+const maxChecksumArgs = 64 * 1024
+
+// MD5Sums 在设备上计算文件的 MD5，返回路径到十六进制摘要的映射
+// 文件较多时分批执行 md5sum；无法读取的文件不会出现在结果中
+func MD5Sums(ctx context.Context, r Runner, deviceId string, paths []string) (map[string]string, error) {
+	for _, p := range paths {
+		if err := ValidateRemotePath(p); err != nil {
+			return nil, err
+		}
+	}
+	// 计算大文件的摘要需要读取整个文件
+	ctx = WithPriority(ctx, PriorityBulk)
+	sums := make(map[string]string, len(paths))
+	for start := 0; start < len(paths); {
+		end, length := start, 0
+		for end < len(paths) && (end == start || length+len(paths[end]) < maxChecksumArgs) {
+			length += len(paths[end]) + 3
+			end++
+		}
+		command := ShellCommand("md5sum", paths[start:end]...)
+		res, err := runShell(ctx, r, OpTransfer, deviceId, command)
+		if err != nil {
+			return nil, err
+		}
+		if res.ExitCode != 0 {
+			log.Printf("MD5Sums: Some files on device %s could not be read: %s", deviceId, strings.TrimSpace(string(res.Stderr)))
+		}
+		for _, line := range strings.Split(string(res.Stdout), "\n") {
+			if m := md5Line.FindStringSubmatch(line); m != nil {
+				sums[m[2]] = m[1]
+			}
+		}
+		start = end
+	}
+	return sums, nil
+}
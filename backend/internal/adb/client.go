@@ -0,0 +1,331 @@
+package adb
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultServerAddr 是本机 adb server 的默认监听地址
+const DefaultServerAddr = "localhost:5037"
+
+// Client 直接通过套接字与 adb server 通信，不再为每次调用启动 adb 进程
+type Client struct {
+	Addr        string
+	DialTimeout time.Duration
+}
+
+// NewClient 创建一个连接到指定 adb server 地址的客户端
+func NewClient(addr string) *Client {
+	if addr == "" {
+		addr = DefaultServerAddr
+	}
+	return &Client{Addr: addr, DialTimeout: 5 * time.Second}
+}
+
+// ctxConn 在 ctx 结束时关闭底层连接，使阻塞中的读写立即返回
+// 关闭连接后 adbd 会结束对应的服务，相当于杀掉了设备上的进程
+type ctxConn struct {
+	net.Conn
+	ctx  context.Context
+	stop func() bool
+}
+
+func newCtxConn(ctx context.Context, conn net.Conn) *ctxConn {
+	return &ctxConn{
+		Conn: conn,
+		ctx:  ctx,
+		stop: context.AfterFunc(ctx, func() { conn.Close() }),
+	}
+}
+
+// Read 在 ctx 已结束时返回 ctx.Err()，而不是连接被关闭导致的网络错误
+func (c *ctxConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	if err != nil && err != io.EOF {
+		if ctxErr := c.ctx.Err(); ctxErr != nil {
+			err = ctxErr
+		}
+	}
+	return n, err
+}
+
+func (c *ctxConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	if err != nil {
+		if ctxErr := c.ctx.Err(); ctxErr != nil {
+			err = ctxErr
+		}
+	}
+	return n, err
+}
+
+func (c *ctxConn) Close() error {
+	c.stop()
+	return c.Conn.Close()
+}
+
+func (c *Client) dial(ctx context.Context) (*ctxConn, error) {
+	dialer := net.Dialer{Timeout: c.DialTimeout}
+	conn, err := dialer.DialContext(ctx, "tcp", c.Addr)
+	if err != nil {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return nil, ctxErr
+		}
+		return nil, fmt.Errorf("%w at %s: %w", ErrServerUnavailable, c.Addr, err)
+	}
+	return newCtxConn(ctx, conn), nil
+}
+
+// hostCommand 发送一个 host: 服务请求并读取其长度前缀的响应
+func (c *Client) hostCommand(ctx context.Context, service string) (string, error) {
+	conn, err := c.dial(ctx)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+
+	if err := writeRequest(conn, service); err != nil {
+		return "", err
+	}
+	if err := readStatus(conn, service); err != nil {
+		return "", err
+	}
+	return readHexPrefixed(conn)
+}
+
+// Version 执行 host:version，返回 adb server 的内部版本号
+func (c *Client) Version(ctx context.Context) (int, error) {
+	resp, err := c.hostCommand(ctx, "host:version")
+	if err != nil {
+		return 0, err
+	}
+	v, err := strconv.ParseInt(resp, 16, 32)
+	if err != nil {
+		return 0, fmt.Errorf("adb: invalid version response %q", resp)
+	}
+	return int(v), nil
+}
+
+// Devices 执行 host:devices-l 并解析设备列表
+func (c *Client) Devices(ctx context.Context) ([]DeviceInfo, error) {
+	resp, err := c.hostCommand(ctx, "host:devices-l")
+	if err != nil {
+		return nil, err
+	}
+	return ParseDevices(resp)
+}
+
+// TrackDevices 通过 host:track-devices-l 订阅设备变化
+// 每当设备列表改变时 adb server 推送一份完整列表，fn 会以解析后的列表被调用
+// 该方法会一直阻塞，直到 ctx 结束或连接出错
+func (c *Client) TrackDevices(ctx context.Context, fn func([]DeviceInfo)) error {
+	conn, err := c.dial(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	service := "host:track-devices-l"
+	if err := writeRequest(conn, service); err != nil {
+		return err
+	}
+	if err := readStatus(conn, service); err != nil {
+		return err
+	}
+	for {
+		resp, err := readHexPrefixed(conn)
+		if err != nil {
+			return err
+		}
+		devices, err := ParseDevices(resp)
+		if err != nil {
+			return err
+		}
+		fn(devices)
+	}
+}
+
+// ParseDevices 解析 "devices -l" 格式的输出，每行形如
+// "emulator-5554          device product:sdk model:x device:y transport_id:1"
+// USB 设备还会带有 "usb:1-1.2" 字段；没有 -l 的简单格式同样可以解析
+func ParseDevices(out string) ([]DeviceInfo, error) {
+	var devices []DeviceInfo
+	scanner := bufio.NewScanner(strings.NewReader(out))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "List of devices") {
+			continue
+		}
+		parts := strings.Fields(line)
+		if len(parts) < 2 {
+			continue
+		}
+		d := DeviceInfo{ID: parts[0], Status: parts[1]}
+		for _, attr := range parts[2:] {
+			key, value, ok := strings.Cut(attr, ":")
+			if !ok {
+				continue
+			}
+			switch key {
+			case "product":
+				d.Product = value
+			case "model":
+				d.Model = value
+			case "device":
+				d.Device = value
+			case "transport_id":
+				d.TransportID = value
+			case "usb":
+				d.USB = value
+			}
+		}
+		devices = append(devices, d)
+	}
+	return devices, scanner.Err()
+}
+
+// transport 建立一个已切换到指定设备传输通道的连接
+func (c *Client) transport(ctx context.Context, serial string) (*ctxConn, error) {
+	conn, err := c.dial(ctx)
+	if err != nil {
+		return nil, err
+	}
+	service := "host:transport:" + serial
+	if err := writeRequest(conn, service); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if err := readStatus(conn, service); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+// openService 在设备上打开一个服务 (shell:, exec:, sync: 等)，返回原始数据流
+func (c *Client) openService(ctx context.Context, serial string, service string) (*ctxConn, error) {
+	if serial == "" {
+		return nil, fmt.Errorf("adb: device serial cannot be empty")
+	}
+	conn, err := c.transport(ctx, serial)
+	if err != nil {
+		return nil, err
+	}
+	if err := writeRequest(conn, service); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if err := readStatus(conn, service); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+// Shell 通过旧版 shell: 服务执行命令，stdout 与 stderr 混合返回，无法获得退出码
+func (c *Client) Shell(ctx context.Context, serial string, command string) ([]byte, error) {
+	conn, err := c.openService(ctx, serial, "shell:"+command)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	return io.ReadAll(conn)
+}
+
+// ShellResult 保存 shell,v2: 命令的执行结果
+type ShellResult struct {
+	Stdout   []byte
+	Stderr   []byte
+	ExitCode int
+}
+
+// Output 返回合并后的 stdout 与 stderr，与 adb 命令行的行为一致
+func (r *ShellResult) Output() string {
+	return strings.TrimSpace(string(r.Stdout) + "\n" + string(r.Stderr))
+}
+
+// shell,v2 协议的数据包类型
+const (
+	shellIDStdin      = 0
+	shellIDStdout     = 1
+	shellIDStderr     = 2
+	shellIDExit       = 3
+	shellIDCloseStdin = 4
+)
+
+// ShellV2 通过 shell,v2: 服务执行命令，分别返回 stdout、stderr 与退出码
+// 设备不支持 shell_v2 时回退到 shell:，此时 stderr 为空且退出码固定为 0
+func (c *Client) ShellV2(ctx context.Context, serial string, command string) (*ShellResult, error) {
+	conn, err := c.openService(ctx, serial, "shell,v2,raw:"+command)
+	if err != nil {
+		// 只有 adbd 不支持 shell,v2 时才回退，设备不存在或离线等错误直接返回
+		var serverErr *ServerError
+		if errors.As(err, &serverErr) && classifyMessage(serverErr.Message) == nil {
+			out, legacyErr := c.Shell(ctx, serial, command)
+			if legacyErr != nil {
+				return nil, legacyErr
+			}
+			return &ShellResult{Stdout: out}, nil
+		}
+		return nil, err
+	}
+	defer conn.Close()
+	return readShellV2(conn)
+}
+
+// readShellV2 读取 shell,v2 数据包直到收到退出码
+// 每个数据包为 1 字节类型 + 4 字节小端长度 + 数据
+func readShellV2(r io.Reader) (*ShellResult, error) {
+	var stdout, stderr bytes.Buffer
+	br := bufio.NewReader(r)
+	var header [5]byte
+	for {
+		if _, err := io.ReadFull(br, header[:]); err != nil {
+			if err == io.EOF {
+				return nil, fmt.Errorf("adb: shell,v2 stream ended without exit status")
+			}
+			return nil, err
+		}
+		size := binary.LittleEndian.Uint32(header[1:])
+		switch header[0] {
+		case shellIDStdout:
+			if _, err := io.CopyN(&stdout, br, int64(size)); err != nil {
+				return nil, err
+			}
+		case shellIDStderr:
+			if _, err := io.CopyN(&stderr, br, int64(size)); err != nil {
+				return nil, err
+			}
+		case shellIDExit:
+			payload := make([]byte, size)
+			if _, err := io.ReadFull(br, payload); err != nil {
+				return nil, err
+			}
+			code := 0
+			if len(payload) > 0 {
+				code = int(payload[0])
+			}
+			return &ShellResult{Stdout: stdout.Bytes(), Stderr: stderr.Bytes(), ExitCode: code}, nil
+		default:
+			if _, err := io.CopyN(io.Discard, br, int64(size)); err != nil {
+				return nil, err
+			}
+		}
+	}
+}
+
+// Exec 通过 exec: 服务执行命令，返回未经 pty 处理的原始 stdout 数据流
+// 适用于 screencap -p 等二进制输出，调用者负责关闭返回的流
+// ctx 结束时数据流会被关闭
+func (c *Client) Exec(ctx context.Context, serial string, command string) (io.ReadCloser, error) {
+	return c.openService(ctx, serial, "exec:"+command)
+}
@@ -0,0 +1,264 @@
+package adb_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fishyinhe/backend/internal/adb"
+	"fishyinhe/backend/internal/adb/adbtest"
+	"io"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestClient(t *testing.T) (*adbtest.Server, *adb.Client) {
+	t.Helper()
+	s := adbtest.NewServer()
+	t.Cleanup(s.Close)
+	return s, adb.NewClient(s.Addr())
+}
+
+func TestClientVersion(t *testing.T) {
+	s, c := newTestClient(t)
+	s.Version = 0x29
+	v, err := c.Version(context.Background())
+	if err != nil {
+		t.Fatalf("Version: %v", err)
+	}
+	if v != 41 {
+		t.Errorf("Version = %d, want 41", v)
+	}
+}
+
+func TestClientDevices(t *testing.T) {
+	s, c := newTestClient(t)
+	usb := s.AddDevice("R5CT1234ABC", "device")
+	usb.Attrs = "usb:1-1.2 product:a52qnsxx model:SM_A525F device:a52q transport_id:7"
+	s.AddDevice("emulator-5554", "unauthorized")
+
+	devices, err := c.Devices(context.Background())
+	if err != nil {
+		t.Fatalf("Devices: %v", err)
+	}
+	want := []adb.DeviceInfo{
+		{ID: "R5CT1234ABC", Status: "device", USB: "1-1.2", Product: "a52qnsxx", Model: "SM_A525F", Device: "a52q", TransportID: "7"},
+		{ID: "emulator-5554", Status: "unauthorized"},
+	}
+	if len(devices) != len(want) {
+		t.Fatalf("Devices = %+v, want %+v", devices, want)
+	}
+	for i := range want {
+		if devices[i] != want[i] {
+			t.Errorf("device %d = %+v, want %+v", i, devices[i], want[i])
+		}
+	}
+}
+
+func TestParseDevices(t *testing.T) {
+	out := "List of devices attached\n" +
+		"192.168.1.23:5555       device product:panther model:Pixel_7 device:panther transport_id:2\n" +
+		"0123456789ABCDEF\toffline\n" +
+		"\n" +
+		"garbage\n"
+	devices, err := adb.ParseDevices(out)
+	if err != nil {
+		t.Fatalf("ParseDevices: %v", err)
+	}
+	if len(devices) != 2 {
+		t.Fatalf("ParseDevices returned %d devices, want 2: %+v", len(devices), devices)
+	}
+	if d := devices[0]; d.ID != "192.168.1.23:5555" || d.Model != "Pixel_7" || d.TransportID != "2" || d.USB != "" {
+		t.Errorf("wireless device = %+v", d)
+	}
+	if d := devices[1]; d.ID != "0123456789ABCDEF" || d.Status != "offline" {
+		t.Errorf("offline device = %+v", d)
+	}
+}
+
+func TestClientTransportFailure(t *testing.T) {
+	s, c := newTestClient(t)
+	s.AddDevice("emu-unauthorized", "unauthorized")
+	s.AddDevice("emu-offline", "offline")
+
+	tests := []struct {
+		serial string
+		want   error
+	}{
+		{"missing", adb.ErrDeviceNotFound},
+		{"emu-unauthorized", adb.ErrUnauthorized},
+		{"emu-offline", adb.ErrOffline},
+	}
+	for _, tt := range tests {
+		t.Run(tt.serial, func(t *testing.T) {
+			_, err := c.ShellV2(context.Background(), tt.serial, "true")
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("ShellV2 error = %v, want %v", err, tt.want)
+			}
+			var serverErr *adb.ServerError
+			if !errors.As(err, &serverErr) || serverErr.Service != "host:transport:"+tt.serial {
+				t.Errorf("error %v is not a FAIL response to host:transport", err)
+			}
+		})
+	}
+}
+
+func TestClientServerUnavailable(t *testing.T) {
+	s := adbtest.NewServer()
+	c := adb.NewClient(s.Addr())
+	s.Close()
+	if _, err := c.Version(context.Background()); !errors.Is(err, adb.ErrServerUnavailable) {
+		t.Fatalf("Version error = %v, want ErrServerUnavailable", err)
+	}
+}
+
+func TestClientShellV2ExitCodes(t *testing.T) {
+	s, c := newTestClient(t)
+	s.AddCannedDevice("emu-1")
+	s.HandleShell("emu-1", "echo ok", adbtest.Response{Stdout: "ok\n"})
+	s.HandleShell("emu-1", "ls /nope", adbtest.Response{Stderr: "ls: /nope: No such file or directory\n", ExitCode: 1})
+	s.HandleShell("emu-1", "exit 255", adbtest.Response{ExitCode: 255})
+
+	tests := []struct {
+		command  string
+		stdout   string
+		stderr   string
+		exitCode int
+	}{
+		{"echo ok", "ok\n", "", 0},
+		{"ls /nope", "", "ls: /nope: No such file or directory\n", 1},
+		{"exit 255", "", "", 255},
+		{"frobnicate", "", "/system/bin/sh: frobnicate: not found\n", 127},
+	}
+	for _, tt := range tests {
+		t.Run(tt.command, func(t *testing.T) {
+			res, err := c.ShellV2(context.Background(), "emu-1", tt.command)
+			if err != nil {
+				t.Fatalf("ShellV2: %v", err)
+			}
+			if string(res.Stdout) != tt.stdout || string(res.Stderr) != tt.stderr || res.ExitCode != tt.exitCode {
+				t.Errorf("ShellV2(%q) = {%q %q %d}, want {%q %q %d}", tt.command, res.Stdout, res.Stderr, res.ExitCode, tt.stdout, tt.stderr, tt.exitCode)
+			}
+		})
+	}
+}
+
+func TestClientShellCancel(t *testing.T) {
+	s, c := newTestClient(t)
+	s.AddCannedDevice("emu-1")
+	s.HandleShell("emu-1", "sleep 1", adbtest.Response{Delay: time.Second})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err := c.ShellV2(ctx, "emu-1", "sleep 1")
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("ShellV2 error = %v, want context.DeadlineExceeded", err)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("ShellV2 returned after %s, want it to stop when ctx ends", elapsed)
+	}
+}
+
+func TestClientExec(t *testing.T) {
+	s, c := newTestClient(t)
+	s.AddCannedDevice("emu-1")
+	rc, err := c.Exec(context.Background(), "emu-1", "screencap -p")
+	if err != nil {
+		t.Fatalf("Exec: %v", err)
+	}
+	defer rc.Close()
+	data, err := io.ReadAll(rc)
+	if err != nil {
+		t.Fatalf("reading exec stream: %v", err)
+	}
+	if !bytes.Equal(data, adbtest.CannedScreenshot) {
+		t.Errorf("Exec returned %d bytes, want the %d byte canned screenshot", len(data), len(adbtest.CannedScreenshot))
+	}
+}
+
+func TestClientSync(t *testing.T) {
+	s, c := newTestClient(t)
+	s.AddCannedDevice("emu-1")
+	ctx := context.Background()
+	// 大于一个 sync 数据块 (64 KiB)，覆盖多个 DATA 包
+	payload := bytes.Repeat([]byte("0123456789abcdef"), 10000)
+	mtime := time.Unix(1715600000, 0)
+
+	if err := c.Push(ctx, "emu-1", bytes.NewReader(payload), "/sdcard/Download/big.bin", 0600, mtime); err != nil {
+		t.Fatalf("Push: %v", err)
+	}
+	if got, ok := s.ReadFile("emu-1", "/sdcard/Download/big.bin"); !ok || !bytes.Equal(got, payload) {
+		t.Fatalf("pushed file has %d bytes (exists: %v), want %d", len(got), ok, len(payload))
+	}
+
+	st, err := c.Stat(ctx, "emu-1", "/sdcard/Download/big.bin")
+	if err != nil {
+		t.Fatalf("Stat: %v", err)
+	}
+	if !st.Exists() || st.Size != int64(len(payload)) || st.Mode != 0600 || !st.ModTime.Equal(mtime) {
+		t.Errorf("Stat = %+v, want size %d, mode 0600, mtime %s", st, len(payload), mtime)
+	}
+	if st, err := c.Stat(ctx, "emu-1", "/sdcard/Download"); err != nil || !st.Mode.IsDir() {
+		t.Errorf("Stat(dir) = %+v, %v, want a directory", st, err)
+	}
+	if st, err := c.Stat(ctx, "emu-1", "/sdcard/missing.txt"); err != nil || st.Exists() {
+		t.Errorf("Stat(missing) = %+v, %v, want a zero stat", st, err)
+	}
+
+	var buf bytes.Buffer
+	if err := c.Pull(ctx, "emu-1", "/sdcard/Download/big.bin", &buf); err != nil {
+		t.Fatalf("Pull: %v", err)
+	}
+	if !bytes.Equal(buf.Bytes(), payload) {
+		t.Errorf("Pull returned %d bytes, want %d", buf.Len(), len(payload))
+	}
+
+	err = c.Pull(ctx, "emu-1", "/sdcard/missing.txt", io.Discard)
+	if !errors.Is(err, adb.ErrPathNotFound) {
+		t.Errorf("Pull(missing) error = %v, want ErrPathNotFound", err)
+	}
+}
+
+func TestClientPushFileMode(t *testing.T) {
+	s, c := newTestClient(t)
+	s.AddCannedDevice("emu-1")
+	if err := c.Push(context.Background(), "emu-1", strings.NewReader("#!/bin/sh\n"), "/data/local/tmp/run.sh", os.FileMode(0755), time.Now()); err != nil {
+		t.Fatalf("Push: %v", err)
+	}
+	st, err := c.Stat(context.Background(), "emu-1", "/data/local/tmp/run.sh")
+	if err != nil || st.Mode != 0755 {
+		t.Errorf("Stat = %+v, %v, want mode 0755", st, err)
+	}
+}
+
+func TestClientStatV1Fallback(t *testing.T) {
+	s, c := newTestClient(t)
+	s.DisableSTA2 = true
+	s.AddCannedDevice("emu-1")
+	ctx := context.Background()
+	mtime := time.Unix(1715600000, 0)
+	if err := c.Push(ctx, "emu-1", strings.NewReader("hello"), "/sdcard/a.txt", 0644, mtime); err != nil {
+		t.Fatalf("Push: %v", err)
+	}
+
+	st, err := c.Stat(ctx, "emu-1", "/sdcard/a.txt")
+	if err != nil || st.Size != 5 || st.Mode != 0644 || !st.ModTime.Equal(mtime) || st.SizeInexact {
+		t.Errorf("Stat = %+v, %v, want size 5, mode 0644, mtime %s", st, err, mtime)
+	}
+	if st, err := c.Stat(ctx, "emu-1", "/sdcard/missing.txt"); err != nil || st.Exists() {
+		t.Errorf("Stat(missing) = %+v, %v, want a zero stat", st, err)
+	}
+
+	// STAT 只返回低 32 位，完整大小来自 stat 命令
+	s.HandleShell("emu-1", "stat -c %s /sdcard/a.txt", adbtest.Response{Stdout: "5000000005\n"})
+	if st, err := c.Stat(ctx, "emu-1", "/sdcard/a.txt"); err != nil || st.Size != 5000000005 || st.SizeInexact {
+		t.Errorf("Stat(large) = %+v, %v, want size 5000000005", st, err)
+	}
+
+	s.HandleShell("emu-1", "stat -c %s /sdcard/a.txt", adbtest.Response{Stdout: "/system/bin/sh: stat: not found\n", ExitCode: 127})
+	if st, err := c.Stat(ctx, "emu-1", "/sdcard/a.txt"); err != nil || st.Size != 5 || !st.SizeInexact {
+		t.Errorf("Stat(no stat command) = %+v, %v, want the STAT size marked inexact", st, err)
+	}
+}
@@ -0,0 +1,145 @@
+package adb
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// adb 操作可能返回的错误类别，使用 errors.Is 判断
+var (
+	ErrDeviceNotFound   = errors.New("device not found")
+	ErrUnauthorized     = errors.New("device unauthorized")
+	ErrOffline          = errors.New("device offline")
+	ErrPathNotFound     = errors.New("path not found")
+	ErrPermissionDenied = errors.New("permission denied")
+	ErrInstallFailed    = errors.New("install failed")
+	ErrTimeout          = errors.New("operation timed out")
+	ErrConnectFailed    = errors.New("connect failed")
+	ErrPairFailed       = errors.New("pairing failed")
+	ErrNoWifiAddress    = errors.New("device has no wlan0 address")
+	ErrInvalidArgument  = errors.New("invalid argument")
+	ErrArchiveTooLarge  = errors.New("archive too large")
+	ErrAlreadyExists    = errors.New("already exists")
+	ErrFileTooLarge     = errors.New("file too large")
+	ErrNotText          = errors.New("not a text file")
+	ErrConflict         = errors.New("file changed on device")
+	ErrNoThumbnail      = errors.New("no thumbnail available")
+	ErrNotDebuggable    = errors.New("package is not debuggable")
+	ErrPackageNotFound  = errors.New("package not found")
+
+	// ErrServerUnavailable 表示无法连接到 adb server
+	ErrServerUnavailable = errors.New("adb: cannot connect to adb server")
+)
+
+// deviceNotFound 匹配 adb server 找不到设备时的完整错误信息: "device 'emulator-5554' not found" 或 "device not found"
+// 只匹配整条信息，避免把 shell 的 stderr (例如 "/dev/block/...: device node not found") 误判为设备不存在
+var deviceNotFound = regexp.MustCompile(`^(error: )?device ('[^']*' )?not found$`)
+
+// classifyMessage 根据 adb server、adbd 或设备上 shell 的错误信息判断错误类别
+func classifyMessage(msg string) error {
+	lower := strings.ToLower(msg)
+	switch {
+	case strings.Contains(lower, "run-as: package not debuggable"),
+		strings.Contains(lower, "run-as: package") && strings.Contains(lower, "is not debuggable"):
+		// 新旧两种 run-as 的错误信息，Android 6 及以前为 "run-as: Package 'com.example' is not debuggable"
+		return ErrNotDebuggable
+	case strings.Contains(lower, "run-as: unknown package"),
+		strings.Contains(lower, "run-as: package") && strings.Contains(lower, "is unknown"):
+		// 新旧两种 run-as 的错误信息，例如 "run-as: Package 'com.example' is unknown"
+		return ErrPackageNotFound
+	case strings.Contains(lower, "unauthorized"):
+		return ErrUnauthorized
+	case strings.Contains(lower, "device offline"), strings.Contains(lower, "device still connecting"):
+		return ErrOffline
+	case deviceNotFound.MatchString(strings.TrimSpace(lower)),
+		strings.Contains(lower, "no devices/emulators found"),
+		strings.Contains(lower, "no such device '"):
+		return ErrDeviceNotFound
+	case strings.Contains(lower, "no such file or directory"),
+		strings.Contains(lower, "not a directory"),
+		strings.Contains(lower, "does not exist"):
+		return ErrPathNotFound
+	case strings.Contains(lower, "permission denied"),
+		strings.Contains(lower, "read-only file system"),
+		strings.Contains(lower, "operation not permitted"):
+		return ErrPermissionDenied
+	case strings.Contains(lower, "file exists"):
+		return ErrAlreadyExists
+	case strings.Contains(lower, "is a directory"):
+		// 例如不带 -r 删除目录
+		return ErrInvalidArgument
+	}
+	return nil
+}
+
+// Is 使 errors.Is(err, ErrDeviceNotFound) 等判断对 adb server 的 FAIL 响应成立
+func (e *ServerError) Is(target error) bool {
+	return target != nil && classifyMessage(e.Message) == target
+}
+
+// ShellError 表示设备上的命令以非零退出码结束
+type ShellError struct {
+	Command  string
+	ExitCode int
+	Stderr   string
+}
+
+func (e *ShellError) Error() string {
+	if e.Stderr == "" {
+		return fmt.Sprintf("adb: %q exited with %d", e.Command, e.ExitCode)
+	}
+	return fmt.Sprintf("adb: %q exited with %d: %s", e.Command, e.ExitCode, e.Stderr)
+}
+
+// Is 根据 stderr 的内容识别路径不存在、权限不足等错误
+func (e *ShellError) Is(target error) bool {
+	return target != nil && classifyMessage(e.Stderr) == target
+}
+
+// checkShell 在命令失败或 stderr 表明出错时返回 *ShellError
+// 一些命令 (例如 toybox ls) 对部分失败仍返回 0，因此 stderr 中的已知错误也视为失败
+func checkShell(command string, res *ShellResult) error {
+	stderr := strings.TrimSpace(string(res.Stderr))
+	if res.ExitCode == 0 && classifyMessage(stderr) == nil {
+		return nil
+	}
+	if stderr == "" {
+		// 旧版 shell: 协议下 stderr 混在 stdout 中
+		stderr = strings.TrimSpace(string(res.Stdout))
+	}
+	return &ShellError{Command: command, ExitCode: res.ExitCode, Stderr: stderr}
+}
+
+// InstallError 表示 pm install 报告了失败，Reason 为 INSTALL_FAILED_* 等错误码
+type InstallError struct {
+	Reason string
+	Output string
+}
+
+func (e *InstallError) Error() string {
+	if e.Reason == "" {
+		return fmt.Sprintf("adb: install failed: %s", e.Output)
+	}
+	return fmt.Sprintf("adb: install failed (%s): %s", e.Reason, e.Output)
+}
+
+// Is 使 errors.Is(err, ErrInstallFailed) 成立
+func (e *InstallError) Is(target error) bool {
+	return target == ErrInstallFailed
+}
+
+// installFailure 匹配 pm install 的失败输出，例如 "Failure [INSTALL_FAILED_OLDER_SDK: ...]"
+var installFailure = regexp.MustCompile(`Failure \[([A-Z0-9_]+)`)
+
+// parseInstallOutput 在 pm install 输出表明失败时返回 *InstallError
+func parseInstallOutput(output string, exitCode int) error {
+	if m := installFailure.FindStringSubmatch(output); m != nil {
+		return &InstallError{Reason: m[1], Output: output}
+	}
+	if exitCode != 0 {
+		return &InstallError{Output: output}
+	}
+	return nil
+}
@@ -0,0 +1,51 @@
+package adb
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestClassifyMessage(t *testing.T) {
+	tests := []struct {
+		msg  string
+		want error
+	}{
+		{"device 'emulator-5554' not found", ErrDeviceNotFound},
+		{"device '192.168.1.23:5555' not found\n", ErrDeviceNotFound},
+		{"device not found", ErrDeviceNotFound},
+		{"error: device not found", ErrDeviceNotFound},
+		{"no devices/emulators found", ErrDeviceNotFound},
+		{"no such device '192.168.1.23:5555'", ErrDeviceNotFound},
+		{"device unauthorized.\nThis adb server's $ADB_VENDOR_KEYS is not set", ErrUnauthorized},
+		{"device offline", ErrOffline},
+		{"device still connecting", ErrOffline},
+		{"ls: /sdcard/nope: No such file or directory", ErrPathNotFound},
+		{"rm: /system/bin/sh: Read-only file system", ErrPermissionDenied},
+		{"mkdir: '/sdcard/a': File exists", ErrAlreadyExists},
+		{"run-as: package not debuggable: com.example.app", ErrNotDebuggable},
+		{"run-as: Package 'com.example.app' is not debuggable", ErrNotDebuggable},
+		{"run-as: Package 'com.example.app' is unknown", ErrPackageNotFound},
+		{"run-as: unknown package: com.example.app", ErrPackageNotFound},
+		// shell 的 stderr 中出现 device 与 not found 不表示设备不存在
+		{"mount: /dev/block/dm-7: device node not found", nil},
+		{"blockdev: /dev/block/sda: device not found or busy", nil},
+		{"cat: /dev/input/event9: No such device", nil},
+		{"", nil},
+	}
+	for _, tt := range tests {
+		if got := classifyMessage(tt.msg); got != tt.want {
+			t.Errorf("classifyMessage(%q) = %v, want %v", tt.msg, got, tt.want)
+		}
+	}
+}
+
+func TestShellErrorIs(t *testing.T) {
+	err := checkShell("ls /dev/block/by-name", &ShellResult{Stderr: []byte("ls: /dev/block/by-name/x: device node not found\n"), ExitCode: 1})
+	if errors.Is(err, ErrDeviceNotFound) {
+		t.Errorf("shell stderr %v was classified as a missing device", err)
+	}
+	var shellErr *ShellError
+	if !errors.As(err, &shellErr) || shellErr.ExitCode != 1 {
+		t.Errorf("checkShell = %v, want a *ShellError with exit code 1", err)
+	}
+}
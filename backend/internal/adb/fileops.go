@@ -0,0 +1,154 @@
+package adb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// protectedPaths 是不允许删除、移动、覆盖或修改权限的存储根目录与系统目录 (一级目录已由 checkModifiable 拒绝)
+var protectedPaths = map[string]bool{
+	"/storage/emulated":     true,
+	"/storage/emulated/0":   true,
+	"/storage/self":         true,
+	"/storage/self/primary": true,
+	"/mnt/sdcard":           true,
+	"/data/media":           true,
+	"/data/media/0":         true,
+	"/data/local":           true,
+	"/data/local/tmp":       true,
+	"/data/data":            true,
+	"/data/user":            true,
+	"/data/user/0":          true,
+	"/data/app":             true,
+}
+
+// checkModifiable 拒绝根目录、一级目录与存储根目录，它们不应作为删除、移动、复制目标或修改权限的对象
+func checkModifiable(p string) error {
+	if err := ValidateRemotePath(p); err != nil {
+		return err
+	}
+	clean := path.Clean(p)
+	if clean == "/" || strings.Count(clean, "/") < 2 || protectedPaths[clean] {
+		return fmt.Errorf("%w: refusing to modify %s", ErrInvalidArgument, clean)
+	}
+	return nil
+}
+
+// runFileOp 执行一条文件操作命令，命令失败时返回 *ShellError
+func runFileOp(ctx context.Context, r Runner, class OpClass, deviceId string, command string) error {
+	res, err := runShell(ctx, r, class, deviceId, command)
+	if err == nil {
+		err = checkShell(command, res)
+	}
+	return err
+}
+
+// pathExists 通过 sync STAT 判断远程路径是否存在
+func pathExists(ctx context.Context, r Runner, deviceId string, p string) (*RemoteStat, error) {
+	st, err := StatFile(ctx, r, deviceId, p)
+	if errors.Is(err, ErrPathNotFound) {
+		return nil, nil
+	}
+	return st, err
+}
+
+// MakeDir 在设备上创建目录及其不存在的父目录，目录已存在时不报错
+func MakeDir(ctx context.Context, r Runner, deviceId string, dir string) error {
+	if err := ValidateRemotePath(dir); err != nil {
+		return err
+	}
+	return runFileOp(ctx, r, OpShell, deviceId, ShellCommand("mkdir -p", dir))
+}
+
+// RemovePath 删除文件，recursive 为 true 时可以删除目录及其内容
+// 存储根目录等受保护的路径返回 ErrInvalidArgument，路径不存在时返回 ErrPathNotFound
+func RemovePath(ctx context.Context, r Runner, deviceId string, p string, recursive bool) error {
+	if err := checkModifiable(p); err != nil {
+		return err
+	}
+	if recursive {
+		// 删除大目录可能需要较长时间
+		return runFileOp(WithPriority(ctx, PriorityBulk), r, OpTransfer, deviceId, ShellCommand("rm -r", p))
+	}
+	return runFileOp(ctx, r, OpShell, deviceId, ShellCommand("rm", p))
+}
+
+// checkDestination 在 overwrite 为 false 时拒绝已存在的目标，并且从不覆盖已存在的目录
+// (mv/cp 会把来源放进该目录而不是替换它)
+func checkDestination(ctx context.Context, r Runner, deviceId string, src string, dst string, overwrite bool) error {
+	if err := checkModifiable(dst); err != nil {
+		return err
+	}
+	if strings.HasPrefix(path.Clean(dst)+"/", path.Clean(src)+"/") {
+		return fmt.Errorf("%w: cannot move or copy %s into itself", ErrInvalidArgument, src)
+	}
+	st, err := pathExists(ctx, r, deviceId, dst)
+	if err != nil {
+		return err
+	}
+	if st != nil && (!overwrite || st.Mode.IsDir()) {
+		return fmt.Errorf("%w: %s", ErrAlreadyExists, dst)
+	}
+	return nil
+}
+
+// MovePath 将 src 移动或重命名为 dst (完整的目标路径，而不是目标目录)
+// overwrite 为 false 时目标已存在返回 ErrAlreadyExists
+func MovePath(ctx context.Context, r Runner, deviceId string, src string, dst string, overwrite bool) error {
+	if err := checkModifiable(src); err != nil {
+		return err
+	}
+	if err := checkDestination(ctx, r, deviceId, src, dst, overwrite); err != nil {
+		return err
+	}
+	// 跨文件系统的移动需要复制数据
+	return runFileOp(WithPriority(ctx, PriorityBulk), r, OpTransfer, deviceId, ShellCommand("mv -f", src, dst))
+}
+
+// CopyPath 将文件或目录 src 复制为 dst (完整的目标路径)，目录会被递归复制
+// overwrite 为 false 时目标已存在返回 ErrAlreadyExists
+func CopyPath(ctx context.Context, r Runner, deviceId string, src string, dst string, overwrite bool) error {
+	if err := ValidateRemotePath(src); err != nil {
+		return err
+	}
+	if err := checkDestination(ctx, r, deviceId, src, dst, overwrite); err != nil {
+		return err
+	}
+	return runFileOp(WithPriority(ctx, PriorityBulk), r, OpTransfer, deviceId, ShellCommand("cp -Rf", src, dst))
+}
+
+// chmodMode 匹配八进制 (644、0755) 或符号形式 (u+x、go-w,a+r) 的权限
+var chmodMode = regexp.MustCompile(`^([0-7]{3,4}|[ugoa]*[-+=][rwxXst]*(,[ugoa]*[-+=][rwxXst]*)*)$`)
+
+// ChmodPath 修改权限，mode 为八进制或符号形式，recursive 为 true 时同时修改目录下的全部条目
+func ChmodPath(ctx context.Context, r Runner, deviceId string, p string, mode string, recursive bool) error {
+	// chmod -R 000 /sdcard 会让整个存储不可用
+	if err := checkModifiable(p); err != nil {
+		return err
+	}
+	if !chmodMode.MatchString(mode) {
+		return fmt.Errorf("%w: invalid mode %q", ErrInvalidArgument, mode)
+	}
+	if recursive {
+		return runFileOp(WithPriority(ctx, PriorityBulk), r, OpTransfer, deviceId, ShellCommand("chmod -R", mode, p))
+	}
+	return runFileOp(ctx, r, OpShell, deviceId, ShellCommand("chmod", mode, p))
+}
+
+// TouchPath 更新修改时间，文件不存在时创建空文件；mtime 为零时使用设备的当前时间
+func TouchPath(ctx context.Context, r Runner, deviceId string, p string, mtime time.Time) error {
+	if err := ValidateRemotePath(p); err != nil {
+		return err
+	}
+	if mtime.IsZero() {
+		return runFileOp(ctx, r, OpShell, deviceId, ShellCommand("touch", p))
+	}
+	// toybox touch -d 接受 YYYY-MM-DDThh:mm:SS[.frac][tz]
+	stamp := mtime.UTC().Format("2006-01-02T15:04:05Z")
+	return runFileOp(ctx, r, OpShell, deviceId, ShellCommand("touch -d", stamp, p))
+}
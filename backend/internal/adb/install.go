@@ -0,0 +1,140 @@
+package adb
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// ObbDir 是设备上 OBB 扩展文件的根目录，应用 pkg 的文件位于 ObbDir/<pkg>/
+const ObbDir = "/sdcard/Android/obb"
+
+// installSession 匹配 pm install-create 的输出，例如 "Success: created install session [1234]"
+var installSession = regexp.MustCompile(`\[(\d+)\]`)
+
+// InstallMultiple 通过 pm 安装会话 (install-create、install-write、install-commit) 一次安装基础 APK 与拆分 APK，
+// 效果与 adb install-multiple -r -g 相同；localPaths 是这些 APK 在服务器上的路径，第一个应为基础 APK
+// 任何一步失败时放弃会话，设备上不会留下安装了一部分的应用
+func InstallMultiple(ctx context.Context, r Runner, deviceId string, localPaths []string) (string, error) {
+	if deviceId == "" || len(localPaths) == 0 {
+		return "", fmt.Errorf("%w: InstallMultiple: deviceId and at least one APK are required", ErrInvalidArgument)
+	}
+	sizes := make([]int64, len(localPaths))
+	var total int64
+	for i, p := range localPaths {
+		st, err := os.Stat(p)
+		if err != nil {
+			log.Printf("InstallMultiple: Error - Local APK file not available on server at path %s: %v", p, err)
+			return "", fmt.Errorf("local APK file not available on server: %w", err)
+		}
+		sizes[i] = st.Size()
+		total += st.Size()
+	}
+
+	ctx, cancel := withTimeout(WithPriority(ctx, PriorityBulk), OpInstall)
+	defer cancel()
+
+	// 与 InstallAPK 一样先推送到 /data/local/tmp，再由 pm install-write 从文件读取
+	stamp := time.Now().UnixNano()
+	remotePaths := make([]string, 0, len(localPaths))
+	defer func() {
+		if len(remotePaths) == 0 {
+			return
+		}
+		cleanupCtx, cleanupCancel := withTimeout(context.WithoutCancel(ctx), OpShell)
+		defer cleanupCancel()
+		if _, err := r.ShellV2(cleanupCtx, deviceId, ShellCommand("rm -f", remotePaths...)); err != nil {
+			log.Printf("InstallMultiple: Failed to remove staged APKs from device '%s': %v", deviceId, err)
+		}
+	}()
+	for i, p := range localPaths {
+		remote := fmt.Sprintf("/data/local/tmp/install_%d_%d.apk", stamp, i)
+		log.Printf("InstallMultiple: Pushing '%s' to device '%s' at '%s'", p, deviceId, remote)
+		if err := PushFile(ctx, r, deviceId, p, remote); err != nil {
+			return "", fmt.Errorf("adb install-multiple failed to push APK: %w", wrapTimeout(ctx, OpInstall, "install", err))
+		}
+		remotePaths = append(remotePaths, remote)
+	}
+
+	pm := func(args ...string) (*ShellResult, error) {
+		res, err := r.ShellV2(ctx, deviceId, ShellCommand("pm", args...))
+		if err = wrapTimeout(ctx, OpInstall, "install", err); err != nil {
+			log.Printf("InstallMultiple: Failed to run pm %s on device '%s': %v", args[0], deviceId, err)
+			return nil, fmt.Errorf("adb install-multiple command execution failed: %w", err)
+		}
+		return res, nil
+	}
+
+	res, err := pm("install-create", "-r", "-g", "-S", fmt.Sprint(total))
+	if err != nil {
+		return "", err
+	}
+	output := res.Output()
+	m := installSession.FindStringSubmatch(output)
+	if m == nil {
+		log.Printf("InstallMultiple: pm install-create on device '%s' did not return a session. Output: %s", deviceId, output)
+		if err := parseInstallOutput(output, res.ExitCode); err != nil {
+			return output, err
+		}
+		return output, &InstallError{Output: output}
+	}
+	session := m[1]
+	committed := false
+	defer func() {
+		if committed {
+			return
+		}
+		abandonCtx, abandonCancel := withTimeout(context.WithoutCancel(ctx), OpShell)
+		defer abandonCancel()
+		if _, err := r.ShellV2(abandonCtx, deviceId, ShellCommand("pm install-abandon", session)); err != nil {
+			log.Printf("InstallMultiple: Failed to abandon install session %s on device '%s': %v", session, deviceId, err)
+		}
+	}()
+
+	for i, remote := range remotePaths {
+		res, err := pm("install-write", "-S", fmt.Sprint(sizes[i]), session, fmt.Sprintf("%d_%s", i, path.Base(localPaths[i])), remote)
+		if err != nil {
+			return "", err
+		}
+		if out := res.Output(); res.ExitCode != 0 || !strings.Contains(out, "Success") {
+			log.Printf("InstallMultiple: pm install-write of '%s' into session %s on device '%s' failed: %s", localPaths[i], session, deviceId, out)
+			if err := parseInstallOutput(out, res.ExitCode); err != nil {
+				return out, err
+			}
+			return out, &InstallError{Output: out}
+		}
+	}
+
+	res, err = pm("install-commit", session)
+	if err != nil {
+		return "", err
+	}
+	committed = true
+	output = res.Output()
+	log.Printf("InstallMultiple: 'pm install-commit' of session %s (%d APKs) on device '%s' finished. Output: %s", session, len(localPaths), deviceId, output)
+	if err := parseInstallOutput(output, res.ExitCode); err != nil {
+		return output, err
+	}
+	return output, nil
+}
+
+// PushOBB 将服务器上的 OBB 扩展文件推送到应用 pkg 的 OBB 目录，返回设备上的路径
+func PushOBB(ctx context.Context, r Runner, deviceId string, pkg string, localPath string, name string) (string, error) {
+	if err := ValidatePackageName(pkg); err != nil {
+		return "", err
+	}
+	name = path.Base(name)
+	if name == "" || name == "." || name == "/" || name == ".." {
+		return "", fmt.Errorf("%w: invalid OBB file name %q", ErrInvalidArgument, name)
+	}
+	remote := path.Join(ObbDir, pkg, name)
+	if err := PushFile(ctx, r, deviceId, localPath, remote); err != nil {
+		return "", err
+	}
+	return remote, nil
+}
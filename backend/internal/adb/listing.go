@@ -0,0 +1,181 @@
+package adb
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"log"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// AdbFileItem 存储文件或目录信息
+type AdbFileItem struct {
+	Name  string `json:"name"`
+	IsDir bool   `json:"isDir"` // 指向目录的符号链接也视为目录
+
+	Size       int64     `json:"size"`
+	Mode       string    `json:"mode"` // ls 格式的权限，例如 drwxrwx--x
+	Owner      string    `json:"owner"`
+	Group      string    `json:"group"`
+	ModTime    time.Time `json:"modTime"`
+	IsLink     bool      `json:"isLink,omitempty"`
+	LinkTarget string    `json:"linkTarget,omitempty"`
+}
+
+// lsLongLine 匹配 toybox "ls -la [--full-time]" 的一行输出:
+// 权限 [链接数] 所有者 组 [大小 | 主设备号, 次设备号] 日期 时间 [时区] 名称
+var lsLongLine = regexp.MustCompile(`^([-bcdlps][-rwxsStT]{9})[.+@]?\s+(?:\d+\s+)?(\S+)\s+(\S+)\s+(?:(\d+)\s+|\d+,\s*\d+\s+)?(\d{4}-\d{2}-\d{2} \d{2}:\d{2}(?::\d{2}(?:\.\d+)?)?)(?: ([-+]\d{4}))? (.+)$`)
+
+// parseLsLong 解析 "ls -la" 的输出，跳过 total 行以及 . 与 ..
+func parseLsLong(output string) []AdbFileItem {
+	var files []AdbFileItem
+	scanner := bufio.NewScanner(strings.NewReader(output))
+	for scanner.Scan() {
+		item, ok := parseLsLine(scanner.Text())
+		if !ok || item.Name == "." || item.Name == ".." {
+			continue
+		}
+		files = append(files, item)
+	}
+	return files
+}
+
+// parseLsLine 解析 "ls -l" 输出中的一行，不是条目的行 (例如 total 行) 返回 false
+func parseLsLine(line string) (AdbFileItem, bool) {
+	m := lsLongLine.FindStringSubmatch(strings.TrimRight(line, "\r"))
+	if m == nil {
+		return AdbFileItem{}, false
+	}
+	item := AdbFileItem{Mode: m[1], Owner: m[2], Group: m[3], Name: m[7]}
+	item.Size, _ = strconv.ParseInt(m[4], 10, 64)
+	item.ModTime = parseLsTime(m[5], m[6])
+	switch item.Mode[0] {
+	case 'd':
+		item.IsDir = true
+	case 'l':
+		item.IsLink = true
+		if name, target, ok := strings.Cut(item.Name, " -> "); ok {
+			item.Name, item.LinkTarget = name, target
+		}
+	}
+	return item, true
+}
+
+// parseLsTime 解析 --full-time 的 "2006-01-02 15:04:05.000000000 -0700"，
+// 以及不支持 --full-time 时的 "2006-01-02 15:04" (此时没有时区信息，按 UTC 处理)
+func parseLsTime(stamp string, zone string) time.Time {
+	layout := "2006-01-02 15:04"
+	if strings.Count(stamp, ":") == 2 {
+		layout = "2006-01-02 15:04:05.999999999"
+	}
+	if zone != "" {
+		t, _ := time.Parse(layout+" -0700", stamp+" "+zone)
+		return t
+	}
+	t, _ := time.Parse(layout, stamp)
+	return t
+}
+
+// ListFiles 在设备上执行 "ls -la --full-time <path>/" 并解析输出，返回大小、权限、所有者与修改时间
+// 旧版 toybox 不支持 --full-time 时退回 "ls -la" (时间精确到分钟)
+// 部分条目无法访问时 ls 以非零退出码返回，只要有输出就返回能读取到的条目
+func ListFiles(ctx context.Context, r Runner, deviceId string, remotePath string) ([]AdbFileItem, error) {
+	if remotePath == "" {
+		remotePath = "/"
+	}
+	if err := ValidateRemotePath(remotePath); err != nil {
+		return nil, err
+	}
+	// 以 / 结尾使 ls 列出符号链接 (例如 /sdcard) 指向的目录内容，而不是链接本身
+	dir := remotePath
+	if !strings.HasSuffix(dir, "/") {
+		dir += "/"
+	}
+
+	command := ShellCommand("ls -la --full-time", dir)
+	res, err := runShell(ctx, r, OpShell, deviceId, command)
+	if err == nil && res.ExitCode != 0 && strings.Contains(string(res.Stderr), "full-time") {
+		log.Printf("ListFiles: Device %s does not support ls --full-time, falling back to ls -la", deviceId)
+		command = ShellCommand("ls -la", dir)
+		res, err = runShell(ctx, r, OpShell, deviceId, command)
+	}
+	if err != nil {
+		log.Printf("Error running ls for device %s, path %s: %v", deviceId, remotePath, err)
+		return nil, err
+	}
+	if len(res.Stdout) == 0 || res.ExitCode == 0 {
+		if err := checkShell(command, res); err != nil {
+			log.Printf("Error listing device %s, path %s: %v", deviceId, remotePath, err)
+			return nil, err
+		}
+	} else {
+		log.Printf("ListFiles: Partial listing for device %s, path %s: %s", deviceId, remotePath, strings.TrimSpace(string(res.Stderr)))
+	}
+
+	files := parseLsLong(string(res.Stdout))
+	resolveLinkedDirs(ctx, r, deviceId, dir, files)
+	return files, nil
+}
+
+// resolveLinkedDirs 通过一次 "ls -dpL" 判断哪些符号链接指向目录
+// 失败 (例如链接已失效) 时保持 IsDir 为 false
+func resolveLinkedDirs(ctx context.Context, r Runner, deviceId string, dir string, files []AdbFileItem) {
+	var paths []string
+	index := make(map[string]int)
+	for i, f := range files {
+		if f.IsLink {
+			p := path.Join(dir, f.Name)
+			paths = append(paths, p)
+			index[p] = i
+		}
+	}
+	if len(paths) == 0 {
+		return
+	}
+	res, err := runShell(ctx, r, OpShell, deviceId, ShellCommand("ls -dpL", paths...))
+	if err != nil {
+		log.Printf("ListFiles: Failed to resolve symlinks in %s on device %s: %v", dir, deviceId, err)
+		return
+	}
+	for _, line := range strings.Split(string(res.Stdout), "\n") {
+		line = strings.TrimRight(line, "\r")
+		if !strings.HasSuffix(line, "/") {
+			continue
+		}
+		if i, ok := index[strings.TrimSuffix(line, "/")]; ok {
+			files[i].IsDir = true
+		}
+	}
+}
+
+// SortFiles 按 by (name、size 或 mtime) 排序，目录始终排在文件之前
+// 名称不区分大小写；desc 为 true 时降序
+func SortFiles(files []AdbFileItem, by string, desc bool) error {
+	var less func(a, b *AdbFileItem) bool
+	switch by {
+	case "", "name":
+		less = func(a, b *AdbFileItem) bool { return strings.ToLower(a.Name) < strings.ToLower(b.Name) }
+	case "size":
+		less = func(a, b *AdbFileItem) bool { return a.Size < b.Size }
+	case "mtime":
+		less = func(a, b *AdbFileItem) bool { return a.ModTime.Before(b.ModTime) }
+	default:
+		return fmt.Errorf("%w: unknown sort key %q", ErrInvalidArgument, by)
+	}
+	sort.SliceStable(files, func(i, j int) bool {
+		a, b := &files[i], &files[j]
+		if a.IsDir != b.IsDir {
+			return a.IsDir
+		}
+		if desc {
+			return less(b, a)
+		}
+		return less(a, b)
+	})
+	return nil
+}
@@ -0,0 +1,129 @@
+package adb
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// MediaStore 中的媒体集合
+const (
+	MediaImages = "images"
+	MediaVideo  = "video"
+)
+
+// storageAliases 是主存储的其他路径，MediaStore 的 _data 列总是使用 /storage/emulated/0
+var storageAliases = []string{"/sdcard", "/mnt/sdcard", "/storage/self/primary", "/data/media/0"}
+
+// mediaStorePath 将路径转换为 MediaStore 中记录的形式
+func mediaStorePath(p string) string {
+	clean := path.Clean(p)
+	for _, alias := range storageAliases {
+		if clean == alias || strings.HasPrefix(clean, alias+"/") {
+			return "/storage/emulated/0" + strings.TrimPrefix(clean, alias)
+		}
+	}
+	return clean
+}
+
+// contentRow 匹配 content query 输出的一行，例如 "Row: 0 _id=1234"
+var contentRow = regexp.MustCompile(`^Row: \d+ (\w+)=(.*)$`)
+
+// mediaID 匹配 MediaStore 的行 id
+var mediaID = regexp.MustCompile(`^\d+$`)
+
+// queryContent 通过 content query 查询 uri，返回第一行中 column 的值，没有结果时返回空字符串
+func queryContent(ctx context.Context, r Runner, deviceId string, uri string, column string, where string) (string, error) {
+	command := ShellCommand("content query --uri", uri, "--projection", column, "--where", where)
+	res, err := runShell(ctx, r, OpShell, deviceId, command)
+	if err == nil {
+		err = checkShell(command, res)
+	}
+	if err != nil {
+		return "", err
+	}
+	for _, line := range strings.Split(string(res.Stdout), "\n") {
+		if m := contentRow.FindStringSubmatch(strings.TrimSpace(line)); m != nil && m[1] == column {
+			return m[2], nil
+		}
+	}
+	return "", nil
+}
+
+// readContent 通过 content read 读取 uri 的内容，超过 maxBytes 返回 ErrFileTooLarge
+func readContent(ctx context.Context, r Runner, deviceId string, uri string, maxBytes int64) ([]byte, error) {
+	stream, err := runExec(ctx, r, OpShell, deviceId, ShellCommand("content read --uri", uri))
+	if err != nil {
+		return nil, err
+	}
+	defer stream.Close()
+	data, err := io.ReadAll(io.LimitReader(stream, maxBytes+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > maxBytes {
+		return nil, fmt.Errorf("%w: %s is more than %d bytes", ErrFileTooLarge, uri, maxBytes)
+	}
+	return data, nil
+}
+
+// looksLikeImage 根据文件头判断 data 是否是 JPEG、PNG 或 WebP 图片
+// content read 失败时错误信息会混在输出中，因此需要检查内容
+func looksLikeImage(data []byte) bool {
+	return bytes.HasPrefix(data, []byte{0xFF, 0xD8, 0xFF}) ||
+		bytes.HasPrefix(data, []byte("\x89PNG\r\n\x1a\n")) ||
+		len(data) >= 12 && string(data[:4]) == "RIFF" && string(data[8:12]) == "WEBP"
+}
+
+// MediaThumbnail 返回系统媒体库 (MediaStore) 为媒体文件生成的缩略图，collection 为 MediaImages 或 MediaVideo
+// Android 10 及以上通过 content read 读取 .../media/<id>/thumbnail，由媒体库按需生成；
+// 更早的版本从 thumbnails 表中查找已生成的缩略图文件
+// 文件未被媒体库收录或没有缩略图时返回 ErrNoThumbnail
+func MediaThumbnail(ctx context.Context, r Runner, deviceId string, p string, collection string, maxBytes int64) ([]byte, error) {
+	if err := ValidateRemotePath(p); err != nil {
+		return nil, err
+	}
+	if collection != MediaImages && collection != MediaVideo {
+		return nil, fmt.Errorf("%w: unknown media collection %q", ErrInvalidArgument, collection)
+	}
+	base := "content://media/external/" + collection
+	where := "_data='" + strings.ReplaceAll(mediaStorePath(p), "'", "''") + "'"
+	id, err := queryContent(ctx, r, deviceId, base+"/media", "_id", where)
+	if err != nil {
+		return nil, err
+	}
+	if !mediaID.MatchString(id) {
+		return nil, fmt.Errorf("%w: %s is not in the media store", ErrNoThumbnail, p)
+	}
+
+	data, err := readContent(ctx, r, deviceId, base+"/media/"+id+"/thumbnail", maxBytes)
+	if err == nil && looksLikeImage(data) {
+		return data, nil
+	}
+	log.Printf("MediaThumbnail: No generated thumbnail for '%s' (id %s) on device '%s', trying the thumbnails table: %v", p, id, deviceId, err)
+
+	idColumn := "image_id"
+	if collection == MediaVideo {
+		idColumn = "video_id"
+	}
+	thumbPath, err := queryContent(ctx, r, deviceId, base+"/thumbnails", "_data", idColumn+"="+id)
+	if err != nil {
+		return nil, err
+	}
+	if thumbPath == "" || thumbPath == "NULL" {
+		return nil, fmt.Errorf("%w: %s", ErrNoThumbnail, p)
+	}
+	data, _, err = ReadFile(ctx, r, deviceId, thumbPath, maxBytes)
+	if err != nil {
+		return nil, err
+	}
+	if !looksLikeImage(data) {
+		return nil, fmt.Errorf("%w: %s", ErrNoThumbnail, p)
+	}
+	return data, nil
+}
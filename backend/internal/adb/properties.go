@@ -0,0 +1,236 @@
+package adb
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"log"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// propertyCacheTTL 是设备属性缓存的有效期
+// 同一 transport_id 下属性基本不会变化，重新连接后 transport_id 改变会使缓存立即失效
+const propertyCacheTTL = 10 * time.Minute
+
+type deviceProps struct {
+	transportID string
+	props       map[string]string
+	resolution  string
+	fetched     time.Time
+}
+
+var (
+	propsMu    sync.Mutex
+	propsCache = make(map[string]*deviceProps)
+)
+
+// getpropLine 匹配 getprop 的输出行，例如 "[ro.build.version.sdk]: [34]"
+var getpropLine = regexp.MustCompile(`^\[([^\]]+)\]: \[(.*)\]$`)
+
+// parseGetprop 将 getprop 的输出解析为属性表
+func parseGetprop(out string) map[string]string {
+	props := make(map[string]string)
+	scanner := bufio.NewScanner(strings.NewReader(out))
+	for scanner.Scan() {
+		m := getpropLine.FindStringSubmatch(strings.TrimSpace(scanner.Text()))
+		if m != nil {
+			props[m[1]] = m[2]
+		}
+	}
+	return props
+}
+
+// parseWmSize 解析 "wm size" 的输出，存在 Override size 时优先使用它
+func parseWmSize(out string) string {
+	var physical, override string
+	for _, line := range strings.Split(out, "\n") {
+		key, value, ok := strings.Cut(strings.TrimSpace(line), ":")
+		if !ok {
+			continue
+		}
+		switch strings.TrimSpace(key) {
+		case "Physical size":
+			physical = strings.TrimSpace(value)
+		case "Override size":
+			override = strings.TrimSpace(value)
+		}
+	}
+	if override != "" {
+		return override
+	}
+	return physical
+}
+
+// fetchDeviceProps 在设备上执行 getprop 与 wm size
+func fetchDeviceProps(ctx context.Context, r Runner, serial string) (*deviceProps, error) {
+	res, err := runShell(ctx, r, OpShell, serial, "getprop")
+	if err != nil {
+		return nil, err
+	}
+	if err := checkShell("getprop", res); err != nil {
+		return nil, err
+	}
+	entry := &deviceProps{props: parseGetprop(string(res.Stdout)), fetched: time.Now()}
+
+	// wm size 失败 (例如设备尚未完成启动) 不影响其余属性
+	if res, err := runShell(ctx, r, OpShell, serial, "wm size"); err == nil && res.ExitCode == 0 {
+		entry.resolution = parseWmSize(string(res.Stdout))
+	}
+	return entry, nil
+}
+
+// cachedProps 返回设备属性缓存，缓存缺失、过期或 transport_id 改变时重新获取
+// transportID 为空表示调用者不知道当前的 transport_id，此时只按有效期判断
+func cachedProps(ctx context.Context, r Runner, serial string, transportID string, refresh bool) (*deviceProps, error) {
+	propsMu.Lock()
+	entry, ok := propsCache[serial]
+	propsMu.Unlock()
+	if ok && !refresh && time.Since(entry.fetched) < propertyCacheTTL &&
+		(transportID == "" || transportID == entry.transportID) {
+		return entry, nil
+	}
+
+	entry, err := fetchDeviceProps(ctx, r, serial)
+	if err != nil {
+		return nil, err
+	}
+	entry.transportID = transportID
+	propsMu.Lock()
+	propsCache[serial] = entry
+	propsMu.Unlock()
+	return entry, nil
+}
+
+// InvalidateProperties 丢弃设备的属性缓存，通常在设备断开时调用
+func InvalidateProperties(serial string) {
+	propsMu.Lock()
+	defer propsMu.Unlock()
+	delete(propsCache, serial)
+}
+
+// GetProperties 返回设备完整的 getprop 属性表，refresh 为 true 时跳过缓存
+func GetProperties(ctx context.Context, r Runner, deviceId string, refresh bool) (map[string]string, error) {
+	if deviceId == "" {
+		return nil, fmt.Errorf("GetProperties: deviceId cannot be empty")
+	}
+	entry, err := cachedProps(ctx, r, deviceId, "", refresh)
+	if err != nil {
+		log.Printf("GetProperties: Failed for device '%s': %v", deviceId, err)
+		return nil, fmt.Errorf("GetProperties: Failed for device '%s': %w", deviceId, err)
+	}
+	props := make(map[string]string, len(entry.props))
+	for k, v := range entry.props {
+		props[k] = v
+	}
+	return props, nil
+}
+
+// EnrichDevices 为处于 device 状态的设备补充品牌、系统版本、ABI 等属性
+// 各设备并行查询，单台设备失败只会记录日志并保留 devices -l 中的信息
+func EnrichDevices(ctx context.Context, r Runner, devices []DeviceInfo) []DeviceInfo {
+	enriched := make([]DeviceInfo, len(devices))
+	copy(enriched, devices)
+
+	var wg sync.WaitGroup
+	for i := range enriched {
+		if enriched[i].Status != "device" {
+			continue
+		}
+		wg.Add(1)
+		go func(d *DeviceInfo) {
+			defer wg.Done()
+			entry, err := cachedProps(ctx, r, d.ID, d.TransportID, false)
+			if err != nil {
+				log.Printf("EnrichDevices: Failed to read properties of device '%s': %v", d.ID, err)
+				return
+			}
+			applyProps(d, entry)
+		}(&enriched[i])
+	}
+	wg.Wait()
+	return enriched
+}
+
+func applyProps(d *DeviceInfo, entry *deviceProps) {
+	p := entry.props
+	d.Brand = p["ro.product.brand"]
+	if d.Model == "" {
+		d.Model = p["ro.product.model"]
+	}
+	d.AndroidRelease = p["ro.build.version.release"]
+	d.SDKLevel, _ = strconv.Atoi(p["ro.build.version.sdk"])
+	d.ABI = p["ro.product.cpu.abi"]
+	d.Fingerprint = p["ro.build.fingerprint"]
+	d.ScreenResolution = entry.resolution
+}
+
+// DeviceSpec 是判断安装包能否安装在设备上所需的设备信息
+type DeviceSpec struct {
+	SDK int `json:"sdk"`
+	// ABIs 是设备支持的 ABI，按优先级排列 (ro.product.cpu.abilist)
+	ABIs []string `json:"abis"`
+	// Density 是屏幕密度 (dpi)，未知时为 0
+	Density int `json:"density"`
+	// Locales 是系统语言区域 (例如 zh-CN)，未知时为空
+	Locales []string `json:"locales"`
+}
+
+// GetDeviceSpec 从 (缓存的) 设备属性中读取 SDK 版本、支持的 ABI、屏幕密度与系统语言
+func GetDeviceSpec(ctx context.Context, r Runner, deviceId string) (*DeviceSpec, error) {
+	entry, err := cachedProps(ctx, r, deviceId, "", false)
+	if err != nil {
+		return nil, fmt.Errorf("GetDeviceSpec: Failed for device '%s': %w", deviceId, err)
+	}
+	p := entry.props
+	spec := &DeviceSpec{ABIs: []string{}}
+	spec.SDK, _ = strconv.Atoi(p["ro.build.version.sdk"])
+	if list := p["ro.product.cpu.abilist"]; list != "" {
+		spec.ABIs = strings.Split(list, ",")
+	} else {
+		// Android 5.0 之前没有 abilist
+		for _, key := range []string{"ro.product.cpu.abi", "ro.product.cpu.abi2"} {
+			if abi := p[key]; abi != "" {
+				spec.ABIs = append(spec.ABIs, abi)
+			}
+		}
+	}
+	for _, key := range []string{"ro.sf.lcd_density", "qemu.sf.lcd_density"} {
+		if n, err := strconv.Atoi(p[key]); err == nil && n > 0 {
+			spec.Density = n
+			break
+		}
+	}
+	spec.Locales = deviceLocales(p)
+	return spec, nil
+}
+
+// deviceLocales 返回系统语言区域: persist.sys.locale 可能是逗号分隔的列表；Android 5.0 之前使用 persist.sys.language 与 persist.sys.country
+func deviceLocales(p map[string]string) []string {
+	locales := []string{}
+	for _, key := range []string{"persist.sys.locale", "ro.product.locale"} {
+		for _, l := range strings.Split(p[key], ",") {
+			if l = strings.TrimSpace(l); l != "" {
+				locales = append(locales, l)
+			}
+		}
+		if len(locales) > 0 {
+			return locales
+		}
+	}
+	for _, keys := range [][2]string{
+		{"persist.sys.language", "persist.sys.country"},
+		{"ro.product.locale.language", "ro.product.locale.region"},
+	} {
+		if lang := p[keys[0]]; lang != "" {
+			if country := p[keys[1]]; country != "" {
+				lang += "-" + country
+			}
+			return append(locales, lang)
+		}
+	}
+	return locales
+}
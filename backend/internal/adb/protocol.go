@@ -0,0 +1,72 @@
+package adb
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// adb server 智能套接字 (smart socket) 协议的基础编解码
+// 请求格式: 4 位十六进制长度 + 服务名，例如 "000chost:version"
+// 响应格式: "OKAY" 或 "FAIL" + 4 位十六进制长度 + 错误信息
+
+const (
+	statusOkay = "OKAY"
+	statusFail = "FAIL"
+)
+
+// ServerError 表示 adb server 或 adbd 以 FAIL 响应拒绝了请求
+type ServerError struct {
+	Service string
+	Message string
+}
+
+func (e *ServerError) Error() string {
+	return fmt.Sprintf("adb: %s failed: %s", e.Service, e.Message)
+}
+
+// writeRequest 以 "%04x<payload>" 的格式写出一个请求
+func writeRequest(w io.Writer, payload string) error {
+	if len(payload) > 0xffff {
+		return fmt.Errorf("adb: request too long (%d bytes)", len(payload))
+	}
+	_, err := fmt.Fprintf(w, "%04x%s", len(payload), payload)
+	return err
+}
+
+// readStatus 读取 OKAY/FAIL 状态，FAIL 时返回 *ServerError
+func readStatus(r io.Reader, service string) error {
+	var status [4]byte
+	if _, err := io.ReadFull(r, status[:]); err != nil {
+		return fmt.Errorf("adb: reading status for %s: %w", service, err)
+	}
+	switch string(status[:]) {
+	case statusOkay:
+		return nil
+	case statusFail:
+		msg, err := readHexPrefixed(r)
+		if err != nil {
+			return fmt.Errorf("adb: reading failure message for %s: %w", service, err)
+		}
+		return &ServerError{Service: service, Message: msg}
+	default:
+		return fmt.Errorf("adb: unexpected status %q for %s", string(status[:]), service)
+	}
+}
+
+// readHexPrefixed 读取一个以 4 位十六进制长度开头的字符串
+func readHexPrefixed(r io.Reader) (string, error) {
+	var lenBuf [4]byte
+	if _, err := io.ReadFull(r, lenBuf[:]); err != nil {
+		return "", err
+	}
+	n, err := strconv.ParseUint(string(lenBuf[:]), 16, 32)
+	if err != nil {
+		return "", fmt.Errorf("adb: invalid length prefix %q", string(lenBuf[:]))
+	}
+	buf := make([]byte, n)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return "", err
+	}
+	return string(buf), nil
+}
@@ -0,0 +1,144 @@
+package adb
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+// runAsStagingDir 是 run-as 上传时暂存文件的目录，shell 可以写入，应用的 uid 可以读取其中的文件
+const runAsStagingDir = "/data/local/tmp"
+
+// runAsRunner 以应用的身份 (run-as <pkg>) 执行命令与文件传输，用于访问可调试应用的私有目录 (/data/data/<pkg>)
+// sync 服务以 shell 身份运行，无法访问这些目录，因此 Stat、Pull、Push 改为通过 stat、cat、cp 实现
+type runAsRunner struct {
+	Runner
+	pkg string
+}
+
+// RunAs 返回一个以应用 pkg 的身份访问设备的 Runner，adb 包的文件操作 (ListFiles、StreamFile、PushStream、RemovePath 等) 都可以使用它
+// 应用不是可调试的 (debuggable) 时返回 ErrNotDebuggable，应用未安装时返回 ErrPackageNotFound
+func RunAs(ctx context.Context, r Runner, deviceId string, pkg string) (Runner, error) {
+	if err := ValidatePackageName(pkg); err != nil {
+		return nil, err
+	}
+	command := ShellCommand("run-as", pkg, "true")
+	res, err := runShell(ctx, r, OpShell, deviceId, command)
+	if err == nil {
+		err = checkShell(command, res)
+	}
+	if err != nil {
+		log.Printf("RunAs: Cannot run as %s on device %s: %v", pkg, deviceId, err)
+		return nil, fmt.Errorf("run-as %s: %w", pkg, err)
+	}
+	return &runAsRunner{Runner: r, pkg: pkg}, nil
+}
+
+// wrap 将命令放到应用身份的 sh 中执行，管道与重定向都以应用的身份运行
+func (r *runAsRunner) wrap(command string) string {
+	return ShellCommand("run-as", r.pkg, "sh", "-c", command)
+}
+
+func (r *runAsRunner) ShellV2(ctx context.Context, serial string, command string) (*ShellResult, error) {
+	return r.Runner.ShellV2(ctx, serial, r.wrap(command))
+}
+
+func (r *runAsRunner) Exec(ctx context.Context, serial string, command string) (io.ReadCloser, error) {
+	return r.Runner.Exec(ctx, serial, r.wrap(command))
+}
+
+// Stat 通过 "stat -c" 获取文件信息，文件不存在时与 sync STAT 一样返回全零
+func (r *runAsRunner) Stat(ctx context.Context, serial string, remotePath string) (*RemoteStat, error) {
+	command := ShellCommand("stat -c", "%f %s %Y", remotePath)
+	res, err := r.ShellV2(ctx, serial, command)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkShell(command, res); err != nil {
+		if errors.Is(err, ErrPathNotFound) {
+			return &RemoteStat{ModTime: time.Unix(0, 0)}, nil
+		}
+		return nil, err
+	}
+	var mode uint32
+	var size, mtime int64
+	if _, err := fmt.Sscanf(strings.TrimSpace(string(res.Stdout)), "%x %d %d", &mode, &size, &mtime); err != nil {
+		return nil, fmt.Errorf("unexpected stat output for %s: %q", remotePath, res.Stdout)
+	}
+	return &RemoteStat{Mode: fileModeFromUnix(mode), Size: size, ModTime: time.Unix(mtime, 0)}, nil
+}
+
+// Pull 通过 cat 读取文件；exec 数据流中没有 stderr，因此先 STAT 以报告文件不存在等错误
+func (r *runAsRunner) Pull(ctx context.Context, serial string, remotePath string, w io.Writer) error {
+	st, err := r.Stat(ctx, serial, remotePath)
+	if err != nil {
+		return err
+	}
+	if !st.Exists() {
+		return fmt.Errorf("%w: %s", ErrPathNotFound, remotePath)
+	}
+	if st.Mode.IsDir() {
+		return fmt.Errorf("%w: %s is a directory", ErrInvalidArgument, remotePath)
+	}
+	stream, err := r.Exec(ctx, serial, ShellCommand("cat", remotePath))
+	if err != nil {
+		return err
+	}
+	defer stream.Close()
+	n, err := io.Copy(w, stream)
+	if err != nil {
+		return err
+	}
+	if n < st.Size {
+		return fmt.Errorf("pull %s: %w", remotePath, io.ErrUnexpectedEOF)
+	}
+	return nil
+}
+
+// Push 先以 shell 身份把内容推送到暂存目录，再以应用的身份复制到目标路径，最后删除暂存文件
+// 与 sync SEND 一致，目标的父目录不存在时自动创建
+func (r *runAsRunner) Push(ctx context.Context, serial string, src io.Reader, remotePath string, mode os.FileMode, mtime time.Time) error {
+	var suffix [8]byte
+	rand.Read(suffix[:])
+	tmp := path.Join(runAsStagingDir, ".run-as-"+hex.EncodeToString(suffix[:]))
+	// 暂存文件必须对应用的 uid 可读
+	if err := r.Runner.Push(ctx, serial, src, tmp, 0644, mtime); err != nil {
+		return err
+	}
+	defer func() {
+		cleanupCtx, cancel := withTimeout(context.WithoutCancel(ctx), OpShell)
+		defer cancel()
+		if _, err := r.Runner.ShellV2(cleanupCtx, serial, ShellCommand("rm -f", tmp)); err != nil {
+			log.Printf("RunAs: Failed to remove staging file %s on device %s: %v", tmp, serial, err)
+		}
+	}()
+
+	perm := mode.Perm()
+	if perm == 0 {
+		perm = 0644
+	}
+	command := strings.Join([]string{
+		ShellCommand("mkdir -p", path.Dir(remotePath)),
+		ShellCommand("cp", tmp, remotePath),
+		ShellCommand("chmod", fmt.Sprintf("%o", perm), remotePath),
+		ShellCommand("touch -d", mtime.UTC().Format("2006-01-02T15:04:05Z"), remotePath),
+	}, " && ")
+	res, err := r.ShellV2(ctx, serial, command)
+	if err != nil {
+		return err
+	}
+	return checkShell(command, res)
+}
+
+// OpenShell 不支持以应用身份的常驻会话，命令都通过 ShellV2 逐条执行
+func (r *runAsRunner) OpenShell(ctx context.Context, serial string) (ShellSession, error) {
+	return nil, fmt.Errorf("%w: interactive shells are not available through run-as", ErrInvalidArgument)
+}
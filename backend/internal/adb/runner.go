@@ -0,0 +1,41 @@
+package adb
+
+import (
+	"context"
+	"io"
+	"os"
+	"time"
+)
+
+// Runner 是 adb 包访问设备的底层抽象，包内所有操作 (ListFiles、InstallAPK 等) 都构建在它之上
+// *Client 通过 adb server 实现它，adbtest.Fake 提供可编排的假实现用于测试
+// 所有方法都必须在 ctx 结束时尽快返回，并释放设备上对应的进程
+type Runner interface {
+	// Devices 返回当前连接的设备列表
+	Devices(ctx context.Context) ([]DeviceInfo, error)
+	// TrackDevices 在设备列表每次变化时以完整列表调用 fn，阻塞直到 ctx 结束或连接中断
+	TrackDevices(ctx context.Context, fn func([]DeviceInfo)) error
+	// ShellV2 在设备上执行 shell 命令，分别返回 stdout、stderr 与退出码
+	ShellV2(ctx context.Context, serial string, command string) (*ShellResult, error)
+	// Exec 在设备上执行命令并返回原始 stdout 数据流
+	Exec(ctx context.Context, serial string, command string) (io.ReadCloser, error)
+	// Stat 获取远程文件信息
+	Stat(ctx context.Context, serial string, remotePath string) (*RemoteStat, error)
+	// Pull 将远程文件内容写入 w
+	Pull(ctx context.Context, serial string, remotePath string, w io.Writer) error
+	// Push 将 r 的内容写入远程文件
+	Push(ctx context.Context, serial string, r io.Reader, remotePath string, mode os.FileMode, mtime time.Time) error
+	// OpenShell 在设备上启动一个常驻 shell 会话，ctx 只用于建立会话
+	OpenShell(ctx context.Context, serial string) (ShellSession, error)
+
+	// Connect 连接 addr (host:port) 上的无线调试设备，返回 adb server 的提示信息
+	Connect(ctx context.Context, addr string) (string, error)
+	// Disconnect 断开 addr 上的无线调试设备
+	Disconnect(ctx context.Context, addr string) (string, error)
+	// Pair 使用配对码与 addr 上的设备配对 (Android 11+ 的无线调试)
+	Pair(ctx context.Context, addr string, code string) (string, error)
+	// TCPIP 让 USB 设备的 adbd 在 port 上监听 TCP 连接
+	TCPIP(ctx context.Context, serial string, port int) (string, error)
+}
+
+var _ Runner = (*Client)(nil)
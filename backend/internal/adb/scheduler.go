@@ -0,0 +1,303 @@
+package adb
+
+import (
+	"context"
+	"io"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Priority 是设备命令的优先级，数值越大越先执行
+type Priority int
+
+const (
+	PriorityBulk  Priority = iota // 文件传输、安装、logcat 导出
+	PriorityFrame                 // 屏幕镜像的截图帧
+	PriorityQuery                 // 文件列表、应用列表等 UI 查询
+	PriorityInput                 // 点击、滑动、按键等交互输入
+
+	numPriorities = int(PriorityInput) + 1
+)
+
+var priorityNames = [numPriorities]string{"bulk", "frame", "query", "input"}
+
+func (p Priority) String() string {
+	if p < 0 || int(p) >= numPriorities {
+		return "unknown"
+	}
+	return priorityNames[p]
+}
+
+type priorityKey struct{}
+
+// WithPriority 返回携带命令优先级的 ctx，Scheduler 据此决定排队顺序
+func WithPriority(ctx context.Context, p Priority) context.Context {
+	return context.WithValue(ctx, priorityKey{}, p)
+}
+
+// priorityFrom 返回 ctx 中的优先级，没有设置时使用 fallback
+func priorityFrom(ctx context.Context, fallback Priority) Priority {
+	if p, ok := ctx.Value(priorityKey{}).(Priority); ok {
+		return p
+	}
+	return fallback
+}
+
+// DefaultConcurrency 是每台设备默认允许同时执行的命令数
+const DefaultConcurrency = 2
+
+// Scheduler 包装一个 Runner，限制每台设备同时执行的命令数，并按优先级安排等待中的命令
+// 同一优先级内按先来先服务。Devices 与 TrackDevices 不针对具体设备，直接透传
+// 下载、安装与数据流会在整个传输期间占用名额，因此交互输入另有一个预留名额，不会排在长时间的传输之后
+type Scheduler struct {
+	r     Runner
+	limit int
+
+	mu     sync.Mutex
+	queues map[string]*deviceQueue
+}
+
+var _ Runner = (*Scheduler)(nil)
+
+type deviceQueue struct {
+	running    int
+	waiting    [numPriorities][]chan struct{}
+	completed  uint64
+	maxWaiting int
+	lastWait   time.Duration
+}
+
+func (q *deviceQueue) waitingTotal() int {
+	return q.waitingFrom(0)
+}
+
+// waitingFrom 返回优先级不低于 p 的等待数
+func (q *deviceQueue) waitingFrom(p Priority) int {
+	n := 0
+	for _, w := range q.waiting[p:] {
+		n += len(w)
+	}
+	return n
+}
+
+// NewScheduler 创建一个每台设备最多同时执行 limit 条命令的 Scheduler，limit <= 0 时使用 DefaultConcurrency
+func NewScheduler(r Runner, limit int) *Scheduler {
+	if limit <= 0 {
+		limit = DefaultConcurrency
+	}
+	return &Scheduler{r: r, limit: limit, queues: make(map[string]*deviceQueue)}
+}
+
+// acquire 等待设备上的一个执行名额，返回的函数用于归还名额
+func (s *Scheduler) acquire(ctx context.Context, serial string, p Priority) (func(), error) {
+	start := time.Now()
+	s.mu.Lock()
+	q, ok := s.queues[serial]
+	if !ok {
+		q = &deviceQueue{}
+		s.queues[serial] = q
+	}
+	if q.running < s.capacity(p) && q.waitingFrom(p) == 0 {
+		q.running++
+		s.mu.Unlock()
+		return s.releaser(serial), nil
+	}
+	ready := make(chan struct{})
+	q.waiting[p] = append(q.waiting[p], ready)
+	if n := q.waitingTotal(); n > q.maxWaiting {
+		q.maxWaiting = n
+	}
+	s.mu.Unlock()
+
+	select {
+	case <-ready:
+		s.mu.Lock()
+		q.lastWait = time.Since(start)
+		s.mu.Unlock()
+		return s.releaser(serial), nil
+	case <-ctx.Done():
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		for i, ch := range q.waiting[p] {
+			if ch == ready {
+				q.waiting[p] = append(q.waiting[p][:i], q.waiting[p][i+1:]...)
+				return nil, ctx.Err()
+			}
+		}
+		// 名额已经分配给了本次调用，转交给下一个等待者
+		s.releaseLocked(q)
+		return nil, ctx.Err()
+	}
+}
+
+func (s *Scheduler) releaser(serial string) func() {
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			s.mu.Lock()
+			defer s.mu.Unlock()
+			q := s.queues[serial]
+			q.completed++
+			s.releaseLocked(q)
+		})
+	}
+}
+
+// capacity 返回优先级 p 的命令可以使用的名额数: 交互输入比其他命令多一个预留名额
+func (s *Scheduler) capacity(p Priority) int {
+	if p == PriorityInput {
+		return s.limit + 1
+	}
+	return s.limit
+}
+
+// releaseLocked 归还一个名额，并按优先级唤醒可以执行的等待者
+// 优先级最高的等待者没有可用名额时 (例如预留名额之外都被占用)，更低优先级的等待者也不会被唤醒
+func (s *Scheduler) releaseLocked(q *deviceQueue) {
+	q.running--
+	for {
+		p := numPriorities - 1
+		for p >= 0 && len(q.waiting[p]) == 0 {
+			p--
+		}
+		if p < 0 || q.running >= s.capacity(Priority(p)) {
+			return
+		}
+		next := q.waiting[p][0]
+		q.waiting[p] = q.waiting[p][1:]
+		q.running++
+		close(next)
+	}
+}
+
+// QueueStats 是一台设备的排队情况
+type QueueStats struct {
+	Serial     string         `json:"serial"`
+	Limit      int            `json:"limit"`
+	Running    int            `json:"running"`
+	Waiting    map[string]int `json:"waiting"`
+	MaxWaiting int            `json:"maxWaiting"`
+	Completed  uint64         `json:"completed"`
+	LastWaitMs int64          `json:"lastWaitMs"`
+}
+
+// Stats 返回各设备当前的执行数与按优先级统计的等待数 (按序列号排序)
+func (s *Scheduler) Stats() []QueueStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stats := make([]QueueStats, 0, len(s.queues))
+	for serial, q := range s.queues {
+		waiting := make(map[string]int, numPriorities)
+		for p := range q.waiting {
+			waiting[Priority(p).String()] = len(q.waiting[p])
+		}
+		stats = append(stats, QueueStats{
+			Serial:     serial,
+			Limit:      s.limit,
+			Running:    q.running,
+			Waiting:    waiting,
+			MaxWaiting: q.maxWaiting,
+			Completed:  q.completed,
+			LastWaitMs: q.lastWait.Milliseconds(),
+		})
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].Serial < stats[j].Serial })
+	return stats
+}
+
+func (s *Scheduler) Devices(ctx context.Context) ([]DeviceInfo, error) {
+	return s.r.Devices(ctx)
+}
+
+func (s *Scheduler) TrackDevices(ctx context.Context, fn func([]DeviceInfo)) error {
+	return s.r.TrackDevices(ctx, fn)
+}
+
+func (s *Scheduler) ShellV2(ctx context.Context, serial string, command string) (*ShellResult, error) {
+	release, err := s.acquire(ctx, serial, priorityFrom(ctx, PriorityQuery))
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	return s.r.ShellV2(ctx, serial, command)
+}
+
+// Exec 占用的名额在返回的数据流关闭时归还
+func (s *Scheduler) Exec(ctx context.Context, serial string, command string) (io.ReadCloser, error) {
+	release, err := s.acquire(ctx, serial, priorityFrom(ctx, PriorityQuery))
+	if err != nil {
+		return nil, err
+	}
+	stream, err := s.r.Exec(ctx, serial, command)
+	if err != nil {
+		release()
+		return nil, err
+	}
+	return &scheduledStream{ReadCloser: stream, release: release}, nil
+}
+
+func (s *Scheduler) Stat(ctx context.Context, serial string, remotePath string) (*RemoteStat, error) {
+	release, err := s.acquire(ctx, serial, priorityFrom(ctx, PriorityQuery))
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	return s.r.Stat(ctx, serial, remotePath)
+}
+
+func (s *Scheduler) Pull(ctx context.Context, serial string, remotePath string, w io.Writer) error {
+	release, err := s.acquire(ctx, serial, priorityFrom(ctx, PriorityBulk))
+	if err != nil {
+		return err
+	}
+	defer release()
+	return s.r.Pull(ctx, serial, remotePath, w)
+}
+
+func (s *Scheduler) Push(ctx context.Context, serial string, r io.Reader, remotePath string, mode os.FileMode, mtime time.Time) error {
+	release, err := s.acquire(ctx, serial, priorityFrom(ctx, PriorityBulk))
+	if err != nil {
+		return err
+	}
+	defer release()
+	return s.r.Push(ctx, serial, r, remotePath, mode, mtime)
+}
+
+// OpenShell 只建立会话不执行命令，不占用设备名额；会话上的命令由 ShellPool 经 ShellV2 调度
+func (s *Scheduler) OpenShell(ctx context.Context, serial string) (ShellSession, error) {
+	return s.r.OpenShell(ctx, serial)
+}
+
+// Connect、Disconnect 与 Pair 由 adb server 处理，不占用设备名额
+func (s *Scheduler) Connect(ctx context.Context, addr string) (string, error) {
+	return s.r.Connect(ctx, addr)
+}
+
+func (s *Scheduler) Disconnect(ctx context.Context, addr string) (string, error) {
+	return s.r.Disconnect(ctx, addr)
+}
+
+func (s *Scheduler) Pair(ctx context.Context, addr string, code string) (string, error) {
+	return s.r.Pair(ctx, addr, code)
+}
+
+func (s *Scheduler) TCPIP(ctx context.Context, serial string, port int) (string, error) {
+	release, err := s.acquire(ctx, serial, priorityFrom(ctx, PriorityQuery))
+	if err != nil {
+		return "", err
+	}
+	defer release()
+	return s.r.TCPIP(ctx, serial, port)
+}
+
+type scheduledStream struct {
+	io.ReadCloser
+	release func()
+}
+
+func (s *scheduledStream) Close() error {
+	defer s.release()
+	return s.ReadCloser.Close()
+}
@@ -0,0 +1,118 @@
+package adb_test
+
+import (
+	"context"
+	"errors"
+	"fishyinhe/backend/internal/adb"
+	"fishyinhe/backend/internal/adb/adbtest"
+	"io"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newTestScheduler(limit int) (*adbtest.Fake, *adb.Scheduler) {
+	f := adbtest.NewFake()
+	f.AddCannedDevice("emu-1")
+	f.HandleShell("emu-1", "true", adbtest.Response{})
+	f.HandleShell("emu-1", "cat /sdcard/big.mp4", adbtest.Response{Stdout: "video"})
+	return f, adb.NewScheduler(f, limit)
+}
+
+// openStreams 打开 n 个不关闭的 exec 数据流，模拟占满名额的长时间下载
+func openStreams(t *testing.T, s *adb.Scheduler, n int) []io.ReadCloser {
+	t.Helper()
+	ctx := adb.WithPriority(context.Background(), adb.PriorityBulk)
+	var streams []io.ReadCloser
+	for i := 0; i < n; i++ {
+		stream, err := s.Exec(ctx, "emu-1", "cat /sdcard/big.mp4")
+		if err != nil {
+			t.Fatalf("Exec: %v", err)
+		}
+		streams = append(streams, stream)
+	}
+	t.Cleanup(func() {
+		for _, stream := range streams {
+			stream.Close()
+		}
+	})
+	return streams
+}
+
+func TestSchedulerInputNotBlockedByTransfers(t *testing.T) {
+	_, s := newTestScheduler(2)
+	streams := openStreams(t, s, 2)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if _, err := s.ShellV2(adb.WithPriority(ctx, adb.PriorityInput), "emu-1", "true"); err != nil {
+		t.Fatalf("input waited behind the transfers: %v", err)
+	}
+
+	// 其他命令仍受并发上限限制，直到一个传输结束
+	queryCtx, queryCancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer queryCancel()
+	if _, err := s.ShellV2(adb.WithPriority(queryCtx, adb.PriorityQuery), "emu-1", "true"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("query with both slots in use = %v, want it to wait", err)
+	}
+	streams[0].Close()
+	if _, err := s.ShellV2(adb.WithPriority(ctx, adb.PriorityQuery), "emu-1", "true"); err != nil {
+		t.Fatalf("query after a transfer finished: %v", err)
+	}
+
+	stats := s.Stats()
+	if len(stats) != 1 || stats[0].Running != 1 || stats[0].Waiting["query"] != 0 {
+		t.Errorf("Stats = %+v, want one running stream and no waiting queries", stats)
+	}
+}
+
+func TestSchedulerPriorityOrder(t *testing.T) {
+	_, s := newTestScheduler(1)
+	streams := openStreams(t, s, 1)
+
+	var mu sync.Mutex
+	var order []string
+	var wg sync.WaitGroup
+	for _, c := range []struct {
+		name     string
+		priority adb.Priority
+	}{
+		{"bulk", adb.PriorityBulk},
+		{"frame", adb.PriorityFrame},
+		{"query", adb.PriorityQuery},
+	} {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := s.ShellV2(adb.WithPriority(context.Background(), c.priority), "emu-1", "true"); err != nil {
+				t.Errorf("%s: %v", c.name, err)
+			}
+			mu.Lock()
+			order = append(order, c.name)
+			mu.Unlock()
+		}()
+		// 等待命令进入队列，使入队顺序与优先级相反
+		for s.Stats()[0].Waiting[c.name] == 0 {
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	// 取消的等待者不占用名额
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := s.ShellV2(adb.WithPriority(ctx, adb.PriorityQuery), "emu-1", "true"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("ShellV2 = %v, want context.DeadlineExceeded", err)
+	}
+
+	streams[0].Close()
+	wg.Wait()
+	want := []string{"query", "frame", "bulk"}
+	for i := range want {
+		if i >= len(order) || order[i] != want[i] {
+			t.Fatalf("commands ran in order %v, want %v", order, want)
+		}
+	}
+	if stats := s.Stats(); stats[0].Running != 0 || stats[0].MaxWaiting < 3 {
+		t.Errorf("Stats = %+v, want nothing running and at least 3 waiting at peak", stats)
+	}
+}
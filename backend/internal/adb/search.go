@@ -0,0 +1,148 @@
+package adb
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SearchOptions 是 SearchFiles 的过滤条件，除大小以外的零值字段表示不过滤
+type SearchOptions struct {
+	// Name 是文件名的 glob (例如 *.hprof)，不区分大小写
+	Name string
+	// Type 为 "f" (文件)、"d" (目录) 或 "l" (符号链接)
+	Type string
+	// MinSize 与 MaxSize 是以字节为单位的闭区间，< 0 表示不限制
+	MinSize int64
+	MaxSize int64
+	// NewerThan 非零时只返回修改时间晚于它的条目
+	NewerThan time.Time
+	// MaxDepth 是相对于搜索根目录的最大深度，<= 0 表示不限制
+	MaxDepth int
+	// Limit 是返回的条目数上限，<= 0 表示不限制
+	Limit int
+}
+
+// FoundFile 是搜索结果中的一个条目，Path 是完整路径
+type FoundFile struct {
+	Path string `json:"path"`
+	AdbFileItem
+}
+
+// validate 检查过滤条件的取值
+func (o *SearchOptions) validate() error {
+	switch o.Type {
+	case "", "f", "d", "l":
+	default:
+		return fmt.Errorf("%w: type must be f, d or l", ErrInvalidArgument)
+	}
+	if o.MinSize >= 0 && o.MaxSize >= 0 && o.MinSize > o.MaxSize {
+		return fmt.Errorf("%w: minSize is greater than maxSize", ErrInvalidArgument)
+	}
+	if _, err := path.Match(o.Name, ""); err != nil {
+		return fmt.Errorf("%w: invalid name pattern %q", ErrInvalidArgument, o.Name)
+	}
+	return nil
+}
+
+// findCommand 生成 find 命令，匹配的条目交给 "ls -ld" 输出完整信息，权限错误被丢弃
+func findCommand(root string, opts SearchOptions, fullTime bool) string {
+	// 以 / 结尾使 find 进入符号链接 (例如 /sdcard) 指向的目录
+	if !strings.HasSuffix(root, "/") {
+		root += "/"
+	}
+	args := []string{root, "-mindepth", "1"}
+	if opts.MaxDepth > 0 {
+		args = append(args, "-maxdepth", strconv.Itoa(opts.MaxDepth))
+	}
+	if opts.Type != "" {
+		args = append(args, "-type", opts.Type)
+	}
+	if opts.Name != "" {
+		args = append(args, "-iname", opts.Name)
+	}
+	// find 的 -size Nc 比较的是字节数，+N 表示大于、-N 表示小于
+	if opts.MinSize > 0 {
+		args = append(args, "-size", "+"+strconv.FormatInt(opts.MinSize-1, 10)+"c")
+	}
+	if opts.MaxSize >= 0 {
+		args = append(args, "-size", "-"+strconv.FormatInt(opts.MaxSize+1, 10)+"c")
+	}
+	// toybox 与 busybox 的 find 都支持 -mmin -N (N 分钟内修改过)，向上取整到分钟，
+	// 使设备只输出可能匹配的条目，精确的比较由 SearchFiles 完成
+	if !opts.NewerThan.IsZero() {
+		minutes := int64(math.Ceil(time.Since(opts.NewerThan).Minutes()))
+		args = append(args, "-mmin", "-"+strconv.FormatInt(max(minutes, 0), 10))
+	}
+	ls := []string{"-exec", "ls", "-ld"}
+	if fullTime {
+		ls = append(ls, "--full-time")
+	}
+	args = append(args, append(ls, "{}", "+")...)
+	return ShellCommand("find", args...) + " 2>/dev/null"
+}
+
+// supportsFullTime 报告设备上的 ls 是否支持 --full-time，无法确认 (例如探测命令失败) 时返回 false
+func supportsFullTime(ctx context.Context, r Runner, deviceId string) bool {
+	res, err := runShell(ctx, r, OpShell, deviceId, "ls -ld --full-time /")
+	if err != nil {
+		return false
+	}
+	return res.ExitCode == 0 || !strings.Contains(string(res.Stderr), "full-time")
+}
+
+// SearchFiles 在设备上运行 find 搜索 root 下的条目，每找到一个条目就调用 fn
+// 结果按 find 的遍历顺序逐个返回，不需要等待搜索结束；fn 返回错误时停止搜索并返回该错误
+// 达到 Limit 时停止搜索并返回 truncated = true；无法访问的目录被跳过
+func SearchFiles(ctx context.Context, r Runner, deviceId string, root string, opts SearchOptions, fn func(FoundFile) error) (truncated bool, err error) {
+	if err := ValidateRemotePath(root); err != nil {
+		return false, err
+	}
+	if err := opts.validate(); err != nil {
+		return false, err
+	}
+	ctx = WithPriority(ctx, PriorityBulk)
+	fullTime := supportsFullTime(ctx, r, deviceId)
+	command := findCommand(root, opts, fullTime)
+
+	// 搜索整个存储可能需要较长时间，使用传输类操作的超时
+	stream, err := runExec(ctx, r, OpTransfer, deviceId, command)
+	if err != nil {
+		return false, err
+	}
+	defer stream.Close()
+
+	count := 0
+	scanner := bufio.NewScanner(stream)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		item, ok := parseLsLine(scanner.Text())
+		if !ok {
+			continue
+		}
+		// 没有 --full-time 时 ls 的时间只精确到分钟，只能依赖 find 的 -mmin
+		if fullTime && !opts.NewerThan.IsZero() && !item.ModTime.After(opts.NewerThan) {
+			continue
+		}
+		if opts.Limit > 0 && count >= opts.Limit {
+			return true, nil
+		}
+		found := FoundFile{Path: item.Name, AdbFileItem: item}
+		found.Name = path.Base(item.Name)
+		if err := fn(found); err != nil {
+			return false, err
+		}
+		count++
+	}
+	if err := scanner.Err(); err != nil {
+		return false, err
+	}
+	log.Printf("SearchFiles: Found %d entries under %s on device %s", count, root, deviceId)
+	return false, nil
+}
@@ -0,0 +1,77 @@
+package adb_test
+
+import (
+	"context"
+	"fishyinhe/backend/internal/adb"
+	"fishyinhe/backend/internal/adb/adbtest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSearchFilesNewerThan(t *testing.T) {
+	f := adbtest.NewFake()
+	f.AddCannedDevice("emu-1")
+	ctx := context.Background()
+	now := time.Now()
+	for name, mtime := range map[string]time.Time{
+		"/sdcard/logs/old.log":    now.Add(-48 * time.Hour),
+		"/sdcard/logs/recent.log": now.Add(-30 * time.Minute),
+		"/sdcard/logs/edge.log":   now.Add(-60*time.Minute - 30*time.Second),
+	} {
+		if err := f.Push(ctx, "emu-1", strings.NewReader("log"), name, 0644, mtime); err != nil {
+			t.Fatalf("Push: %v", err)
+		}
+	}
+
+	var found []string
+	_, err := adb.SearchFiles(ctx, f, "emu-1", "/sdcard/logs", adb.SearchOptions{MinSize: -1, MaxSize: -1, NewerThan: now.Add(-time.Hour)}, func(file adb.FoundFile) error {
+		found = append(found, file.Path)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("SearchFiles: %v", err)
+	}
+	// edge.log 在 -mmin 向上取整的范围内，由精确比较排除
+	if len(found) != 1 || found[0] != "/sdcard/logs/recent.log" {
+		t.Errorf("found %q, want only recent.log", found)
+	}
+
+	var command string
+	for _, call := range f.Calls() {
+		if strings.HasPrefix(call.Command, "find ") {
+			command = call.Command
+		}
+	}
+	if !strings.Contains(command, " -mmin -61 ") {
+		t.Errorf("find command %q does not filter by modification time", command)
+	}
+}
+
+// failingProbe 使 --full-time 探测命令本身失败，例如设备上的 shell 超时
+type failingProbe struct {
+	*adbtest.Fake
+}
+
+func (r *failingProbe) ShellV2(ctx context.Context, serial string, command string) (*adb.ShellResult, error) {
+	if command == "ls -ld --full-time /" {
+		return nil, adb.ErrTimeout
+	}
+	return r.Fake.ShellV2(ctx, serial, command)
+}
+
+func TestSearchFilesProbeFailure(t *testing.T) {
+	f := adbtest.NewFake()
+	f.AddCannedDevice("emu-1")
+	f.PutFile("emu-1", "/sdcard/a.txt", []byte("a"))
+
+	_, err := adb.SearchFiles(context.Background(), &failingProbe{f}, "emu-1", "/sdcard", adb.SearchOptions{MinSize: -1, MaxSize: -1}, func(adb.FoundFile) error { return nil })
+	if err != nil {
+		t.Fatalf("SearchFiles: %v", err)
+	}
+	for _, call := range f.Calls() {
+		if strings.HasPrefix(call.Command, "find ") && strings.Contains(call.Command, "--full-time") {
+			t.Errorf("find used --full-time although the probe failed: %q", call.Command)
+		}
+	}
+}
@@ -0,0 +1,163 @@
+package adb
+
+import (
+	"context"
+	"errors"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultShellPoolSize 是每台设备默认保持的常驻 shell 会话数
+const DefaultShellPoolSize = 2
+
+// ShellPool 包装一个 Runner，让 ShellV2 通过每台设备上常驻的 shell 会话执行，
+// 省去为每次点击、按键或 getprop 建立新连接与启动新进程的开销
+// 会话全部忙碌或命令跨越多行时直接使用底层 Runner，其余方法原样透传
+type ShellPool struct {
+	Runner
+
+	// IdleTimeout 是空闲会话被关闭前的最长时间
+	IdleTimeout time.Duration
+
+	size int
+	mu   sync.Mutex
+	idle map[string][]*pooledSession
+	open map[string]int // 每台设备已打开 (空闲 + 忙碌) 的会话数
+}
+
+type pooledSession struct {
+	ShellSession
+	lastUsed time.Time
+}
+
+// NewShellPool 创建每台设备最多保持 size 个会话的 ShellPool，size <= 0 时使用 DefaultShellPoolSize
+func NewShellPool(r Runner, size int) *ShellPool {
+	if size <= 0 {
+		size = DefaultShellPoolSize
+	}
+	return &ShellPool{
+		Runner:      r,
+		IdleTimeout: 5 * time.Minute,
+		size:        size,
+		idle:        make(map[string][]*pooledSession),
+		open:        make(map[string]int),
+	}
+}
+
+var _ Runner = (*ShellPool)(nil)
+
+// ShellV2 通过会话执行命令；复用的会话已经失效时 (设备断开或 adbd 重启) 自动换用新会话重试一次
+func (p *ShellPool) ShellV2(ctx context.Context, serial string, command string) (*ShellResult, error) {
+	if strings.ContainsAny(command, "\r\n") {
+		return p.Runner.ShellV2(ctx, serial, command)
+	}
+	for attempt := 0; ; attempt++ {
+		s, reused, err := p.get(ctx, serial)
+		if err != nil {
+			var serverErr *ServerError
+			if errors.As(err, &serverErr) && classifyMessage(serverErr.Message) == nil {
+				// adbd 无法启动常驻 shell，退回每条命令一个连接
+				return p.Runner.ShellV2(ctx, serial, command)
+			}
+			return nil, err
+		}
+		if s == nil {
+			// 所有会话都在执行命令 (例如一次较长的 pm install)
+			return p.Runner.ShellV2(ctx, serial, command)
+		}
+		res, err := s.Run(ctx, command)
+		if err == nil {
+			p.put(serial, s)
+			return res, nil
+		}
+		p.discard(serial, s)
+		if reused && attempt == 0 && errors.Is(err, errSessionClosed) && ctx.Err() == nil {
+			// 设备断开过时其余空闲会话也已失效，一并丢弃
+			log.Printf("ShellPool: Session for device '%s' went stale, reconnecting", serial)
+			p.CloseDevice(serial)
+			continue
+		}
+		return nil, err
+	}
+}
+
+// get 取出一个空闲会话，没有空闲会话且未达上限时新建一个
+// 返回 nil 会话表示已达上限，reused 表示会话之前执行过命令
+func (p *ShellPool) get(ctx context.Context, serial string) (*pooledSession, bool, error) {
+	p.mu.Lock()
+	for list := p.idle[serial]; len(list) > 0; list = p.idle[serial] {
+		s := list[len(list)-1]
+		p.idle[serial] = list[:len(list)-1]
+		if p.IdleTimeout > 0 && time.Since(s.lastUsed) > p.IdleTimeout {
+			p.open[serial]--
+			s.Close()
+			continue
+		}
+		p.mu.Unlock()
+		return s, true, nil
+	}
+	if p.open[serial] >= p.size {
+		p.mu.Unlock()
+		return nil, false, nil
+	}
+	p.open[serial]++
+	p.mu.Unlock()
+
+	session, err := p.Runner.OpenShell(ctx, serial)
+	if err != nil {
+		p.mu.Lock()
+		p.open[serial]--
+		p.mu.Unlock()
+		return nil, false, err
+	}
+	return &pooledSession{ShellSession: session}, false, nil
+}
+
+func (p *ShellPool) put(serial string, s *pooledSession) {
+	s.lastUsed = time.Now()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.idle[serial] = append(p.idle[serial], s)
+}
+
+func (p *ShellPool) discard(serial string, s *pooledSession) {
+	s.Close()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.open[serial]--
+}
+
+// CloseDevice 关闭设备的全部空闲会话，通常在设备断开时调用
+// 正在执行命令的会话会在命令结束后因连接失效而被丢弃
+func (p *ShellPool) CloseDevice(serial string) {
+	p.mu.Lock()
+	list := p.idle[serial]
+	delete(p.idle, serial)
+	p.open[serial] -= len(list)
+	p.mu.Unlock()
+	for _, s := range list {
+		s.Close()
+	}
+}
+
+// CloseOnDisconnect 订阅 t 的设备事件，设备断开或离线时关闭其空闲会话，阻塞直到 ctx 结束
+// 不订阅时失效的会话也会在下一次使用时被发现并重建，订阅只是让重建不必等到下一条命令
+func (p *ShellPool) CloseOnDisconnect(ctx context.Context, t *Tracker) {
+	events, stop := t.Subscribe()
+	defer stop()
+	for {
+		select {
+		case ev, ok := <-events:
+			if !ok {
+				return
+			}
+			if ev.Type != DeviceConnected {
+				p.CloseDevice(ev.Device.ID)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
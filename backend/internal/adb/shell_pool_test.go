@@ -0,0 +1,160 @@
+package adb_test
+
+import (
+	"context"
+	"fishyinhe/backend/internal/adb"
+	"fishyinhe/backend/internal/adb/adbtest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// countingRunner 统计 OpenShell 与绕过会话的 ShellV2 调用次数
+type countingRunner struct {
+	adb.Runner
+	opened atomic.Int32
+	direct atomic.Int32
+}
+
+func (r *countingRunner) OpenShell(ctx context.Context, serial string) (adb.ShellSession, error) {
+	r.opened.Add(1)
+	return r.Runner.OpenShell(ctx, serial)
+}
+
+func (r *countingRunner) ShellV2(ctx context.Context, serial string, command string) (*adb.ShellResult, error) {
+	r.direct.Add(1)
+	return r.Runner.ShellV2(ctx, serial, command)
+}
+
+func newTestPool(t *testing.T, size int) (*adbtest.Server, *countingRunner, *adb.ShellPool) {
+	t.Helper()
+	s, c := newTestClient(t)
+	s.AddCannedDevice("emu-1")
+	r := &countingRunner{Runner: c}
+	return s, r, adb.NewShellPool(r, size)
+}
+
+func TestShellPoolSession(t *testing.T) {
+	for _, v2 := range []bool{true, false} {
+		name := "shell,v2"
+		if !v2 {
+			name = "exec:sh"
+		}
+		t.Run(name, func(t *testing.T) {
+			s, r, p := newTestPool(t, 1)
+			s.DisableShellV2 = !v2
+			s.HandleShell("emu-1", "echo ok", adbtest.Response{Stdout: "ok\n"})
+			s.HandleShell("emu-1", "ls /nope", adbtest.Response{Stdout: "partial\n", Stderr: "ls: /nope: No such file or directory\n", ExitCode: 1})
+			s.HandleShell("emu-1", "exit 255", adbtest.Response{ExitCode: 255})
+
+			tests := []struct {
+				command  string
+				stdout   string
+				stderr   string
+				exitCode int
+			}{
+				{"echo ok", "ok\n", "", 0},
+				{"ls /nope", "partial\n", "ls: /nope: No such file or directory\n", 1},
+				{"exit 255", "", "", 255},
+				{"echo ok", "ok\n", "", 0},
+			}
+			for _, tt := range tests {
+				res, err := p.ShellV2(context.Background(), "emu-1", tt.command)
+				if err != nil {
+					t.Fatalf("ShellV2(%q): %v", tt.command, err)
+				}
+				stdout, stderr := tt.stdout, tt.stderr
+				if !v2 {
+					// exec:sh 会话把 stderr 并入 stdout
+					stdout, stderr = stdout+stderr, ""
+				}
+				if string(res.Stdout) != stdout || string(res.Stderr) != stderr || res.ExitCode != tt.exitCode {
+					t.Errorf("ShellV2(%q) = {%q %q %d}, want {%q %q %d}", tt.command, res.Stdout, res.Stderr, res.ExitCode, stdout, stderr, tt.exitCode)
+				}
+			}
+			if n := r.opened.Load(); n != 1 {
+				t.Errorf("opened %d sessions, want the single session to be reused", n)
+			}
+			if n := r.direct.Load(); n != 0 {
+				t.Errorf("%d commands bypassed the session", n)
+			}
+		})
+	}
+}
+
+func TestShellPoolReconnectsStaleSession(t *testing.T) {
+	s, r, p := newTestPool(t, 1)
+	s.HandleShell("emu-1", "getprop ro.serialno", adbtest.Response{Stdout: "emu-1\n"})
+	if _, err := p.ShellV2(context.Background(), "emu-1", "getprop ro.serialno"); err != nil {
+		t.Fatalf("ShellV2: %v", err)
+	}
+
+	// 设备重新连接后 adbd 已重启，池中的会话在读到命令后直接断开
+	s.AddCannedDevice("emu-1")
+	s.HandleShell("emu-1", "ls /nope", adbtest.Response{Stderr: "ls: /nope: No such file or directory\n", ExitCode: 1})
+	res, err := p.ShellV2(context.Background(), "emu-1", "ls /nope")
+	if err != nil {
+		t.Fatalf("ShellV2 after reconnect: %v", err)
+	}
+	if string(res.Stderr) != "ls: /nope: No such file or directory\n" || res.ExitCode != 1 {
+		t.Errorf("ShellV2 after reconnect = {%q %q %d}", res.Stdout, res.Stderr, res.ExitCode)
+	}
+	if n := r.opened.Load(); n != 2 {
+		t.Errorf("opened %d sessions, want the stale one replaced once", n)
+	}
+	if n := r.direct.Load(); n != 0 {
+		t.Errorf("%d commands bypassed the session", n)
+	}
+}
+
+func TestShellPoolDeviceGone(t *testing.T) {
+	s, _, p := newTestPool(t, 1)
+	if _, err := p.ShellV2(context.Background(), "emu-1", "true"); err != nil {
+		t.Fatalf("ShellV2: %v", err)
+	}
+	s.RemoveDevice("emu-1")
+	if _, err := p.ShellV2(context.Background(), "emu-1", "true"); err == nil {
+		t.Fatal("ShellV2 on a removed device succeeded")
+	}
+}
+
+func TestShellPoolMultiLineBypassesSession(t *testing.T) {
+	s, r, p := newTestPool(t, 1)
+	const script = "cd /sdcard\nls"
+	s.HandleShell("emu-1", script, adbtest.Response{Stdout: "Download\n"})
+	res, err := p.ShellV2(context.Background(), "emu-1", script)
+	if err != nil {
+		t.Fatalf("ShellV2: %v", err)
+	}
+	if string(res.Stdout) != "Download\n" {
+		t.Errorf("Stdout = %q", res.Stdout)
+	}
+	if r.opened.Load() != 0 || r.direct.Load() != 1 {
+		t.Errorf("opened %d sessions and ran %d commands directly, want 0 and 1", r.opened.Load(), r.direct.Load())
+	}
+}
+
+func TestShellPoolBusyFallsBack(t *testing.T) {
+	s, r, p := newTestPool(t, 1)
+	s.HandleShell("emu-1", "sleep 1", adbtest.Response{Delay: 300 * time.Millisecond})
+
+	done := make(chan error)
+	go func() {
+		_, err := p.ShellV2(context.Background(), "emu-1", "sleep 1")
+		done <- err
+	}()
+	// 等待会话被占用
+	deadline := time.Now().Add(2 * time.Second)
+	for r.opened.Load() == 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if _, err := p.ShellV2(context.Background(), "emu-1", "true"); err != nil {
+		t.Fatalf("ShellV2 while busy: %v", err)
+	}
+	if err := <-done; err != nil {
+		t.Fatalf("ShellV2(sleep 1): %v", err)
+	}
+	if r.opened.Load() != 1 || r.direct.Load() != 1 {
+		t.Errorf("opened %d sessions and ran %d commands directly, want 1 and 1", r.opened.Load(), r.direct.Load())
+	}
+}
@@ -0,0 +1,204 @@
+package adb
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"sync/atomic"
+)
+
+// ShellSession 是设备上一个常驻的 shell 进程，可以依次执行多条命令而不必为每条命令建立新的连接
+// 同一会话同一时间只能执行一条命令
+type ShellSession interface {
+	// Run 执行一条单行命令，分别返回 stdout、stderr 与退出码
+	// ctx 在命令完成前结束时会话会被关闭，之后不能再使用
+	Run(ctx context.Context, command string) (*ShellResult, error)
+	Close() error
+}
+
+// errSessionClosed 表示会话在返回任何输出之前就已经断开，通常是设备断开或 adbd 重启导致的失效会话
+var errSessionClosed = errors.New("adb: shell session closed")
+
+// OpenShell 在设备上启动一个常驻的 sh 进程
+// 优先使用 shell,v2 以区分 stdout 与 stderr，adbd 不支持时回退到 exec:sh (stderr 并入 stdout)
+// ctx 只用于建立会话，会话在 Close 之前一直有效
+func (c *Client) OpenShell(ctx context.Context, serial string) (ShellSession, error) {
+	v2 := true
+	conn, err := c.openService(ctx, serial, "shell,v2,raw:sh")
+	if err != nil {
+		var serverErr *ServerError
+		if !errors.As(err, &serverErr) || classifyMessage(serverErr.Message) != nil {
+			return nil, err
+		}
+		v2 = false
+		if conn, err = c.openService(ctx, serial, "exec:sh"); err != nil {
+			return nil, err
+		}
+	}
+	// 会话的生命周期与建立它的 ctx 无关
+	if !conn.stop() {
+		conn.Conn.Close()
+		return nil, ctx.Err()
+	}
+	return &shellSession{conn: conn.Conn, v2: v2}, nil
+}
+
+// shellSession 通过哨兵行划分每条命令的输出:
+// 命令在子 shell 中执行 (避免 cd、exit 等影响会话)，结束后向 stdout 输出 "<marker><退出码>"，
+// 并向 stderr 输出 "<marker>"，读到两者即表示命令完成
+type shellSession struct {
+	conn net.Conn
+	v2   bool
+
+	mu     sync.Mutex // 保证同一时间只有一条命令在执行
+	broken atomic.Bool
+}
+
+// newMarker 生成每条命令唯一的哨兵，避免与命令输出冲突
+func newMarker() string {
+	var b [8]byte
+	rand.Read(b[:])
+	return "__FISHYINHE_" + hex.EncodeToString(b[:]) + "__"
+}
+
+// frameShellCommand 返回在常驻 shell 中执行 command 时实际写入 stdin 的内容
+// mergeStderr 为 true 时 (exec:sh 会话) stderr 并入 stdout，只在 stdout 上输出哨兵
+func frameShellCommand(command string, marker string, mergeStderr bool) string {
+	if mergeStderr {
+		return fmt.Sprintf("(%s\n) </dev/null 2>&1; echo %s$?\n", command, marker)
+	}
+	return fmt.Sprintf("(%s\n) </dev/null; echo %s$?; echo %s >&2\n", command, marker, marker)
+}
+
+func (s *shellSession) Run(ctx context.Context, command string) (*ShellResult, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.broken.Load() {
+		return nil, errSessionClosed
+	}
+
+	stop := context.AfterFunc(ctx, func() { s.conn.Close() })
+	defer stop()
+
+	res, err := s.run(command)
+	if err != nil {
+		s.broken.Store(true)
+		s.conn.Close()
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return nil, ctxErr
+		}
+		return nil, err
+	}
+	return res, nil
+}
+
+func (s *shellSession) run(command string) (*ShellResult, error) {
+	marker := newMarker()
+	input := []byte(frameShellCommand(command, marker, !s.v2))
+	if s.v2 {
+		packet := make([]byte, 5+len(input))
+		packet[0] = shellIDStdin
+		binary.LittleEndian.PutUint32(packet[1:], uint32(len(input)))
+		copy(packet[5:], input)
+		input = packet
+	}
+	if _, err := s.conn.Write(input); err != nil {
+		return nil, fmt.Errorf("%w: %v", errSessionClosed, err)
+	}
+
+	var stdout, stderr bytes.Buffer
+	received := false
+	for {
+		if res, ok := parseFramedOutput(&stdout, &stderr, marker, s.v2); ok {
+			return res, nil
+		}
+		var err error
+		if s.v2 {
+			err = s.readPacket(&stdout, &stderr)
+		} else {
+			err = s.readRaw(&stdout)
+		}
+		if err != nil {
+			if !received && (err == io.EOF || errors.Is(err, net.ErrClosed)) {
+				return nil, errSessionClosed
+			}
+			return nil, err
+		}
+		received = true
+	}
+}
+
+// readPacket 读取一个 shell,v2 数据包并追加到对应的缓冲区
+func (s *shellSession) readPacket(stdout, stderr *bytes.Buffer) error {
+	var header [5]byte
+	if _, err := io.ReadFull(s.conn, header[:]); err != nil {
+		return err
+	}
+	size := int64(binary.LittleEndian.Uint32(header[1:]))
+	switch header[0] {
+	case shellIDStdout:
+		_, err := io.CopyN(stdout, s.conn, size)
+		return err
+	case shellIDStderr:
+		_, err := io.CopyN(stderr, s.conn, size)
+		return err
+	case shellIDExit:
+		// sh 本身退出了 (例如被杀死)，会话不再可用
+		return io.EOF
+	default:
+		_, err := io.CopyN(io.Discard, s.conn, size)
+		return err
+	}
+}
+
+func (s *shellSession) readRaw(stdout *bytes.Buffer) error {
+	buf := make([]byte, 32*1024)
+	n, err := s.conn.Read(buf)
+	stdout.Write(buf[:n])
+	if n > 0 {
+		return nil
+	}
+	return err
+}
+
+// parseFramedOutput 在 stdout (以及 v2 时的 stderr) 中都出现哨兵后返回命令结果
+func parseFramedOutput(stdout, stderr *bytes.Buffer, marker string, v2 bool) (*ShellResult, bool) {
+	out := stdout.Bytes()
+	idx := bytes.Index(out, []byte(marker))
+	if idx < 0 {
+		return nil, false
+	}
+	rest := out[idx+len(marker):]
+	nl := bytes.IndexByte(rest, '\n')
+	if nl < 0 {
+		return nil, false
+	}
+	code, err := strconv.Atoi(string(rest[:nl]))
+	if err != nil {
+		code = -1
+	}
+	res := &ShellResult{Stdout: append([]byte(nil), out[:idx]...), ExitCode: code}
+	if v2 {
+		errOut := stderr.Bytes()
+		errIdx := bytes.Index(errOut, []byte(marker+"\n"))
+		if errIdx < 0 {
+			return nil, false
+		}
+		res.Stderr = append([]byte(nil), errOut[:errIdx]...)
+	}
+	return res, true
+}
+
+// Close 可以在 Run 执行期间调用，此时 Run 会立即返回错误
+func (s *shellSession) Close() error {
+	s.broken.Store(true)
+	return s.conn.Close()
+}
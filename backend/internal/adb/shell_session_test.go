@@ -0,0 +1,72 @@
+package adb
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestFrameShellCommand(t *testing.T) {
+	const marker = "__FISHYINHE_0011223344556677__"
+	tests := []struct {
+		command     string
+		mergeStderr bool
+		want        string
+	}{
+		{"getprop ro.product.model", false, "(getprop ro.product.model\n) </dev/null; echo " + marker + "$?; echo " + marker + " >&2\n"},
+		{"getprop ro.product.model", true, "(getprop ro.product.model\n) </dev/null 2>&1; echo " + marker + "$?\n"},
+		// 以注释结尾的命令不能吞掉后面的哨兵
+		{"ls /sdcard # trailing comment", false, "(ls /sdcard # trailing comment\n) </dev/null; echo " + marker + "$?; echo " + marker + " >&2\n"},
+	}
+	for _, tt := range tests {
+		if got := frameShellCommand(tt.command, marker, tt.mergeStderr); got != tt.want {
+			t.Errorf("frameShellCommand(%q, %v) = %q, want %q", tt.command, tt.mergeStderr, got, tt.want)
+		}
+	}
+}
+
+func TestNewMarkerUnique(t *testing.T) {
+	a, b := newMarker(), newMarker()
+	if a == b {
+		t.Errorf("newMarker returned %q twice", a)
+	}
+	if strings.ContainsAny(a, " \t\n$'\"") {
+		t.Errorf("marker %q needs quoting in sh", a)
+	}
+}
+
+func TestParseFramedOutput(t *testing.T) {
+	const marker = "__M__"
+	tests := []struct {
+		name       string
+		stdout     string
+		stderr     string
+		v2         bool
+		ok         bool
+		wantStdout string
+		wantStderr string
+		wantCode   int
+	}{
+		{name: "complete", stdout: "out\n__M__0\n", stderr: "err\n__M__\n", v2: true, ok: true, wantStdout: "out\n", wantStderr: "err\n"},
+		{name: "exit code", stdout: "__M__127\n", stderr: "sh: nope: not found\n__M__\n", v2: true, ok: true, wantStderr: "sh: nope: not found\n", wantCode: 127},
+		{name: "output without trailing newline", stdout: "no newline__M__0\n", stderr: "__M__\n", v2: true, ok: true, wantStdout: "no newline"},
+		{name: "stdout marker not terminated", stdout: "out\n__M__1", stderr: "__M__\n", v2: true},
+		{name: "stderr marker missing", stdout: "out\n__M__0\n", stderr: "err\n", v2: true},
+		{name: "merged", stdout: "out\nerr\n__M__2\n", ok: true, wantStdout: "out\nerr\n", wantCode: 2},
+		{name: "merged ignores stderr", stdout: "__M__0\n", stderr: "unused", ok: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res, ok := parseFramedOutput(bytes.NewBufferString(tt.stdout), bytes.NewBufferString(tt.stderr), marker, tt.v2)
+			if ok != tt.ok {
+				t.Fatalf("parseFramedOutput ok = %v, want %v", ok, tt.ok)
+			}
+			if !ok {
+				return
+			}
+			if string(res.Stdout) != tt.wantStdout || string(res.Stderr) != tt.wantStderr || res.ExitCode != tt.wantCode {
+				t.Errorf("parseFramedOutput = {%q %q %d}, want {%q %q %d}", res.Stdout, res.Stderr, res.ExitCode, tt.wantStdout, tt.wantStderr, tt.wantCode)
+			}
+		})
+	}
+}
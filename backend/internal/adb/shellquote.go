@@ -0,0 +1,89 @@
+package adb
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+// adbd 把命令交给设备上的 sh 解释，所有来自用户的参数都必须经过 QuoteArg 或 ShellCommand 转义，
+// 否则空格、引号、; 或 $() 会拆分参数甚至在设备上执行任意命令
+
+// safeShellArg 匹配无需加引号的参数，保持常见命令 (ls -la /sdcard/、pm list packages -3) 的可读性
+var safeShellArg = regexp.MustCompile(`^[A-Za-z0-9_@%+=:,./-]+$`)
+
+// QuoteArg 将 s 转义为 sh 中的单个参数
+// 除安全字符外一律使用单引号包裹，参数中的单引号先结束引号、转义后再重新开始引号
+func QuoteArg(s string) string {
+	if s == "" {
+		return "''"
+	}
+	if safeShellArg.MatchString(s) {
+		return s
+	}
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// ShellCommand 拼接命令名与逐个转义后的参数
+// name 必须是可信的常量 (例如 "pm uninstall")，不会被转义
+func ShellCommand(name string, args ...string) string {
+	var b strings.Builder
+	b.WriteString(name)
+	for _, arg := range args {
+		b.WriteByte(' ')
+		b.WriteString(QuoteArg(arg))
+	}
+	return b.String()
+}
+
+// packageNamePattern 是 Android 应用包名的语法: 至少两段，每段以字母开头，由字母、数字与下划线组成
+var packageNamePattern = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_]*(\.[A-Za-z][A-Za-z0-9_]*)+$`)
+
+// maxPackageNameLength 与 PackageManager 对包名长度的限制一致
+const maxPackageNameLength = 255
+
+// ValidatePackageName 检查 name 是否为合法的应用包名
+func ValidatePackageName(name string) error {
+	if len(name) > maxPackageNameLength || !packageNamePattern.MatchString(name) {
+		return fmt.Errorf("%w: invalid package name %q", ErrInvalidArgument, name)
+	}
+	return nil
+}
+
+// 设备文件系统 (ext4/f2fs) 的路径与文件名长度限制
+const (
+	maxRemotePathLength = 4096
+	maxRemoteNameLength = 255
+)
+
+// ValidateRemotePath 检查 p 是否为可以传给设备命令的路径:
+// 绝对路径、合法 UTF-8、不含 NUL 等控制字符 (换行会破坏按行解析的命令输出)、不含 .. 路径段，且长度在文件系统限制之内
+// 拒绝 .. 使调用者按前缀做的检查 (例如受保护目录、应用私有目录) 不会被 /sdcard/../data 绕过
+func ValidateRemotePath(p string) error {
+	invalid := func(reason string) error {
+		return fmt.Errorf("%w: invalid remote path %q: %s", ErrInvalidArgument, p, reason)
+	}
+	switch {
+	case !strings.HasPrefix(p, "/"):
+		return invalid("must be absolute")
+	case len(p) > maxRemotePathLength:
+		return invalid("too long")
+	case !utf8.ValidString(p):
+		return invalid("not valid UTF-8")
+	}
+	for _, r := range p {
+		if r < 0x20 || r == 0x7f {
+			return invalid("contains control characters")
+		}
+	}
+	for _, name := range strings.Split(p, "/") {
+		if len(name) > maxRemoteNameLength {
+			return invalid("file name too long")
+		}
+		if name == ".." {
+			return invalid("must not contain .. components")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,173 @@
+package adb_test
+
+import (
+	"errors"
+	"fishyinhe/backend/internal/adb"
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+// hostileArgs 是来自用户的参数及其转义结果，转义后交给 sh 必须原样还原为一个参数
+var hostileArgs = []struct {
+	arg    string
+	quoted string
+}{
+	{"", "''"},
+	{"com.example.app", "com.example.app"},
+	{"/sdcard/DCIM/", "/sdcard/DCIM/"},
+	{"-3", "-3"},
+	{"a b", "'a b'"},
+	{"it's", `'it'\''s'`},
+	{"'''", `''\'''\'''\'''`},
+	{`say "hi"`, `'say "hi"'`},
+	{`"; rm -rf / #`, `'"; rm -rf / #'`},
+	{"a;reboot", "'a;reboot'"},
+	{"a && reboot", "'a && reboot'"},
+	{"a | sh", "'a | sh'"},
+	{"$(reboot)", "'$(reboot)'"},
+	{"${HOME}", "'${HOME}'"},
+	{"`id`", "'`id`'"},
+	{"line1\nline2", "'line1\nline2'"},
+	{"tab\there", "'tab\there'"},
+	{`back\slash`, `'back\slash'`},
+	{"*.log", "'*.log'"},
+	{"~/x", "'~/x'"},
+	{"a>b", "'a>b'"},
+	{"ü€ 中文", "'ü€ 中文'"},
+	{"%s%n", "%s%n"},
+}
+
+func TestQuoteArg(t *testing.T) {
+	for _, tt := range hostileArgs {
+		if got := adb.QuoteArg(tt.arg); got != tt.quoted {
+			t.Errorf("QuoteArg(%q) = %s, want %s", tt.arg, got, tt.quoted)
+		}
+	}
+}
+
+// TestQuoteArgShellRoundTrip 在本机的 sh 中执行转义后的参数，确认设备上的 sh 看到的正是原来的参数
+func TestQuoteArgShellRoundTrip(t *testing.T) {
+	sh, err := exec.LookPath("sh")
+	if err != nil {
+		t.Skip("sh not available")
+	}
+	for _, tt := range hostileArgs {
+		out, err := exec.Command(sh, "-c", "printf %s "+adb.QuoteArg(tt.arg)).Output()
+		if err != nil {
+			t.Errorf("sh -c printf %%s %s: %v", adb.QuoteArg(tt.arg), err)
+			continue
+		}
+		if string(out) != tt.arg {
+			t.Errorf("sh saw %q, want %q", out, tt.arg)
+		}
+	}
+}
+
+func TestQuoteArgNUL(t *testing.T) {
+	// sh 的参数不能包含 NUL，QuoteArg 不会移除它，因此路径在转义前必须经过 ValidateRemotePath
+	if got := adb.QuoteArg("a\x00b"); got != "'a\x00b'" {
+		t.Errorf("QuoteArg(NUL) = %q", got)
+	}
+}
+
+func TestShellCommand(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		want string
+	}{
+		{"pm list packages", []string{"-3"}, "pm list packages -3"},
+		{"input text", []string{"hi there; reboot"}, "input text 'hi there; reboot'"},
+		{"ls -la --full-time", []string{"/sdcard/it's mine/"}, `ls -la --full-time '/sdcard/it'\''s mine/'`},
+		{"rm -f", []string{"/data/local/tmp/a", "$(id)"}, "rm -f /data/local/tmp/a '$(id)'"},
+		{"am force-stop", nil, "am force-stop"},
+	}
+	for _, tt := range tests {
+		if got := adb.ShellCommand(tt.name, tt.args...); got != tt.want {
+			t.Errorf("ShellCommand(%q, %q) = %s, want %s", tt.name, tt.args, got, tt.want)
+		}
+	}
+}
+
+func TestValidatePackageName(t *testing.T) {
+	tests := []struct {
+		name  string
+		valid bool
+	}{
+		{"com.example.app", true},
+		{"a.b", true},
+		{"A_1.b2", true},
+		{"com.android.chrome", true},
+		{"", false},
+		{"app", false},
+		{"-3", false},
+		{"-f.x", false},
+		{"--user.0", false},
+		{".com.example", false},
+		{"com.example.", false},
+		{"com..example", false},
+		{"1com.example", false},
+		{"com.1example", false},
+		{"com.example;reboot", false},
+		{"com.example && reboot", false},
+		{"com.ex ample", false},
+		{"com.example$(id)", false},
+		{"com.example`id`", false},
+		{"com.example'x", false},
+		{`com.example"x`, false},
+		{"com.example\nreboot", false},
+		{"com.example\x00.x", false},
+		{"com/example.app", false},
+		{"com.exämple.app", false},
+		{"a." + strings.Repeat("b", 255), false},
+	}
+	for _, tt := range tests {
+		err := adb.ValidatePackageName(tt.name)
+		if tt.valid && err != nil {
+			t.Errorf("ValidatePackageName(%q) = %v, want nil", tt.name, err)
+		}
+		if !tt.valid && !errors.Is(err, adb.ErrInvalidArgument) {
+			t.Errorf("ValidatePackageName(%q) = %v, want ErrInvalidArgument", tt.name, err)
+		}
+	}
+}
+
+func TestValidateRemotePath(t *testing.T) {
+	tests := []struct {
+		path  string
+		valid bool
+	}{
+		{"/", true},
+		{"/sdcard/", true},
+		{"/sdcard/DCIM/Camera/IMG_0001.jpg", true},
+		{"/sdcard/it's mine; $(reboot) `id`.txt", true},
+		{"/sdcard/中文 文件.txt", true},
+		{"/sdcard/.hidden/..name", true},
+		{"/sdcard/./x", true},
+		{"", false},
+		{"sdcard/x", false},
+		{"-rf", false},
+		{"../etc/passwd", false},
+		{"/sdcard/..", false},
+		{"/sdcard/../data/data/com.example.app", false},
+		{"/data/data/com.example.app/../../", false},
+		{"/sdcard/a\nb", false},
+		{"/sdcard/a\rb", false},
+		{"/sdcard/a\tb", false},
+		{"/sdcard/a\x00b", false},
+		{"/sdcard/a\x7fb", false},
+		{"/sdcard/\xff\xfe", false},
+		{"/sdcard/" + strings.Repeat("a", 256), false},
+		{"/" + strings.Repeat("a/", 2100), false},
+	}
+	for _, tt := range tests {
+		err := adb.ValidateRemotePath(tt.path)
+		if tt.valid && err != nil {
+			t.Errorf("ValidateRemotePath(%q) = %v, want nil", tt.path, err)
+		}
+		if !tt.valid && !errors.Is(err, adb.ErrInvalidArgument) {
+			t.Errorf("ValidateRemotePath(%q) = %v, want ErrInvalidArgument", tt.path, err)
+		}
+	}
+}
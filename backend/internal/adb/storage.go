@@ -0,0 +1,180 @@
+package adb
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// StorageInfo 是一个分区的空间使用情况，大小以字节为单位
+type StorageInfo struct {
+	// Label 为 data、sdcard、system 或 external (外置 SD 卡)
+	Label      string  `json:"label"`
+	Path       string  `json:"path"` // 查询时使用的路径
+	Filesystem string  `json:"filesystem"`
+	MountedOn  string  `json:"mountedOn"`
+	Size       int64   `json:"size"`
+	Used       int64   `json:"used"`
+	Available  int64   `json:"available"`
+	UsePercent float64 `json:"usePercent"`
+	// Low 表示剩余空间不足，安装应用或写入文件可能失败 (例如 INSTALL_FAILED_INSUFFICIENT_STORAGE)
+	Low bool `json:"low"`
+}
+
+// lowStorageBytes 与 lowStorageRatio 是判断剩余空间不足的阈值，满足其一即视为不足
+// Android 自身在 /data 剩余不足 5% 或 500 MB 时开始拒绝安装
+const (
+	lowStorageBytes = 500 << 20
+	lowStorageRatio = 0.05
+)
+
+// storagePaths 是 DeviceStorage 查询的分区，system-as-root 的设备上 /system 对应的是 /
+var storagePaths = []struct{ label, path string }{
+	{"data", "/data"},
+	{"sdcard", "/sdcard"},
+	{"system", "/system"},
+}
+
+// externalVolume 匹配外置存储卷的名称，例如 /storage/1A2B-3C4D
+var externalVolume = regexp.MustCompile(`^[0-9A-F]{4}-[0-9A-F]{4}$`)
+
+// parseHumanSize 解析旧版 toolbox df 输出的大小，例如 12.5G、512K、0
+func parseHumanSize(s string) (int64, bool) {
+	mult := float64(1)
+	if n := len(s); n > 0 {
+		switch s[n-1] {
+		case 'K':
+			mult = 1 << 10
+		case 'M':
+			mult = 1 << 20
+		case 'G':
+			mult = 1 << 30
+		case 'T':
+			mult = 1 << 40
+		}
+		if mult != 1 {
+			s = s[:n-1]
+		}
+	}
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, false
+	}
+	return int64(v * mult), true
+}
+
+// parseDf 解析对单个路径执行 df 的输出
+// toybox "df -k": Filesystem 1K-blocks Used Available Use% Mounted on (过长的文件系统名可能使数据折行)
+// 旧版 toolbox "df": Filesystem Size Used Free Blksize，其中第一列实际是挂载点，大小带有 K/M/G 单位
+func parseDf(out string) (*StorageInfo, error) {
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	if len(lines) < 2 {
+		return nil, fmt.Errorf("unexpected df output: %q", out)
+	}
+	header := lines[0]
+	fields := strings.Fields(strings.Join(lines[1:], " "))
+
+	if strings.Contains(header, "Blksize") {
+		if len(fields) < 5 {
+			return nil, fmt.Errorf("unexpected df output: %q", out)
+		}
+		info := &StorageInfo{Filesystem: fields[0], MountedOn: fields[0]}
+		var ok1, ok2, ok3 bool
+		info.Size, ok1 = parseHumanSize(fields[1])
+		info.Used, ok2 = parseHumanSize(fields[2])
+		info.Available, ok3 = parseHumanSize(fields[3])
+		if !ok1 || !ok2 || !ok3 {
+			return nil, fmt.Errorf("unexpected df output: %q", out)
+		}
+		return info, nil
+	}
+
+	n := len(fields)
+	if n < 6 {
+		return nil, fmt.Errorf("unexpected df output: %q", out)
+	}
+	var kb [3]int64
+	for i := range kb {
+		v, err := strconv.ParseInt(fields[n-5+i], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("unexpected df output: %q", out)
+		}
+		kb[i] = v << 10
+	}
+	return &StorageInfo{
+		Filesystem: strings.Join(fields[:n-5], " "),
+		MountedOn:  fields[n-1],
+		Size:       kb[0],
+		Used:       kb[1],
+		Available:  kb[2],
+	}, nil
+}
+
+// statStorage 对 p 执行 df 并返回其所在分区的信息
+func statStorage(ctx context.Context, r Runner, deviceId string, p string) (*StorageInfo, error) {
+	command := ShellCommand("df -k", p)
+	res, err := runShell(ctx, r, OpShell, deviceId, command)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkShell(command, res); err != nil {
+		if len(res.Stdout) != 0 {
+			return nil, err
+		}
+		// 旧版 toolbox df 不支持 -k；不带 -k 也失败时报告原来的错误
+		legacy := ShellCommand("df", p)
+		lres, lerr := runShell(ctx, r, OpShell, deviceId, legacy)
+		if lerr != nil || checkShell(legacy, lres) != nil {
+			return nil, err
+		}
+		res = lres
+	}
+	return parseStorage(p, string(res.Stdout))
+}
+
+// parseStorage 解析 df 的输出并计算使用率与剩余空间是否不足
+func parseStorage(p string, out string) (*StorageInfo, error) {
+	info, err := parseDf(out)
+	if err != nil {
+		return nil, err
+	}
+	info.Path = p
+	if info.Size > 0 {
+		info.UsePercent = float64(int64(float64(info.Used)/float64(info.Size)*1000)) / 10
+	}
+	info.Low = info.Size > 0 && (info.Available < lowStorageBytes || float64(info.Available) < float64(info.Size)*lowStorageRatio)
+	return info, nil
+}
+
+// DeviceStorage 返回 data、sdcard、system 分区以及外置存储卷的空间使用情况
+// 无法查询的分区 (例如没有权限) 会被跳过并记录日志
+func DeviceStorage(ctx context.Context, r Runner, deviceId string) ([]StorageInfo, error) {
+	targets := append([]struct{ label, path string }(nil), storagePaths...)
+	if res, err := runShell(ctx, r, OpShell, deviceId, "ls /storage"); err == nil {
+		for _, name := range strings.Fields(string(res.Stdout)) {
+			if externalVolume.MatchString(name) {
+				targets = append(targets, struct{ label, path string }{"external", "/storage/" + name})
+			}
+		}
+	}
+
+	var result []StorageInfo
+	var lastErr error
+	for _, t := range targets {
+		info, err := statStorage(ctx, r, deviceId, t.path)
+		if err != nil {
+			log.Printf("DeviceStorage: Failed to query %s on device %s: %v", t.path, deviceId, err)
+			lastErr = err
+			continue
+		}
+		info.Label = t.label
+		result = append(result, *info)
+	}
+	if len(result) == 0 && lastErr != nil {
+		return nil, lastErr
+	}
+	return result, nil
+}
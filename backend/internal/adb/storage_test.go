@@ -0,0 +1,117 @@
+package adb
+
+import "testing"
+
+func TestParseHumanSize(t *testing.T) {
+	tests := []struct {
+		in   string
+		want int64
+		ok   bool
+	}{
+		{"0", 0, true},
+		{"4096", 4096, true},
+		{"512K", 512 << 10, true},
+		{"3M", 3 << 20, true},
+		{"12.5G", 25 << 29, true},
+		{"1.5T", 3 << 39, true},
+		{"", 0, false},
+		{"G", 0, false},
+		{"12.5X", 0, false},
+	}
+	for _, tt := range tests {
+		got, ok := parseHumanSize(tt.in)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("parseHumanSize(%q) = %d, %v, want %d, %v", tt.in, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestParseDf(t *testing.T) {
+	tests := []struct {
+		name string
+		out  string
+		want StorageInfo
+	}{
+		{
+			name: "toybox",
+			out: "Filesystem      1K-blocks     Used Available Use% Mounted on\n" +
+				"/dev/block/dm-5 115075600 45234288  69710240  40% /data\n",
+			want: StorageInfo{Filesystem: "/dev/block/dm-5", MountedOn: "/data", Size: 115075600 << 10, Used: 45234288 << 10, Available: 69710240 << 10},
+		},
+		{
+			name: "toybox wrapped line",
+			out: "Filesystem                                    1K-blocks     Used Available Use% Mounted on\n" +
+				"/dev/block/platform/soc/1d84000.ufshc/by-name/system\n" +
+				"                                                3096336  3075116     21220 100% /\n",
+			want: StorageInfo{Filesystem: "/dev/block/platform/soc/1d84000.ufshc/by-name/system", MountedOn: "/", Size: 3096336 << 10, Used: 3075116 << 10, Available: 21220 << 10},
+		},
+		{
+			name: "toybox fuse sdcard",
+			out: "Filesystem     1K-blocks     Used Available Use% Mounted on\n" +
+				"/dev/fuse      115075600 45234288  69710240  40% /storage/emulated\n",
+			want: StorageInfo{Filesystem: "/dev/fuse", MountedOn: "/storage/emulated", Size: 115075600 << 10, Used: 45234288 << 10, Available: 69710240 << 10},
+		},
+		{
+			name: "toolbox",
+			out: "Filesystem               Size     Used     Free   Blksize\n" +
+				"/data                   12.5G    3.25G    9.25G   4096\n",
+			want: StorageInfo{Filesystem: "/data", MountedOn: "/data", Size: 25 << 29, Used: 13 << 28, Available: 37 << 28},
+		},
+		{
+			name: "toolbox kilobytes",
+			out: "Filesystem             Size   Used   Free   Blksize\n" +
+				"/mnt/secure/asec      512K     0K   512K   4096\n",
+			want: StorageInfo{Filesystem: "/mnt/secure/asec", MountedOn: "/mnt/secure/asec", Size: 512 << 10, Available: 512 << 10},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseDf(tt.out)
+			if err != nil {
+				t.Fatalf("parseDf: %v", err)
+			}
+			if *got != tt.want {
+				t.Errorf("parseDf =\n  %+v\nwant\n  %+v", *got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseDfErrors(t *testing.T) {
+	for _, out := range []string{
+		"",
+		"Filesystem 1K-blocks Used Available Use% Mounted on\n",
+		"Filesystem 1K-blocks Used Available Use% Mounted on\n/dev/block/dm-5 big 1 1 1% /data\n",
+		"Filesystem Size Used Free Blksize\n/data 12.5G 3.2G\n",
+		"Filesystem Size Used Free Blksize\n/data 12.5Q 3.2G 9.3G 4096\n",
+	} {
+		if info, err := parseDf(out); err == nil {
+			t.Errorf("parseDf(%q) = %+v, want an error", out, info)
+		}
+	}
+}
+
+func TestParseStorageLow(t *testing.T) {
+	tests := []struct {
+		name    string
+		out     string
+		percent float64
+		low     bool
+	}{
+		// 21 MB 可用
+		{"nearly full system", "Filesystem 1K-blocks Used Available Use% Mounted on\n/dev/root 3096336 3075116 21220 100% /\n", 99.3, true},
+		// 66 GB 可用
+		{"roomy data", "Filesystem 1K-blocks Used Available Use% Mounted on\n/dev/block/dm-5 115075600 45234288 69710240 40% /data\n", 39.3, false},
+		// 600 MB 可用，但不足 5%
+		{"large disk under 5%", "Filesystem 1K-blocks Used Available Use% Mounted on\n/dev/block/dm-5 20000000 19385600 614400 97% /data\n", 96.9, true},
+	}
+	for _, tt := range tests {
+		info, err := parseStorage("/data", tt.out)
+		if err != nil {
+			t.Fatalf("%s: parseStorage: %v", tt.name, err)
+		}
+		if info.UsePercent != tt.percent || info.Low != tt.low {
+			t.Errorf("%s: UsePercent = %v, Low = %v, want %v, %v", tt.name, info.UsePercent, info.Low, tt.percent, tt.low)
+		}
+	}
+}
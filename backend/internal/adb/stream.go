@@ -0,0 +1,149 @@
+package adb
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"time"
+)
+
+// StatFile 获取远程文件信息，文件不存在时返回 ErrPathNotFound
+func StatFile(ctx context.Context, r Runner, deviceId string, remotePath string) (*RemoteStat, error) {
+	if err := ValidateRemotePath(remotePath); err != nil {
+		return nil, err
+	}
+	statCtx, cancel := withTimeout(ctx, OpShell)
+	defer cancel()
+	st, err := r.Stat(statCtx, deviceId, remotePath)
+	if err = wrapTimeout(statCtx, OpShell, "stat "+remotePath, err); err != nil {
+		return nil, err
+	}
+	if !st.Exists() {
+		return nil, fmt.Errorf("%w: %s", ErrPathNotFound, remotePath)
+	}
+	return st, nil
+}
+
+// limitedBuffer 在写入超过 max 字节时返回 ErrFileTooLarge，用于防止文件在 STAT 之后变大
+type limitedBuffer struct {
+	bytes.Buffer
+	max int64
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if int64(b.Len()+len(p)) > b.max {
+		return 0, fmt.Errorf("%w: more than %d bytes", ErrFileTooLarge, b.max)
+	}
+	return b.Buffer.Write(p)
+}
+
+// readRaw 读取不超过 maxBytes 的远程文件，目录返回 ErrInvalidArgument，超过大小返回 ErrFileTooLarge
+func readRaw(ctx context.Context, r Runner, deviceId string, p string, st *RemoteStat, maxBytes int64) ([]byte, error) {
+	if st.Mode.IsDir() {
+		return nil, fmt.Errorf("%w: %s is a directory", ErrInvalidArgument, p)
+	}
+	if st.Size > maxBytes {
+		return nil, fmt.Errorf("%w: %s is %d bytes, the limit is %d", ErrFileTooLarge, p, st.Size, maxBytes)
+	}
+	pullCtx, cancel := withTimeout(ctx, OpTransfer)
+	defer cancel()
+	buf := &limitedBuffer{max: maxBytes}
+	err := r.Pull(pullCtx, deviceId, p, buf)
+	if err = wrapTimeout(pullCtx, OpTransfer, "pull "+p, err); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// ReadFile 将不超过 maxBytes 的远程文件读入内存，同时返回读取前的 STAT 结果
+// 目录返回 ErrInvalidArgument，超过大小返回 ErrFileTooLarge
+func ReadFile(ctx context.Context, r Runner, deviceId string, remotePath string, maxBytes int64) ([]byte, *RemoteStat, error) {
+	st, err := StatFile(ctx, r, deviceId, remotePath)
+	if err != nil {
+		return nil, nil, err
+	}
+	data, err := readRaw(WithPriority(ctx, PriorityBulk), r, deviceId, remotePath, st, maxBytes)
+	if err != nil {
+		return nil, nil, err
+	}
+	return data, st, nil
+}
+
+// StreamFile 将远程文件从 offset 开始的 length 字节直接写入 w，length < 0 表示读到文件末尾
+// 完整文件通过 sync RECV 传输；指定范围时通过 "tail -c +N | head -c M" 只读取需要的部分，
+// 避免为了视频拖动或断点续传而传输整个文件
+// 传输时长取决于接收方 (例如浏览器边下边播)，因此不设置超时，由 ctx 在客户端断开时结束传输
+func StreamFile(ctx context.Context, r Runner, deviceId string, remotePath string, offset int64, length int64, w io.Writer) error {
+	if err := ValidateRemotePath(remotePath); err != nil {
+		return err
+	}
+	ctx = WithPriority(ctx, PriorityBulk)
+	if offset == 0 && length < 0 {
+		return r.Pull(ctx, deviceId, remotePath, w)
+	}
+
+	command := ShellCommand("tail -c", "+"+strconv.FormatInt(offset+1, 10), remotePath)
+	if length >= 0 {
+		command += " | " + ShellCommand("head -c", strconv.FormatInt(length, 10))
+	}
+	stream, err := r.Exec(ctx, deviceId, command)
+	if err != nil {
+		return err
+	}
+	defer stream.Close()
+	n, err := io.Copy(w, stream)
+	if err != nil {
+		return err
+	}
+	if length >= 0 && n < length {
+		log.Printf("StreamFile: Short read from '%s' on device '%s': got %d of %d bytes", remotePath, deviceId, n, length)
+		return fmt.Errorf("stream %s: %w", remotePath, io.ErrUnexpectedEOF)
+	}
+	return nil
+}
+
+// TransferProgress 在传输过程中被调用，sent 是已发送的字节数，total 是总字节数
+type TransferProgress func(sent int64, total int64)
+
+// progressInterval 是两次进度报告之间的最短间隔，传输结束时总会报告一次
+const progressInterval = 250 * time.Millisecond
+
+// progressReader 统计读取的字节数并按 progressInterval 报告进度
+type progressReader struct {
+	r     io.Reader
+	sent  int64
+	total int64
+	last  time.Time
+	fn    TransferProgress
+}
+
+func (p *progressReader) Read(b []byte) (int, error) {
+	n, err := p.r.Read(b)
+	if n > 0 {
+		p.sent += int64(n)
+		if p.sent >= p.total || time.Since(p.last) >= progressInterval {
+			p.last = time.Now()
+			p.fn(p.sent, p.total)
+		}
+	}
+	return n, err
+}
+
+// PushStream 通过 sync SEND 将 src 的内容写入远程文件，adbd 会自动创建不存在的父目录
+// mode 只使用权限位，mtime 为零时使用当前时间
+func PushStream(ctx context.Context, r Runner, deviceId string, src io.Reader, remotePath string, mode os.FileMode, mtime time.Time) error {
+	if err := ValidateRemotePath(remotePath); err != nil {
+		return err
+	}
+	if mtime.IsZero() {
+		mtime = time.Now()
+	}
+	pushCtx, cancel := withTimeout(WithPriority(ctx, PriorityBulk), OpTransfer)
+	defer cancel()
+	err := r.Push(pushCtx, deviceId, src, remotePath, mode, mtime)
+	return wrapTimeout(pushCtx, OpTransfer, "push "+remotePath, err)
+}
@@ -0,0 +1,307 @@
+package adb
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// sync: 服务用于文件传输，每个请求为 4 字节命令 + 4 字节小端长度 + 数据
+
+const syncMaxChunk = 64 * 1024
+
+// RemoteStat 是 sync STAT 返回的远程文件信息
+type RemoteStat struct {
+	Mode    os.FileMode
+	Size    int64
+	ModTime time.Time
+	// SizeInexact 为 true 时 Size 可能只是真实大小的低 32 位:
+	// adbd 只支持 STAT，且设备上无法通过 stat 命令获取完整大小
+	SizeInexact bool
+}
+
+// Exists 报告远程文件是否存在 (STAT 对不存在的文件返回全零)
+func (s *RemoteStat) Exists() bool {
+	return s.Mode != 0 || s.Size != 0 || !s.ModTime.Equal(time.Unix(0, 0))
+}
+
+type syncConn struct {
+	*ctxConn
+}
+
+// openSync 在设备上打开 sync: 服务
+func (c *Client) openSync(ctx context.Context, serial string) (*syncConn, error) {
+	conn, err := c.openService(ctx, serial, "sync:")
+	if err != nil {
+		return nil, err
+	}
+	return &syncConn{ctxConn: conn}, nil
+}
+
+func (s *syncConn) sendRequest(id string, data []byte) error {
+	buf := make([]byte, 8+len(data))
+	copy(buf, id)
+	binary.LittleEndian.PutUint32(buf[4:], uint32(len(data)))
+	copy(buf[8:], data)
+	_, err := s.Write(buf)
+	return err
+}
+
+func (s *syncConn) readHeader() (string, uint32, error) {
+	var header [8]byte
+	if _, err := io.ReadFull(s, header[:]); err != nil {
+		return "", 0, err
+	}
+	return string(header[:4]), binary.LittleEndian.Uint32(header[4:]), nil
+}
+
+// readFail 读取 FAIL 响应携带的错误信息
+func (s *syncConn) readFail(service string, length uint32) error {
+	msg := make([]byte, length)
+	if _, err := io.ReadFull(s, msg); err != nil {
+		return err
+	}
+	return &ServerError{Service: service, Message: string(msg)}
+}
+
+func (s *syncConn) quit() {
+	s.sendRequest("QUIT", nil)
+	s.Close()
+}
+
+// Stat 获取远程文件的模式、大小与修改时间，符号链接会被解析 (例如 /sdcard)
+// 优先使用 STA2 (Android 8+，64 位大小)，adbd 不支持时退回 STAT；
+// STAT 的大小只有 32 位，因此普通文件的大小再通过 "stat -c %s" 获取，失败时标记 SizeInexact
+func (c *Client) Stat(ctx context.Context, serial string, remotePath string) (*RemoteStat, error) {
+	st, err := c.stat2(ctx, serial, remotePath)
+	var serverErr *ServerError
+	if !errors.As(err, &serverErr) || serverErr.Service != "sync:STA2" {
+		return st, err
+	}
+	st, err = c.statV1(ctx, serial, remotePath)
+	if err != nil || !st.Mode.IsRegular() {
+		return st, err
+	}
+	size, err := c.statSize(ctx, serial, remotePath)
+	if err != nil {
+		st.SizeInexact = true
+		return st, nil
+	}
+	st.Size = size
+	return st, nil
+}
+
+// statSize 通过设备上的 stat 命令获取文件的完整大小
+func (c *Client) statSize(ctx context.Context, serial string, remotePath string) (int64, error) {
+	command := ShellCommand("stat -c", "%s", remotePath)
+	res, err := c.ShellV2(ctx, serial, command)
+	if err != nil {
+		return 0, err
+	}
+	if err := checkShell(command, res); err != nil {
+		return 0, err
+	}
+	size, err := strconv.ParseInt(strings.TrimSpace(string(res.Stdout)), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("unexpected stat output for %s: %q", remotePath, res.Stdout)
+	}
+	return size, nil
+}
+
+// sta2 响应中 STA2 之后的字段: error dev ino mode nlink uid gid size atime mtime ctime
+const sta2Length = 4 + 8 + 8 + 4 + 4 + 4 + 4 + 8 + 8 + 8 + 8
+
+// STA2 返回的 errno 中需要单独处理的值
+const (
+	errnoENOENT  = 2
+	errnoEACCES  = 13
+	errnoENOTDIR = 20
+)
+
+func (c *Client) stat2(ctx context.Context, serial string, remotePath string) (*RemoteStat, error) {
+	s, err := c.openSync(ctx, serial)
+	if err != nil {
+		return nil, err
+	}
+	defer s.quit()
+
+	if err := s.sendRequest("STA2", []byte(remotePath)); err != nil {
+		return nil, err
+	}
+	id, length, err := s.readHeader()
+	if err != nil {
+		return nil, err
+	}
+	switch id {
+	case "STA2":
+	case "FAIL":
+		s.readFail("sync:STA2", length)
+		return nil, &ServerError{Service: "sync:STA2", Message: "not supported"}
+	default:
+		return nil, fmt.Errorf("adb: unexpected sync response %q to STA2", id)
+	}
+	// readHeader 已读取 error 字段
+	var rest [sta2Length - 4]byte
+	if _, err := io.ReadFull(s, rest[:]); err != nil {
+		return nil, err
+	}
+	switch length {
+	case 0:
+	case errnoENOENT, errnoENOTDIR:
+		// 与 STAT 一致，不存在的文件返回全零
+		return &RemoteStat{ModTime: time.Unix(0, 0)}, nil
+	case errnoEACCES:
+		return nil, &ServerError{Service: "sync:STA2 " + remotePath, Message: "Permission denied"}
+	default:
+		return nil, &ServerError{Service: "sync:STA2 " + remotePath, Message: fmt.Sprintf("stat failed: errno %d", length)}
+	}
+	// rest: dev(8) ino(8) mode(4) nlink(4) uid(4) gid(4) size(8) atime(8) mtime(8) ctime(8)
+	return &RemoteStat{
+		Mode:    fileModeFromUnix(binary.LittleEndian.Uint32(rest[16:])),
+		Size:    int64(binary.LittleEndian.Uint64(rest[32:])),
+		ModTime: time.Unix(int64(binary.LittleEndian.Uint64(rest[48:])), 0),
+	}, nil
+}
+
+func (c *Client) statV1(ctx context.Context, serial string, remotePath string) (*RemoteStat, error) {
+	s, err := c.openSync(ctx, serial)
+	if err != nil {
+		return nil, err
+	}
+	defer s.quit()
+
+	if err := s.sendRequest("STAT", []byte(remotePath)); err != nil {
+		return nil, err
+	}
+	// STAT 响应为 "STAT" + mode + size + mtime，readHeader 已读取 mode
+	id, mode, err := s.readHeader()
+	if err != nil {
+		return nil, err
+	}
+	if id != "STAT" {
+		return nil, fmt.Errorf("adb: unexpected sync response %q to STAT", id)
+	}
+	var rest [8]byte
+	if _, err := io.ReadFull(s, rest[:]); err != nil {
+		return nil, err
+	}
+	return &RemoteStat{
+		Mode:    fileModeFromUnix(mode),
+		Size:    int64(binary.LittleEndian.Uint32(rest[:4])),
+		ModTime: time.Unix(int64(binary.LittleEndian.Uint32(rest[4:])), 0),
+	}, nil
+}
+
+// Pull 通过 sync RECV 将远程文件内容写入 w
+func (c *Client) Pull(ctx context.Context, serial string, remotePath string, w io.Writer) error {
+	s, err := c.openSync(ctx, serial)
+	if err != nil {
+		return err
+	}
+	defer s.quit()
+
+	if err := s.sendRequest("RECV", []byte(remotePath)); err != nil {
+		return err
+	}
+	for {
+		id, length, err := s.readHeader()
+		if err != nil {
+			return err
+		}
+		switch id {
+		case "DATA":
+			if _, err := io.CopyN(w, s, int64(length)); err != nil {
+				return err
+			}
+		case "DONE":
+			return nil
+		case "FAIL":
+			return s.readFail("sync:RECV "+remotePath, length)
+		default:
+			return fmt.Errorf("adb: unexpected sync response %q to RECV", id)
+		}
+	}
+}
+
+// Push 通过 sync SEND 将 r 的内容写入远程文件
+func (c *Client) Push(ctx context.Context, serial string, r io.Reader, remotePath string, mode os.FileMode, mtime time.Time) error {
+	s, err := c.openSync(ctx, serial)
+	if err != nil {
+		return err
+	}
+	defer s.quit()
+
+	target := fmt.Sprintf("%s,%d", remotePath, unixModeFromFile(mode))
+	if err := s.sendRequest("SEND", []byte(target)); err != nil {
+		return err
+	}
+	buf := make([]byte, syncMaxChunk)
+	for {
+		n, readErr := r.Read(buf)
+		if n > 0 {
+			if err := s.sendRequest("DATA", buf[:n]); err != nil {
+				return err
+			}
+		}
+		if readErr == io.EOF {
+			break
+		}
+		if readErr != nil {
+			return readErr
+		}
+	}
+	var done [8]byte
+	copy(done[:], "DONE")
+	binary.LittleEndian.PutUint32(done[4:], uint32(mtime.Unix()))
+	if _, err := s.Write(done[:]); err != nil {
+		return err
+	}
+
+	id, length, err := s.readHeader()
+	if err != nil {
+		return err
+	}
+	switch id {
+	case "OKAY":
+		return nil
+	case "FAIL":
+		return s.readFail("sync:SEND "+remotePath, length)
+	default:
+		return fmt.Errorf("adb: unexpected sync response %q to SEND", id)
+	}
+}
+
+// Unix 文件类型位
+const (
+	unixTypeMask = 0170000
+	unixDir      = 0040000
+	unixSymlink  = 0120000
+	unixRegular  = 0100000
+)
+
+// fileModeFromUnix 将 st_mode 转换为 os.FileMode
+func fileModeFromUnix(mode uint32) os.FileMode {
+	fm := os.FileMode(mode & 0777)
+	switch mode & unixTypeMask {
+	case unixDir:
+		fm |= os.ModeDir
+	case unixSymlink:
+		fm |= os.ModeSymlink
+	}
+	return fm
+}
+
+// unixModeFromFile 将 os.FileMode 转换为 SEND 请求使用的 st_mode
+func unixModeFromFile(mode os.FileMode) uint32 {
+	perm := uint32(mode.Perm())
+	if perm == 0 {
+		perm = 0644
+	}
+	return unixRegular | perm
+}
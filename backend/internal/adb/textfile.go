@@ -0,0 +1,346 @@
+package adb
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"golang.org/x/text/encoding/simplifiedchinese"
+	"log"
+	"os"
+	"path"
+	"strings"
+	"time"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// 文本文件支持的编码
+const (
+	EncodingUTF8    = "utf-8"
+	EncodingUTF16LE = "utf-16le"
+	EncodingUTF16BE = "utf-16be"
+	EncodingGB18030 = "gb18030"
+)
+
+// 换行符风格
+const (
+	LineEndingLF   = "lf"
+	LineEndingCRLF = "crlf"
+)
+
+// TextFormat 描述文本文件在设备上的编码方式，保存时按相同的方式编码以免改变文件格式
+type TextFormat struct {
+	Encoding string `json:"encoding"`
+	// BOM 表示文件以字节顺序标记开头 (UTF-16 总是带有 BOM)
+	BOM bool `json:"bom"`
+	// LineEnding 为 "lf" 或 "crlf"，两种换行混用时为空；写入时为空表示不转换换行符
+	LineEnding string `json:"lineEnding"`
+}
+
+// TextFile 是解码后的文本文件，Hash 是原始字节的 SHA-256，用作保存时的前置条件
+type TextFile struct {
+	Path    string `json:"path"`
+	Content string `json:"content"`
+	TextFormat
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"modTime"`
+	Hash    string    `json:"hash"`
+}
+
+// TextPrecondition 是 WriteTextFile 的乐观并发条件，零值字段不检查
+type TextPrecondition struct {
+	// Hash 是读取文件时得到的 TextFile.Hash
+	Hash string
+	// ModTime 是读取文件时的修改时间 (精确到秒)
+	ModTime time.Time
+	// MustNotExist 表示只允许创建新文件
+	MustNotExist bool
+}
+
+// IsZero 报告是否没有设置任何条件
+func (p TextPrecondition) IsZero() bool {
+	return p.Hash == "" && p.ModTime.IsZero() && !p.MustNotExist
+}
+
+// ConflictError 表示文件在读取之后被修改过，Exists 为 false 时文件已被删除
+type ConflictError struct {
+	Path    string
+	Exists  bool
+	Size    int64
+	ModTime time.Time
+	Hash    string // 文件过大无法读取时为空
+}
+
+func (e *ConflictError) Error() string {
+	if !e.Exists {
+		return fmt.Sprintf("adb: %s was deleted on the device", e.Path)
+	}
+	return fmt.Sprintf("adb: %s was modified on the device (modified %s)", e.Path, e.ModTime.Format(time.RFC3339))
+}
+
+// Is 使 errors.Is(err, ErrConflict) 成立
+func (e *ConflictError) Is(target error) bool {
+	return target == ErrConflict
+}
+
+// binaryControlRatio 是控制字符 (制表符、换行等除外) 所占比例的上限，超过时视为二进制文件
+const binaryControlRatio = 0.05
+
+// looksBinary 报告 data 是否像二进制内容: 包含 NUL 或较多控制字符
+func looksBinary(data []byte) bool {
+	if bytes.IndexByte(data, 0) >= 0 {
+		return true
+	}
+	controls := 0
+	for _, b := range data {
+		if b < 0x20 && b != '\t' && b != '\n' && b != '\r' && b != '\f' && b != '\v' && b != '\b' && b != 0x1b {
+			controls++
+		}
+	}
+	return float64(controls) > float64(len(data))*binaryControlRatio
+}
+
+// decodeText 识别 data 的编码并解码
+// 带 BOM 的 UTF-8/UTF-16 按 BOM 识别；否则依次尝试 UTF-8 与 GB18030，都不合法时返回 ErrNotText
+func decodeText(data []byte) (string, TextFormat, error) {
+	var format TextFormat
+	var text string
+	switch {
+	case bytes.HasPrefix(data, []byte{0xFF, 0xFE}), bytes.HasPrefix(data, []byte{0xFE, 0xFF}):
+		format = TextFormat{Encoding: EncodingUTF16LE, BOM: true}
+		if data[0] == 0xFE {
+			format.Encoding = EncodingUTF16BE
+		}
+		body := data[2:]
+		if len(body)%2 != 0 {
+			return "", format, fmt.Errorf("%w: odd length for UTF-16", ErrNotText)
+		}
+		units := make([]uint16, len(body)/2)
+		for i := range units {
+			if format.Encoding == EncodingUTF16LE {
+				units[i] = uint16(body[2*i]) | uint16(body[2*i+1])<<8
+			} else {
+				units[i] = uint16(body[2*i])<<8 | uint16(body[2*i+1])
+			}
+		}
+		text = string(utf16.Decode(units))
+		if looksBinary([]byte(text)) {
+			return "", format, ErrNotText
+		}
+	default:
+		format = TextFormat{Encoding: EncodingUTF8}
+		body := data
+		if bytes.HasPrefix(body, []byte{0xEF, 0xBB, 0xBF}) {
+			format.BOM = true
+			body = body[3:]
+		}
+		if looksBinary(body) {
+			return "", format, ErrNotText
+		}
+		if utf8.Valid(body) {
+			text = string(body)
+			break
+		}
+		if format.BOM {
+			return "", format, fmt.Errorf("%w: invalid UTF-8", ErrNotText)
+		}
+		decoded, err := simplifiedchinese.GB18030.NewDecoder().Bytes(body)
+		if err != nil || bytes.ContainsRune(decoded, utf8.RuneError) {
+			return "", format, fmt.Errorf("%w: unknown encoding", ErrNotText)
+		}
+		format.Encoding = EncodingGB18030
+		text = string(decoded)
+	}
+	switch crlf := strings.Count(text, "\r\n"); {
+	case crlf == 0:
+		format.LineEnding = LineEndingLF
+	case crlf == strings.Count(text, "\n"):
+		format.LineEnding = LineEndingCRLF
+	}
+	return text, format, nil
+}
+
+// encodeText 按 format 编码 text；LineEnding 为 crlf 时把换行统一为 \r\n
+func encodeText(text string, format TextFormat) ([]byte, error) {
+	switch format.LineEnding {
+	case "":
+	case LineEndingLF:
+		text = strings.ReplaceAll(text, "\r\n", "\n")
+	case LineEndingCRLF:
+		text = strings.ReplaceAll(strings.ReplaceAll(text, "\r\n", "\n"), "\n", "\r\n")
+	default:
+		return nil, fmt.Errorf("%w: unknown line ending %q", ErrInvalidArgument, format.LineEnding)
+	}
+	if !utf8.ValidString(text) {
+		return nil, fmt.Errorf("%w: content is not valid UTF-8", ErrInvalidArgument)
+	}
+	switch format.Encoding {
+	case EncodingUTF8:
+		if format.BOM {
+			return append([]byte{0xEF, 0xBB, 0xBF}, text...), nil
+		}
+		return []byte(text), nil
+	case EncodingUTF16LE, EncodingUTF16BE:
+		units := utf16.Encode([]rune(text))
+		out := make([]byte, 2, 2+2*len(units))
+		out[0], out[1] = 0xFF, 0xFE
+		if format.Encoding == EncodingUTF16BE {
+			out[0], out[1] = 0xFE, 0xFF
+		}
+		for _, u := range units {
+			if format.Encoding == EncodingUTF16LE {
+				out = append(out, byte(u), byte(u>>8))
+			} else {
+				out = append(out, byte(u>>8), byte(u))
+			}
+		}
+		return out, nil
+	case EncodingGB18030:
+		return simplifiedchinese.GB18030.NewEncoder().Bytes([]byte(text))
+	}
+	return nil, fmt.Errorf("%w: unsupported encoding %q", ErrInvalidArgument, format.Encoding)
+}
+
+func hashBytes(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// ReadTextFile 读取并解码远程文本文件
+// 超过 maxBytes 返回 ErrFileTooLarge，二进制或无法识别编码的文件返回 ErrNotText
+func ReadTextFile(ctx context.Context, r Runner, deviceId string, p string, maxBytes int64) (*TextFile, error) {
+	st, err := StatFile(ctx, r, deviceId, p)
+	if err != nil {
+		return nil, err
+	}
+	data, err := readRaw(ctx, r, deviceId, p, st, maxBytes)
+	if err != nil {
+		return nil, err
+	}
+	text, format, err := decodeText(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", p, err)
+	}
+	return &TextFile{
+		Path:       p,
+		Content:    text,
+		TextFormat: format,
+		Size:       int64(len(data)),
+		ModTime:    st.ModTime,
+		Hash:       hashBytes(data),
+	}, nil
+}
+
+// conflictWith 返回描述文件当前状态 st (nil 表示已删除) 的 *ConflictError
+func conflictWith(p string, st *RemoteStat) *ConflictError {
+	if st == nil {
+		return &ConflictError{Path: p}
+	}
+	return &ConflictError{Path: p, Exists: true, Size: st.Size, ModTime: st.ModTime}
+}
+
+// checkTextPrecondition 检查文件当前的状态是否满足 cond，不满足时返回 *ConflictError
+// 返回值为文件当前的 STAT 结果，文件不存在时为 nil
+func checkTextPrecondition(ctx context.Context, r Runner, deviceId string, p string, cond TextPrecondition, maxBytes int64) (*RemoteStat, error) {
+	st, err := pathExists(ctx, r, deviceId, p)
+	if err != nil {
+		return nil, err
+	}
+	if st == nil {
+		if cond.Hash != "" || !cond.ModTime.IsZero() {
+			return nil, conflictWith(p, nil)
+		}
+		return nil, nil
+	}
+	if st.Mode.IsDir() {
+		return nil, fmt.Errorf("%w: %s is a directory", ErrInvalidArgument, p)
+	}
+	conflict := conflictWith(p, st)
+	if cond.MustNotExist || (!cond.ModTime.IsZero() && !st.ModTime.Equal(cond.ModTime.Truncate(time.Second))) {
+		return nil, conflict
+	}
+	if cond.Hash != "" {
+		data, err := readRaw(ctx, r, deviceId, p, st, maxBytes)
+		if errors.Is(err, ErrFileTooLarge) {
+			// 编辑时文件不可能超过上限，已经变大说明被修改过
+			return nil, conflict
+		}
+		if err != nil {
+			return nil, err
+		}
+		conflict.Hash = hashBytes(data)
+		if conflict.Hash != strings.ToLower(cond.Hash) {
+			return nil, conflict
+		}
+	}
+	return st, nil
+}
+
+// sameStat 报告两次 STAT 的结果是否相同 (nil 表示文件不存在)
+func sameStat(a *RemoteStat, b *RemoteStat) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.Size == b.Size && a.ModTime.Equal(b.ModTime)
+}
+
+// WriteTextFile 将 content 按 format 编码后写入远程文件，返回写入后的文件信息 (不含 Content)
+// 写入前检查 cond，文件已被修改时返回 *ConflictError (errors.Is(err, ErrConflict))
+// 内容先通过 sync SEND 写入同一目录下的临时文件，再用 mv 替换目标，因此读取方不会看到写了一半的文件；
+// 已存在的文件保留原有权限
+func WriteTextFile(ctx context.Context, r Runner, deviceId string, p string, content string, format TextFormat, cond TextPrecondition, maxBytes int64) (*TextFile, error) {
+	if err := ValidateRemotePath(p); err != nil {
+		return nil, err
+	}
+	if format.Encoding == "" {
+		format.Encoding = EncodingUTF8
+	}
+	data, err := encodeText(content, format)
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > maxBytes {
+		return nil, fmt.Errorf("%w: content is %d bytes, the limit is %d", ErrFileTooLarge, len(data), maxBytes)
+	}
+	st, err := checkTextPrecondition(ctx, r, deviceId, p, cond, maxBytes)
+	if err != nil {
+		return nil, err
+	}
+	mode := os.FileMode(0644)
+	if st != nil {
+		mode = st.Mode.Perm()
+	}
+
+	var suffix [4]byte
+	rand.Read(suffix[:])
+	tmp := path.Join(path.Dir(p), "."+path.Base(p)+".tmp-"+hex.EncodeToString(suffix[:]))
+	if err := PushStream(ctx, r, deviceId, bytes.NewReader(data), tmp, mode, time.Now()); err != nil {
+		return nil, err
+	}
+	// 检查与替换之间仍有很短的窗口，替换前再比较一次 STAT 以缩小它
+	current, err := pathExists(ctx, r, deviceId, p)
+	if err == nil && !sameStat(st, current) {
+		err = conflictWith(p, current)
+	}
+	if err == nil {
+		err = runFileOp(ctx, r, OpShell, deviceId, ShellCommand("mv -f", tmp, p))
+	}
+	if err != nil {
+		if rmErr := runFileOp(context.WithoutCancel(ctx), r, OpShell, deviceId, ShellCommand("rm -f", tmp)); rmErr != nil {
+			log.Printf("WriteTextFile: Failed to remove temporary file '%s' on device '%s': %v", tmp, deviceId, rmErr)
+		}
+		return nil, err
+	}
+
+	saved := &TextFile{Path: p, TextFormat: format, Size: int64(len(data)), Hash: hashBytes(data)}
+	if st, err := StatFile(ctx, r, deviceId, p); err == nil {
+		saved.ModTime = st.ModTime
+	} else {
+		log.Printf("WriteTextFile: Saved '%s' on device '%s' but could not stat it: %v", p, deviceId, err)
+	}
+	return saved, nil
+}
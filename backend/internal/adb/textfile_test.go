@@ -0,0 +1,97 @@
+package adb
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestDecodeText(t *testing.T) {
+	tests := []struct {
+		name   string
+		data   []byte
+		text   string
+		format TextFormat
+	}{
+		{"utf-8", []byte("hello\n世界\n"), "hello\n世界\n", TextFormat{Encoding: EncodingUTF8, LineEnding: LineEndingLF}},
+		{"utf-8 with bom", []byte("\xEF\xBB\xBFa\r\nb\r\n"), "a\r\nb\r\n", TextFormat{Encoding: EncodingUTF8, BOM: true, LineEnding: LineEndingCRLF}},
+		{"mixed line endings", []byte("a\r\nb\n"), "a\r\nb\n", TextFormat{Encoding: EncodingUTF8}},
+		{"empty", nil, "", TextFormat{Encoding: EncodingUTF8, LineEnding: LineEndingLF}},
+		{"utf-16le", []byte{0xFF, 0xFE, 'h', 0, 'i', 0, 0x16, 0x4E, '\n', 0}, "hi世\n", TextFormat{Encoding: EncodingUTF16LE, BOM: true, LineEnding: LineEndingLF}},
+		{"utf-16be", []byte{0xFE, 0xFF, 0, 'h', 0, 'i', 0, '\r', 0, '\n'}, "hi\r\n", TextFormat{Encoding: EncodingUTF16BE, BOM: true, LineEnding: LineEndingCRLF}},
+		// "中文" 的 GBK 编码不是合法的 UTF-8
+		{"gb18030", []byte{0xD6, 0xD0, 0xCE, 0xC4, '\n'}, "中文\n", TextFormat{Encoding: EncodingGB18030, LineEnding: LineEndingLF}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			text, format, err := decodeText(tt.data)
+			if err != nil {
+				t.Fatalf("decodeText: %v", err)
+			}
+			if text != tt.text || format != tt.format {
+				t.Errorf("decodeText = %q %+v, want %q %+v", text, format, tt.text, tt.format)
+			}
+			// 按读取时的格式保存未修改的内容应得到原来的字节
+			data, err := encodeText(text, format)
+			if err != nil {
+				t.Fatalf("encodeText: %v", err)
+			}
+			if !bytes.Equal(data, tt.data) {
+				t.Errorf("encodeText = % x, want % x", data, tt.data)
+			}
+		})
+	}
+}
+
+func TestDecodeTextRejectsBinary(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"nul byte", []byte("PK\x03\x04\x00\x00")},
+		{"control characters", bytes.Repeat([]byte{0x01, 'a', 0x02, 'b'}, 8)},
+		{"png header", []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR")},
+		{"odd utf-16", []byte{0xFF, 0xFE, 'a'}},
+		{"utf-16 binary", []byte{0xFF, 0xFE, 0x00, 0x00, 0x01, 0x00}},
+		{"invalid utf-8 after bom", []byte("\xEF\xBB\xBF\xFF\xFE\xFD")},
+	}
+	for _, tt := range tests {
+		if _, _, err := decodeText(tt.data); !errors.Is(err, ErrNotText) {
+			t.Errorf("%s: decodeText = %v, want ErrNotText", tt.name, err)
+		}
+	}
+}
+
+func TestEncodeText(t *testing.T) {
+	tests := []struct {
+		name   string
+		text   string
+		format TextFormat
+		want   []byte
+	}{
+		{"lf to crlf", "a\nb\r\nc", TextFormat{Encoding: EncodingUTF8, LineEnding: LineEndingCRLF}, []byte("a\r\nb\r\nc")},
+		{"crlf to lf", "a\r\nb\n", TextFormat{Encoding: EncodingUTF8, LineEnding: LineEndingLF}, []byte("a\nb\n")},
+		{"keep line endings", "a\r\nb\n", TextFormat{Encoding: EncodingUTF8}, []byte("a\r\nb\n")},
+		{"utf-16le surrogate pair", "😀", TextFormat{Encoding: EncodingUTF16LE, BOM: true}, []byte{0xFF, 0xFE, 0x3D, 0xD8, 0x00, 0xDE}},
+		{"gb18030 outside gbk", "😀", TextFormat{Encoding: EncodingGB18030}, []byte{0x94, 0x39, 0xFC, 0x36}},
+	}
+	for _, tt := range tests {
+		got, err := encodeText(tt.text, tt.format)
+		if err != nil {
+			t.Errorf("%s: encodeText: %v", tt.name, err)
+			continue
+		}
+		if !bytes.Equal(got, tt.want) {
+			t.Errorf("%s: encodeText = % x, want % x", tt.name, got, tt.want)
+		}
+	}
+
+	for _, format := range []TextFormat{{Encoding: "latin1"}, {Encoding: EncodingUTF8, LineEnding: "cr"}} {
+		if _, err := encodeText("a", format); !errors.Is(err, ErrInvalidArgument) {
+			t.Errorf("encodeText(%+v) = %v, want ErrInvalidArgument", format, err)
+		}
+	}
+	if _, err := encodeText("a\xff", TextFormat{Encoding: EncodingUTF8}); !errors.Is(err, ErrInvalidArgument) {
+		t.Errorf("encodeText(invalid UTF-8) = %v, want ErrInvalidArgument", err)
+	}
+}
@@ -0,0 +1,140 @@
+package adb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// OpClass 是 adb 操作的类别，每个类别有各自的默认超时
+type OpClass string
+
+const (
+	OpShell    OpClass = "shell"    // 普通 shell 命令、按键输入、截图等
+	OpTransfer OpClass = "transfer" // 文件 push/pull 与 logcat 导出
+	OpInstall  OpClass = "install"  // APK 安装
+)
+
+// Timeouts 定义各类操作的默认超时，0 表示不限制
+type Timeouts struct {
+	Shell    time.Duration `yaml:"shell"`
+	Transfer time.Duration `yaml:"transfer"`
+	Install  time.Duration `yaml:"install"`
+}
+
+// DefaultTimeouts 是未配置时使用的超时
+var DefaultTimeouts = Timeouts{
+	Shell:    30 * time.Second,
+	Transfer: 10 * time.Minute,
+	Install:  5 * time.Minute,
+}
+
+var (
+	timeoutsMu sync.RWMutex
+	timeouts   = DefaultTimeouts
+)
+
+// SetTimeouts 替换包内操作使用的默认超时，通常在启动时根据配置调用
+func SetTimeouts(t Timeouts) {
+	timeoutsMu.Lock()
+	defer timeoutsMu.Unlock()
+	timeouts = t
+}
+
+func timeoutFor(class OpClass) time.Duration {
+	timeoutsMu.RLock()
+	defer timeoutsMu.RUnlock()
+	switch class {
+	case OpShell:
+		return timeouts.Shell
+	case OpTransfer:
+		return timeouts.Transfer
+	case OpInstall:
+		return timeouts.Install
+	}
+	return 0
+}
+
+// TimeoutError 表示 adb 操作超过了其类别的超时限制，设备上的进程已被终止
+type TimeoutError struct {
+	Op    string
+	Class OpClass
+	Limit time.Duration
+}
+
+func (e *TimeoutError) Error() string {
+	if e.Limit > 0 {
+		return fmt.Sprintf("adb: %s (%s) timed out after %s", e.Op, e.Class, e.Limit)
+	}
+	return fmt.Sprintf("adb: %s (%s) timed out", e.Op, e.Class)
+}
+
+// Timeout 满足 net.Error 风格的超时判断
+func (e *TimeoutError) Timeout() bool { return true }
+
+// Is 使 errors.Is(err, ErrTimeout) 与 errors.Is(err, context.DeadlineExceeded) 对超时错误成立
+func (e *TimeoutError) Is(target error) bool {
+	return target == ErrTimeout || target == context.DeadlineExceeded
+}
+
+// withTimeout 为 ctx 加上 class 对应的超时
+func withTimeout(ctx context.Context, class OpClass) (context.Context, context.CancelFunc) {
+	if d := timeoutFor(class); d > 0 {
+		return context.WithTimeout(ctx, d)
+	}
+	return context.WithCancel(ctx)
+}
+
+// wrapTimeout 在 ctx 因超时结束时把 err 替换为 *TimeoutError
+func wrapTimeout(ctx context.Context, class OpClass, op string, err error) error {
+	if err == nil {
+		return nil
+	}
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return &TimeoutError{Op: op, Class: class, Limit: timeoutFor(class)}
+	}
+	return err
+}
+
+// runShell 以 class 对应的超时执行 shell 命令
+func runShell(ctx context.Context, r Runner, class OpClass, serial string, command string) (*ShellResult, error) {
+	ctx, cancel := withTimeout(ctx, class)
+	defer cancel()
+	res, err := r.ShellV2(ctx, serial, command)
+	return res, wrapTimeout(ctx, class, command, err)
+}
+
+// runExec 以 class 对应的超时打开 exec 数据流，超时从打开时开始计算直到流被关闭
+func runExec(ctx context.Context, r Runner, class OpClass, serial string, command string) (io.ReadCloser, error) {
+	ctx, cancel := withTimeout(ctx, class)
+	stream, err := r.Exec(ctx, serial, command)
+	if err != nil {
+		cancel()
+		return nil, wrapTimeout(ctx, class, command, err)
+	}
+	return &timeoutStream{ReadCloser: stream, ctx: ctx, cancel: cancel, class: class, op: command}, nil
+}
+
+type timeoutStream struct {
+	io.ReadCloser
+	ctx    context.Context
+	cancel context.CancelFunc
+	class  OpClass
+	op     string
+}
+
+func (s *timeoutStream) Read(p []byte) (int, error) {
+	n, err := s.ReadCloser.Read(p)
+	if err != nil && err != io.EOF {
+		err = wrapTimeout(s.ctx, s.class, s.op, err)
+	}
+	return n, err
+}
+
+func (s *timeoutStream) Close() error {
+	defer s.cancel()
+	return s.ReadCloser.Close()
+}
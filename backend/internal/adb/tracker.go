@@ -0,0 +1,166 @@
+package adb
+
+import (
+	"context"
+	"log"
+	"sort"
+	"sync"
+	"time"
+)
+
+// DeviceEventType 描述设备状态的变化
+type DeviceEventType string
+
+const (
+	DeviceConnected    DeviceEventType = "connected"    // 设备进入 device 状态
+	DeviceDisconnected DeviceEventType = "disconnected" // 设备从列表中消失
+	DeviceUnauthorized DeviceEventType = "unauthorized" // 设备等待 USB 调试授权
+	DeviceOffline      DeviceEventType = "offline"      // 设备无响应
+)
+
+// DeviceEvent 是 Tracker 推送给订阅者的一次状态变化
+type DeviceEvent struct {
+	Type           DeviceEventType `json:"type"`
+	Device         DeviceInfo      `json:"device"`
+	PreviousStatus string          `json:"previousStatus,omitempty"`
+	Time           time.Time       `json:"time"`
+}
+
+// eventTypeFor 将 adb 设备状态映射为事件类型
+// 除 device/unauthorized/offline 之外的状态 (recovery、sideload、authorizing 等) 直接使用状态名
+func eventTypeFor(status string) DeviceEventType {
+	switch status {
+	case "device":
+		return DeviceConnected
+	case "unauthorized":
+		return DeviceUnauthorized
+	case "offline":
+		return DeviceOffline
+	}
+	return DeviceEventType(status)
+}
+
+// Tracker 通过 host:track-devices-l 维护一份内存中的设备注册表，并向订阅者推送状态变化
+type Tracker struct {
+	// RetryInterval 是与 adb server 的跟踪连接断开后的重连间隔
+	RetryInterval time.Duration
+
+	r       Runner
+	mu      sync.RWMutex
+	devices map[string]DeviceInfo
+	synced  bool
+	subs    map[chan DeviceEvent]struct{}
+}
+
+// NewTracker 创建一个基于 r 的 Tracker，需要调用 Run 才会开始跟踪
+func NewTracker(r Runner) *Tracker {
+	return &Tracker{
+		RetryInterval: 2 * time.Second,
+		r:             r,
+		devices:       make(map[string]DeviceInfo),
+		subs:          make(map[chan DeviceEvent]struct{}),
+	}
+}
+
+// Run 持续跟踪设备变化，连接中断时自动重连，直到 ctx 结束
+func (t *Tracker) Run(ctx context.Context) {
+	for {
+		err := t.r.TrackDevices(ctx, t.update)
+		t.mu.Lock()
+		t.synced = false
+		t.mu.Unlock()
+		if ctx.Err() != nil {
+			return
+		}
+		log.Printf("DeviceTracker: track-devices stream ended: %v. Retrying in %s", err, t.RetryInterval)
+		select {
+		case <-time.After(t.RetryInterval):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Devices 返回注册表中的设备 (按 ID 排序)
+// ok 为 false 表示当前没有与 adb server 保持同步，调用者应直接查询设备
+func (t *Tracker) Devices() (devices []DeviceInfo, ok bool) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	devices = make([]DeviceInfo, 0, len(t.devices))
+	for _, d := range t.devices {
+		devices = append(devices, d)
+	}
+	sort.Slice(devices, func(i, j int) bool { return devices[i].ID < devices[j].ID })
+	return devices, t.synced
+}
+
+// Device 返回注册表中指定设备的信息
+func (t *Tracker) Device(serial string) (DeviceInfo, bool) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	d, ok := t.devices[serial]
+	return d, ok
+}
+
+// Subscribe 订阅设备状态变化，调用返回的函数取消订阅
+// 订阅者处理过慢时事件会被丢弃，而不会阻塞 Tracker
+func (t *Tracker) Subscribe() (<-chan DeviceEvent, func()) {
+	ch := make(chan DeviceEvent, 64)
+	t.mu.Lock()
+	t.subs[ch] = struct{}{}
+	t.mu.Unlock()
+	return ch, func() {
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		if _, ok := t.subs[ch]; ok {
+			delete(t.subs, ch)
+			close(ch)
+		}
+	}
+}
+
+// update 将新的设备列表与注册表比较，记录并广播差异
+func (t *Tracker) update(devices []DeviceInfo) {
+	now := time.Now()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var events []DeviceEvent
+	seen := make(map[string]bool, len(devices))
+	for _, d := range devices {
+		seen[d.ID] = true
+		prev, existed := t.devices[d.ID]
+		t.devices[d.ID] = d
+		if existed && prev.Status == d.Status {
+			continue
+		}
+		ev := DeviceEvent{Type: eventTypeFor(d.Status), Device: d, Time: now}
+		if existed {
+			ev.PreviousStatus = prev.Status
+		}
+		events = append(events, ev)
+	}
+	for id, prev := range t.devices {
+		if seen[id] {
+			continue
+		}
+		delete(t.devices, id)
+		InvalidateProperties(id)
+		InvalidateDiskUsage(id)
+		gone := prev
+		gone.Status = "disconnected"
+		events = append(events, DeviceEvent{Type: DeviceDisconnected, Device: gone, PreviousStatus: prev.Status, Time: now})
+	}
+	t.synced = true
+
+	for _, ev := range events {
+		log.Printf("DeviceTracker: %s %s (previous: %q)", ev.Device.ID, ev.Type, ev.PreviousStatus)
+		for ch := range t.subs {
+			select {
+			case ch <- ev:
+			default:
+				log.Printf("DeviceTracker: subscriber too slow, dropping %s event for %s", ev.Type, ev.Device.ID)
+			}
+		}
+	}
+}
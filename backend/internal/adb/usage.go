@@ -0,0 +1,237 @@
+package adb
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"log"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MaxUsageEntries 是扫描结果中保留的最大目录与文件的数量上限
+const MaxUsageEntries = 200
+
+// 目录占用扫描结果的缓存有效期；失败的结果只保留较短时间，以便轮询的客户端能取到错误
+const (
+	usageCacheTTL      = 10 * time.Minute
+	usageErrorCacheTTL = 30 * time.Second
+)
+
+// UsageEntry 是目录占用扫描中的一个文件或目录，Size 为占用的磁盘空间 (字节)
+type UsageEntry struct {
+	Path  string `json:"path"`
+	Size  int64  `json:"size"`
+	IsDir bool   `json:"isDir"`
+}
+
+// UsageReport 是一次目录占用扫描的结果
+type UsageReport struct {
+	Path string `json:"path"`
+	Size int64  `json:"size"`
+	// Entries 是扫描到的文件与目录的总数
+	Entries int `json:"entries"`
+	// Children 是直接子项，按大小降序
+	Children []UsageEntry `json:"children"`
+	// LargestDirs 与 LargestFiles 是整棵树中最大的目录与文件，按大小降序，最多 MaxUsageEntries 个
+	LargestDirs  []UsageEntry `json:"largestDirs"`
+	LargestFiles []UsageEntry `json:"largestFiles"`
+	// Unreadable 是因权限等原因无法读取的路径数，这些路径不计入大小
+	Unreadable int `json:"unreadable"`
+}
+
+// topEntries 保留大小最大的 n 个条目，按大小降序
+type topEntries struct {
+	n     int
+	items []UsageEntry
+}
+
+func (t *topEntries) add(e UsageEntry) {
+	if len(t.items) == t.n && e.Size <= t.items[len(t.items)-1].Size {
+		return
+	}
+	i := sort.Search(len(t.items), func(i int) bool { return t.items[i].Size < e.Size })
+	if len(t.items) < t.n {
+		t.items = append(t.items, UsageEntry{})
+	}
+	copy(t.items[i+1:], t.items[i:])
+	t.items[i] = e
+}
+
+// DiskUsage 在设备上对 root 执行 du，统计其占用空间以及其中最大的目录与文件
+// 不跨越文件系统；符号链接不会被跟随，但 root 本身是链接 (例如 /sdcard) 时统计链接指向的目录
+func DiskUsage(ctx context.Context, r Runner, deviceId string, root string) (*UsageReport, error) {
+	if err := ValidateRemotePath(root); err != nil {
+		return nil, err
+	}
+	root = path.Clean(root)
+	ctx = WithPriority(ctx, PriorityBulk)
+	// du 的输出是后序的: 子项总是在所属目录之前；错误信息合并到输出中以便统计无法读取的路径
+	command := ShellCommand("du", "-a", "-k", "-x", strings.TrimSuffix(root, "/")+"/") + " 2>&1"
+	stream, err := runExec(ctx, r, OpTransfer, deviceId, command)
+	if err != nil {
+		return nil, err
+	}
+	defer stream.Close()
+
+	report := &UsageReport{Path: root}
+	dirs := &topEntries{n: MaxUsageEntries}
+	files := &topEntries{n: MaxUsageEntries}
+	prefix := strings.TrimSuffix(root, "/") + "/"
+	found := false
+	prev, firstError := "", ""
+	scanner := bufio.NewScanner(stream)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		size, name, ok := strings.Cut(line, "\t")
+		kb, err := strconv.ParseInt(size, 10, 64)
+		if !ok || err != nil {
+			if strings.TrimSpace(line) != "" {
+				report.Unreadable++
+				if firstError == "" {
+					firstError = strings.TrimSpace(line)
+				}
+			}
+			continue
+		}
+		p := path.Clean(name)
+		// 目录的子项紧挨在它之前输出；空目录无法与文件区分，按文件处理
+		isDir := strings.HasPrefix(prev, strings.TrimSuffix(p, "/")+"/")
+		prev = p
+		e := UsageEntry{Path: p, Size: kb << 10, IsDir: isDir}
+		if p == root {
+			report.Size = e.Size
+			found = true
+			continue
+		}
+		report.Entries++
+		if isDir {
+			dirs.add(e)
+		} else {
+			files.add(e)
+		}
+		if rest, ok := strings.CutPrefix(p, prefix); ok && !strings.Contains(rest, "/") {
+			report.Children = append(report.Children, e)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if !found {
+		// root 本身无法读取或不存在
+		if kind := classifyMessage(firstError); kind != nil {
+			return nil, fmt.Errorf("du %s: %w: %s", root, kind, firstError)
+		}
+		return nil, fmt.Errorf("du %s: unexpected output: %q", root, firstError)
+	}
+	sort.SliceStable(report.Children, func(i, j int) bool { return report.Children[i].Size > report.Children[j].Size })
+	report.LargestDirs = dirs.items
+	report.LargestFiles = files.items
+	return report, nil
+}
+
+// UsageJob 是一次在后台运行的目录占用扫描
+type UsageJob struct {
+	DeviceID   string
+	Path       string
+	StartedAt  time.Time
+	FinishedAt time.Time
+
+	done   chan struct{}
+	report *UsageReport
+	err    error
+}
+
+// Done 返回一个在扫描结束时关闭的 channel
+func (j *UsageJob) Done() <-chan struct{} {
+	return j.done
+}
+
+// Result 返回扫描结果，只能在 Done 关闭后调用
+func (j *UsageJob) Result() (*UsageReport, error) {
+	return j.report, j.err
+}
+
+// expired 判断已结束的扫描结果是否已经过期
+func (j *UsageJob) expired(now time.Time) bool {
+	select {
+	case <-j.done:
+	default:
+		return false
+	}
+	ttl := usageCacheTTL
+	if j.err != nil {
+		ttl = usageErrorCacheTTL
+	}
+	return now.Sub(j.FinishedAt) >= ttl
+}
+
+var (
+	usageMu   sync.Mutex
+	usageJobs = make(map[string]*UsageJob)
+)
+
+// StartDiskUsage 返回设备上 root 的目录占用扫描
+// 同一目录正在扫描或有未过期的结果时直接返回它，否则在后台开始一次新的扫描；refresh 为 true 时丢弃已结束的结果
+// 扫描不随请求取消，结果可供之后的请求 (例如轮询) 使用
+func StartDiskUsage(r Runner, deviceId string, root string, refresh bool) (*UsageJob, error) {
+	if err := ValidateRemotePath(root); err != nil {
+		return nil, err
+	}
+	root = path.Clean(root)
+	key := deviceId + "\x00" + root
+	now := time.Now()
+
+	usageMu.Lock()
+	defer usageMu.Unlock()
+	for k, j := range usageJobs {
+		if j.expired(now) {
+			delete(usageJobs, k)
+		}
+	}
+	if j, ok := usageJobs[key]; ok {
+		select {
+		case <-j.done:
+			if !refresh {
+				return j, nil
+			}
+		default:
+			return j, nil
+		}
+	}
+
+	j := &UsageJob{DeviceID: deviceId, Path: root, StartedAt: now, done: make(chan struct{})}
+	usageJobs[key] = j
+	go func() {
+		j.report, j.err = DiskUsage(context.Background(), r, deviceId, root)
+		j.FinishedAt = time.Now()
+		if j.err != nil {
+			log.Printf("StartDiskUsage: Failed to scan %s on device %s: %v", root, deviceId, j.err)
+		} else {
+			log.Printf("StartDiskUsage: Scanned %s on device %s in %s: %d entries, %d bytes", root, deviceId, j.FinishedAt.Sub(j.StartedAt).Round(time.Millisecond), j.report.Entries, j.report.Size)
+		}
+		close(j.done)
+	}()
+	return j, nil
+}
+
+// InvalidateDiskUsage 丢弃设备上已结束的目录占用扫描结果，正在运行的扫描不受影响
+func InvalidateDiskUsage(deviceId string) {
+	usageMu.Lock()
+	defer usageMu.Unlock()
+	for k, j := range usageJobs {
+		if j.DeviceID != deviceId {
+			continue
+		}
+		select {
+		case <-j.done:
+			delete(usageJobs, k)
+		default:
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package adb_test
+
+import (
+	"context"
+	"errors"
+	"fishyinhe/backend/internal/adb"
+	"fishyinhe/backend/internal/adb/adbtest"
+	"testing"
+)
+
+func TestDiskUsage(t *testing.T) {
+	f := adbtest.NewFake()
+	f.AddCannedDevice("emu-1")
+	// du 的输出是后序的，a/b 与 a/c 在 a 之前；ab 与 a 同名前缀但不是 a 的子项
+	f.HandleShell("emu-1", "du -a -k -x /sdcard/ 2>&1", adbtest.Response{Stdout: "" +
+		"4\t/sdcard/ab\n" +
+		"100\t/sdcard/a/b\n" +
+		"8\t/sdcard/a/c/d.txt\n" +
+		"12\t/sdcard/a/c\n" +
+		"116\t/sdcard/a\n" +
+		"du: /sdcard/Android/data: Permission denied\n" +
+		"0\t/sdcard/empty\n" +
+		"2\t/sdcard/a.txt\n" +
+		"126\t/sdcard/\n"})
+
+	report, err := adb.DiskUsage(context.Background(), f, "emu-1", "/sdcard")
+	if err != nil {
+		t.Fatalf("DiskUsage: %v", err)
+	}
+	if report.Path != "/sdcard" || report.Size != 126<<10 || report.Entries != 7 || report.Unreadable != 1 {
+		t.Errorf("report = {Path %s, Size %d, Entries %d, Unreadable %d}, want {/sdcard, %d, 7, 1}", report.Path, report.Size, report.Entries, report.Unreadable, 126<<10)
+	}
+
+	wantChildren := []adb.UsageEntry{
+		{Path: "/sdcard/a", Size: 116 << 10, IsDir: true},
+		{Path: "/sdcard/ab", Size: 4 << 10},
+		{Path: "/sdcard/a.txt", Size: 2 << 10},
+		// 空目录无法与文件区分
+		{Path: "/sdcard/empty", Size: 0},
+	}
+	checkUsage(t, "Children", report.Children, wantChildren)
+	checkUsage(t, "LargestDirs", report.LargestDirs, []adb.UsageEntry{
+		{Path: "/sdcard/a", Size: 116 << 10, IsDir: true},
+		{Path: "/sdcard/a/c", Size: 12 << 10, IsDir: true},
+	})
+	checkUsage(t, "LargestFiles", report.LargestFiles, []adb.UsageEntry{
+		{Path: "/sdcard/a/b", Size: 100 << 10},
+		{Path: "/sdcard/a/c/d.txt", Size: 8 << 10},
+		{Path: "/sdcard/ab", Size: 4 << 10},
+		{Path: "/sdcard/a.txt", Size: 2 << 10},
+		{Path: "/sdcard/empty", Size: 0},
+	})
+}
+
+func checkUsage(t *testing.T, name string, got []adb.UsageEntry, want []adb.UsageEntry) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Errorf("%s = %+v, want %+v", name, got, want)
+		return
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("%s[%d] = %+v, want %+v", name, i, got[i], want[i])
+		}
+	}
+}
+
+func TestDiskUsageUnreadableRoot(t *testing.T) {
+	f := adbtest.NewFake()
+	f.AddCannedDevice("emu-1")
+	f.HandleShell("emu-1", "du -a -k -x /data/ 2>&1", adbtest.Response{Stdout: "du: /data/: Permission denied\n"})
+	if _, err := adb.DiskUsage(context.Background(), f, "emu-1", "/data"); !errors.Is(err, adb.ErrPermissionDenied) {
+		t.Errorf("DiskUsage = %v, want ErrPermissionDenied", err)
+	}
+}
@@ -0,0 +1,239 @@
+package adb
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultTCPIPPort 是 adb tcpip 的默认端口
+const DefaultTCPIPPort = 5555
+
+// Connect 执行 host:connect:<addr>
+// adb server 对连接失败同样回复 OKAY，因此需要根据提示信息判断结果
+func (c *Client) Connect(ctx context.Context, addr string) (string, error) {
+	msg, err := c.hostCommand(ctx, "host:connect:"+addr)
+	if err != nil {
+		return "", err
+	}
+	if !strings.HasPrefix(msg, "connected to") && !strings.HasPrefix(msg, "already connected to") {
+		return msg, fmt.Errorf("%w: %s", ErrConnectFailed, msg)
+	}
+	return msg, nil
+}
+
+// Disconnect 执行 host:disconnect:<addr>，设备不存在时 adb server 回复 FAIL "no such device"
+func (c *Client) Disconnect(ctx context.Context, addr string) (string, error) {
+	return c.hostCommand(ctx, "host:disconnect:"+addr)
+}
+
+// Pair 执行 host:pair:<code>:<addr>，失败时 adb server 回复 OKAY 与 "Failed: ..." 提示
+func (c *Client) Pair(ctx context.Context, addr string, code string) (string, error) {
+	msg, err := c.hostCommand(ctx, "host:pair:"+code+":"+addr)
+	if err != nil {
+		return "", err
+	}
+	if !strings.HasPrefix(msg, "Successfully paired") {
+		return msg, fmt.Errorf("%w: %s", ErrPairFailed, msg)
+	}
+	return msg, nil
+}
+
+// TCPIP 在设备上打开 tcpip:<port> 服务，adbd 随后会以 TCP 模式重启
+func (c *Client) TCPIP(ctx context.Context, serial string, port int) (string, error) {
+	conn, err := c.openService(ctx, serial, "tcpip:"+strconv.Itoa(port))
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	out, err := io.ReadAll(conn)
+	msg := strings.TrimSpace(string(out))
+	if err != nil {
+		return msg, err
+	}
+	if !strings.Contains(msg, "restarting in TCP mode") {
+		return msg, &ServerError{Service: "tcpip:" + strconv.Itoa(port), Message: msg}
+	}
+	return msg, nil
+}
+
+// ConnectDevice 连接 addr 上的无线调试设备，addr 未指定端口时使用 5555
+func ConnectDevice(ctx context.Context, r Runner, addr string) (string, error) {
+	if addr = NormalizeAddr(addr); addr == "" {
+		return "", fmt.Errorf("%w: ConnectDevice: address cannot be empty", ErrInvalidArgument)
+	}
+	ctx, cancel := withTimeout(ctx, OpShell)
+	defer cancel()
+	msg, err := r.Connect(ctx, addr)
+	if err = wrapTimeout(ctx, OpShell, "connect "+addr, err); err != nil {
+		log.Printf("ConnectDevice: Failed to connect to '%s': %v", addr, err)
+		return msg, err
+	}
+	log.Printf("ConnectDevice: %s", msg)
+	return msg, nil
+}
+
+// DisconnectDevice 断开 addr 上的无线调试设备
+func DisconnectDevice(ctx context.Context, r Runner, addr string) (string, error) {
+	// 空地址会让 adb server 断开所有无线设备，这里不允许
+	if addr = NormalizeAddr(addr); addr == "" {
+		return "", fmt.Errorf("%w: DisconnectDevice: address cannot be empty", ErrInvalidArgument)
+	}
+	ctx, cancel := withTimeout(ctx, OpShell)
+	defer cancel()
+	msg, err := r.Disconnect(ctx, addr)
+	if err = wrapTimeout(ctx, OpShell, "disconnect "+addr, err); err != nil {
+		log.Printf("DisconnectDevice: Failed to disconnect '%s': %v", addr, err)
+		return msg, err
+	}
+	log.Printf("DisconnectDevice: %s", msg)
+	return msg, nil
+}
+
+// pairingCode 匹配"无线调试"页面上显示的 6 位数字配对码
+var pairingCode = regexp.MustCompile(`^[0-9]{6}$`)
+
+// checkPort 检查端口是否在 1..65535 之间
+func checkPort(port int) error {
+	if port < 1 || port > 65535 {
+		return fmt.Errorf("%w: port %d out of range 1-65535", ErrInvalidArgument, port)
+	}
+	return nil
+}
+
+// PairDevice 使用设备"无线调试"页面上显示的配对码与配对端口配对
+// 配对端口与之后 connect 使用的端口不同，因此 addr 必须带端口
+func PairDevice(ctx context.Context, r Runner, addr string, code string) (string, error) {
+	addr = strings.TrimSpace(addr)
+	if addr == "" || code == "" {
+		return "", fmt.Errorf("%w: PairDevice: address and pairing code cannot be empty", ErrInvalidArgument)
+	}
+	if !pairingCode.MatchString(code) {
+		return "", fmt.Errorf("%w: PairDevice: pairing code must be 6 digits", ErrInvalidArgument)
+	}
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil || host == "" {
+		return "", fmt.Errorf("%w: PairDevice: address '%s' must be host:port", ErrInvalidArgument, addr)
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		return "", fmt.Errorf("%w: PairDevice: invalid port '%s'", ErrInvalidArgument, portStr)
+	}
+	if err := checkPort(port); err != nil {
+		return "", err
+	}
+	ctx, cancel := withTimeout(ctx, OpShell)
+	defer cancel()
+	msg, err := r.Pair(ctx, addr, code)
+	if err = wrapTimeout(ctx, OpShell, "pair "+addr, err); err != nil {
+		log.Printf("PairDevice: Failed to pair with '%s': %v", addr, err)
+		return msg, err
+	}
+	log.Printf("PairDevice: %s", msg)
+	return msg, nil
+}
+
+// EnableTCPIP 让 USB 连接的设备在 port 上监听无线调试连接，port 为 0 时使用 5555
+func EnableTCPIP(ctx context.Context, r Runner, deviceId string, port int) (string, error) {
+	if deviceId == "" {
+		return "", fmt.Errorf("%w: EnableTCPIP: deviceId cannot be empty", ErrInvalidArgument)
+	}
+	if port == 0 {
+		port = DefaultTCPIPPort
+	}
+	if err := checkPort(port); err != nil {
+		return "", err
+	}
+	ctx, cancel := withTimeout(ctx, OpShell)
+	defer cancel()
+	msg, err := r.TCPIP(ctx, deviceId, port)
+	if err = wrapTimeout(ctx, OpShell, "tcpip "+strconv.Itoa(port), err); err != nil {
+		log.Printf("EnableTCPIP: Failed for device '%s': %v", deviceId, err)
+		return msg, err
+	}
+	log.Printf("EnableTCPIP: Device '%s': %s", deviceId, msg)
+	return msg, nil
+}
+
+// inetAddr 匹配 "ip addr show" 输出中的 IPv4 地址行，例如 "inet 192.168.1.23/24 brd ..."
+var inetAddr = regexp.MustCompile(`\binet (\d+\.\d+\.\d+\.\d+)/`)
+
+// WifiAddress 读取设备 wlan0 接口的 IPv4 地址
+func WifiAddress(ctx context.Context, r Runner, deviceId string) (string, error) {
+	res, err := runShell(ctx, r, OpShell, deviceId, "ip addr show wlan0")
+	if err != nil {
+		return "", err
+	}
+	if err := checkShell("ip addr show wlan0", res); err != nil {
+		return "", err
+	}
+	m := inetAddr.FindStringSubmatch(string(res.Stdout))
+	if m == nil {
+		return "", fmt.Errorf("%w: device '%s'", ErrNoWifiAddress, deviceId)
+	}
+	return m[1], nil
+}
+
+// switchConnectAttempts 与 switchConnectDelay 控制 tcpip 之后等待 adbd 重启的重试
+const (
+	switchConnectAttempts = 5
+	switchConnectDelay    = time.Second
+)
+
+// SwitchToWireless 将 USB 连接的设备切换为无线调试:
+// 读取 wlan0 地址、执行 tcpip，然后在 adbd 重启完成后连接 <ip>:<port>
+// 返回无线连接的地址，成功后设备会以该地址作为新的序列号出现在设备列表中
+func SwitchToWireless(ctx context.Context, r Runner, deviceId string, port int) (string, error) {
+	if port == 0 {
+		port = DefaultTCPIPPort
+	}
+	if err := checkPort(port); err != nil {
+		return "", err
+	}
+	ip, err := WifiAddress(ctx, r, deviceId)
+	if err != nil {
+		log.Printf("SwitchToWireless: Failed to read wlan0 address of device '%s': %v", deviceId, err)
+		return "", err
+	}
+	if _, err := EnableTCPIP(ctx, r, deviceId, port); err != nil {
+		return "", err
+	}
+
+	addr := net.JoinHostPort(ip, strconv.Itoa(port))
+	for attempt := 1; ; attempt++ {
+		// adbd 以 TCP 模式重启需要一点时间，立即连接通常会被拒绝
+		select {
+		case <-time.After(switchConnectDelay):
+		case <-ctx.Done():
+			return "", ctx.Err()
+		}
+		_, err = ConnectDevice(ctx, r, addr)
+		if err == nil {
+			log.Printf("SwitchToWireless: Device '%s' is now reachable at '%s'", deviceId, addr)
+			return addr, nil
+		}
+		if attempt == switchConnectAttempts {
+			return "", err
+		}
+	}
+}
+
+// NormalizeAddr 去掉地址两端的空白，并为没有端口的地址补上默认的 5555
+// IPv6 地址以 [fe80::1]:5555 的形式返回；没有方括号的 IPv6 地址 (fe80::1) 视为不带端口
+func NormalizeAddr(addr string) string {
+	addr = strings.TrimSpace(addr)
+	if addr == "" {
+		return ""
+	}
+	if host, port, err := net.SplitHostPort(addr); err == nil {
+		return net.JoinHostPort(host, port)
+	}
+	host := strings.TrimSuffix(strings.TrimPrefix(addr, "["), "]")
+	return net.JoinHostPort(host, strconv.Itoa(DefaultTCPIPPort))
+}
@@ -0,0 +1,167 @@
+package adb
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// WirelessDevice 是一条记住的无线调试连接
+type WirelessDevice struct {
+	Address       string    `json:"address"`
+	Serial        string    `json:"serial,omitempty"` // 通过 USB 切换时原来的序列号
+	Model         string    `json:"model,omitempty"`
+	LastConnected time.Time `json:"lastConnected"`
+}
+
+// WirelessRegistry 记录成功连接过的无线设备，并持久化到 JSON 文件，以便后端重启后重新连接
+type WirelessRegistry struct {
+	// ReconnectAttempts 与 ReconnectInterval 控制 ReconnectAll 对每个地址的重试
+	ReconnectAttempts int
+	ReconnectInterval time.Duration
+
+	path    string
+	mu      sync.Mutex
+	devices map[string]WirelessDevice
+}
+
+// LoadWirelessRegistry 从 path 读取已记住的无线设备，文件不存在时返回空的注册表
+// path 为空时注册表只保存在内存中
+func LoadWirelessRegistry(path string) (*WirelessRegistry, error) {
+	w := &WirelessRegistry{
+		ReconnectAttempts: 3,
+		ReconnectInterval: 5 * time.Second,
+		path:              path,
+		devices:           make(map[string]WirelessDevice),
+	}
+	if path == "" {
+		return w, nil
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return w, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("adb: reading wireless registry %s: %w", path, err)
+	}
+	var devices []WirelessDevice
+	if err := json.Unmarshal(data, &devices); err != nil {
+		return nil, fmt.Errorf("adb: parsing wireless registry %s: %w", path, err)
+	}
+	for _, d := range devices {
+		w.devices[d.Address] = d
+	}
+	return w, nil
+}
+
+// List 返回已记住的无线设备 (按地址排序)
+func (w *WirelessRegistry) List() []WirelessDevice {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.listLocked()
+}
+
+func (w *WirelessRegistry) listLocked() []WirelessDevice {
+	devices := make([]WirelessDevice, 0, len(w.devices))
+	for _, d := range w.devices {
+		devices = append(devices, d)
+	}
+	sort.Slice(devices, func(i, j int) bool { return devices[i].Address < devices[j].Address })
+	return devices
+}
+
+// Remember 记录一次成功的连接，已有记录中的 Serial 与 Model 在新值为空时保留
+func (w *WirelessRegistry) Remember(d WirelessDevice) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if prev, ok := w.devices[d.Address]; ok {
+		if d.Serial == "" {
+			d.Serial = prev.Serial
+		}
+		if d.Model == "" {
+			d.Model = prev.Model
+		}
+	}
+	if d.LastConnected.IsZero() {
+		d.LastConnected = time.Now()
+	}
+	w.devices[d.Address] = d
+	return w.saveLocked()
+}
+
+// Forget 删除一条记录，之后启动时不再尝试连接该地址
+func (w *WirelessRegistry) Forget(addr string) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if _, ok := w.devices[addr]; !ok {
+		return nil
+	}
+	delete(w.devices, addr)
+	return w.saveLocked()
+}
+
+// saveLocked 先写入临时文件再重命名，避免进程中断时留下损坏的 JSON
+func (w *WirelessRegistry) saveLocked() error {
+	if w.path == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(w.listLocked(), "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(w.path), 0755); err != nil {
+		return fmt.Errorf("adb: saving wireless registry: %w", err)
+	}
+	tmp := w.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("adb: saving wireless registry: %w", err)
+	}
+	if err := os.Rename(tmp, w.path); err != nil {
+		return fmt.Errorf("adb: saving wireless registry: %w", err)
+	}
+	return nil
+}
+
+// ReconnectAll 并行连接所有已记住的地址，每个地址最多尝试 ReconnectAttempts 次
+// 通常在后端启动时于后台调用，连接失败只记录日志并保留记录
+func (w *WirelessRegistry) ReconnectAll(ctx context.Context, r Runner) {
+	devices := w.List()
+	if len(devices) == 0 {
+		return
+	}
+	log.Printf("WirelessRegistry: Reconnecting %d remembered wireless device(s)", len(devices))
+	var wg sync.WaitGroup
+	for _, d := range devices {
+		wg.Add(1)
+		go func(d WirelessDevice) {
+			defer wg.Done()
+			for attempt := 1; attempt <= w.ReconnectAttempts; attempt++ {
+				_, err := ConnectDevice(ctx, r, d.Address)
+				if err == nil {
+					d.LastConnected = time.Now()
+					if err := w.Remember(d); err != nil {
+						log.Printf("WirelessRegistry: %v", err)
+					}
+					return
+				}
+				log.Printf("WirelessRegistry: Attempt %d/%d to reconnect '%s' failed: %v", attempt, w.ReconnectAttempts, d.Address, err)
+				if attempt == w.ReconnectAttempts {
+					return
+				}
+				select {
+				case <-time.After(w.ReconnectInterval):
+				case <-ctx.Done():
+					return
+				}
+			}
+		}(d)
+	}
+	wg.Wait()
+}
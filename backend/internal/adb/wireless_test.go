@@ -0,0 +1,82 @@
+package adb_test
+
+import (
+	"context"
+	"errors"
+	"fishyinhe/backend/internal/adb"
+	"fishyinhe/backend/internal/adb/adbtest"
+	"testing"
+)
+
+func TestNormalizeAddr(t *testing.T) {
+	tests := []struct {
+		addr string
+		want string
+	}{
+		{"", ""},
+		{"   ", ""},
+		{"192.168.1.23", "192.168.1.23:5555"},
+		{" 192.168.1.23:37123\n", "192.168.1.23:37123"},
+		{"pixel.lan", "pixel.lan:5555"},
+		{"pixel.lan:5556", "pixel.lan:5556"},
+		{"fe80::1", "[fe80::1]:5555"},
+		{"fe80::1%wlan0", "[fe80::1%wlan0]:5555"},
+		{"[fe80::1]", "[fe80::1]:5555"},
+		{"[fe80::1]:40001", "[fe80::1]:40001"},
+		{"2001:db8::23", "[2001:db8::23]:5555"},
+	}
+	for _, tt := range tests {
+		if got := adb.NormalizeAddr(tt.addr); got != tt.want {
+			t.Errorf("NormalizeAddr(%q) = %q, want %q", tt.addr, got, tt.want)
+		}
+	}
+}
+
+func TestPairDeviceValidation(t *testing.T) {
+	tests := []struct {
+		addr string
+		code string
+	}{
+		{"", "123456"},
+		{"192.168.1.23:37123", ""},
+		{"192.168.1.23:37123", "12345"},
+		{"192.168.1.23:37123", "1234567"},
+		{"192.168.1.23:37123", "12345a"},
+		{"192.168.1.23:37123", " 123456"},
+		{"192.168.1.23", "123456"},
+		{":37123", "123456"},
+		{"192.168.1.23:0", "123456"},
+		{"192.168.1.23:65536", "123456"},
+		{"192.168.1.23:port", "123456"},
+	}
+	f := adbtest.NewFake()
+	for _, tt := range tests {
+		_, err := adb.PairDevice(context.Background(), f, tt.addr, tt.code)
+		if !errors.Is(err, adb.ErrInvalidArgument) {
+			t.Errorf("PairDevice(%q, %q) = %v, want ErrInvalidArgument", tt.addr, tt.code, err)
+		}
+	}
+	if calls := f.Calls(); len(calls) != 0 {
+		t.Errorf("invalid input reached the adb server: %v", calls)
+	}
+}
+
+func TestEnableTCPIPPort(t *testing.T) {
+	f := adbtest.NewFake()
+	f.AddCannedDevice("emu-1")
+	for _, port := range []int{-1, 65536} {
+		if _, err := adb.EnableTCPIP(context.Background(), f, "emu-1", port); !errors.Is(err, adb.ErrInvalidArgument) {
+			t.Errorf("EnableTCPIP(port %d) = %v, want ErrInvalidArgument", port, err)
+		}
+	}
+	if _, err := adb.EnableTCPIP(context.Background(), f, "", 5555); !errors.Is(err, adb.ErrInvalidArgument) {
+		t.Errorf("EnableTCPIP(empty deviceId) = %v, want ErrInvalidArgument", err)
+	}
+	if _, err := adb.EnableTCPIP(context.Background(), f, "emu-1", 0); err != nil {
+		t.Fatalf("EnableTCPIP(port 0): %v", err)
+	}
+	calls := f.Calls()
+	if len(calls) != 1 || calls[0].Method != "TCPIP" || calls[0].Command != "5555" {
+		t.Errorf("calls = %v, want a single tcpip on the default port", calls)
+	}
+}
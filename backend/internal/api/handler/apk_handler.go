@@ -2,19 +2,77 @@
 package handler
 
 import (
+	"context"
+	"encoding/base64"
+	"errors"
 	"fishyinhe/backend/internal/adb" // 确保模块路径正确
+	"fishyinhe/backend/internal/apk"
 	"fmt"
 	"github.com/gin-gonic/gin"
 	"io"
 	"log"
+	"mime"
+	"mime/multipart"
 	"net/http"
 	"os"
+	"path"
 	"path/filepath"
+	"strconv"
 	"time"
 )
 
+// InspectAPKHandler 解析上传的 APK (表单字段 apkFile) 并返回包名、版本、SDK 要求、原生库 ABI、权限、应用名称与图标
+// 表单字段 deviceId 非空时同时检查 APK 能否安装在该设备上
+func (h *Handler) InspectAPKHandler(c *gin.Context) {
+	file, header, err := c.Request.FormFile("apkFile")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "检索上传的 APK 文件时出错: " + err.Error()})
+		return
+	}
+	defer file.Close()
+
+	info, err := apk.Parse(file, header.Size)
+	if err != nil {
+		log.Printf("InspectAPKHandler: 解析 APK %s 失败: %v", header.Filename, err)
+		abortWithError(c, err, gin.H{"error": "无法解析 APK 文件 (Invalid APK file)", "filename": header.Filename})
+		return
+	}
+	body := gin.H{"filename": header.Filename, "size": header.Size, "apk": info}
+	if len(info.IconData) > 0 {
+		body["icon"] = "data:" + mime.TypeByExtension(path.Ext(info.IconPath)) + ";base64," + base64.StdEncoding.EncodeToString(info.IconData)
+	}
+
+	if deviceId := c.PostForm("deviceId"); deviceId != "" {
+		spec, err := adb.GetDeviceSpec(c.Request.Context(), h.adb, deviceId)
+		if err != nil {
+			abortWithError(c, err, gin.H{"error": "读取设备信息失败 (Failed to read device properties)", "deviceId": deviceId})
+			return
+		}
+		compat := gin.H{"deviceId": deviceId, "device": spec, "compatible": true}
+		var incompatible *apk.IncompatibleError
+		if err := apk.CheckCompatibility(info, spec.SDK, spec.ABIs); errors.As(err, &incompatible) {
+			compat["compatible"] = false
+			compat["reason"] = incompatible.Reason
+			compat["message"] = incompatible.Message
+		}
+		body["compatibility"] = compat
+	}
+	c.JSON(http.StatusOK, body)
+}
+
+// checkAPKCompatibility 检查 APK 的 SDK 与 ABI 要求是否与设备匹配，不匹配时返回 *apk.IncompatibleError
+func (h *Handler) checkAPKCompatibility(ctx context.Context, deviceId string, info *apk.Info) error {
+	spec, err := adb.GetDeviceSpec(ctx, h.adb, deviceId)
+	if err != nil {
+		return err
+	}
+	return apk.CheckCompatibility(info, spec.SDK, spec.ABIs)
+}
+
 // InstallLocalAPKHandler 处理从本地上传并直接安装 APK 的请求
-func InstallLocalAPKHandler(c *gin.Context) {
+// 安装前解析 APK 并检查 SDK 与 ABI 要求，与设备不匹配时直接拒绝，不推送到设备
+// 表单字段 keep=true 时安装成功后把 APK 保存到 APK 库，而不是删除
+func (h *Handler) InstallLocalAPKHandler(c *gin.Context) {
 	deviceId := c.Param("deviceId")
 	if deviceId == "" {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "必须提供设备 ID (Device ID is required)"})
@@ -78,7 +136,7 @@ func InstallLocalAPKHandler(c *gin.Context) {
 
 	defer func() {
 		log.Printf("InstallLocalAPKHandler: 尝试移除服务器临时文件: %s", localTempApkPath)
-		if rmErr := os.Remove(localTempApkPath); rmErr != nil {
+		if rmErr := os.Remove(localTempApkPath); rmErr != nil && !os.IsNotExist(rmErr) {
 			log.Printf("InstallLocalAPKHandler: 移除服务器临时文件 %s 失败: %v", localTempApkPath, rmErr)
 		} else {
 			log.Printf("InstallLocalAPKHandler: 成功移除服务器临时文件: %s", localTempApkPath)
@@ -93,21 +151,164 @@ func InstallLocalAPKHandler(c *gin.Context) {
 	}
 	log.Printf("InstallLocalAPKHandler: 确认临时文件 %s 存在，准备调用 adb.InstallAPK", localTempApkPath)
 
-	output, err := adb.InstallAPK(deviceId, localTempApkPath)
+	info, err := apk.ParseFile(localTempApkPath)
+	if err != nil {
+		log.Printf("InstallLocalAPKHandler: 解析 APK %s 失败: %v", originalFilename, err)
+		abortWithError(c, err, gin.H{"error": "无法解析 APK 文件 (Invalid APK file)", "filename": originalFilename})
+		return
+	}
+	if err := h.checkAPKCompatibility(c.Request.Context(), deviceId, info); err != nil {
+		log.Printf("InstallLocalAPKHandler: APK %s (%s %s) 无法安装在设备 %s 上: %v", originalFilename, info.PackageName, info.VersionName, deviceId, err)
+		abortWithError(c, err, gin.H{"error": "APK 与设备不兼容 (APK is incompatible with the device)", "filename": originalFilename, "apk": info})
+		return
+	}
+
+	output, err := adb.InstallAPK(c.Request.Context(), h.adb, deviceId, localTempApkPath)
 	if err != nil {
 		log.Printf("InstallLocalAPKHandler: 在设备 %s 上从服务器路径 %s (原始文件名: %s) 安装 APK 失败: %v", deviceId, localTempApkPath, originalFilename, err)
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error":    "安装 APK 失败 (Failed to install APK)",
-			"details":  output,
-			"rawError": err.Error(),
-		})
+		abortWithError(c, err, gin.H{"error": "安装 APK 失败 (Failed to install APK)"})
 		return
 	}
 
 	log.Printf("InstallLocalAPKHandler: 设备 %s 上的 APK 安装过程（原始文件 %s）产生的输出: %s", deviceId, originalFilename, output)
-	c.JSON(http.StatusOK, gin.H{
+	body := gin.H{
 		"message":  "APK 安装命令已执行 (APK installation command executed)",
 		"details":  output,
 		"filename": originalFilename,
+		"apk":      info,
+	}
+	// 表单字段 keep=true 时把 APK 保存到库中，之后可以直接安装到其他设备而不必重新上传
+	if keep, _ := strconv.ParseBool(c.PostForm("keep")); keep {
+		if entry := h.keepInLibrary(localTempApkPath, originalFilename); entry != nil {
+			body["library"] = entry
+		}
+	}
+	c.JSON(http.StatusOK, body)
+}
+
+// obbResult 是推送到设备的 OBB 扩展文件
+type obbResult struct {
+	Name       string `json:"name"`
+	Size       int64  `json:"size"`
+	RemotePath string `json:"remotePath"`
+}
+
+// InstallBundleHandler 安装拆分 APK: 表单字段 files 可以是多个 APK、bundletool 生成的 .apks、.xapk 或 zip 安装包以及 OBB 扩展文件
+// 根据设备的 ABI、屏幕密度与系统语言选择拆分，通过 pm 安装会话一次安装，成功后将 OBB 文件推送到 Android/obb/<包名>/
+func (h *Handler) InstallBundleHandler(c *gin.Context) {
+	deviceId := c.Param("deviceId")
+	if deviceId == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "必须提供设备 ID (Device ID is required)"})
+		return
+	}
+	form, err := c.MultipartForm()
+	if err != nil || len(form.File["files"]) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "必须上传至少一个文件 (form field 'files' is required)"})
+		return
+	}
+	headers := form.File["files"]
+
+	currentWorkDir, err := os.Getwd()
+	if err != nil {
+		log.Printf("InstallBundleHandler: 无法获取当前工作目录: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "服务器错误：无法确定应用工作路径"})
+		return
+	}
+	tempServerDir := filepath.Join(currentWorkDir, "temp_apk_storage_server")
+	if err := os.MkdirAll(tempServerDir, 0755); err != nil {
+		log.Printf("InstallBundleHandler: 创建服务器临时目录 %s 失败: %v", tempServerDir, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "服务器错误：无法创建临时目录"})
+		return
+	}
+	dir, err := os.MkdirTemp(tempServerDir, "bundle_")
+	if err != nil {
+		log.Printf("InstallBundleHandler: 创建临时目录失败: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "服务器错误：无法创建临时目录"})
+		return
+	}
+	defer func() {
+		if err := os.RemoveAll(dir); err != nil {
+			log.Printf("InstallBundleHandler: 移除服务器临时目录 %s 失败: %v", dir, err)
+		}
+	}()
+
+	bundle := apk.NewBundle(dir)
+	for i, header := range headers {
+		localPath := filepath.Join(dir, fmt.Sprintf("upload_%03d%s", i, filepath.Ext(header.Filename)))
+		if err := saveUploadedFile(header, localPath); err != nil {
+			log.Printf("InstallBundleHandler: 保存上传的文件 %s 失败: %v", header.Filename, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "服务器错误：无法保存上传的文件", "filename": header.Filename})
+			return
+		}
+		if err := bundle.Add(header.Filename, localPath); err != nil {
+			log.Printf("InstallBundleHandler: 无法读取上传的文件 %s: %v", header.Filename, err)
+			abortWithError(c, err, gin.H{"error": "无法解析安装包 (Invalid APK or bundle)", "filename": header.Filename})
+			return
+		}
+	}
+
+	ctx := c.Request.Context()
+	spec, err := adb.GetDeviceSpec(ctx, h.adb, deviceId)
+	if err != nil {
+		abortWithError(c, err, gin.H{"error": "读取设备信息失败 (Failed to read device properties)", "deviceId": deviceId})
+		return
+	}
+	sel, err := bundle.Select(spec.SDK, spec.ABIs, spec.Density, spec.Locales)
+	if err != nil {
+		log.Printf("InstallBundleHandler: 无法为设备 %s 选择 APK: %v", deviceId, err)
+		abortWithError(c, err, gin.H{"error": "安装包与设备不兼容 (Bundle cannot be installed on the device)", "device": spec, "apks": bundle.APKs})
+		return
+	}
+	paths := make([]string, len(sel.APKs))
+	for i, a := range sel.APKs {
+		paths[i] = a.Path
+	}
+	log.Printf("InstallBundleHandler: 在设备 %s 上安装 %s %s 的 %d 个 APK (跳过 %d 个拆分)", deviceId, sel.Base.PackageName, sel.Base.VersionName, len(sel.APKs), len(sel.Skipped))
+	output, err := adb.InstallMultiple(ctx, h.adb, deviceId, paths)
+	if err != nil {
+		log.Printf("InstallBundleHandler: 在设备 %s 上安装 %s 失败: %v", deviceId, sel.Base.PackageName, err)
+		abortWithError(c, err, gin.H{"error": "安装 APK 失败 (Failed to install APK)", "apks": sel.APKs})
+		return
+	}
+
+	obbs := make([]obbResult, 0, len(bundle.OBBs))
+	for _, obb := range bundle.OBBs {
+		remote, err := adb.PushOBB(ctx, h.adb, deviceId, sel.Base.PackageName, obb.Path, obb.Name)
+		if err != nil {
+			log.Printf("InstallBundleHandler: 推送 OBB 文件 %s 到设备 %s 失败: %v", obb.Name, deviceId, err)
+			abortWithError(c, err, gin.H{"error": "应用已安装，但推送 OBB 文件失败 (APK installed but pushing OBB files failed)", "filename": obb.Name, "details": output, "obbs": obbs})
+			return
+		}
+		obbs = append(obbs, obbResult{Name: obb.Name, Size: obb.Size, RemotePath: remote})
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":     "APK 安装命令已执行 (APK installation command executed)",
+		"details":     output,
+		"packageName": sel.Base.PackageName,
+		"versionName": sel.Base.VersionName,
+		"versionCode": sel.Base.VersionCode,
+		"device":      spec,
+		"installed":   sel.APKs,
+		"skipped":     sel.Skipped,
+		"obbs":        obbs,
 	})
 }
+
+// saveUploadedFile 将上传的文件保存到服务器上的 dst
+func saveUploadedFile(header *multipart.FileHeader, dst string) error {
+	src, err := header.Open()
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, src); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
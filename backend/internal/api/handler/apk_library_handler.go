@@ -0,0 +1,233 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"fishyinhe/backend/internal/adb"
+	"fishyinhe/backend/internal/api/middleware"
+	"fishyinhe/backend/internal/apk"
+	"fishyinhe/backend/internal/apkrepo"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// maxInstallDevices 是一次请求最多安装的设备数
+const maxInstallDevices = 200
+
+// InstallResult 是 APK 在一台设备上的安装结果
+type InstallResult struct {
+	DeviceID string `json:"deviceId"`
+	OK       bool   `json:"ok"`
+	Output   string `json:"output,omitempty"`
+	Error    string `json:"error,omitempty"`
+	Code     string `json:"code,omitempty"`
+	// Reason 是 pm 的失败原因 (例如 INSTALL_FAILED_OLDER_SDK)，安装前的兼容性检查失败时也会填写
+	Reason     string `json:"reason,omitempty"`
+	DurationMs int64  `json:"durationMs"`
+}
+
+// installReason 返回安装失败的 INSTALL_FAILED_* 原因，没有时返回空字符串
+func installReason(err error) string {
+	var installErr *adb.InstallError
+	if errors.As(err, &installErr) {
+		return installErr.Reason
+	}
+	var incompatible *apk.IncompatibleError
+	if errors.As(err, &incompatible) {
+		return incompatible.Reason
+	}
+	return ""
+}
+
+// installOnDevice 检查兼容性后把服务器上的 APK 安装到一台设备，失败时填写错误类别与原因
+// progress 非 nil 时在推送 APK 的过程中报告进度
+func (h *Handler) installOnDevice(ctx context.Context, deviceId string, localPath string, info *apk.Info, progress adb.TransferProgress) (InstallResult, error) {
+	start := time.Now()
+	res := InstallResult{DeviceID: deviceId}
+	err := h.checkAPKCompatibility(ctx, deviceId, info)
+	if err == nil {
+		res.Output, err = adb.InstallAPKWithProgress(ctx, h.adb, deviceId, localPath, progress)
+	}
+	res.DurationMs = time.Since(start).Milliseconds()
+	if err != nil {
+		log.Printf("Failed to install %s %s on device %s: %v", info.PackageName, info.VersionName, deviceId, err)
+		_, res.Code = middleware.ClassifyError(err)
+		res.Error = err.Error()
+		res.Reason = installReason(err)
+		return res, err
+	}
+	res.OK = true
+	return res, nil
+}
+
+// ListLibraryHandler 列出 APK 库中的构建，查询参数 package 非空时只列出该包的版本
+func (h *Handler) ListLibraryHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"apks": h.apks.List(c.Query("package"))})
+}
+
+// AddToLibraryHandler 将上传的 APK (表单字段 apkFile) 保存到 APK 库
+// 新增或替换时返回 201；库中已有内容相同的构建时返回 200 与原来的条目
+func (h *Handler) AddToLibraryHandler(c *gin.Context) {
+	header, err := c.FormFile("apkFile")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "检索上传的 APK 文件时出错: " + err.Error()})
+		return
+	}
+
+	tmp, err := os.CreateTemp("", "apk_library_*.apk")
+	if err != nil {
+		log.Printf("AddToLibraryHandler: 创建临时文件失败: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "服务器错误：无法创建临时文件"})
+		return
+	}
+	tmp.Close()
+	defer os.Remove(tmp.Name())
+	if err := saveUploadedFile(header, tmp.Name()); err != nil {
+		log.Printf("AddToLibraryHandler: 保存上传的文件 %s 失败: %v", header.Filename, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "服务器错误：无法保存上传的 APK"})
+		return
+	}
+
+	entry, added, err := h.apks.Add(tmp.Name(), header.Filename)
+	if err != nil {
+		log.Printf("AddToLibraryHandler: 保存 %s 到 APK 库失败: %v", header.Filename, err)
+		abortWithError(c, err, gin.H{"error": "无法保存 APK 到库中 (Failed to add APK to the library)", "filename": header.Filename})
+		return
+	}
+	status := http.StatusOK
+	if added {
+		status = http.StatusCreated
+		log.Printf("AddToLibraryHandler: 已保存 %s %s (%d) 到 APK 库", entry.PackageName, entry.VersionName, entry.VersionCode)
+	}
+	c.JSON(status, gin.H{"apk": entry, "added": added})
+}
+
+// findLibraryEntry 查找库中包 pkg 的构建，version 为 versionCode 或 latest
+func (h *Handler) findLibraryEntry(pkg string, version string) (apkrepo.Entry, error) {
+	if version == "latest" || version == "" {
+		return h.apks.Latest(pkg)
+	}
+	code, err := strconv.ParseInt(version, 10, 64)
+	if err != nil {
+		return apkrepo.Entry{}, fmt.Errorf("%w: version must be a versionCode or \"latest\", got %q", adb.ErrInvalidArgument, version)
+	}
+	return h.apks.Get(pkg, code)
+}
+
+// libraryEntry 根据路径参数 package 与 version (versionCode 或 latest) 查找库中的构建，找不到时返回错误响应与 false
+func (h *Handler) libraryEntry(c *gin.Context) (apkrepo.Entry, bool) {
+	pkg, version := c.Param("package"), c.Param("version")
+	entry, err := h.findLibraryEntry(pkg, version)
+	if err != nil {
+		abortWithError(c, err, gin.H{"error": "APK 库中没有该版本 (APK not found in the library)", "package": pkg, "version": version})
+		return apkrepo.Entry{}, false
+	}
+	return entry, true
+}
+
+// GetLibraryAPKHandler 返回库中一个构建的元数据
+func (h *Handler) GetLibraryAPKHandler(c *gin.Context) {
+	entry, ok := h.libraryEntry(c)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"apk": entry})
+}
+
+// DownloadLibraryAPKHandler 下载库中的一个构建，文件名为上传时的文件名
+func (h *Handler) DownloadLibraryAPKHandler(c *gin.Context) {
+	entry, ok := h.libraryEntry(c)
+	if !ok {
+		return
+	}
+	name := entry.Filename
+	if name == "" {
+		name = fmt.Sprintf("%s-%d.apk", entry.PackageName, entry.VersionCode)
+	}
+	c.Header("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": name}))
+	c.Header("Content-Type", "application/vnd.android.package-archive")
+	c.Header("ETag", `"`+entry.SHA256+`"`)
+	c.File(h.apks.Path(entry))
+}
+
+// DeleteLibraryAPKHandler 从库中删除一个构建
+func (h *Handler) DeleteLibraryAPKHandler(c *gin.Context) {
+	entry, ok := h.libraryEntry(c)
+	if !ok {
+		return
+	}
+	if _, err := h.apks.Delete(entry.PackageName, entry.VersionCode); err != nil {
+		abortWithError(c, err, gin.H{"error": "删除 APK 失败 (Failed to delete APK)", "package": entry.PackageName, "versionCode": entry.VersionCode})
+		return
+	}
+	log.Printf("DeleteLibraryAPKHandler: 已从 APK 库删除 %s %d", entry.PackageName, entry.VersionCode)
+	c.JSON(http.StatusOK, gin.H{"deleted": entry})
+}
+
+// installLibraryRequest 是安装库中构建的请求体
+type installLibraryRequest struct {
+	DeviceIDs []string `json:"deviceIds"`
+}
+
+// InstallLibraryAPKHandler 将库中的一个构建并行安装到请求中的设备 (最多同时安装 defaultBatchConcurrency 台)，某台设备失败不影响其余设备
+// 全部成功时返回 200；只有一台设备且失败时返回该错误对应的错误响应；部分失败时返回 207
+func (h *Handler) InstallLibraryAPKHandler(c *gin.Context) {
+	entry, ok := h.libraryEntry(c)
+	if !ok {
+		return
+	}
+	var req installLibraryRequest
+	if err := c.ShouldBindJSON(&req); err != nil || len(req.DeviceIDs) == 0 || len(req.DeviceIDs) > maxInstallDevices {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("deviceIds must list between 1 and %d devices", maxInstallDevices)})
+		return
+	}
+
+	// 安装期间条目可能被删除或按保留规则清理，固定住文件直到全部设备安装结束
+	localPath, release, err := h.apks.Pin(entry)
+	if err != nil {
+		abortWithError(c, err, gin.H{"error": "APK 库中没有该版本 (APK not found in the library)", "package": entry.PackageName, "versionCode": entry.VersionCode})
+		return
+	}
+	defer release()
+
+	results := make([]InstallResult, len(req.DeviceIDs))
+	var lastErr error
+	failed := 0
+	h.installBatch(c.Request.Context(), localPath, entry.Info, req.DeviceIDs, defaultBatchConcurrency, nil, nil, func(i int, res InstallResult, err error) {
+		results[i] = res
+		if err != nil {
+			lastErr = err
+			failed++
+		}
+	})
+	log.Printf("InstallLibraryAPKHandler: %s %s (%d) installed on %d device(s), %d failed", entry.PackageName, entry.VersionName, entry.VersionCode, len(results)-failed, failed)
+
+	body := gin.H{"apk": entry, "results": results, "succeeded": len(results) - failed, "failed": failed}
+	switch {
+	case failed == 0:
+		c.JSON(http.StatusOK, body)
+	case len(results) == 1:
+		body["error"] = "安装 APK 失败 (Failed to install APK)"
+		body["deviceId"] = req.DeviceIDs[0]
+		abortWithError(c, lastErr, body)
+	default:
+		c.JSON(http.StatusMultiStatus, body)
+	}
+}
+
+// keepInLibrary 在安装成功后把上传的 APK 移动到库中，失败只记录日志，不影响安装结果
+func (h *Handler) keepInLibrary(localPath string, filename string) *apkrepo.Entry {
+	entry, _, err := h.apks.Add(localPath, filepath.Base(filename))
+	if err != nil {
+		log.Printf("Failed to keep %s in the APK library: %v", filename, err)
+		return nil
+	}
+	return &entry
+}
@@ -8,7 +8,7 @@ import (
 )
 
 // ListInstalledAppsHandler 处理列出设备上已安装应用的请求
-func ListInstalledAppsHandler(c *gin.Context) {
+func (h *Handler) ListInstalledAppsHandler(c *gin.Context) {
 	deviceId := c.Param("deviceId")
 	if deviceId == "" {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Device ID is required"})
@@ -24,10 +24,10 @@ func ListInstalledAppsHandler(c *gin.Context) {
 	// 未来可以添加其他过滤选项，如 "system" (-s) 等
 
 	log.Printf("ListInstalledAppsHandler: Received request for device %s, filter: %s (adb options: '%s')", deviceId, filterOption, adbOptions)
-	packages, err := adb.ListInstalledPackages(deviceId, adbOptions)
+	packages, err := adb.ListInstalledPackages(c.Request.Context(), h.adb, deviceId, adbOptions)
 	if err != nil {
 		log.Printf("ListInstalledAppsHandler: Error listing packages for device %s: %v", deviceId, err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list installed applications", "details": err.Error()})
+		abortWithError(c, err, gin.H{"error": "Failed to list installed applications"})
 		return
 	}
 
@@ -46,7 +46,7 @@ type UninstallAppRequest struct {
 }
 
 // UninstallAppHandler 处理卸载设备上应用的请求
-func UninstallAppHandler(c *gin.Context) {
+func (h *Handler) UninstallAppHandler(c *gin.Context) {
 	deviceId := c.Param("deviceId")
 	if deviceId == "" {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Device ID is required"})
@@ -73,14 +73,10 @@ func UninstallAppHandler(c *gin.Context) {
 
 	log.Printf("UninstallAppHandler: Request to uninstall package. Device: %s, Package: %s, KeepData: %t", deviceId, packageName, req.KeepData)
 
-	output, err := adb.UninstallPackage(deviceId, packageName, adbOptions)
+	output, err := adb.UninstallPackage(c.Request.Context(), h.adb, deviceId, packageName, adbOptions)
 	if err != nil {
 		log.Printf("UninstallAppHandler: Failed to uninstall package on device %s, package %s: %v", deviceId, packageName, err)
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error":    "Failed to uninstall package",
-			"details":  output,
-			"rawError": err.Error(),
-		})
+		abortWithError(c, err, gin.H{"error": "Failed to uninstall package"})
 		return
 	}
 
@@ -97,7 +93,7 @@ type ForceStopAppRequest struct {
 }
 
 // ForceStopAppHandler 处理停止设备上应用的请求
-func ForceStopAppHandler(c *gin.Context) {
+func (h *Handler) ForceStopAppHandler(c *gin.Context) {
 	deviceId := c.Param("deviceId")
 	if deviceId == "" {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Device ID is required"})
@@ -119,14 +115,10 @@ func ForceStopAppHandler(c *gin.Context) {
 
 	log.Printf("ForceStopAppHandler: Request to force stop package. Device: %s, Package: %s", deviceId, packageName)
 
-	output, err := adb.ForceStopPackage(deviceId, packageName)
+	output, err := adb.ForceStopPackage(c.Request.Context(), h.adb, deviceId, packageName)
 	if err != nil {
 		log.Printf("ForceStopAppHandler: Failed to force stop package on device %s, package %s: %v", deviceId, packageName, err)
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error":    "Failed to force stop package",
-			"details":  output,
-			"rawError": err.Error(),
-		})
+		abortWithError(c, err, gin.H{"error": "Failed to force stop package"})
 		return
 	}
 
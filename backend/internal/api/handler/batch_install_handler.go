@@ -0,0 +1,294 @@
+package handler
+
+import (
+	"context"
+	"fishyinhe/backend/internal/adb"
+	"fishyinhe/backend/internal/api/middleware"
+	"fishyinhe/backend/internal/apk"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 批量安装同时进行的设备数: 默认值与请求中 concurrency 的上限
+const (
+	defaultBatchConcurrency = 4
+	maxBatchConcurrency     = 16
+)
+
+// installBatch 以最多 concurrency 个并发把服务器上的 APK 安装到 deviceIds 中的每台设备
+// started 在某台设备开始安装时调用，pushed 在向其推送 APK 的过程中报告已发送的字节数 (两者都可以为 nil)，done 在其完成时调用；
+// i 是设备在 deviceIds 中的下标；回调都在调用者的 goroutine 中依次执行，可以直接写入响应；ctx 被取消时尚未开始的设备以 ctx 的错误完成
+func (h *Handler) installBatch(ctx context.Context, localPath string, info *apk.Info, deviceIds []string, concurrency int, started func(i int), pushed func(i int, sent int64, total int64), done func(i int, res InstallResult, err error)) {
+	type event struct {
+		i           int
+		started     bool
+		pushing     bool
+		sent, total int64
+		res         InstallResult
+		err         error
+	}
+	events := make(chan event)
+	jobs := make(chan int)
+	cancelled := func(i int) event {
+		err := ctx.Err()
+		_, code := middleware.ClassifyError(err)
+		return event{i: i, res: InstallResult{DeviceID: deviceIds[i], Error: err.Error(), Code: code}, err: err}
+	}
+	var wg sync.WaitGroup
+	for w := 0; w < min(concurrency, len(deviceIds)); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				// select 在 ctx 结束后仍可能分发下一台设备
+				if ctx.Err() != nil {
+					events <- cancelled(i)
+					continue
+				}
+				events <- event{i: i, started: true}
+				var progress adb.TransferProgress
+				if pushed != nil {
+					progress = func(sent int64, total int64) {
+						events <- event{i: i, pushing: true, sent: sent, total: total}
+					}
+				}
+				res, err := h.installOnDevice(ctx, deviceIds[i], localPath, info, progress)
+				events <- event{i: i, res: res, err: err}
+			}
+		}()
+	}
+	go func() {
+		i := 0
+	feed:
+		for ; i < len(deviceIds); i++ {
+			select {
+			case jobs <- i:
+			case <-ctx.Done():
+				break feed
+			}
+		}
+		close(jobs)
+		// 客户端已断开，剩余的设备不再安装
+		for ; i < len(deviceIds); i++ {
+			events <- cancelled(i)
+		}
+		wg.Wait()
+		close(events)
+	}()
+	for ev := range events {
+		switch {
+		case ev.started:
+			if started != nil {
+				started(ev.i)
+			}
+		case ev.pushing:
+			pushed(ev.i, ev.sent, ev.total)
+		default:
+			done(ev.i, ev.res, ev.err)
+		}
+	}
+}
+
+// splitList 合并重复的表单字段与逗号分隔的值，去掉空白与空项
+func splitList(values []string) []string {
+	var out []string
+	for _, v := range values {
+		for _, item := range strings.Split(v, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				out = append(out, item)
+			}
+		}
+	}
+	return out
+}
+
+// normalizeModel 统一型号的写法: adb devices -l 中的型号以下划线代替空格 (Pixel_7)
+func normalizeModel(s string) string {
+	return strings.ToLower(strings.ReplaceAll(s, "_", " "))
+}
+
+// wirelessSerial 判断序列号是否为无线调试连接 (host:port 或 mDNS 服务名)
+func wirelessSerial(serial string) bool {
+	return strings.Contains(serial, ":") || strings.Contains(serial, "._adb-tls-connect.")
+}
+
+// matchDeviceTag 判断在线设备是否匹配标签，标签未知时返回错误
+// 标签可以是 all、usb、wireless，或 key:value 形式的 model、brand、product、device、abi、sdk、release (不区分大小写)
+func matchDeviceTag(d adb.DeviceInfo, tag string) (bool, error) {
+	switch strings.ToLower(tag) {
+	case "all":
+		return true, nil
+	case "usb":
+		return !wirelessSerial(d.ID), nil
+	case "wireless":
+		return wirelessSerial(d.ID), nil
+	}
+	key, value, ok := strings.Cut(tag, ":")
+	if !ok {
+		return false, fmt.Errorf("%w: unknown device tag %q", adb.ErrInvalidArgument, tag)
+	}
+	switch strings.ToLower(key) {
+	case "model":
+		return normalizeModel(d.Model) == normalizeModel(value), nil
+	case "brand":
+		return strings.EqualFold(d.Brand, value), nil
+	case "product":
+		return strings.EqualFold(d.Product, value), nil
+	case "device":
+		return strings.EqualFold(d.Device, value), nil
+	case "abi":
+		return strings.EqualFold(d.ABI, value), nil
+	case "sdk":
+		return strconv.Itoa(d.SDKLevel) == value, nil
+	case "release":
+		return d.AndroidRelease == value, nil
+	}
+	return false, fmt.Errorf("%w: unknown device tag %q", adb.ErrInvalidArgument, tag)
+}
+
+// resolveDevices 合并显式的设备 ID 与按标签匹配到的在线设备，保持顺序并去重
+func (h *Handler) resolveDevices(ctx context.Context, deviceIds []string, tags []string) ([]string, error) {
+	seen := make(map[string]bool)
+	var out []string
+	add := func(id string) {
+		if !seen[id] {
+			seen[id] = true
+			out = append(out, id)
+		}
+	}
+	for _, id := range deviceIds {
+		add(id)
+	}
+	if len(tags) == 0 {
+		return out, nil
+	}
+	for _, tag := range tags {
+		if _, err := matchDeviceTag(adb.DeviceInfo{}, tag); err != nil {
+			return nil, err
+		}
+	}
+	devices, err := h.listDevices(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, tag := range tags {
+		for _, d := range devices {
+			if d.Status != "device" {
+				continue
+			}
+			if ok, _ := matchDeviceTag(d, tag); ok {
+				add(d.ID)
+			}
+		}
+	}
+	return out, nil
+}
+
+// batchInstallRequest 是批量安装的请求: multipart 表单 (可以附带 apkFile) 或 JSON
+// 不上传 APK 时使用库中包 Package 的构建 Version (versionCode 或 latest，默认 latest)
+type batchInstallRequest struct {
+	Package     string   `form:"package" json:"package"`
+	Version     string   `form:"version" json:"version"`
+	DeviceIDs   []string `form:"deviceIds" json:"deviceIds"`
+	Tags        []string `form:"tags" json:"tags"`
+	Concurrency int      `form:"concurrency" json:"concurrency"`
+}
+
+// InstallBatchHandler 把一个 APK 并行安装到多台设备: 上传的 apkFile，或 APK 库中的构建 (package 与 version)
+// 设备由 deviceIds 与 tags (见 matchDeviceTag) 指定，表单中的多个值可以重复字段或以逗号分隔；concurrency 是同时安装的设备数
+// 默认返回 NDJSON，format=sse 或 Accept: text/event-stream 时返回 SSE:
+// 首先是 start 事件 (APK 与设备列表)，每台设备开始安装时一个 status 为 installing 的 progress 事件，
+// 推送 APK 期间定期发送 status 为 pushing 的 progress 事件 (已发送的字节数 sent 与总字节数 total)，
+// 完成时一个 result 事件 (成功、INSTALL_FAILED_* 原因与耗时)，最后是 done 事件；开始安装前的错误返回普通的错误响应
+func (h *Handler) InstallBatchHandler(c *gin.Context) {
+	var req batchInstallRequest
+	if err := c.ShouldBind(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body: " + err.Error()})
+		return
+	}
+	if req.Concurrency == 0 {
+		req.Concurrency = defaultBatchConcurrency
+	}
+	if req.Concurrency < 1 || req.Concurrency > maxBatchConcurrency {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("concurrency must be between 1 and %d", maxBatchConcurrency)})
+		return
+	}
+	ctx := c.Request.Context()
+
+	var localPath, source string
+	var info *apk.Info
+	if header, err := c.FormFile("apkFile"); err == nil {
+		tmp, err := os.CreateTemp("", "install_batch_*.apk")
+		if err != nil {
+			log.Printf("InstallBatchHandler: 创建临时文件失败: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "服务器错误：无法创建临时文件"})
+			return
+		}
+		tmp.Close()
+		defer os.Remove(tmp.Name())
+		if err := saveUploadedFile(header, tmp.Name()); err != nil {
+			log.Printf("InstallBatchHandler: 保存上传的文件 %s 失败: %v", header.Filename, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "服务器错误：无法保存上传的 APK"})
+			return
+		}
+		if info, err = apk.ParseFile(tmp.Name()); err != nil {
+			log.Printf("InstallBatchHandler: 解析 APK %s 失败: %v", header.Filename, err)
+			abortWithError(c, err, gin.H{"error": "无法解析 APK 文件 (Invalid APK file)", "filename": header.Filename})
+			return
+		}
+		localPath, source = tmp.Name(), header.Filename
+	} else if req.Package != "" {
+		entry, err := h.findLibraryEntry(req.Package, req.Version)
+		if err != nil {
+			abortWithError(c, err, gin.H{"error": "APK 库中没有该版本 (APK not found in the library)", "package": req.Package, "version": req.Version})
+			return
+		}
+		// 安装期间条目可能被删除或按保留规则清理，固定住文件直到全部设备安装结束
+		pinned, release, err := h.apks.Pin(entry)
+		if err != nil {
+			abortWithError(c, err, gin.H{"error": "APK 库中没有该版本 (APK not found in the library)", "package": req.Package, "version": req.Version})
+			return
+		}
+		defer release()
+		localPath, info, source = pinned, entry.Info, "library"
+	} else {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "必须上传 apkFile 或指定 APK 库中的 package (apkFile or package is required)"})
+		return
+	}
+
+	deviceIds, err := h.resolveDevices(ctx, splitList(req.DeviceIDs), splitList(req.Tags))
+	if err != nil {
+		abortWithError(c, err, gin.H{"error": "无法确定要安装的设备 (Failed to resolve target devices)", "tags": req.Tags})
+		return
+	}
+	if len(deviceIds) == 0 || len(deviceIds) > maxInstallDevices {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("deviceIds and tags must select between 1 and %d devices", maxInstallDevices), "devices": deviceIds})
+		return
+	}
+
+	log.Printf("InstallBatchHandler: Installing %s %s (%s) on %d device(s) with concurrency %d", info.PackageName, info.VersionName, source, len(deviceIds), req.Concurrency)
+	start := time.Now()
+	stream := newEventStream(c)
+	stream.send("start", gin.H{"apk": info, "source": source, "devices": deviceIds, "concurrency": req.Concurrency})
+	failed := 0
+	h.installBatch(ctx, localPath, info, deviceIds, req.Concurrency, func(i int) {
+		stream.send("progress", gin.H{"deviceId": deviceIds[i], "status": "installing"})
+	}, func(i int, sent int64, total int64) {
+		stream.send("progress", gin.H{"deviceId": deviceIds[i], "status": "pushing", "sent": sent, "total": total})
+	}, func(i int, res InstallResult, err error) {
+		if err != nil {
+			failed++
+		}
+		stream.send("result", res)
+	})
+	duration := time.Since(start)
+	log.Printf("InstallBatchHandler: %s installed on %d device(s), %d failed, in %s", info.PackageName, len(deviceIds)-failed, failed, duration.Round(time.Millisecond))
+	stream.send("done", gin.H{"done": true, "succeeded": len(deviceIds) - failed, "failed": failed, "durationMs": duration.Milliseconds()})
+}
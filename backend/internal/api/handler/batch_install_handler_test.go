@@ -0,0 +1,337 @@
+package handler_test
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fishyinhe/backend/internal/adb/adbtest"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+var le = binary.LittleEndian
+
+// testAPK 构造只包含二进制 AndroidManifest.xml 的最小 APK，manifest 只有 package 与 versionCode 两个属性
+// 没有 targetSdkVersion 的 APK 不能安装在 Android 14 上，因此批量安装的设备都报告 API 30 (见 addBatchDevice)
+func testAPK(pkg string, versionCode uint32) []byte {
+	strs := []string{"manifest", "package", "versionCode", pkg}
+	var data []byte
+	var offsets []byte
+	for _, s := range strs {
+		offsets = le.AppendUint32(offsets, uint32(len(data)))
+		data = append(data, byte(len(s)), byte(len(s)))
+		data = append(append(data, s...), 0)
+	}
+	for len(data)%4 != 0 {
+		data = append(data, 0)
+	}
+	const poolHeader = 28
+	pool := le.AppendUint16(nil, 0x0001)
+	pool = le.AppendUint16(pool, poolHeader)
+	pool = le.AppendUint32(pool, uint32(poolHeader+len(offsets)+len(data)))
+	pool = le.AppendUint32(pool, uint32(len(strs)))
+	pool = le.AppendUint32(pool, 0)
+	pool = le.AppendUint32(pool, 1<<8) // UTF-8
+	pool = le.AppendUint32(pool, uint32(poolHeader+len(offsets)))
+	pool = le.AppendUint32(pool, 0)
+	pool = append(append(pool, offsets...), data...)
+
+	attr := func(b []byte, name uint32, raw uint32, dataType byte, value uint32) []byte {
+		b = le.AppendUint32(b, 0xffffffff)
+		b = le.AppendUint32(b, name)
+		b = le.AppendUint32(b, raw)
+		b = le.AppendUint16(b, 8)
+		b = append(b, 0, dataType)
+		return le.AppendUint32(b, value)
+	}
+	start := le.AppendUint16(nil, 0x0102)
+	start = le.AppendUint16(start, 16)
+	start = le.AppendUint32(start, 16+20+2*20)
+	start = le.AppendUint32(start, 1)
+	start = le.AppendUint32(start, 0xffffffff)
+	start = le.AppendUint32(start, 0xffffffff)
+	start = le.AppendUint32(start, 0)
+	start = le.AppendUint16(start, 20)
+	start = le.AppendUint16(start, 20)
+	start = le.AppendUint16(start, 2)
+	start = append(start, make([]byte, 6)...)
+	start = attr(start, 1, 3, 0x03, 3)
+	start = attr(start, 2, 0xffffffff, 0x10, versionCode)
+
+	end := le.AppendUint16(nil, 0x0103)
+	end = le.AppendUint16(end, 16)
+	end = le.AppendUint32(end, 24)
+	end = le.AppendUint32(end, 1)
+	end = le.AppendUint32(end, 0xffffffff)
+	end = le.AppendUint32(end, 0xffffffff)
+	end = le.AppendUint32(end, 0)
+
+	body := append(append(pool, start...), end...)
+	manifest := le.AppendUint16(nil, 0x0003)
+	manifest = le.AppendUint16(manifest, 8)
+	manifest = le.AppendUint32(manifest, uint32(8+len(body)))
+	manifest = append(manifest, body...)
+
+	var b bytes.Buffer
+	zw := zip.NewWriter(&b)
+	w, _ := zw.Create("AndroidManifest.xml")
+	w.Write(manifest)
+	zw.Close()
+	return b.Bytes()
+}
+
+// addBatchDevice 挂载一台型号为 model (adb devices -l 的写法) 的 API 30 预置设备
+// 属性缓存按序列号全局保存，批量安装的测试只使用 batch- 开头的序列号
+func addBatchDevice(f *adbtest.Fake, serial string, model string) *adbtest.Device {
+	d := f.AddCannedDevice(serial)
+	d.Attrs = "product:sdk_gphone64 model:" + model + " device:generic transport_id:1"
+	d.Commands["getprop"] = adbtest.Response{Stdout: strings.Replace(adbtest.CannedGetprop, "[ro.build.version.sdk]: [34]", "[ro.build.version.sdk]: [30]", 1)}
+	return d
+}
+
+// newBatchFake 返回挂载了 batch-a (Pixel 7)、batch-b (Pixel 8)、batch-c (Pixel 7) 与离线设备 batch-offline 的 Fake
+func newBatchFake() *adbtest.Fake {
+	f := adbtest.NewFake()
+	addBatchDevice(f, "batch-a", "Pixel_7")
+	addBatchDevice(f, "batch-b", "Pixel_8")
+	addBatchDevice(f, "batch-c", "Pixel_7")
+	f.AddDevice("batch-offline", "offline")
+	return f
+}
+
+// postBatch 以 multipart 表单上传测试 APK 并发出批量安装请求，fields 是成对的字段名与值
+func postBatch(ctx context.Context, r http.Handler, fields ...string) *httptest.ResponseRecorder {
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	for i := 0; i+1 < len(fields); i += 2 {
+		mw.WriteField(fields[i], fields[i+1])
+	}
+	fw, _ := mw.CreateFormFile("apkFile", "app.apk")
+	fw.Write(testAPK("com.example.app", 3))
+	mw.Close()
+	req := httptest.NewRequest("POST", "/api/apk/install-batch", &body).WithContext(ctx)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+// batchEvents 按 NDJSON 的行解析批量安装的事件流
+func batchEvents(t *testing.T, w *httptest.ResponseRecorder) []map[string]any {
+	t.Helper()
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", w.Code, w.Body)
+	}
+	if ct := w.Header().Get("Content-Type"); ct != "application/x-ndjson" {
+		t.Fatalf("Content-Type = %q, want application/x-ndjson", ct)
+	}
+	var events []map[string]any
+	sc := bufio.NewScanner(w.Body)
+	for sc.Scan() {
+		var ev map[string]any
+		if err := json.Unmarshal(sc.Bytes(), &ev); err != nil {
+			t.Fatalf("line %q: %v", sc.Text(), err)
+		}
+		events = append(events, ev)
+	}
+	if len(events) < 2 || events[0]["apk"] == nil || events[len(events)-1]["done"] != true {
+		t.Fatalf("stream does not begin with start and end with done: %s", w.Body)
+	}
+	return events
+}
+
+// batchResults 返回每台设备的 result 事件，同一设备有多个 result 时报错
+func batchResults(t *testing.T, events []map[string]any) map[string]map[string]any {
+	t.Helper()
+	results := map[string]map[string]any{}
+	for _, ev := range events {
+		if _, ok := ev["ok"]; !ok {
+			continue
+		}
+		id, _ := ev["deviceId"].(string)
+		if results[id] != nil {
+			t.Errorf("more than one result for %s", id)
+		}
+		results[id] = ev
+	}
+	return results
+}
+
+func startDevices(events []map[string]any) []string {
+	var ids []string
+	for _, id := range events[0]["devices"].([]any) {
+		ids = append(ids, id.(string))
+	}
+	return ids
+}
+
+func TestInstallBatchResultPerDevice(t *testing.T) {
+	f := newBatchFake()
+	d, _ := f.Lookup("batch-b")
+	canned := d.Handler
+	d.Handler = func(command string) (adbtest.Response, bool) {
+		if strings.HasPrefix(command, "pm install ") {
+			return adbtest.Response{Stdout: "Failure [INSTALL_FAILED_INSUFFICIENT_STORAGE]\n", ExitCode: 1}, true
+		}
+		return canned(command)
+	}
+	r := newTestRouter(t, f)
+
+	events := batchEvents(t, postBatch(context.Background(), r, "deviceIds", "batch-a,batch-b"))
+	results := batchResults(t, events)
+	if len(results) != 2 {
+		t.Fatalf("results = %v, want one for each of batch-a and batch-b", results)
+	}
+	if a := results["batch-a"]; a["ok"] != true {
+		t.Errorf("batch-a result = %v, want ok", a)
+	}
+	if b := results["batch-b"]; b["ok"] != false || b["reason"] != "INSTALL_FAILED_INSUFFICIENT_STORAGE" {
+		t.Errorf("batch-b result = %v, want INSTALL_FAILED_INSUFFICIENT_STORAGE", b)
+	}
+	done := events[len(events)-1]
+	if done["succeeded"] != 1.0 || done["failed"] != 1.0 {
+		t.Errorf("done = %v, want 1 succeeded and 1 failed", done)
+	}
+
+	// 每台设备先有 installing 再有 result
+	installing := map[string]bool{}
+	for _, ev := range events[1 : len(events)-1] {
+		id := ev["deviceId"].(string)
+		switch {
+		case ev["status"] == "installing":
+			installing[id] = true
+		case ev["ok"] != nil && !installing[id]:
+			t.Errorf("result for %s before its installing event", id)
+		}
+	}
+}
+
+func TestInstallBatchDeviceResolution(t *testing.T) {
+	tests := []struct {
+		name   string
+		fields []string
+		want   []string
+		// status 非 200 时请求在开始安装前被拒绝
+		status int
+		code   string
+	}{
+		{name: "explicit ids keep order and drop duplicates", fields: []string{"deviceIds", "batch-c, batch-a", "deviceIds", "batch-c"}, want: []string{"batch-c", "batch-a"}},
+		{name: "model tag ignores case and underscores", fields: []string{"tags", "model:pixel 7"}, want: []string{"batch-a", "batch-c"}},
+		{name: "model tag with underscores", fields: []string{"tags", "MODEL:Pixel_8"}, want: []string{"batch-b"}},
+		{name: "ids before tag matches", fields: []string{"deviceIds", "batch-c", "tags", "all"}, want: []string{"batch-c", "batch-a", "batch-b"}},
+		{name: "several tags", fields: []string{"tags", "model:Pixel_8,sdk:30"}, want: []string{"batch-b", "batch-a", "batch-c"}},
+		{name: "usb excludes offline devices", fields: []string{"tags", "usb"}, want: []string{"batch-a", "batch-b", "batch-c"}},
+		{name: "unknown tag", fields: []string{"tags", "color:red"}, status: 400, code: "invalid_argument"},
+		{name: "tag without key", fields: []string{"deviceIds", "batch-a", "tags", "pixel"}, status: 400, code: "invalid_argument"},
+		{name: "tag matching nothing", fields: []string{"tags", "model:Nexus_5"}, status: 400},
+		{name: "concurrency too high", fields: []string{"deviceIds", "batch-a", "concurrency", "17"}, status: 400},
+	}
+	r := newTestRouter(t, newBatchFake())
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := postBatch(context.Background(), r, tt.fields...)
+			if tt.status != 0 {
+				if w.Code != tt.status {
+					t.Fatalf("status = %d, want %d: %s", w.Code, tt.status, w.Body)
+				}
+				if tt.code != "" && !strings.Contains(w.Body.String(), `"code":"`+tt.code+`"`) {
+					t.Errorf("body = %s, want code %s", w.Body, tt.code)
+				}
+				return
+			}
+			events := batchEvents(t, w)
+			if got := startDevices(events); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("devices = %v, want %v", got, tt.want)
+			}
+			if results := batchResults(t, events); len(results) != len(tt.want) {
+				t.Errorf("%d results, want %d", len(results), len(tt.want))
+			}
+		})
+	}
+}
+
+// gatedPush 记录同时进行的推送数，并在推送前调用 before (可以为 nil)
+type gatedPush struct {
+	*adbtest.Fake
+	before func(ctx context.Context) error
+
+	mu           sync.Mutex
+	active, peak int
+	calls        int
+	pushDuration time.Duration
+}
+
+func (r *gatedPush) Push(ctx context.Context, serial string, src io.Reader, remotePath string, mode os.FileMode, mtime time.Time) error {
+	r.mu.Lock()
+	r.active++
+	r.calls++
+	r.peak = max(r.peak, r.active)
+	r.mu.Unlock()
+	defer func() {
+		r.mu.Lock()
+		r.active--
+		r.mu.Unlock()
+	}()
+	if r.before != nil {
+		if err := r.before(ctx); err != nil {
+			return err
+		}
+	}
+	time.Sleep(r.pushDuration)
+	return r.Fake.Push(ctx, serial, src, remotePath, mode, mtime)
+}
+
+func TestInstallBatchConcurrency(t *testing.T) {
+	f := newBatchFake()
+	ids := []string{"batch-a", "batch-b", "batch-c"}
+	for _, id := range []string{"batch-d", "batch-e"} {
+		addBatchDevice(f, id, "Pixel_7")
+		ids = append(ids, id)
+	}
+	r := &gatedPush{Fake: f, pushDuration: 20 * time.Millisecond}
+	events := batchEvents(t, postBatch(context.Background(), newTestRouter(t, r), "deviceIds", strings.Join(ids, ","), "concurrency", "2"))
+	results := batchResults(t, events)
+	for _, id := range ids {
+		if results[id]["ok"] != true {
+			t.Errorf("%s result = %v, want ok", id, results[id])
+		}
+	}
+	if r.peak != 2 {
+		t.Errorf("at most %d pushes ran at once, want 2", r.peak)
+	}
+}
+
+func TestInstallBatchCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	// 第一台设备推送时客户端断开
+	r := &gatedPush{Fake: newBatchFake(), before: func(pushCtx context.Context) error {
+		cancel()
+		<-pushCtx.Done()
+		return pushCtx.Err()
+	}}
+	events := batchEvents(t, postBatch(ctx, newTestRouter(t, r), "deviceIds", "batch-a,batch-b,batch-c", "concurrency", "1"))
+	results := batchResults(t, events)
+	for _, id := range []string{"batch-a", "batch-b", "batch-c"} {
+		if res := results[id]; res == nil || res["ok"] != false || res["error"] == "" {
+			t.Errorf("%s result = %v, want a failure", id, res)
+		}
+	}
+	if r.calls != 1 {
+		t.Errorf("%d pushes, want only the first device to start", r.calls)
+	}
+	if done := events[len(events)-1]; done["failed"] != 3.0 {
+		t.Errorf("done = %v, want 3 failed", done)
+	}
+}
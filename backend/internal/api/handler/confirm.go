@@ -0,0 +1,57 @@
+package handler
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// confirmTTL 是确认令牌的有效期
+const confirmTTL = 5 * time.Minute
+
+// confirmer 为递归删除等破坏性操作签发确认令牌
+// 令牌是 "<过期时间>.<HMAC>"，绑定设备与操作对象，服务器不需要保存任何状态
+type confirmer struct {
+	key []byte
+}
+
+func newConfirmer() *confirmer {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		panic("handler: cannot generate confirmation key: " + err.Error())
+	}
+	return &confirmer{key: key}
+}
+
+// sign 计算 subject 在 expires 之前有效的签名，paths 的顺序不影响结果
+func (c *confirmer) sign(expires int64, subject string, paths []string) string {
+	sorted := append([]string(nil), paths...)
+	sort.Strings(sorted)
+	mac := hmac.New(sha256.New, c.key)
+	mac.Write([]byte(strconv.FormatInt(expires, 10) + "\x00" + subject + "\x00" + strings.Join(sorted, "\x00")))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// issue 签发确认令牌，subject 描述操作 (例如设备与 "rm -r")
+func (c *confirmer) issue(subject string, paths []string) (string, time.Time) {
+	expires := time.Now().Add(confirmTTL).Truncate(time.Second)
+	return strconv.FormatInt(expires.Unix(), 10) + "." + c.sign(expires.Unix(), subject, paths), expires
+}
+
+// verify 报告 token 是否由 issue 为同一操作签发且尚未过期
+func (c *confirmer) verify(token string, subject string, paths []string) bool {
+	stamp, sig, ok := strings.Cut(token, ".")
+	if !ok {
+		return false
+	}
+	expires, err := strconv.ParseInt(stamp, 10, 64)
+	if err != nil || time.Now().Unix() > expires {
+		return false
+	}
+	return hmac.Equal([]byte(sig), []byte(c.sign(expires, subject, paths)))
+}
@@ -0,0 +1,140 @@
+package handler
+
+import (
+	"errors"
+	"fishyinhe/backend/internal/adb"
+	"github.com/gin-gonic/gin"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// SaveTextRequest 是保存文本文件的请求体
+// encoding、bom、lineEnding 通常原样使用读取时返回的值；encoding 为空时使用 UTF-8，lineEnding 为空时不转换换行符
+type SaveTextRequest struct {
+	Content    *string `json:"content" binding:"required"`
+	Encoding   string  `json:"encoding"`
+	BOM        bool    `json:"bom"`
+	LineEnding string  `json:"lineEnding"`
+}
+
+// setTextHeaders 设置文本文件的 ETag (内容的 SHA-256) 与 Last-Modified，保存时通过 If-Match 回传
+func setTextHeaders(c *gin.Context, f *adb.TextFile) {
+	header := c.Writer.Header()
+	header.Set("ETag", `"`+f.Hash+`"`)
+	if !f.ModTime.IsZero() {
+		header.Set("Last-Modified", f.ModTime.UTC().Format(http.TimeFormat))
+	}
+	header.Set("Cache-Control", "no-cache")
+}
+
+// textPrecondition 从 If-Match、If-Unmodified-Since 与 If-None-Match: * 读取保存的前置条件
+func textPrecondition(c *gin.Context) (adb.TextPrecondition, error) {
+	var cond adb.TextPrecondition
+	if v := c.GetHeader("If-Match"); v != "" {
+		cond.Hash = strings.Trim(strings.TrimPrefix(strings.TrimSpace(v), "W/"), `"`)
+	}
+	if v := c.GetHeader("If-Unmodified-Since"); v != "" {
+		t, err := http.ParseTime(v)
+		if err != nil {
+			return cond, errors.New("If-Unmodified-Since must be an HTTP date")
+		}
+		cond.ModTime = t
+	}
+	cond.MustNotExist = strings.TrimSpace(c.GetHeader("If-None-Match")) == "*"
+	return cond, nil
+}
+
+// GetTextContentHandler 读取设备上的文本文件用于预览与编辑
+// 查询参数 path 为文件路径；返回解码后的内容、识别出的编码与换行符，以及作为 ETag 的内容哈希
+// 超过大小上限返回 413，二进制或无法识别编码的文件返回 415
+func (h *Handler) GetTextContentHandler(c *gin.Context) {
+	deviceId := c.Param("deviceId")
+	remotePath := c.Query("path")
+	if remotePath == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "File path is required"})
+		return
+	}
+
+	f, err := adb.ReadTextFile(c.Request.Context(), h.adb, deviceId, remotePath, h.opts.TextMaxBytes)
+	if err != nil {
+		log.Printf("Failed to read text file. Device: %s, Path: %s, Error: %v", deviceId, remotePath, err)
+		abortWithError(c, err, gin.H{"error": "Failed to read file", "path": remotePath, "maxSize": h.opts.TextMaxBytes})
+		return
+	}
+	log.Printf("Read text file %s from device %s (%d bytes, %s)", remotePath, deviceId, f.Size, f.Encoding)
+	setTextHeaders(c, f)
+	c.JSON(http.StatusOK, f)
+}
+
+// PutTextContentHandler 保存设备上的文本文件，查询参数 path 为文件路径
+// 必须带有前置条件: If-Match (读取时的 ETag)、If-Unmodified-Since (读取时的 Last-Modified)
+// 或创建新文件时的 If-None-Match: *，否则返回 428；文件已被修改或删除时返回 409 与文件的当前状态
+// 内容先写入临时文件再替换原文件
+func (h *Handler) PutTextContentHandler(c *gin.Context) {
+	deviceId := c.Param("deviceId")
+	remotePath := c.Query("path")
+	if remotePath == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "File path is required"})
+		return
+	}
+	cond, err := textPrecondition(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if cond.IsZero() {
+		c.JSON(http.StatusPreconditionRequired, gin.H{
+			"error": "Saving requires If-Match, If-Unmodified-Since or If-None-Match: *",
+			"code":  "precondition_required",
+		})
+		return
+	}
+
+	// JSON 转义可能使内容变长数倍，这里只防止明显过大的请求，编码后的大小由 WriteTextFile 检查
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, 8*h.opts.TextMaxBytes+64<<10)
+	var req SaveTextRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "Content is too large", "code": "file_too_large", "maxSize": h.opts.TextMaxBytes})
+			return
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body: " + err.Error()})
+		return
+	}
+	format := adb.TextFormat{Encoding: req.Encoding, BOM: req.BOM, LineEnding: req.LineEnding}
+
+	log.Printf("Request to save text file. Device: %s, Path: %s, Format: %+v, Precondition: %+v", deviceId, remotePath, format, cond)
+	saved, err := adb.WriteTextFile(c.Request.Context(), h.adb, deviceId, remotePath, *req.Content, format, cond, h.opts.TextMaxBytes)
+	if err != nil {
+		log.Printf("Failed to save text file. Device: %s, Path: %s, Error: %v", deviceId, remotePath, err)
+		body := gin.H{"error": "Failed to save file", "path": remotePath, "maxSize": h.opts.TextMaxBytes}
+		var conflict *adb.ConflictError
+		if errors.As(err, &conflict) {
+			body["error"] = "File was changed on the device since it was opened"
+			current := gin.H{"exists": conflict.Exists}
+			if conflict.Exists {
+				current["size"] = conflict.Size
+				current["modTime"] = conflict.ModTime
+				if conflict.Hash != "" {
+					current["hash"] = conflict.Hash
+				}
+			}
+			body["current"] = current
+		}
+		abortWithError(c, err, body)
+		return
+	}
+	log.Printf("Saved text file %s on device %s (%d bytes)", remotePath, deviceId, saved.Size)
+	setTextHeaders(c, saved)
+	c.JSON(http.StatusOK, gin.H{
+		"path":       saved.Path,
+		"size":       saved.Size,
+		"modTime":    saved.ModTime,
+		"hash":       saved.Hash,
+		"encoding":   saved.Encoding,
+		"bom":        saved.BOM,
+		"lineEnding": saved.LineEnding,
+	})
+}
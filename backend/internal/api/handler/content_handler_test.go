@@ -0,0 +1,65 @@
+package handler_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPutTextContentPreconditions(t *testing.T) {
+	f := newTestFake()
+	f.PutFile("emu-1", "/sdcard/todo.txt", []byte("buy milk\n"))
+	r := newTestRouter(t, f)
+	const url = "/api/files/content/emu-1?path=/sdcard/todo.txt"
+
+	w := serve(r, "GET", url, "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("GET = %d: %s", w.Code, w.Body)
+	}
+	etag, lastModified := w.Header().Get("ETag"), w.Header().Get("Last-Modified")
+	if etag == "" || lastModified == "" {
+		t.Fatalf("GET returned ETag %q and Last-Modified %q", etag, lastModified)
+	}
+
+	put := func(header string, value string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("PUT", url, strings.NewReader(`{"content":"buy eggs\n","encoding":"utf-8","lineEnding":"lf"}`))
+		req.Header.Set("Content-Type", "application/json")
+		if header != "" {
+			req.Header.Set(header, value)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	if w := put("", ""); w.Code != http.StatusPreconditionRequired {
+		t.Errorf("PUT without a precondition = %d: %s, want 428", w.Code, w.Body)
+	}
+	if w := put("If-None-Match", "*"); w.Code != http.StatusConflict || !strings.Contains(w.Body.String(), `"code":"conflict"`) {
+		t.Errorf("PUT If-None-Match on an existing file = %d: %s, want 409 conflict", w.Code, w.Body)
+	}
+	stale := time.Now().Add(-time.Hour).UTC().Format(http.TimeFormat)
+	if w := put("If-Unmodified-Since", stale); w.Code != http.StatusConflict || !strings.Contains(w.Body.String(), `"exists":true`) {
+		t.Errorf("PUT with a stale If-Unmodified-Since = %d: %s, want 409 with the current state", w.Code, w.Body)
+	}
+
+	// 打开之后文件在设备上被修改
+	f.PutFile("emu-1", "/sdcard/todo.txt", []byte("buy bread\n"))
+	w = put("If-Match", etag)
+	if w.Code != http.StatusConflict || !strings.Contains(w.Body.String(), `"code":"conflict"`) || !strings.Contains(w.Body.String(), `"hash":`) {
+		t.Errorf("PUT with a stale If-Match = %d: %s, want 409 with the current hash", w.Code, w.Body)
+	}
+	if data, _ := f.ReadFile("emu-1", "/sdcard/todo.txt"); string(data) != "buy bread\n" {
+		t.Errorf("rejected save changed the file to %q", data)
+	}
+
+	w = serve(r, "GET", url, "")
+	if w := put("If-Match", w.Header().Get("ETag")); w.Code != http.StatusOK {
+		t.Fatalf("PUT with the current ETag = %d: %s", w.Code, w.Body)
+	}
+	if data, _ := f.ReadFile("emu-1", "/sdcard/todo.txt"); string(data) != "buy eggs\n" {
+		t.Errorf("saved file = %q, want %q", data, "buy eggs\n")
+	}
+}
@@ -1,28 +1,76 @@
 package handler
 
 import (
+	"context"
 	"fishyinhe/backend/internal/adb" // 确保模块路径正确
 	"github.com/gin-gonic/gin"
+	"io"
 	"log"
 	"net/http"
+	"time"
 )
 
 // GetDevices 处理获取已连接设备列表的请求
-func GetDevices(c *gin.Context) {
-	devices, err := adb.ListConnectedDevices()
+// 优先使用 Tracker 维护的注册表，只有在 Tracker 未与 adb server 同步时才直接查询
+func (h *Handler) GetDevices(c *gin.Context) {
+	devices, err := h.listDevices(c.Request.Context())
 	if err != nil {
 		log.Printf("Failed to list devices: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve device list"})
+		abortWithError(c, err, gin.H{"error": "Failed to retrieve device list"})
 		return
 	}
+	c.JSON(http.StatusOK, devices)
+}
+
+// listDevices 返回补充了设备属性的设备列表，Tracker 已同步时使用其注册表
+func (h *Handler) listDevices(ctx context.Context) ([]adb.DeviceInfo, error) {
+	if h.tracker != nil {
+		if devices, ok := h.tracker.Devices(); ok {
+			return adb.EnrichDevices(ctx, h.adb, devices), nil
+		}
+	}
+	devices, err := adb.ListConnectedDevices(ctx, h.adb)
+	if err != nil {
+		return nil, err
+	}
 	if devices == nil {
 		devices = []adb.DeviceInfo{}
 	}
-	c.JSON(http.StatusOK, devices)
+	return devices, nil
+}
+
+// DeviceQueuesHandler 返回各设备命令队列的执行数与按优先级统计的等待数，用于观察设备上的命令争用
+// 未启用 adb.Scheduler 时返回空列表
+func (h *Handler) DeviceQueuesHandler(c *gin.Context) {
+	stats := []adb.QueueStats{}
+	if scheduler, ok := h.adb.(*adb.Scheduler); ok {
+		stats = scheduler.Stats()
+	}
+	c.JSON(http.StatusOK, stats)
+}
+
+// GetDevicePropertiesHandler 返回设备完整的 getprop 属性表
+// 查询参数 refresh=true 时跳过缓存重新读取
+func (h *Handler) GetDevicePropertiesHandler(c *gin.Context) {
+	deviceId := c.Param("deviceId")
+	if deviceId == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Device ID is required"})
+		return
+	}
+
+	refresh := c.Query("refresh") == "true"
+	props, err := adb.GetProperties(c.Request.Context(), h.adb, deviceId, refresh)
+	if err != nil {
+		log.Printf("GetDevicePropertiesHandler: Error for device %s: %v", deviceId, err)
+		abortWithError(c, err, gin.H{"error": "Failed to read device properties"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"deviceId": deviceId, "properties": props})
 }
 
 // DeviceGoHomeHandler 处理让设备返回主屏幕的请求
-func DeviceGoHomeHandler(c *gin.Context) {
+func (h *Handler) DeviceGoHomeHandler(c *gin.Context) {
 	deviceId := c.Param("deviceId")
 	if deviceId == "" {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Device ID is required"})
@@ -30,10 +78,10 @@ func DeviceGoHomeHandler(c *gin.Context) {
 	}
 
 	log.Printf("DeviceGoHomeHandler: Received request for device %s to go home", deviceId)
-	err := adb.GoToHomeScreen(deviceId) // 调用 adb 包中的 GoToHomeScreen 函数
+	err := adb.GoToHomeScreen(c.Request.Context(), h.adb, deviceId) // 调用 adb 包中的 GoToHomeScreen 函数
 	if err != nil {
 		log.Printf("DeviceGoHomeHandler: Error sending home command for device %s: %v", deviceId, err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to send home command to device", "details": err.Error()})
+		abortWithError(c, err, gin.H{"error": "Failed to send home command to device"})
 		return
 	}
 
@@ -41,7 +89,7 @@ func DeviceGoHomeHandler(c *gin.Context) {
 }
 
 // DeviceWakeUpHandler 处理唤醒设备屏幕的请求
-func DeviceWakeUpHandler(c *gin.Context) {
+func (h *Handler) DeviceWakeUpHandler(c *gin.Context) {
 	deviceId := c.Param("deviceId")
 	if deviceId == "" {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Device ID is required"})
@@ -49,12 +97,52 @@ func DeviceWakeUpHandler(c *gin.Context) {
 	}
 
 	log.Printf("DeviceWakeUpHandler: Received request for device %s to wake up", deviceId)
-	err := adb.WakeUpDevice(deviceId)
+	err := adb.WakeUpDevice(c.Request.Context(), h.adb, deviceId)
 	if err != nil {
 		log.Printf("DeviceWakeUpHandler: Error for device %s: %v", deviceId, err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to send wake up command", "details": err.Error()})
+		abortWithError(c, err, gin.H{"error": "Failed to send wake up command"})
 		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{"message": "Wake up command sent successfully to device " + deviceId})
 }
+
+// DeviceEventsHandler 通过 SSE 推送设备的 connected、disconnected、unauthorized 与 offline 事件
+// 连接建立后首先发送一次 snapshot 事件，内容为当前的设备列表
+func (h *Handler) DeviceEventsHandler(c *gin.Context) {
+	if h.tracker == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Device tracking is not enabled"})
+		return
+	}
+
+	// 先订阅再取快照，避免两者之间发生的变化丢失
+	events, unsubscribe := h.tracker.Subscribe()
+	defer unsubscribe()
+	devices, _ := h.tracker.Devices()
+
+	log.Printf("DeviceEventsHandler: Client %s subscribed to device events", c.ClientIP())
+	c.Header("Cache-Control", "no-cache")
+	c.Header("X-Accel-Buffering", "no") // 禁止反向代理缓冲事件流
+	c.SSEvent("snapshot", devices)
+	c.Writer.Flush()
+
+	keepAlive := time.NewTicker(15 * time.Second)
+	defer keepAlive.Stop()
+	c.Stream(func(w io.Writer) bool {
+		select {
+		case ev, ok := <-events:
+			if !ok {
+				return false
+			}
+			c.SSEvent(string(ev.Type), ev)
+			return true
+		case <-keepAlive.C:
+			// SSE 注释行，仅用于保持连接
+			io.WriteString(w, ": keep-alive\n\n")
+			return true
+		case <-c.Request.Context().Done():
+			return false
+		}
+	})
+	log.Printf("DeviceEventsHandler: Client %s unsubscribed from device events", c.ClientIP())
+}
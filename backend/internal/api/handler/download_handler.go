@@ -0,0 +1,269 @@
+package handler
+
+import (
+	"errors"
+	"fishyinhe/backend/internal/adb"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"log"
+	"mime"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DownloadFileHandler 将设备文件直接流式传输给客户端，不在服务器上保存临时文件
+// 支持单段 Range 请求 (视频拖动、断点续传) 与 If-Range；inline=1 时让浏览器直接打开而不是下载
+// package=<包名> 时以该应用的身份读取其私有目录中的文件
+func (h *Handler) DownloadFileHandler(c *gin.Context) {
+	deviceId := c.Param("deviceId")
+	remoteFilePath := c.Query("filePath") // 例如 /sdcard/Download/my file.txt，gin 已完成 URL 解码
+
+	if deviceId == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Device ID is required"})
+		return
+	}
+	if remoteFilePath == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "File path is required"})
+		return
+	}
+
+	log.Printf("Request to download file. Device: %s, Remote Path: %s, Range: %q", deviceId, remoteFilePath, c.GetHeader("Range"))
+
+	runner, ok := h.fileRunner(c)
+	if !ok {
+		return
+	}
+	ctx := c.Request.Context()
+	st, err := adb.StatFile(ctx, runner, deviceId, remoteFilePath)
+	if err != nil {
+		log.Printf("Failed to stat file for download. Device: %s, Path: %s, Error: %v", deviceId, remoteFilePath, err)
+		abortWithError(c, err, gin.H{"error": "Failed to retrieve file from device", "path": remoteFilePath})
+		return
+	}
+	if st.Mode.IsDir() {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Path is a directory", "path": remoteFilePath})
+		return
+	}
+
+	header := c.Writer.Header()
+	lastModified := st.ModTime.UTC().Format(http.TimeFormat)
+	header.Set("Last-Modified", lastModified)
+	// 大小不可靠时 (旧版 adbd 的 32 位 STAT) 不支持范围请求，也不设置 Content-Length，以免截断超过 4 GiB 的文件
+	if st.SizeInexact {
+		header.Set("Accept-Ranges", "none")
+	} else {
+		header.Set("Accept-Ranges", "bytes")
+	}
+
+	status, offset, length := http.StatusOK, int64(0), st.Size
+	if rangeHeader := c.GetHeader("Range"); rangeHeader != "" && !st.SizeInexact && ifRangeMatches(c.GetHeader("If-Range"), lastModified) {
+		start, n, err := parseByteRange(rangeHeader, st.Size)
+		switch {
+		case errors.Is(err, errRangeUnsatisfiable):
+			header.Set("Content-Range", fmt.Sprintf("bytes */%d", st.Size))
+			c.Status(http.StatusRequestedRangeNotSatisfiable)
+			return
+		case err == nil:
+			status, offset, length = http.StatusPartialContent, start, n
+			header.Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, start+n-1, st.Size))
+		}
+		// 其他情况 (多段范围、语法错误) 按 RFC 9110 忽略 Range，返回完整文件
+	}
+
+	name := path.Base(remoteFilePath)
+	contentType := mime.TypeByExtension(path.Ext(name))
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	disposition := "attachment"
+	if c.Query("inline") == "1" {
+		disposition = "inline"
+	}
+	header.Set("Content-Type", contentType)
+	header.Set("Content-Disposition", mime.FormatMediaType(disposition, map[string]string{"filename": name}))
+	if !st.SizeInexact {
+		header.Set("Content-Length", strconv.FormatInt(length, 10))
+	}
+
+	// 响应头在第一次写入数据时才发送，传输开始前的错误 (例如没有读权限) 仍然可以返回 JSON 错误
+	w := &deferredWriter{c: c, status: status}
+	requestLength := int64(-1)
+	if status == http.StatusPartialContent {
+		requestLength = length
+	}
+	start := time.Now()
+	err = adb.StreamFile(ctx, runner, deviceId, remoteFilePath, offset, requestLength, w)
+	switch {
+	case err == nil:
+		w.start()
+		log.Printf("Streamed %d bytes of %s from device %s in %s", w.written, remoteFilePath, deviceId, time.Since(start).Round(time.Millisecond))
+	case !w.started:
+		for _, k := range []string{"Content-Length", "Content-Range", "Content-Disposition", "Content-Type"} {
+			header.Del(k)
+		}
+		log.Printf("Failed to stream file. Device: %s, Path: %s, Error: %v", deviceId, remoteFilePath, err)
+		abortWithError(c, err, gin.H{"error": "Failed to retrieve file from device", "path": remoteFilePath})
+	case ctx.Err() != nil:
+		log.Printf("Download of %s from device %s aborted by client after %d bytes", remoteFilePath, deviceId, w.written)
+	default:
+		// 响应头已发送，只能中断连接；大小不确定时响应是分块编码的，不中断连接客户端无法识别出失败
+		log.Printf("Streaming %s from device %s failed after %d bytes: %v", remoteFilePath, deviceId, w.written, err)
+		abortTransfer(c)
+	}
+}
+
+// deferredWriter 在第一次写入时才写出状态码与响应头
+type deferredWriter struct {
+	c       *gin.Context
+	status  int
+	started bool
+	written int64
+}
+
+func (w *deferredWriter) start() {
+	if !w.started {
+		w.started = true
+		w.c.Status(w.status)
+		w.c.Writer.WriteHeaderNow()
+	}
+}
+
+func (w *deferredWriter) Write(p []byte) (int, error) {
+	w.start()
+	n, err := w.c.Writer.Write(p)
+	w.written += int64(n)
+	return n, err
+}
+
+var (
+	errRangeUnsatisfiable = errors.New("range not satisfiable")
+	errRangeUnsupported   = errors.New("unsupported range")
+)
+
+// parseByteRange 解析单段 "bytes=a-b"、"bytes=a-" 或 "bytes=-n"，返回起始位置与长度
+// 多段范围返回 errRangeUnsupported，起始位置超出文件大小时返回 errRangeUnsatisfiable
+func parseByteRange(header string, size int64) (int64, int64, error) {
+	spec, ok := strings.CutPrefix(header, "bytes=")
+	if !ok || strings.Contains(spec, ",") {
+		return 0, 0, errRangeUnsupported
+	}
+	first, last, ok := strings.Cut(strings.TrimSpace(spec), "-")
+	if !ok {
+		return 0, 0, errRangeUnsupported
+	}
+	if first == "" {
+		// 后缀范围: 最后 n 个字节
+		n, err := strconv.ParseInt(last, 10, 64)
+		if err != nil || n < 0 {
+			return 0, 0, errRangeUnsupported
+		}
+		if n == 0 || size == 0 {
+			return 0, 0, errRangeUnsatisfiable
+		}
+		n = min(n, size)
+		return size - n, n, nil
+	}
+	start, err := strconv.ParseInt(first, 10, 64)
+	if err != nil || start < 0 {
+		return 0, 0, errRangeUnsupported
+	}
+	if start >= size {
+		return 0, 0, errRangeUnsatisfiable
+	}
+	end := size - 1
+	if last != "" {
+		end, err = strconv.ParseInt(last, 10, 64)
+		if err != nil || end < start {
+			return 0, 0, errRangeUnsupported
+		}
+		end = min(end, size-1)
+	}
+	return start, end - start + 1, nil
+}
+
+// ifRangeMatches 判断 If-Range 是否仍然有效；我们只提供 Last-Modified，不提供 ETag
+func ifRangeMatches(ifRange string, lastModified string) bool {
+	return ifRange == "" || ifRange == lastModified
+}
+
+// DownloadArchiveHandler 将设备目录打包为 zip 或 tar.gz 流式下载
+// 查询参数: path (必填)、format=zip|tar.gz (默认 zip)、include/exclude (glob，可重复或以逗号分隔)、
+// maxSize (字节，只能低于配置的上限)
+// 打包前先遍历目录并检查总大小，超过上限时返回 413 而不会开始传输
+func (h *Handler) DownloadArchiveHandler(c *gin.Context) {
+	deviceId := c.Param("deviceId")
+	remotePath := c.Query("path")
+	if len(remotePath) > 1 {
+		remotePath = strings.TrimSuffix(remotePath, "/")
+	}
+	if remotePath == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Directory path is required"})
+		return
+	}
+
+	opts := adb.ArchiveOptions{
+		Format:   adb.ArchiveFormat(c.DefaultQuery("format", string(adb.ArchiveZip))),
+		Include:  queryList(c, "include"),
+		Exclude:  queryList(c, "exclude"),
+		MaxBytes: h.opts.ArchiveMaxBytes,
+	}
+	if v := c.Query("maxSize"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || n <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "maxSize must be a positive number of bytes"})
+			return
+		}
+		opts.MaxBytes = min(n, opts.MaxBytes)
+	}
+
+	log.Printf("Request to archive directory. Device: %s, Path: %s, Format: %s, Include: %v, Exclude: %v", deviceId, remotePath, opts.Format, opts.Include, opts.Exclude)
+	ctx := c.Request.Context()
+	entries, total, err := adb.CollectArchive(ctx, h.adb, deviceId, remotePath, opts)
+	if err != nil {
+		log.Printf("Failed to collect files for archive. Device: %s, Path: %s, Error: %v", deviceId, remotePath, err)
+		abortWithError(c, err, gin.H{"error": "Failed to archive directory", "path": remotePath})
+		return
+	}
+
+	name := path.Base(remotePath)
+	if name == "/" {
+		name = "root"
+	}
+	contentType := "application/zip"
+	if opts.Format == adb.ArchiveTarGz {
+		contentType = "application/gzip"
+	}
+	c.Header("Content-Type", contentType)
+	c.Header("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": name + "." + string(opts.Format)}))
+	c.Status(http.StatusOK)
+
+	start := time.Now()
+	stats, err := adb.WriteArchive(ctx, h.adb, deviceId, remotePath, opts.Format, entries, c.Writer)
+	switch {
+	case err == nil:
+		log.Printf("Archived %d files (%d of %d bytes, %d skipped) from %s on device %s in %s",
+			stats.Files, stats.Bytes, total, len(stats.Skipped), remotePath, deviceId, time.Since(start).Round(time.Millisecond))
+	case ctx.Err() != nil:
+		log.Printf("Archive of %s from device %s aborted by client after %d files", remotePath, deviceId, stats.Files)
+	default:
+		// 响应已经开始，只能中断连接；否则客户端会收到一个以 200 正常结束但内容损坏的归档
+		log.Printf("Archiving %s from device %s failed after %d files: %v", remotePath, deviceId, stats.Files, err)
+		abortTransfer(c)
+	}
+}
+
+// queryList 返回可重复且可以逗号分隔的查询参数，例如 include=*.jpg&include=*.mp4 或 include=*.jpg,*.mp4
+func queryList(c *gin.Context, key string) []string {
+	var values []string
+	for _, v := range c.QueryArray(key) {
+		for _, item := range strings.Split(v, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				values = append(values, item)
+			}
+		}
+	}
+	return values
+}
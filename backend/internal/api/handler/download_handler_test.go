@@ -0,0 +1,85 @@
+package handler_test
+
+import (
+	"context"
+	"errors"
+	"fishyinhe/backend/internal/adb"
+	"fishyinhe/backend/internal/adb/adbtest"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// brokenPull 在写出 path 的前半部分后返回 ErrOffline，模拟传输途中设备断开
+// inexact 为 true 时 Stat 报告大小不精确，模拟只支持 STAT 的旧设备上的大文件
+type brokenPull struct {
+	*adbtest.Fake
+	path    string
+	inexact bool
+}
+
+func (r *brokenPull) Stat(ctx context.Context, serial string, remotePath string) (*adb.RemoteStat, error) {
+	st, err := r.Fake.Stat(ctx, serial, remotePath)
+	if err == nil && r.inexact {
+		st.SizeInexact = true
+	}
+	return st, err
+}
+
+func (r *brokenPull) Pull(ctx context.Context, serial string, remotePath string, w io.Writer) error {
+	if remotePath != r.path {
+		return r.Fake.Pull(ctx, serial, remotePath, w)
+	}
+	data, _ := r.Fake.ReadFile(serial, remotePath)
+	w.Write(data[:len(data)/2])
+	if f, ok := w.(http.Flusher); ok {
+		f.Flush()
+	}
+	return adb.ErrOffline
+}
+
+// getTruncated 通过真实的 HTTP 连接发出请求，返回状态码与读取响应体时的错误
+func getTruncated(t *testing.T, r adb.Runner, url string) (int, []byte, error) {
+	t.Helper()
+	srv := httptest.NewServer(newTestRouter(t, r))
+	defer srv.Close()
+	resp, err := http.Get(srv.URL + url)
+	if err != nil {
+		t.Fatalf("GET %s: %v", url, err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	return resp.StatusCode, body, err
+}
+
+func TestDownloadFileTruncatedTransfer(t *testing.T) {
+	f := newTestFake()
+	f.PutFile("emu-1", "/sdcard/big.bin", make([]byte, 64*1024))
+	for _, inexact := range []bool{false, true} {
+		r := &brokenPull{Fake: f, path: "/sdcard/big.bin", inexact: inexact}
+		status, body, err := getTruncated(t, r, "/api/files/download/emu-1?filePath=/sdcard/big.bin")
+		if status != http.StatusOK {
+			t.Fatalf("inexact=%v: status = %d, want 200", inexact, status)
+		}
+		if !errors.Is(err, io.ErrUnexpectedEOF) {
+			t.Errorf("inexact=%v: reading %d bytes of the body ended with %v, want io.ErrUnexpectedEOF", inexact, len(body), err)
+		}
+	}
+}
+
+func TestDownloadArchiveTruncatedTransfer(t *testing.T) {
+	f := newTestFake()
+	f.PutFile("emu-1", "/sdcard/DCIM/a.txt", []byte("first file"))
+	f.PutFile("emu-1", "/sdcard/DCIM/b.bin", make([]byte, 64*1024))
+	for _, format := range []string{"zip", "tar.gz"} {
+		r := &brokenPull{Fake: f, path: "/sdcard/DCIM/b.bin"}
+		status, body, err := getTruncated(t, r, "/api/files/archive/emu-1?path=/sdcard/DCIM&format="+format)
+		if status != http.StatusOK {
+			t.Fatalf("%s: status = %d, want 200", format, status)
+		}
+		if !errors.Is(err, io.ErrUnexpectedEOF) {
+			t.Errorf("%s: reading %d bytes of the archive ended with %v, want io.ErrUnexpectedEOF", format, len(body), err)
+		}
+	}
+}
@@ -0,0 +1,39 @@
+package handler
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// abortWithError 记录 adb 操作返回的错误并中止请求，
+// 实际的状态码与响应体由 middleware.ErrorEnvelope 根据错误类型生成
+// body 至少应包含面向用户的 "error" 文本，其中的字段会原样合并到响应中
+func abortWithError(c *gin.Context, err error, body gin.H) {
+	c.Error(err).SetMeta(body)
+	c.Abort()
+}
+
+// abortTransfer 在响应头与部分数据已经发出后中断传输
+// c.Abort 只是不再执行后续处理函数，net/http 仍会写出分块编码的结束块，客户端会把截断的数据当作完整的响应；
+// 这里接管并直接关闭底层连接，让客户端得到传输被截断的错误
+// gin 的 Recovery 会吞掉 http.ErrAbortHandler，不能用 panic 代替
+func abortTransfer(c *gin.Context) {
+	c.Abort()
+	var w http.ResponseWriter = c.Writer
+	// gin 的 Hijack 不检查底层 ResponseWriter 是否支持接管，需要先解开
+	if u, ok := w.(interface{ Unwrap() http.ResponseWriter }); ok {
+		w = u.Unwrap()
+	}
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		return
+	}
+	// 先发出响应头与缓冲中的数据，接管连接会丢弃尚未发出的缓冲
+	c.Writer.Flush()
+	conn, _, err := hijacker.Hijack()
+	if err != nil {
+		return
+	}
+	conn.Close()
+}
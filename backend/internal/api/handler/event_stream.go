@@ -0,0 +1,59 @@
+package handler
+
+import (
+	"encoding/json"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"strings"
+)
+
+// eventStream 以 NDJSON 或 SSE 格式逐条写出搜索结果、同步进度等事件，响应头在第一次写入时才发送，
+// 因此开始前的错误仍然可以返回普通的 JSON 错误响应
+type eventStream struct {
+	c       *gin.Context
+	sse     bool
+	started bool
+}
+
+// newEventStream 在 format=sse 或 Accept 包含 text/event-stream 时使用 SSE，否则使用 NDJSON
+func newEventStream(c *gin.Context) *eventStream {
+	return &eventStream{
+		c:   c,
+		sse: c.Query("format") == "sse" || strings.Contains(c.GetHeader("Accept"), "text/event-stream"),
+	}
+}
+
+func (s *eventStream) start() {
+	if s.started {
+		return
+	}
+	s.started = true
+	header := s.c.Writer.Header()
+	if s.sse {
+		header.Set("Content-Type", "text/event-stream")
+	} else {
+		header.Set("Content-Type", "application/x-ndjson")
+	}
+	header.Set("Cache-Control", "no-cache")
+	header.Set("X-Accel-Buffering", "no") // 禁止反向代理缓冲结果
+	s.c.Status(http.StatusOK)
+	s.c.Writer.WriteHeaderNow()
+}
+
+// send 写出一条记录；NDJSON 中每行是一个对象，event 只用于 SSE 的事件名
+func (s *eventStream) send(event string, v any) error {
+	s.start()
+	if s.sse {
+		s.c.SSEvent(event, v)
+	} else {
+		data, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		if _, err := s.c.Writer.Write(append(data, '\n')); err != nil {
+			return err
+		}
+	}
+	s.c.Writer.Flush()
+	return s.c.Request.Context().Err()
+}
@@ -4,25 +4,31 @@ package handler
 import (
 	"fishyinhe/backend/internal/adb" // 导入 adb 包
 	"github.com/gin-gonic/gin"
-	"io"
 	"log"
 	"net/http"
-	"net/url"
-	"os"
-	"os/exec"
-	"path/filepath"
+	"strconv"
 	"strings"
+	"time"
 )
 
 // ListFilesHandler 处理列出设备文件的请求
-func ListFilesHandler(c *gin.Context) {
+func (h *Handler) ListFilesHandler(c *gin.Context) {
 	deviceId := c.Param("deviceId")
 	remotePath := c.Query("path") // 从查询参数获取路径，例如 /api/devices/emulator-5554/files?path=/sdcard/
+	// 可选参数: sort=name|size|mtime, order=asc|desc, offset, limit (用于 DCIM 等大目录分页)
+	// package=<包名> 时以该应用的身份列出其私有目录，path 省略时为 /data/data/<包名>/
 
 	if deviceId == "" {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Device ID is required"})
 		return
 	}
+	runner, ok := h.fileRunner(c)
+	if !ok {
+		return
+	}
+	if remotePath == "" && c.Query("package") != "" {
+		remotePath = "/data/data/" + c.Query("package") + "/"
+	}
 	if remotePath == "" {
 		remotePath = "/sdcard/" // 默认路径，安卓的 SD 卡通常挂载点
 	}
@@ -36,96 +42,77 @@ func ListFilesHandler(c *gin.Context) {
 
 	log.Printf("Listing files for device: %s, path: %s", deviceId, remotePath)
 
-	files, err := adb.ListFiles(deviceId, remotePath)
+	files, err := adb.ListFiles(c.Request.Context(), runner, deviceId, remotePath)
 	if err != nil {
-		// 检查错误是否表示路径不存在
-		// stderr 的具体内容可能因 ADB 版本和设备而异
-		errMsg := "Failed to list files"
-		if exitErr, ok := err.(*exec.ExitError); ok { // exec.ExitError 没有直接导出，所以类型断言可能失败
-			stderrStr := string(exitErr.Stderr)
-			if strings.Contains(stderrStr, "No such file or directory") || strings.Contains(stderrStr, "Not a directory") {
-				errMsg = "Path not found or is not a directory"
-				c.JSON(http.StatusNotFound, gin.H{"error": errMsg, "path": remotePath})
-				return
-			}
-		}
 		log.Printf("Error in adb.ListFiles for device %s, path %s: %v", deviceId, remotePath, err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": errMsg, "details": err.Error()})
+		abortWithError(c, err, gin.H{"error": "Failed to list files", "path": remotePath})
+		return
+	}
+
+	if err := adb.SortFiles(files, c.Query("sort"), c.Query("order") == "desc"); err != nil {
+		abortWithError(c, err, gin.H{"error": "Invalid sort parameter"})
 		return
 	}
 
+	// 分页: offset 从 0 开始，limit 为 0 或省略时返回 offset 之后的全部条目
+	total := len(files)
+	offset, limit := queryInt(c, "offset"), queryInt(c, "limit")
+	if offset < 0 || limit < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "offset and limit must be non-negative integers"})
+		return
+	}
+	if offset > total {
+		offset = total
+	}
+	files = files[offset:]
+	if limit > 0 && limit < len(files) {
+		files = files[:limit]
+	}
+
 	if files == nil {
 		files = []adb.AdbFileItem{} // 确保返回空数组而不是 null
 	}
 
 	c.JSON(http.StatusOK, gin.H{
-		"path":  remotePath,
-		"files": files,
+		"path":   remotePath,
+		"files":  files,
+		"total":  total,
+		"offset": offset,
+		"limit":  limit,
 	})
 }
 
-// DownloadFileHandler 处理下载设备文件的请求
-func DownloadFileHandler(c *gin.Context) {
-	deviceId := c.Param("deviceId")
-	// remoteFilePath 通常包含特殊字符，需要进行 URL 解码
-	remoteFilePathQuery := c.Query("filePath") // 例如 /sdcard/Download/my file.txt
-
-	if deviceId == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Device ID is required"})
-		return
-	}
-	if remoteFilePathQuery == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "File path is required"})
-		return
+// fileRunner 返回文件接口访问设备使用的 Runner: 查询参数 package 非空时以该应用的身份 (run-as) 访问，
+// 用于读写可调试应用的私有目录；应用未安装或不可调试时写入错误响应并返回 false
+func (h *Handler) fileRunner(c *gin.Context) (adb.Runner, bool) {
+	pkg := c.Query("package")
+	if pkg == "" {
+		return h.adb, true
 	}
-
-	// 对查询参数进行 URL 解码
-	remoteFilePath, err := url.QueryUnescape(remoteFilePathQuery)
+	runner, err := adb.RunAs(c.Request.Context(), h.adb, c.Param("deviceId"), pkg)
 	if err != nil {
-		log.Printf("Error unescaping file path '%s': %v", remoteFilePathQuery, err)
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid file path encoding"})
-		return
+		abortWithError(c, err, gin.H{"error": "Cannot access the private files of " + pkg, "package": pkg})
+		return nil, false
 	}
+	return runner, true
+}
 
-	log.Printf("Request to download file. Device: %s, Remote Path: %s", deviceId, remoteFilePath)
-
-	// 定义服务器上的临时存储目录，你可以根据你的服务器环境修改
-	// 确保这个目录是可写的
-	tempDir := filepath.Join(os.TempDir(), "adb_pulled_files") // 例如 C:\Users\YourUser\AppData\Local\Temp\adb_pulled_files
-
-	localFilePath, err := adb.PullFile(deviceId, remoteFilePath, tempDir)
+// queryInt 读取整数查询参数，省略时返回 0，无法解析时返回 -1
+func queryInt(c *gin.Context, key string) int {
+	v := c.Query(key)
+	if v == "" {
+		return 0
+	}
+	n, err := strconv.Atoi(v)
 	if err != nil {
-		log.Printf("Failed to pull file for download. Device: %s, Path: %s, Error: %v", deviceId, remoteFilePath, err)
-		// 这里可以根据 adb.PullFile 返回的错误类型来决定 HTTP 状态码
-		if strings.Contains(err.Error(), "does not exist") || strings.Contains(err.Error(), "No such file or directory") {
-			c.JSON(http.StatusNotFound, gin.H{"error": "File not found on device", "path": remoteFilePath})
-		} else {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve file from device"})
-		}
-		return
+		return -1
 	}
-
-	// 确保文件在函数结束时被删除
-	defer func() {
-		log.Printf("Attempting to remove temporary file: %s", localFilePath)
-		err := os.Remove(localFilePath)
-		if err != nil {
-			log.Printf("Failed to remove temporary file %s: %v", localFilePath, err)
-		} else {
-			log.Printf("Successfully removed temporary file: %s", localFilePath)
-		}
-	}()
-
-	// 让浏览器下载文件，而不是直接显示
-	// 第一个参数是本地文件路径，第二个参数是希望在浏览器中显示的文件名
-	originalFileName := filepath.Base(remoteFilePath)
-	c.FileAttachment(localFilePath, originalFileName)
-	// c.File(localFilePath) // 如果只是想让浏览器尝试打开（例如图片），而不是强制下载
-
-	log.Printf("Sent file %s (original: %s) to client for download.", localFilePath, originalFileName)
+	return n
 }
 
-func UploadFileHandler(c *gin.Context) {
+// UploadFileHandler 将表单中的文件推送到设备上的 remoteDirPath 目录
+// 查询参数 package=<包名> 时以该应用的身份写入其私有目录
+func (h *Handler) UploadFileHandler(c *gin.Context) {
 	deviceId := c.Param("deviceId")
 	if deviceId == "" {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Device ID is required"})
@@ -144,6 +131,11 @@ func UploadFileHandler(c *gin.Context) {
 		remoteDirPath += "/"
 	}
 
+	runner, ok := h.fileRunner(c)
+	if !ok {
+		return
+	}
+
 	// 从表单数据中获取上传的文件
 	file, header, err := c.Request.FormFile("file") // "file" 是前端 <input type="file" name="file"> 的 name
 	if err != nil {
@@ -157,54 +149,14 @@ func UploadFileHandler(c *gin.Context) {
 	log.Printf("Received file upload request. Device: %s, Target Dir: %s, Filename: %s, Size: %d",
 		deviceId, remoteDirPath, originalFilename, header.Size)
 
-	// 1. 将上传的文件保存到服务器的临时位置
-	//    确保这个临时目录是可写的，并且会定期清理 (或在此处确保删除)
-	tempServerDir := filepath.Join(os.TempDir(), "adb_uploads_temp")
-	if err := os.MkdirAll(tempServerDir, 0755); err != nil {
-		log.Printf("Failed to create server temp directory %s: %v", tempServerDir, err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Server error: could not create temp directory"})
-		return
-	}
-	localTempFilePath := filepath.Join(tempServerDir, originalFilename)
-
-	// 创建临时文件用于写入
-	tempFile, err := os.Create(localTempFilePath)
-	if err != nil {
-		log.Printf("Failed to create temp file %s on server: %v", localTempFilePath, err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Server error: could not create temp file"})
-		return
-	}
-	// 将上传文件的内容复制到临时文件
-	_, err = io.Copy(tempFile, file) // io 包需要导入: "io"
-	tempFile.Close()                 // 确保关闭文件，即使复制失败
-	if err != nil {
-		log.Printf("Failed to copy uploaded file content to %s: %v", localTempFilePath, err)
-		os.Remove(localTempFilePath) // 尝试删除部分写入的文件
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Server error: could not save uploaded file"})
-		return
-	}
-
-	// 函数结束时删除服务器上的临时文件
-	defer func() {
-		log.Printf("Attempting to remove server temporary file: %s", localTempFilePath)
-		errRemove := os.Remove(localTempFilePath)
-		if errRemove != nil {
-			log.Printf("Failed to remove server temporary file %s: %v", localTempFilePath, errRemove)
-		} else {
-			log.Printf("Successfully removed server temporary file: %s", localTempFilePath)
-		}
-	}()
-
-	// 2. 将服务器上的临时文件推送到设备的指定远程路径
-	//    远程路径是目录 +原始文件名
-	fullRemoteDevicePath := remoteDirPath + originalFilename // 假设 remoteDirPath 以 '/' 结尾
+	// 直接将上传的文件内容通过 sync SEND 推送到设备，不再以原始文件名保存到共享的临时目录，
+	// 避免同名文件的并发上传互相覆盖；大文件请使用 /files/uploads 分块上传
+	fullRemoteDevicePath := remoteDirPath + originalFilename // remoteDirPath 已以 '/' 结尾
 
-	err = adb.PushFile(deviceId, localTempFilePath, fullRemoteDevicePath)
+	err = adb.PushStream(c.Request.Context(), runner, deviceId, file, fullRemoteDevicePath, 0644, time.Now())
 	if err != nil {
-		log.Printf("Failed to push file to device. Device: %s, Local: %s, Remote: %s, Error: %v",
-			deviceId, localTempFilePath, fullRemoteDevicePath, err)
-		// 具体的错误信息已在 adb.PushFile 中记录，这里可以返回一个通用错误
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to push file to device", "details": err.Error()})
+		log.Printf("Failed to push file to device. Device: %s, Remote: %s, Error: %v", deviceId, fullRemoteDevicePath, err)
+		abortWithError(c, err, gin.H{"error": "Failed to push file to device"})
 		return
 	}
 
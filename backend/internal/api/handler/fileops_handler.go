@@ -0,0 +1,246 @@
+package handler
+
+import (
+	"context"
+	"fishyinhe/backend/internal/adb"
+	"fishyinhe/backend/internal/api/middleware"
+	"github.com/gin-gonic/gin"
+	"log"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+)
+
+// maxPathsPerRequest 是一次文件操作请求中的目标数上限
+const maxPathsPerRequest = 1000
+
+// PathsRequest 是 mkdir、touch 等只需要目标路径的请求体
+type PathsRequest struct {
+	Paths []string `json:"paths" binding:"required"`
+}
+
+// RemoveRequest 是删除请求体
+// recursive 为 true 时需要确认: 第一次请求返回 428 与 confirmToken，带上该令牌再次请求才会执行删除
+type RemoveRequest struct {
+	Paths        []string `json:"paths" binding:"required"`
+	Recursive    bool     `json:"recursive"`
+	ConfirmToken string   `json:"confirmToken,omitempty"`
+}
+
+// TransferRequest 是移动与复制的请求体
+// 有多个来源或 destination 以 / 结尾时，destination 是目标目录，每个来源保留原来的名称；
+// 否则 destination 是唯一来源的新路径 (重命名)
+type TransferRequest struct {
+	Sources     []string `json:"sources" binding:"required"`
+	Destination string   `json:"destination" binding:"required"`
+	Overwrite   bool     `json:"overwrite"`
+}
+
+// ChmodRequest 是修改权限的请求体，mode 为八进制 (0644) 或符号形式 (u+x)
+type ChmodRequest struct {
+	Paths     []string `json:"paths" binding:"required"`
+	Mode      string   `json:"mode" binding:"required"`
+	Recursive bool     `json:"recursive"`
+}
+
+// TouchRequest 是 touch 的请求体，mtime 省略时使用设备的当前时间
+type TouchRequest struct {
+	Paths []string  `json:"paths" binding:"required"`
+	MTime time.Time `json:"mtime"`
+}
+
+// PathResult 是对单个目标的操作结果
+type PathResult struct {
+	Path   string `json:"path"`
+	Target string `json:"target,omitempty"` // 移动与复制的目标路径
+	OK     bool   `json:"ok"`
+	Error  string `json:"error,omitempty"`
+	Code   string `json:"code,omitempty"` // 与错误响应中的 code 相同
+}
+
+// checkPaths 检查目标数量，不合法时返回 400 并返回 false
+func checkPaths(c *gin.Context, paths []string) bool {
+	if len(paths) == 0 || len(paths) > maxPathsPerRequest {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Between 1 and 1000 paths are required"})
+		return false
+	}
+	return true
+}
+
+// runPathOps 依次对每个目标执行 op 并返回结果，某个目标失败不影响其余目标
+// 全部成功时返回 200；只有一个目标且失败时返回该错误对应的错误响应；部分失败时返回 207
+func runPathOps(c *gin.Context, action string, paths []string, target func(string) string, op func(ctx context.Context, p string, dst string) error) {
+	deviceId := c.Param("deviceId")
+	ctx := c.Request.Context()
+	results := make([]PathResult, len(paths))
+	var lastErr error
+	failed := 0
+	for i, p := range paths {
+		res := PathResult{Path: p}
+		if target != nil {
+			res.Target = target(p)
+		}
+		if err := op(ctx, p, res.Target); err != nil {
+			log.Printf("Failed to %s %s on device %s: %v", action, p, deviceId, err)
+			_, res.Code = middleware.ClassifyError(err)
+			res.Error = err.Error()
+			lastErr = err
+			failed++
+		} else {
+			res.OK = true
+		}
+		results[i] = res
+	}
+	log.Printf("%s on device %s: %d succeeded, %d failed", action, deviceId, len(paths)-failed, failed)
+
+	body := gin.H{"results": results, "succeeded": len(paths) - failed, "failed": failed}
+	switch {
+	case failed == 0:
+		c.JSON(http.StatusOK, body)
+	case len(paths) == 1:
+		body["error"] = "Failed to " + action + " " + paths[0]
+		body["path"] = paths[0]
+		abortWithError(c, lastErr, body)
+	default:
+		c.JSON(http.StatusMultiStatus, body)
+	}
+}
+
+// MakeDirHandler 创建目录 (包括不存在的父目录)
+func (h *Handler) MakeDirHandler(c *gin.Context) {
+	var req PathsRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body: " + err.Error()})
+		return
+	}
+	if !checkPaths(c, req.Paths) {
+		return
+	}
+	runPathOps(c, "mkdir", req.Paths, nil, func(ctx context.Context, p string, _ string) error {
+		return adb.MakeDir(ctx, h.adb, c.Param("deviceId"), p)
+	})
+}
+
+// RemoveHandler 删除文件或目录；递归删除需要先获取确认令牌
+// 没有 (或令牌无效、已过期) 时返回 428，响应中包含新的 confirmToken 以及每个目标的类型与大小，供界面提示用户
+// 查询参数 package=<包名> 时以该应用的身份删除其私有目录中的文件
+func (h *Handler) RemoveHandler(c *gin.Context) {
+	deviceId := c.Param("deviceId")
+	var req RemoveRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body: " + err.Error()})
+		return
+	}
+	if !checkPaths(c, req.Paths) {
+		return
+	}
+	runner, ok := h.fileRunner(c)
+	if !ok {
+		return
+	}
+	if req.Recursive {
+		// 令牌绑定设备与 package，为共享存储签发的令牌不能用于删除应用私有目录 (反之亦然)
+		subject := deviceId + "\x00rm -r\x00" + c.Query("package")
+		if !h.confirm.verify(req.ConfirmToken, subject, req.Paths) {
+			token, expires := h.confirm.issue(subject, req.Paths)
+			c.JSON(http.StatusPreconditionRequired, gin.H{
+				"error":        "Recursive delete must be confirmed; repeat the request with confirmToken",
+				"code":         "confirmation_required",
+				"confirmToken": token,
+				"expiresAt":    expires,
+				"targets":      describeTargets(c.Request.Context(), runner, deviceId, req.Paths),
+			})
+			return
+		}
+	}
+	runPathOps(c, "delete", req.Paths, nil, func(ctx context.Context, p string, _ string) error {
+		return adb.RemovePath(ctx, runner, deviceId, p, req.Recursive)
+	})
+}
+
+// describeTargets 返回删除确认时展示的目标信息，无法获取时只返回路径
+func describeTargets(ctx context.Context, r adb.Runner, deviceId string, paths []string) []gin.H {
+	targets := make([]gin.H, len(paths))
+	for i, p := range paths {
+		t := gin.H{"path": p, "exists": false}
+		if st, err := adb.StatFile(ctx, r, deviceId, p); err == nil {
+			t["exists"] = true
+			t["isDir"] = st.Mode.IsDir()
+			t["size"] = st.Size
+		}
+		targets[i] = t
+	}
+	return targets
+}
+
+// transferTarget 根据 TransferRequest 的规则计算来源 src 的目标路径
+func transferTarget(req TransferRequest) func(string) string {
+	intoDir := len(req.Sources) > 1 || strings.HasSuffix(req.Destination, "/")
+	return func(src string) string {
+		if intoDir {
+			return path.Join(req.Destination, path.Base(src))
+		}
+		return req.Destination
+	}
+}
+
+// MoveHandler 移动或重命名文件与目录
+func (h *Handler) MoveHandler(c *gin.Context) {
+	var req TransferRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body: " + err.Error()})
+		return
+	}
+	if !checkPaths(c, req.Sources) {
+		return
+	}
+	runPathOps(c, "move", req.Sources, transferTarget(req), func(ctx context.Context, src string, dst string) error {
+		return adb.MovePath(ctx, h.adb, c.Param("deviceId"), src, dst, req.Overwrite)
+	})
+}
+
+// CopyHandler 复制文件与目录 (目录递归复制)
+func (h *Handler) CopyHandler(c *gin.Context) {
+	var req TransferRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body: " + err.Error()})
+		return
+	}
+	if !checkPaths(c, req.Sources) {
+		return
+	}
+	runPathOps(c, "copy", req.Sources, transferTarget(req), func(ctx context.Context, src string, dst string) error {
+		return adb.CopyPath(ctx, h.adb, c.Param("deviceId"), src, dst, req.Overwrite)
+	})
+}
+
+// ChmodHandler 修改文件与目录的权限
+func (h *Handler) ChmodHandler(c *gin.Context) {
+	var req ChmodRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body: " + err.Error()})
+		return
+	}
+	if !checkPaths(c, req.Paths) {
+		return
+	}
+	runPathOps(c, "chmod", req.Paths, nil, func(ctx context.Context, p string, _ string) error {
+		return adb.ChmodPath(ctx, h.adb, c.Param("deviceId"), p, req.Mode, req.Recursive)
+	})
+}
+
+// TouchHandler 更新修改时间，文件不存在时创建空文件
+func (h *Handler) TouchHandler(c *gin.Context) {
+	var req TouchRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body: " + err.Error()})
+		return
+	}
+	if !checkPaths(c, req.Paths) {
+		return
+	}
+	runPathOps(c, "touch", req.Paths, nil, func(ctx context.Context, p string, _ string) error {
+		return adb.TouchPath(ctx, h.adb, c.Param("deviceId"), p, req.MTime)
+	})
+}
@@ -0,0 +1,84 @@
+package handler
+
+import (
+	"fishyinhe/backend/internal/adb"
+	"fishyinhe/backend/internal/apkrepo"
+	"fishyinhe/backend/internal/thumbnail"
+	"fishyinhe/backend/internal/upload"
+	"os"
+	"path/filepath"
+)
+
+// Handler 持有 HTTP 处理函数共享的依赖
+type Handler struct {
+	adb        adb.Runner
+	tracker    *adb.Tracker
+	wireless   *adb.WirelessRegistry
+	uploads    *upload.Store
+	thumbnails *thumbnail.Cache
+	apks       *apkrepo.Repo
+	confirm    *confirmer
+	opts       Options
+}
+
+// Options 是处理函数的可配置限制与依赖，零值字段使用默认值
+type Options struct {
+	// ArchiveMaxBytes 是目录打包下载的总大小上限，请求中的 maxSize 只能进一步降低它
+	ArchiveMaxBytes int64
+	// TextMaxBytes 是在线预览与编辑的文本文件大小上限
+	TextMaxBytes int64
+	// Uploads 保存分块上传的会话，为 nil 时使用系统临时目录下的 Store (不恢复之前的会话)
+	Uploads *upload.Store
+	// Thumbnails 缓存生成的缩略图，为 nil 时使用系统临时目录下的缓存
+	Thumbnails *thumbnail.Cache
+	// APKLibrary 保存上传过的 APK，为 nil 时使用系统临时目录下的库 (不读取之前保存的 APK，也不删除旧版本)
+	APKLibrary *apkrepo.Repo
+	// SyncRoot 是目录同步时服务器一侧允许使用的根目录，为空时使用系统临时目录下的 adb_sync
+	SyncRoot string
+}
+
+// DefaultArchiveMaxBytes 是未配置时目录打包下载的大小上限 (4 GiB)
+const DefaultArchiveMaxBytes = 4 << 30
+
+// DefaultThumbnailCacheBytes 是未配置时缩略图缓存的大小上限 (256 MiB)
+const DefaultThumbnailCacheBytes = 256 << 20
+
+// DefaultTextMaxBytes 是未配置时可以在线编辑的文本文件大小上限 (2 MiB)
+const DefaultTextMaxBytes = 2 << 20
+
+// New 创建使用指定 adb.Runner 访问设备的 Handler
+// tracker 可以为 nil，此时设备列表直接通过 runner 查询，且设备事件流不可用
+// wireless 可以为 nil，此时无线连接仍然可用，但不会被记住
+func New(runner adb.Runner, tracker *adb.Tracker, wireless *adb.WirelessRegistry, opts Options) *Handler {
+	if opts.ArchiveMaxBytes <= 0 {
+		opts.ArchiveMaxBytes = DefaultArchiveMaxBytes
+	}
+	if opts.TextMaxBytes <= 0 {
+		opts.TextMaxBytes = DefaultTextMaxBytes
+	}
+	uploads := opts.Uploads
+	if uploads == nil {
+		uploads = upload.NewStore(filepath.Join(os.TempDir(), "adb_uploads"))
+	}
+	if opts.SyncRoot == "" {
+		opts.SyncRoot = filepath.Join(os.TempDir(), "adb_sync")
+	}
+	thumbnails := opts.Thumbnails
+	if thumbnails == nil {
+		thumbnails = thumbnail.NewCache(filepath.Join(os.TempDir(), "adb_thumbnails"), DefaultThumbnailCacheBytes)
+	}
+	apks := opts.APKLibrary
+	if apks == nil {
+		apks = apkrepo.New(filepath.Join(os.TempDir(), "adb_apks"), apkrepo.Retention{})
+	}
+	return &Handler{
+		adb:        runner,
+		tracker:    tracker,
+		wireless:   wireless,
+		uploads:    uploads,
+		thumbnails: thumbnails,
+		apks:       apks,
+		confirm:    newConfirmer(),
+		opts:       opts,
+	}
+}
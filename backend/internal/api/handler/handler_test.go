@@ -0,0 +1,308 @@
+package handler_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fishyinhe/backend/internal/adb"
+	"fishyinhe/backend/internal/adb/adbtest"
+	"fishyinhe/backend/internal/api"
+	"fishyinhe/backend/internal/api/handler"
+	"fishyinhe/backend/internal/apkrepo"
+	"fishyinhe/backend/internal/thumbnail"
+	"fishyinhe/backend/internal/upload"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+)
+
+func init() {
+	gin.SetMode(gin.TestMode)
+}
+
+// newTestRouter 返回连接到 r (通常是 Fake) 的路由，上传、缩略图、APK 库与同步目录都放在测试的临时目录中
+func newTestRouter(t *testing.T, r adb.Runner) *gin.Engine {
+	t.Helper()
+	dir := t.TempDir()
+	return api.SetupRouter(r, nil, nil, handler.Options{
+		Uploads:    upload.NewStore(filepath.Join(dir, "uploads")),
+		Thumbnails: thumbnail.NewCache(filepath.Join(dir, "thumbnails"), handler.DefaultThumbnailCacheBytes),
+		APKLibrary: apkrepo.New(filepath.Join(dir, "apks"), apkrepo.Retention{}),
+		SyncRoot:   filepath.Join(dir, "sync"),
+	})
+}
+
+// newTestFake 返回挂载了预置设备 emu-1、未授权设备 emu-unauthorized 与离线设备 emu-offline 的 Fake
+func newTestFake() *adbtest.Fake {
+	f := adbtest.NewFake()
+	f.AddCannedDevice("emu-1")
+	f.AddDevice("emu-unauthorized", "unauthorized")
+	f.AddDevice("emu-offline", "offline")
+	return f
+}
+
+func serve(r http.Handler, method string, url string, body string) *httptest.ResponseRecorder {
+	var reader io.Reader
+	if body != "" {
+		reader = strings.NewReader(body)
+	}
+	req := httptest.NewRequest(method, url, reader)
+	if body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func TestRoutes(t *testing.T) {
+	tests := []struct {
+		name   string
+		method string
+		url    string
+		body   string
+		setup  func(f *adbtest.Fake)
+		status int
+		// code 是错误响应中的 code 字段，为空时要求响应中没有 code
+		code string
+		// contains 是响应体中应包含的文本
+		contains string
+	}{
+		{name: "health", method: "GET", url: "/api/health", status: 200, contains: `"status":"ok"`},
+		{name: "devices", method: "GET", url: "/api/devices", status: 200, contains: `"model":"Pixel_7"`},
+		{name: "device properties", method: "GET", url: "/api/devices/emu-1/properties", status: 200, contains: `"ro.build.version.sdk":"34"`},
+		{name: "list files", method: "GET", url: "/api/files/list/emu-1?path=/sdcard", status: 200, contains: `"name":"notes.txt"`},
+		{name: "list files on missing device", method: "GET", url: "/api/files/list/missing?path=/sdcard", status: 404, code: "device_not_found"},
+		{name: "list files on unauthorized device", method: "GET", url: "/api/files/list/emu-unauthorized?path=/sdcard", status: 403, code: "device_unauthorized"},
+		{name: "list files on offline device", method: "GET", url: "/api/files/list/emu-offline?path=/sdcard", status: 503, code: "device_offline"},
+		{name: "list files with parent segments", method: "GET", url: "/api/files/list/emu-1?path=/sdcard/../data", status: 400, code: "invalid_argument"},
+		{name: "list files with control characters", method: "GET", url: "/api/files/list/emu-1?path=/sdcard/a%0Ab", status: 400, code: "invalid_argument"},
+		{
+			name: "list missing directory", method: "GET", url: "/api/files/list/emu-1?path=/sdcard/nope",
+			setup: func(f *adbtest.Fake) {
+				f.HandleShell("emu-1", "ls -la --full-time /sdcard/nope/", adbtest.Response{Stderr: "ls: /sdcard/nope/: No such file or directory\n", ExitCode: 1})
+			},
+			status: 404, code: "path_not_found",
+		},
+		{
+			name: "list protected directory", method: "GET", url: "/api/files/list/emu-1?path=/data",
+			setup: func(f *adbtest.Fake) {
+				f.HandleShell("emu-1", "ls -la --full-time /data/", adbtest.Response{Stderr: "ls: /data/: Permission denied\n", ExitCode: 1})
+			},
+			status: 403, code: "permission_denied",
+		},
+		{
+			name: "list private directory of a release build", method: "GET", url: "/api/files/list/emu-1?package=com.example.app",
+			setup: func(f *adbtest.Fake) {
+				f.HandleShell("emu-1", "run-as com.example.app true", adbtest.Response{Stderr: "run-as: package not debuggable: com.example.app\n", ExitCode: 1})
+			},
+			status: 403, code: "not_debuggable",
+		},
+		{name: "download file", method: "GET", url: "/api/files/download/emu-1?filePath=/sdcard/notes.txt", status: 200, contains: "hello from device"},
+		{name: "download missing file", method: "GET", url: "/api/files/download/emu-1?filePath=/sdcard/nope.txt", status: 404, code: "path_not_found"},
+		{name: "list third-party apps", method: "GET", url: "/api/apps/list/emu-1?filter=third_party", status: 200, contains: `["com.example.app"]`},
+		{name: "force stop app", method: "POST", url: "/api/apps/stop/emu-1", body: `{"packageName":"com.example.app"}`, status: 200},
+		{name: "force stop hostile package name", method: "POST", url: "/api/apps/stop/emu-1", body: `{"packageName":"com.example;reboot"}`, status: 400, code: "invalid_argument"},
+		{name: "uninstall hostile package name", method: "POST", url: "/api/apps/uninstall/emu-1", body: `{"packageName":"$(reboot).app"}`, status: 400, code: "invalid_argument"},
+		{name: "go home", method: "POST", url: "/api/devices/emu-1/gohome", status: 200, contains: "Home command sent"},
+		{name: "go home on missing device", method: "POST", url: "/api/devices/missing/gohome", status: 404, code: "device_not_found"},
+		{name: "download logcat", method: "GET", url: "/api/logcat/download/emu-1", status: 200, contains: "FATAL EXCEPTION"},
+		{name: "library entry not found", method: "GET", url: "/api/apk/library/com.example.app/latest", status: 404, code: "apk_not_found"},
+		{name: "chmod storage root recursively", method: "POST", url: "/api/files/chmod/emu-1", body: `{"paths":["/storage/emulated/0"],"mode":"000","recursive":true}`, status: 400, code: "invalid_argument"},
+		{name: "chmod top-level directory", method: "POST", url: "/api/files/chmod/emu-1", body: `{"paths":["/sdcard"],"mode":"777"}`, status: 400, code: "invalid_argument"},
+		{name: "chmod file", method: "POST", url: "/api/files/chmod/emu-1", body: `{"paths":["/sdcard/notes.txt"],"mode":"600"}`, status: 200, contains: `"succeeded":1`},
+		{name: "copy over a protected directory", method: "POST", url: "/api/files/cp/emu-1", body: `{"sources":["/sdcard/notes.txt"],"destination":"/data/local/tmp","overwrite":true}`, status: 400, code: "invalid_argument"},
+		{name: "copy into a top-level directory", method: "POST", url: "/api/files/cp/emu-1", body: `{"sources":["/sdcard/notes.txt"],"destination":"/system"}`, status: 400, code: "invalid_argument"},
+		{name: "copy file", method: "POST", url: "/api/files/cp/emu-1", body: `{"sources":["/sdcard/notes.txt"],"destination":"/sdcard/notes-copy.txt"}`, status: 200, contains: `"succeeded":1`},
+		{name: "pair with a short code", method: "POST", url: "/api/devices/pair", body: `{"address":"192.168.1.23:37123","code":"1234"}`, status: 400, code: "invalid_argument"},
+		{name: "pair with a non-numeric code", method: "POST", url: "/api/devices/pair", body: `{"address":"192.168.1.23:37123","code":"12a456"}`, status: 400, code: "invalid_argument"},
+		{name: "pair without a port", method: "POST", url: "/api/devices/pair", body: `{"address":"192.168.1.23","code":"123456"}`, status: 400, code: "invalid_argument"},
+		{name: "pair with a port out of range", method: "POST", url: "/api/devices/pair", body: `{"address":"192.168.1.23:70000","code":"123456"}`, status: 400, code: "invalid_argument"},
+		{name: "tcpip with a port out of range", method: "POST", url: "/api/devices/emu-1/tcpip", body: `{"port":65536}`, status: 400, code: "invalid_argument"},
+		{name: "tcpip with a negative port", method: "POST", url: "/api/devices/emu-1/tcpip", body: `{"port":-1}`, status: 400, code: "invalid_argument"},
+		{name: "library version not a number", method: "GET", url: "/api/apk/library/com.example.app/v2", status: 400, code: "invalid_argument"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newTestFake()
+			if tt.setup != nil {
+				tt.setup(f)
+			}
+			w := serve(newTestRouter(t, f), tt.method, tt.url, tt.body)
+			if w.Code != tt.status {
+				t.Fatalf("%s %s = %d, want %d: %s", tt.method, tt.url, w.Code, tt.status, w.Body)
+			}
+			if tt.contains != "" && !strings.Contains(w.Body.String(), tt.contains) {
+				t.Errorf("response does not contain %s: %s", tt.contains, w.Body)
+			}
+			if !strings.HasPrefix(w.Header().Get("Content-Type"), "application/json") {
+				if tt.code != "" {
+					t.Errorf("error response has Content-Type %q", w.Header().Get("Content-Type"))
+				}
+				return
+			}
+			var body map[string]any
+			if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+				// 设备列表等响应是数组
+				if tt.code != "" {
+					t.Fatalf("error response is not a JSON object: %v: %s", err, w.Body)
+				}
+				return
+			}
+			code, _ := body["code"].(string)
+			if code != tt.code {
+				t.Errorf("code = %q, want %q: %s", code, tt.code, w.Body)
+			}
+			if tt.code != "" {
+				if msg, _ := body["error"].(string); msg == "" {
+					t.Errorf("error envelope has no error text: %s", w.Body)
+				}
+			}
+		})
+	}
+}
+
+func TestRoutesSendQuotedCommands(t *testing.T) {
+	f := newTestFake()
+	r := newTestRouter(t, f)
+	if w := serve(r, "POST", "/api/apps/uninstall/emu-1", `{"packageName":"com.example.app","keepData":true}`); w.Code != 200 {
+		t.Fatalf("uninstall = %d: %s", w.Code, w.Body)
+	}
+	if w := serve(r, "GET", "/api/files/list/emu-1?path=/sdcard/it's%20here", ""); w.Code != 404 {
+		t.Fatalf("list = %d: %s", w.Code, w.Body)
+	}
+	var commands []string
+	for _, call := range f.Calls() {
+		commands = append(commands, call.Command)
+	}
+	for _, want := range []string{"pm uninstall -k com.example.app", `ls -la --full-time '/sdcard/it'\''s here/'`} {
+		found := false
+		for _, command := range commands {
+			found = found || command == want
+		}
+		if !found {
+			t.Errorf("command %q was not sent, got %q", want, commands)
+		}
+	}
+}
+
+// dialScreen 建立到 /api/screen/:deviceId 的 WebSocket 连接
+func dialScreen(t *testing.T, f *adbtest.Fake, deviceId string) *websocket.Conn {
+	t.Helper()
+	srv := httptest.NewServer(newTestRouter(t, f))
+	t.Cleanup(srv.Close)
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"/api/screen/"+deviceId, nil)
+	if err != nil {
+		t.Fatalf("dialing screen websocket: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	return conn
+}
+
+// readText 读取下一条文本消息 (跳过屏幕帧) 并解析为 JSON
+func readText(t *testing.T, conn *websocket.Conn) map[string]any {
+	t.Helper()
+	for {
+		kind, data, err := conn.ReadMessage()
+		if err != nil {
+			t.Fatalf("reading websocket message: %v", err)
+		}
+		if kind != websocket.TextMessage {
+			continue
+		}
+		var msg map[string]any
+		if err := json.Unmarshal(data, &msg); err != nil {
+			t.Fatalf("text message is not valid JSON: %v: %s", err, data)
+		}
+		return msg
+	}
+}
+
+func TestScreenMirrorWS(t *testing.T) {
+	f := newTestFake()
+	conn := dialScreen(t, f, "emu-1")
+
+	kind, frame, err := conn.ReadMessage()
+	if err != nil {
+		t.Fatalf("reading frame: %v", err)
+	}
+	if kind != websocket.BinaryMessage || !bytes.Equal(frame, adbtest.CannedScreenshot) {
+		t.Fatalf("first message is type %d with %d bytes, want the canned screenshot", kind, len(frame))
+	}
+
+	tests := []struct {
+		input   string
+		ack     string
+		command string
+	}{
+		{`{"type":"input_tap","x":10,"y":20}`, "input_tap_ack", "input tap 10 20"},
+		{`{"type":"input_text","text":"it's \"quoted\"; reboot"}`, "input_text_ack", `input text 'it'\''s "quoted"; reboot'`},
+		{`{"type":"input_keyevent","keycode":"KEYCODE_HOME"}`, "input_keyevent_ack", "input keyevent KEYCODE_HOME"},
+		{`{"type":"input_fly"}`, "error", ""},
+		{`not json`, "error", ""},
+	}
+	for _, tt := range tests {
+		if err := conn.WriteMessage(websocket.TextMessage, []byte(tt.input)); err != nil {
+			t.Fatalf("sending %s: %v", tt.input, err)
+		}
+		msg := readText(t, conn)
+		if msg["type"] != tt.ack {
+			t.Errorf("reply to %s = %v, want type %s", tt.input, msg, tt.ack)
+		}
+		if tt.command == "" {
+			continue
+		}
+		found := false
+		for _, call := range f.Calls() {
+			found = found || call.Command == tt.command
+		}
+		if !found {
+			t.Errorf("%s did not run %q on the device", tt.input, tt.command)
+		}
+	}
+}
+
+func TestScreenMirrorWSReportsScreencapErrors(t *testing.T) {
+	conn := dialScreen(t, newTestFake(), "missing")
+	msg := readText(t, conn)
+	if msg["type"] != "error" || !strings.Contains(msg["message"].(string), "Screencap failed") {
+		t.Errorf("message = %v, want a screencap error", msg)
+	}
+}
+
+func TestRemoveConfirmTokenBoundToPackage(t *testing.T) {
+	f := newTestFake()
+	f.PutFile("emu-1", "/data/data/com.example.app/cache/a.bin", []byte("a"))
+	r := newTestRouter(t, f)
+	body := `{"paths":["/data/data/com.example.app/cache"],"recursive":true}`
+
+	w := serve(r, "POST", "/api/files/rm/emu-1", body)
+	if w.Code != http.StatusPreconditionRequired {
+		t.Fatalf("rm -r without token = %d: %s", w.Code, w.Body)
+	}
+	var resp struct {
+		ConfirmToken string `json:"confirmToken"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil || resp.ConfirmToken == "" {
+		t.Fatalf("428 response has no confirmToken: %s", w.Body)
+	}
+
+	// 未指定 package 时签发的令牌不能用于以应用身份删除
+	withToken := `{"paths":["/data/data/com.example.app/cache"],"recursive":true,"confirmToken":"` + resp.ConfirmToken + `"}`
+	if w := serve(r, "POST", "/api/files/rm/emu-1?package=com.example.app", withToken); w.Code != http.StatusPreconditionRequired {
+		t.Fatalf("rm -r with a token issued without package = %d, want 428: %s", w.Code, w.Body)
+	}
+	if w := serve(r, "POST", "/api/files/rm/emu-1", withToken); w.Code != http.StatusOK {
+		t.Fatalf("rm -r with a matching token = %d: %s", w.Code, w.Body)
+	}
+	if _, ok := f.ReadFile("emu-1", "/data/data/com.example.app/cache/a.bin"); ok {
+		t.Errorf("file still exists after rm -r")
+	}
+}
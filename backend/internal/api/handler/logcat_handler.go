@@ -14,7 +14,7 @@ import (
 )
 
 // ClearLogcatHandler 处理清除设备 Logcat 缓存的请求
-func ClearLogcatHandler(c *gin.Context) {
+func (h *Handler) ClearLogcatHandler(c *gin.Context) {
 	deviceId := c.Param("deviceId")
 	if deviceId == "" {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Device ID is required"})
@@ -22,10 +22,10 @@ func ClearLogcatHandler(c *gin.Context) {
 	}
 
 	log.Printf("ClearLogcatHandler: Received request for device %s", deviceId)
-	err := adb.ClearLogcatBuffer(deviceId)
+	err := adb.ClearLogcatBuffer(c.Request.Context(), h.adb, deviceId)
 	if err != nil {
 		log.Printf("ClearLogcatHandler: Error for device %s: %v", deviceId, err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to clear logcat buffer", "details": err.Error()})
+		abortWithError(c, err, gin.H{"error": "Failed to clear logcat buffer"})
 		return
 	}
 
@@ -33,7 +33,7 @@ func ClearLogcatHandler(c *gin.Context) {
 }
 
 // DownloadLogcatHandler 处理下载设备 Logcat 文件的请求
-func DownloadLogcatHandler(c *gin.Context) {
+func (h *Handler) DownloadLogcatHandler(c *gin.Context) {
 	deviceId := c.Param("deviceId")
 	if deviceId == "" {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Device ID is required"})
@@ -45,10 +45,10 @@ func DownloadLogcatHandler(c *gin.Context) {
 	// 定义服务器上的临时存储目录
 	tempStorageDir := filepath.Join(os.TempDir(), "adb_logcat_dumps")
 
-	localTempLogFilePath, err := adb.DumpLogcatToFile(deviceId, tempStorageDir)
+	localTempLogFilePath, err := adb.DumpLogcatToFile(c.Request.Context(), h.adb, deviceId, tempStorageDir)
 	if err != nil {
 		log.Printf("DownloadLogcatHandler: Failed to dump logcat for device %s: %v", deviceId, err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve logcat data", "details": err.Error()})
+		abortWithError(c, err, gin.H{"error": "Failed to retrieve logcat data"})
 		return
 	}
 
@@ -2,14 +2,15 @@
 package handler
 
 import (
-	"bytes"
+	"context"
 	"encoding/json" // 用于解析 JSON 消息
-	"fmt"           // 用于格式化错误消息
+	"fishyinhe/backend/internal/adb"
+	"fmt" // 用于格式化错误消息
 	"log"
 	"net/http"
-	"os/exec"
 	"strconv" // 用于将数字转换为字符串
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -41,7 +42,7 @@ type InputMessage struct {
 }
 
 // ScreenMirrorWS 处理屏幕镜像的 WebSocket 请求，并增加输入处理
-func ScreenMirrorWS(c *gin.Context) {
+func (h *Handler) ScreenMirrorWS(c *gin.Context) {
 	deviceId := c.Param("deviceId")
 	if deviceId == "" {
 		log.Println("ScreenMirrorWS: Device ID is required but not provided in URL.")
@@ -62,10 +63,28 @@ func ScreenMirrorWS(c *gin.Context) {
 
 	clientDisconnected := make(chan struct{})
 
+	// websocket.Conn 不允许并发写入: 读取协程发送的确认消息与主循环发送的屏幕帧需要互斥
+	var writeMu sync.Mutex
+	writeJSON := func(v any) error {
+		writeMu.Lock()
+		defer writeMu.Unlock()
+		return conn.WriteJSON(v)
+	}
+	writeFrame := func(data []byte) error {
+		writeMu.Lock()
+		defer writeMu.Unlock()
+		return conn.WriteMessage(websocket.BinaryMessage, data)
+	}
+
+	// 客户端断开时取消 ctx，使正在进行的 screencap 与输入命令立即终止
+	ctx, cancel := context.WithCancel(c.Request.Context())
+	defer cancel()
+
 	// Goroutine 用于读取来自客户端的消息
 	go func() {
 		defer func() {
 			log.Printf("ScreenMirrorWS: Read goroutine for device %s, client %s exiting.", deviceId, conn.RemoteAddr())
+			cancel()
 			close(clientDisconnected)
 		}()
 		for {
@@ -86,8 +105,8 @@ func ScreenMirrorWS(c *gin.Context) {
 				if err := json.Unmarshal(p, &msg); err != nil {
 					log.Printf("ScreenMirrorWS: Error unmarshalling JSON from client %s (device: %s): %v. Message: %s", conn.RemoteAddr(), deviceId, err, string(p))
 					// 可选：发送错误消息回客户端
-					errMsg := fmt.Sprintf("{\"type\":\"error\", \"message\":\"Invalid JSON format: %v\"}", err)
-					if writeErr := conn.WriteMessage(websocket.TextMessage, []byte(errMsg)); writeErr != nil {
+					errMsg := gin.H{"type": "error", "message": fmt.Sprintf("Invalid JSON format: %v", err)}
+					if writeErr := writeJSON(errMsg); writeErr != nil {
 						log.Printf("ScreenMirrorWS: Error sending JSON unmarshal error to client: %v", writeErr)
 					}
 					continue
@@ -96,7 +115,7 @@ func ScreenMirrorWS(c *gin.Context) {
 				log.Printf("ScreenMirrorWS: Received message from client %s (device: %s): Type=%s, X=%d, Y=%d, X1=%d, Y1=%d, X2=%d, Y2=%d, Duration=%d, Text='%s', Keycode='%s'",
 					conn.RemoteAddr(), deviceId, msg.Type, msg.X, msg.Y, msg.X1, msg.Y1, msg.X2, msg.Y2, msg.Duration, msg.Text, msg.Keycode)
 
-				var adbCmd *exec.Cmd
+				var inputArgs []string
 				var actionDescription string
 				var successMessage string
 				var ackType string = "unknown_ack"
@@ -108,7 +127,7 @@ func ScreenMirrorWS(c *gin.Context) {
 						continue
 					}
 					actionDescription = fmt.Sprintf("input tap at (%d,%d)", msg.X, msg.Y)
-					adbCmd = exec.Command("adb", "-s", deviceId, "shell", "input", "tap", strconv.Itoa(msg.X), strconv.Itoa(msg.Y))
+					inputArgs = []string{"tap", strconv.Itoa(msg.X), strconv.Itoa(msg.Y)}
 					successMessage = fmt.Sprintf("Successfully executed tap for device %s at (%d,%d)", deviceId, msg.X, msg.Y)
 					ackType = "input_tap_ack"
 
@@ -118,7 +137,7 @@ func ScreenMirrorWS(c *gin.Context) {
 						continue
 					}
 					actionDescription = fmt.Sprintf("input text '%s'", msg.Text)
-					adbCmd = exec.Command("adb", "-s", deviceId, "shell", "input", "text", msg.Text)
+					inputArgs = []string{"text", msg.Text}
 					successMessage = fmt.Sprintf("Successfully executed input text for device %s, text '%s'", deviceId, msg.Text)
 					ackType = "input_text_ack"
 
@@ -128,7 +147,7 @@ func ScreenMirrorWS(c *gin.Context) {
 						continue
 					}
 					actionDescription = fmt.Sprintf("input keyevent %s", msg.Keycode)
-					adbCmd = exec.Command("adb", "-s", deviceId, "shell", "input", "keyevent", msg.Keycode)
+					inputArgs = []string{"keyevent", msg.Keycode}
 					successMessage = fmt.Sprintf("Successfully executed input keyevent for device %s, keycode '%s'", deviceId, msg.Keycode)
 					ackType = "input_keyevent_ack"
 
@@ -142,45 +161,44 @@ func ScreenMirrorWS(c *gin.Context) {
 						durationStr = strconv.Itoa(msg.Duration)
 					}
 					actionDescription = fmt.Sprintf("input swipe from (%d,%d) to (%d,%d) duration %s ms", msg.X1, msg.Y1, msg.X2, msg.Y2, durationStr)
-					adbCmd = exec.Command("adb", "-s", deviceId, "shell", "input", "swipe",
+					inputArgs = []string{"swipe",
 						strconv.Itoa(msg.X1), strconv.Itoa(msg.Y1),
 						strconv.Itoa(msg.X2), strconv.Itoa(msg.Y2),
-						durationStr)
+						durationStr}
 					successMessage = fmt.Sprintf("Successfully executed swipe for device %s from (%d,%d) to (%d,%d)", deviceId, msg.X1, msg.Y1, msg.X2, msg.Y2)
 					ackType = "input_swipe_ack"
 
 				default:
 					log.Printf("ScreenMirrorWS: Received unknown message type from client %s (device: %s): %s", conn.RemoteAddr(), deviceId, msg.Type)
 					// 发送一个错误消息回客户端
-					errMsg := fmt.Sprintf("{\"type\":\"error\", \"message\":\"Unknown input type: %s\"}", msg.Type)
-					if writeErr := conn.WriteMessage(websocket.TextMessage, []byte(errMsg)); writeErr != nil {
+					errMsg := gin.H{"type": "error", "message": "Unknown input type: " + msg.Type}
+					if writeErr := writeJSON(errMsg); writeErr != nil {
 						log.Printf("ScreenMirrorWS: Error sending unknown type error to client: %v", writeErr)
 					}
 					continue
 				}
 
-				// 执行 ADB 命令 (如果 adbCmd 已被设置)
-				if adbCmd != nil {
-					var cmdStderr bytes.Buffer
-					adbCmd.Stderr = &cmdStderr
-					log.Printf("ScreenMirrorWS: Executing for device %s: %s", deviceId, strings.Join(adbCmd.Args, " "))
-					if err := adbCmd.Run(); err != nil {
-						log.Printf("ScreenMirrorWS: Error executing %s for device %s: %v. Stderr: %s", actionDescription, deviceId, err, cmdStderr.String())
+				// 执行 input 命令 (如果 inputArgs 已被设置)，命令经 ShellPool 在设备的常驻 shell 会话中执行
+				if inputArgs != nil {
+					log.Printf("ScreenMirrorWS: Executing for device %s: input %s", deviceId, strings.Join(inputArgs, " "))
+					if output, err := adb.InputCommand(ctx, h.adb, deviceId, inputArgs...); err != nil {
+						log.Printf("ScreenMirrorWS: Error executing %s for device %s: %v. Output: %s", actionDescription, deviceId, err, output)
 						// 发送错误消息回客户端
-						errMsg := fmt.Sprintf("{\"type\":\"error\", \"message\":\"Failed to execute %s: %s\"}", msg.Type, cmdStderr.String())
-						if writeErr := conn.WriteMessage(websocket.TextMessage, []byte(errMsg)); writeErr != nil {
+						errMsg := gin.H{"type": "error", "message": fmt.Sprintf("Failed to execute %s: %s", msg.Type, output)}
+						if writeErr := writeJSON(errMsg); writeErr != nil {
 							log.Printf("ScreenMirrorWS: Error sending command execution error to client: %v", writeErr)
 						}
 					} else {
 						log.Println(successMessage)
 						// 发送成功确认消息回客户端
-						ackMsg := fmt.Sprintf("{\"type\":\"%s\", \"status\":\"success\"}", ackType)
+						// 文本与按键码来自用户输入，通过 JSON 编码避免引号破坏消息格式
+						ackMsg := gin.H{"type": ackType, "status": "success"}
 						if msg.Type == "input_text" { // 特别地，为文本输入返回发送的文本
-							ackMsg = fmt.Sprintf("{\"type\":\"%s\", \"status\":\"success\", \"text\":\"%s\"}", ackType, msg.Text)
+							ackMsg["text"] = msg.Text
 						} else if msg.Type == "input_keyevent" {
-							ackMsg = fmt.Sprintf("{\"type\":\"%s\", \"status\":\"success\", \"keycode\":\"%s\"}", ackType, msg.Keycode)
+							ackMsg["keycode"] = msg.Keycode
 						}
-						if writeErr := conn.WriteMessage(websocket.TextMessage, []byte(ackMsg)); writeErr != nil {
+						if writeErr := writeJSON(ackMsg); writeErr != nil {
 							log.Printf("ScreenMirrorWS: Error sending ack message to client: %v", writeErr)
 						}
 					}
@@ -193,26 +211,24 @@ func ScreenMirrorWS(c *gin.Context) {
 	for {
 		select {
 		case <-ticker.C:
-			cmd := exec.Command("adb", "-s", deviceId, "exec-out", "screencap", "-p")
-			var out bytes.Buffer
-			var stderr bytes.Buffer
-			cmd.Stdout = &out
-			cmd.Stderr = &stderr
-			err := cmd.Run()
+			pngData, err := adb.CaptureScreen(ctx, h.adb, deviceId)
 			if err != nil {
-				log.Printf("ScreenMirrorWS: Error running screencap for device %s: %v\nStderr: %s", deviceId, err, stderr.String())
-				errMsg := fmt.Sprintf("{\"type\":\"error\", \"message\":\"Screencap failed: %s\"}", strings.TrimSpace(stderr.String()))
-				if writeErr := conn.WriteMessage(websocket.TextMessage, []byte(errMsg)); writeErr != nil {
+				if ctx.Err() != nil {
+					continue // 客户端已断开，等待 clientDisconnected
+				}
+				log.Printf("ScreenMirrorWS: Error running screencap for device %s: %v", deviceId, err)
+				// ShellError 以 %q 格式化命令，错误信息中的引号必须经过 JSON 编码
+				errMsg := gin.H{"type": "error", "message": "Screencap failed: " + err.Error()}
+				if writeErr := writeJSON(errMsg); writeErr != nil {
 					log.Printf("ScreenMirrorWS: Error sending screencap error to client: %v", writeErr)
 				}
 				continue
 			}
-			pngData := out.Bytes()
 			if len(pngData) == 0 {
 				log.Printf("ScreenMirrorWS: Screencap for device %s returned empty data.", deviceId)
 				continue
 			}
-			if err := conn.WriteMessage(websocket.BinaryMessage, pngData); err != nil {
+			if err := writeFrame(pngData); err != nil {
 				if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure, websocket.CloseNormalClosure) {
 					log.Printf("ScreenMirrorWS: Client %s (device: %s) disconnected (write error): %v", conn.RemoteAddr(), deviceId, err)
 				} else if err == websocket.ErrCloseSent {
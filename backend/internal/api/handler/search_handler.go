@@ -0,0 +1,103 @@
+package handler
+
+import (
+	"errors"
+	"fishyinhe/backend/internal/adb"
+	"fishyinhe/backend/internal/api/middleware"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// 搜索结果条数的默认值与上限
+const (
+	defaultSearchLimit = 1000
+	maxSearchLimit     = 10000
+)
+
+// parseSearchOptions 读取搜索的查询参数，返回的错误信息可以直接展示给用户
+func parseSearchOptions(c *gin.Context) (adb.SearchOptions, error) {
+	opts := adb.SearchOptions{
+		Name:    c.Query("name"),
+		Type:    c.Query("type"),
+		MinSize: -1,
+		MaxSize: -1,
+		Limit:   defaultSearchLimit,
+	}
+	for key, dst := range map[string]*int64{"minSize": &opts.MinSize, "maxSize": &opts.MaxSize} {
+		if v := c.Query(key); v != "" {
+			n, err := strconv.ParseInt(v, 10, 64)
+			if err != nil || n < 0 {
+				return opts, fmt.Errorf("%s must be a non-negative number of bytes", key)
+			}
+			*dst = n
+		}
+	}
+	if v := c.Query("maxDepth"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return opts, errors.New("maxDepth must be a positive integer")
+		}
+		opts.MaxDepth = n
+	}
+	if v := c.Query("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxSearchLimit {
+			return opts, fmt.Errorf("limit must be between 1 and %d", maxSearchLimit)
+		}
+		opts.Limit = n
+	}
+	if v := c.Query("newerThan"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			t, err = time.ParseInLocation("2006-01-02", v, time.Local)
+		}
+		if err != nil {
+			return opts, errors.New("newerThan must be an RFC 3339 time or a YYYY-MM-DD date")
+		}
+		opts.NewerThan = t
+	}
+	return opts, nil
+}
+
+// SearchFilesHandler 在设备上运行 find 搜索文件，找到的条目逐条返回，不必等待搜索结束
+// 查询参数: path (默认 /sdcard)、name (glob，不区分大小写)、type=f|d|l、minSize/maxSize (字节)、
+// newerThan (RFC 3339 或 YYYY-MM-DD)、maxDepth、limit (默认 1000，最多 10000)
+// 默认返回 NDJSON: 每行一个与 list 接口相同的文件条目并带有 path，最后一行为 {"done":true,"count":n,"truncated":bool}；
+// format=sse 或 Accept: text/event-stream 时返回 SSE，事件为 file 与 done，中途失败时为 error
+func (h *Handler) SearchFilesHandler(c *gin.Context) {
+	deviceId := c.Param("deviceId")
+	root := c.DefaultQuery("path", "/sdcard")
+	opts, err := parseSearchOptions(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	stream := newEventStream(c)
+
+	log.Printf("Request to search files. Device: %s, Path: %s, Options: %+v", deviceId, root, opts)
+	start := time.Now()
+	count := 0
+	truncated, err := adb.SearchFiles(c.Request.Context(), h.adb, deviceId, root, opts, func(f adb.FoundFile) error {
+		count++
+		return stream.send("file", f)
+	})
+	switch {
+	case err != nil && !stream.started:
+		log.Printf("Failed to search files. Device: %s, Path: %s, Error: %v", deviceId, root, err)
+		abortWithError(c, err, gin.H{"error": "Failed to search files", "path": root})
+	case err != nil && c.Request.Context().Err() != nil:
+		log.Printf("Search under %s on device %s aborted by client after %d results", root, deviceId, count)
+	case err != nil:
+		// 结果已经开始返回，只能在流中报告错误
+		log.Printf("Search under %s on device %s failed after %d results: %v", root, deviceId, count, err)
+		status, code := middleware.ClassifyError(err)
+		stream.send("error", gin.H{"error": "Failed to search files", "code": code, "status": status, "details": err.Error()})
+	default:
+		log.Printf("Search under %s on device %s returned %d results in %s (truncated: %t)", root, deviceId, count, time.Since(start).Round(time.Millisecond), truncated)
+		stream.send("done", gin.H{"done": true, "count": count, "truncated": truncated})
+	}
+}
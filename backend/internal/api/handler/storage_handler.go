@@ -0,0 +1,96 @@
+package handler
+
+import (
+	"fishyinhe/backend/internal/adb"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// 目录占用接口返回的条目数
+const defaultUsageLimit = 20
+
+// usageWait 是目录占用接口等待扫描完成的时间，超过后返回 202，客户端稍后以相同参数轮询
+const usageWait = 3 * time.Second
+
+// GetDeviceStorageHandler 返回设备 data、sdcard、system 分区以及外置存储卷的空间使用情况
+// low 为 true 的分区剩余空间不足，安装应用可能因 INSTALL_FAILED_INSUFFICIENT_STORAGE 失败
+func (h *Handler) GetDeviceStorageHandler(c *gin.Context) {
+	deviceId := c.Param("deviceId")
+	if deviceId == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Device ID is required"})
+		return
+	}
+
+	storage, err := adb.DeviceStorage(c.Request.Context(), h.adb, deviceId)
+	if err != nil {
+		log.Printf("GetDeviceStorageHandler: Error for device %s: %v", deviceId, err)
+		abortWithError(c, err, gin.H{"error": "Failed to read device storage"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"deviceId": deviceId, "storage": storage})
+}
+
+// DiskUsageHandler 返回设备上目录的占用空间以及其中最大的子目录与文件
+// 查询参数: path 为目录，limit 为每个列表返回的条目数 (默认 20，最多 200)，refresh=true 时重新扫描
+// 扫描在后台运行，结果缓存 10 分钟；大目录在等待时间内未扫描完时返回 202 与 status=running，客户端以相同参数 (不带 refresh) 轮询
+func (h *Handler) DiskUsageHandler(c *gin.Context) {
+	deviceId := c.Param("deviceId")
+	remotePath := c.Query("path")
+	if remotePath == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Directory path is required"})
+		return
+	}
+	limit := defaultUsageLimit
+	if v := c.Query("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > adb.MaxUsageEntries {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("limit must be between 1 and %d", adb.MaxUsageEntries)})
+			return
+		}
+		limit = n
+	}
+
+	job, err := adb.StartDiskUsage(h.adb, deviceId, remotePath, c.Query("refresh") == "true")
+	if err != nil {
+		abortWithError(c, err, gin.H{"error": "Failed to scan directory usage", "path": remotePath})
+		return
+	}
+	timer := time.NewTimer(usageWait)
+	defer timer.Stop()
+	select {
+	case <-job.Done():
+	case <-timer.C:
+		c.Header("Retry-After", "2")
+		c.JSON(http.StatusAccepted, gin.H{"status": "running", "path": job.Path, "startedAt": job.StartedAt})
+		return
+	case <-c.Request.Context().Done():
+		return
+	}
+
+	report, err := job.Result()
+	if err != nil {
+		abortWithError(c, err, gin.H{"error": "Failed to scan directory usage", "path": remotePath})
+		return
+	}
+	// 缓存中的结果由多个请求共享，只截取副本
+	usage := *report
+	usage.Children = firstUsageEntries(usage.Children, limit)
+	usage.LargestDirs = firstUsageEntries(usage.LargestDirs, limit)
+	usage.LargestFiles = firstUsageEntries(usage.LargestFiles, limit)
+	c.JSON(http.StatusOK, gin.H{"status": "done", "startedAt": job.StartedAt, "scannedAt": job.FinishedAt, "usage": usage})
+}
+
+func firstUsageEntries(entries []adb.UsageEntry, n int) []adb.UsageEntry {
+	if entries == nil {
+		return []adb.UsageEntry{}
+	}
+	if len(entries) > n {
+		return entries[:n]
+	}
+	return entries
+}
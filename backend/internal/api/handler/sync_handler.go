@@ -0,0 +1,77 @@
+package handler
+
+import (
+	"fishyinhe/backend/internal/api/middleware"
+	"fishyinhe/backend/internal/dirsync"
+	"github.com/gin-gonic/gin"
+	"log"
+	"net/http"
+	"path"
+	"path/filepath"
+	"time"
+)
+
+// SyncRequest 是目录同步的请求体
+type SyncRequest struct {
+	// LocalPath 是服务器上相对于同步根目录 (files.syncRoot) 的目录
+	LocalPath  string `json:"localPath" binding:"required"`
+	RemotePath string `json:"remotePath" binding:"required"`
+	// Direction 为 push (服务器 -> 设备) 或 pull (设备 -> 服务器)
+	Direction string `json:"direction" binding:"required"`
+	Checksum  bool   `json:"checksum"`
+	Delete    bool   `json:"delete"`
+	// DryRun 为 true 时只返回计划，不修改任何文件
+	DryRun bool `json:"dryRun"`
+}
+
+// syncLocalDir 将请求中的相对路径解析为同步根目录下的路径，.. 不能越过根目录
+func (h *Handler) syncLocalDir(rel string) string {
+	return filepath.Join(h.opts.SyncRoot, filepath.FromSlash(path.Clean("/"+rel)))
+}
+
+// SyncDirHandler 同步服务器上同步根目录下的一个目录与设备上的目录，只传输有差异的文件
+// 默认返回 NDJSON，format=sse 或 Accept: text/event-stream 时返回 SSE:
+// 首先是 plan 事件 (全部计划中的操作)，然后每处理完一个文件一个 file 事件，最后是 done 事件；dryRun 时只有 plan 与 done
+// 比较阶段的错误返回普通的错误响应，开始传输后的错误以 error 事件报告
+func (h *Handler) SyncDirHandler(c *gin.Context) {
+	deviceId := c.Param("deviceId")
+	var req SyncRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body: " + err.Error()})
+		return
+	}
+	localDir := h.syncLocalDir(req.LocalPath)
+	opts := dirsync.Options{Direction: dirsync.Direction(req.Direction), Checksum: req.Checksum, Delete: req.Delete}
+	ctx := c.Request.Context()
+
+	log.Printf("Request to sync directories. Device: %s, Local: %s, Remote: %s, Options: %+v, DryRun: %t", deviceId, localDir, req.RemotePath, opts, req.DryRun)
+	start := time.Now()
+	plan, err := dirsync.Compare(ctx, h.adb, deviceId, localDir, req.RemotePath, opts)
+	if err != nil {
+		log.Printf("Failed to compare directories. Device: %s, Local: %s, Remote: %s, Error: %v", deviceId, localDir, req.RemotePath, err)
+		abortWithError(c, err, gin.H{"error": "Failed to compare directories", "localPath": req.LocalPath, "remotePath": req.RemotePath})
+		return
+	}
+	log.Printf("Sync plan for device %s (%s): %d changes, %d unchanged, %d bytes", deviceId, req.RemotePath, len(plan.Changes), plan.Unchanged, plan.Bytes)
+
+	stream := newEventStream(c)
+	stream.send("plan", gin.H{"plan": plan, "dryRun": req.DryRun})
+	if req.DryRun {
+		stream.send("done", gin.H{"done": true, "dryRun": true})
+		return
+	}
+	result, err := dirsync.Apply(ctx, h.adb, deviceId, localDir, req.RemotePath, plan, func(p dirsync.Progress) {
+		stream.send("file", p)
+	})
+	switch {
+	case err != nil && ctx.Err() != nil:
+		log.Printf("Sync of %s on device %s aborted by client after %d files", req.RemotePath, deviceId, result.Applied+result.Failed)
+	case err != nil:
+		log.Printf("Sync of %s on device %s failed: %v", req.RemotePath, deviceId, err)
+		status, code := middleware.ClassifyError(err)
+		stream.send("error", gin.H{"error": "Failed to sync directories", "code": code, "status": status, "details": err.Error()})
+	default:
+		log.Printf("Synced %s on device %s in %s: %+v", req.RemotePath, deviceId, time.Since(start).Round(time.Millisecond), *result)
+		stream.send("done", gin.H{"done": true, "result": result})
+	}
+}
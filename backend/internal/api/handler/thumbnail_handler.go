@@ -0,0 +1,137 @@
+package handler
+
+import (
+	"errors"
+	"fishyinhe/backend/internal/adb"
+	"fishyinhe/backend/internal/thumbnail"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"log"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 缩略图的尺寸 (长边的像素数)
+const (
+	defaultThumbnailSize = 256
+	minThumbnailSize     = 32
+	maxThumbnailSize     = 1024
+)
+
+// thumbnailImageExts 是在后端解码生成缩略图的图片类型
+var thumbnailImageExts = map[string]bool{
+	".jpg": true, ".jpeg": true, ".png": true, ".gif": true, ".webp": true, ".bmp": true,
+}
+
+// thumbnailMediaExts 是使用系统媒体库缩略图的文件类型及其所在的 MediaStore 集合
+var thumbnailMediaExts = map[string]string{
+	".heic": adb.MediaImages, ".heif": adb.MediaImages,
+	".mp4": adb.MediaVideo, ".m4v": adb.MediaVideo, ".3gp": adb.MediaVideo, ".mkv": adb.MediaVideo,
+	".webm": adb.MediaVideo, ".mov": adb.MediaVideo, ".avi": adb.MediaVideo, ".ts": adb.MediaVideo,
+}
+
+// ThumbnailHandler 返回设备上图片或视频的缩略图
+// 查询参数: path 为文件路径，size 为长边的像素数 (默认 256，32-1024)
+// JPEG/PNG/GIF/WebP/BMP 图片拉取到后端解码缩放；视频与 HEIC 以及无法解码的图片使用系统媒体库 (MediaStore) 生成的缩略图
+// 结果按设备、路径、尺寸与文件修改时间缓存在磁盘上；响应带有 ETag，文件未修改时重新验证只需要一次 STAT
+func (h *Handler) ThumbnailHandler(c *gin.Context) {
+	deviceId := c.Param("deviceId")
+	remotePath := c.Query("path")
+	if remotePath == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "File path is required"})
+		return
+	}
+	size := defaultThumbnailSize
+	if v := c.Query("size"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < minThumbnailSize || n > maxThumbnailSize {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("size must be between %d and %d", minThumbnailSize, maxThumbnailSize)})
+			return
+		}
+		size = n
+	}
+	ext := strings.ToLower(path.Ext(remotePath))
+	collection, isMedia := thumbnailMediaExts[ext]
+	if !thumbnailImageExts[ext] && !isMedia {
+		abortWithError(c, fmt.Errorf("%w: %q files have no thumbnail", thumbnail.ErrUnsupported, ext),
+			gin.H{"error": "Thumbnails are only available for images and videos", "path": remotePath})
+		return
+	}
+
+	ctx := c.Request.Context()
+	st, err := adb.StatFile(ctx, h.adb, deviceId, remotePath)
+	if err != nil {
+		abortWithError(c, err, gin.H{"error": "Failed to create thumbnail", "path": remotePath})
+		return
+	}
+	if st.Mode.IsDir() {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Path is a directory", "path": remotePath})
+		return
+	}
+
+	key := thumbnail.Key(deviceId, remotePath, size, st.ModTime)
+	etag := `"` + key + `"`
+	header := c.Writer.Header()
+	header.Set("ETag", etag)
+	// 地址中不包含修改时间，浏览器每次都需要重新验证
+	header.Set("Cache-Control", "private, no-cache")
+	if c.GetHeader("If-None-Match") == etag {
+		c.Status(http.StatusNotModified)
+		return
+	}
+	if data, contentType, ok := h.thumbnails.Get(key); ok {
+		c.Data(http.StatusOK, contentType, data)
+		return
+	}
+
+	start := time.Now()
+	data, contentType, err := h.generateThumbnail(c, remotePath, size, ext, collection)
+	if err != nil {
+		log.Printf("Failed to create thumbnail. Device: %s, Path: %s, Error: %v", deviceId, remotePath, err)
+		header.Del("ETag")
+		header.Del("Cache-Control")
+		abortWithError(c, err, gin.H{"error": "Failed to create thumbnail", "path": remotePath})
+		return
+	}
+	log.Printf("Created %dpx thumbnail for %s on device %s in %s (%d bytes)", size, remotePath, deviceId, time.Since(start).Round(time.Millisecond), len(data))
+	if err := h.thumbnails.Put(key, data, contentType); err != nil {
+		log.Printf("Failed to cache thumbnail for %s on device %s: %v", remotePath, deviceId, err)
+	}
+	c.Data(http.StatusOK, contentType, data)
+}
+
+// generateThumbnail 生成缩略图；图片无法在后端解码 (格式不支持或过大) 时改用系统媒体库的缩略图
+func (h *Handler) generateThumbnail(c *gin.Context, remotePath string, size int, ext string, collection string) ([]byte, string, error) {
+	ctx := c.Request.Context()
+	deviceId := c.Param("deviceId")
+	var err error
+	if thumbnailImageExts[ext] {
+		var src []byte
+		src, _, err = adb.ReadFile(ctx, h.adb, deviceId, remotePath, thumbnail.MaxSourceBytes)
+		if err == nil {
+			var data []byte
+			var contentType string
+			data, contentType, err = thumbnail.Generate(src, size)
+			if err == nil {
+				return data, contentType, nil
+			}
+		}
+		if !errors.Is(err, thumbnail.ErrUnsupported) && !errors.Is(err, thumbnail.ErrTooLarge) && !errors.Is(err, adb.ErrFileTooLarge) {
+			return nil, "", err
+		}
+		collection = adb.MediaImages
+	}
+
+	src, mediaErr := adb.MediaThumbnail(ctx, h.adb, deviceId, remotePath, collection, thumbnail.MaxSourceBytes)
+	if mediaErr != nil {
+		if err != nil && errors.Is(mediaErr, adb.ErrNoThumbnail) {
+			// 报告图片本身无法处理的原因
+			return nil, "", err
+		}
+		return nil, "", mediaErr
+	}
+	return thumbnail.Generate(src, size)
+}
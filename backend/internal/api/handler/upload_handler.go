@@ -0,0 +1,225 @@
+package handler
+
+import (
+	"fishyinhe/backend/internal/adb"
+	"fishyinhe/backend/internal/upload"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// CreateUploadRequest 是创建分块上传会话的请求体
+type CreateUploadRequest struct {
+	RemoteDirPath string              `json:"remoteDirPath" binding:"required"`
+	Files         []UploadFileRequest `json:"files" binding:"required"`
+}
+
+// UploadFileRequest 描述会话中的一个文件
+type UploadFileRequest struct {
+	// Path 是相对于 remoteDirPath 的路径，上传文件夹时使用浏览器提供的 webkitRelativePath
+	Path string `json:"path"`
+	Size int64  `json:"size"`
+	// LastModified 是毫秒时间戳 (与浏览器 File.lastModified 一致)，推送后作为设备上文件的修改时间
+	LastModified int64 `json:"lastModified,omitempty"`
+}
+
+// 分块上传使用的请求头与响应头 (沿用 tus 的命名)
+const (
+	headerUploadOffset = "Upload-Offset"
+	headerUploadLength = "Upload-Length"
+)
+
+// lookupUpload 返回属于 deviceId 的会话，会话不存在或属于其他设备时中止请求并返回 false
+func (h *Handler) lookupUpload(c *gin.Context) (upload.Session, bool) {
+	deviceId, uploadId := c.Param("deviceId"), c.Param("uploadId")
+	sess, err := h.uploads.Get(uploadId)
+	if err == nil && sess.DeviceID != deviceId {
+		err = fmt.Errorf("%w: %s", upload.ErrNotFound, uploadId)
+	}
+	if err != nil {
+		abortWithError(c, err, gin.H{"error": "Upload not found", "uploadId": uploadId})
+		return upload.Session{}, false
+	}
+	return sess, true
+}
+
+// uploadFileIndex 解析 :fileIndex，越界时中止请求并返回 false
+func uploadFileIndex(c *gin.Context, sess upload.Session) (int, bool) {
+	index, err := strconv.Atoi(c.Param("fileIndex"))
+	if err != nil || index < 0 || index >= len(sess.Files) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Upload has no such file", "uploadId": sess.ID, "fileIndex": c.Param("fileIndex")})
+		return 0, false
+	}
+	return index, true
+}
+
+// uploadStatus 是会话状态的响应体
+func uploadStatus(sess upload.Session) gin.H {
+	received, total := sess.Progress()
+	return gin.H{
+		"uploadId":      sess.ID,
+		"remoteDirPath": sess.RemoteDir,
+		"files":         sess.Files,
+		"received":      received,
+		"total":         total,
+		"complete":      sess.Complete(),
+	}
+}
+
+// CreateUploadHandler 创建分块上传会话，一个会话可以包含多个文件以及它们的子目录结构
+// 之后客户端对每个文件 PATCH 数据块，全部完成后调用 finalize 推送到设备
+func (h *Handler) CreateUploadHandler(c *gin.Context) {
+	deviceId := c.Param("deviceId")
+	var req CreateUploadRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body: " + err.Error()})
+		return
+	}
+
+	files := make([]upload.File, len(req.Files))
+	for i, f := range req.Files {
+		files[i] = upload.File{Path: f.Path, Size: f.Size}
+		if f.LastModified > 0 {
+			files[i].ModTime = time.UnixMilli(f.LastModified)
+		}
+	}
+	sess, err := h.uploads.Create(deviceId, req.RemoteDirPath, files)
+	if err != nil {
+		log.Printf("Failed to create upload. Device: %s, Target Dir: %s, Error: %v", deviceId, req.RemoteDirPath, err)
+		abortWithError(c, err, gin.H{"error": "Failed to create upload", "remoteDirPath": req.RemoteDirPath})
+		return
+	}
+	_, total := sess.Progress()
+	log.Printf("Created upload %s for device %s: %d file(s), %d bytes to %s", sess.ID, deviceId, len(sess.Files), total, sess.RemoteDir)
+	c.JSON(http.StatusCreated, uploadStatus(sess))
+}
+
+// GetUploadHandler 返回会话中每个文件已接收的字节数，客户端中断后据此继续上传
+func (h *Handler) GetUploadHandler(c *gin.Context) {
+	sess, ok := h.lookupUpload(c)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, uploadStatus(sess))
+}
+
+// HeadUploadFileHandler 通过 Upload-Offset 与 Upload-Length 响应头返回单个文件的进度
+func (h *Handler) HeadUploadFileHandler(c *gin.Context) {
+	sess, ok := h.lookupUpload(c)
+	if !ok {
+		return
+	}
+	index, ok := uploadFileIndex(c, sess)
+	if !ok {
+		return
+	}
+	c.Header("Cache-Control", "no-store")
+	c.Header(headerUploadOffset, strconv.FormatInt(sess.Files[index].Offset, 10))
+	c.Header(headerUploadLength, strconv.FormatInt(sess.Files[index].Size, 10))
+	c.Status(http.StatusOK)
+}
+
+// PatchUploadFileHandler 将请求体作为一个数据块追加到文件，Upload-Offset 必须等于已接收的字节数
+// 偏移量不一致时返回 409 以及服务器上的 offset，客户端应从该位置重新发送
+func (h *Handler) PatchUploadFileHandler(c *gin.Context) {
+	sess, ok := h.lookupUpload(c)
+	if !ok {
+		return
+	}
+	index, ok := uploadFileIndex(c, sess)
+	if !ok {
+		return
+	}
+	offset, err := strconv.ParseInt(c.GetHeader(headerUploadOffset), 10, 64)
+	if err != nil || offset < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Upload-Offset header must be a non-negative integer"})
+		return
+	}
+
+	current, err := h.uploads.WriteChunk(sess.ID, index, offset, c.Request.Body)
+	c.Header(headerUploadOffset, strconv.FormatInt(current, 10))
+	if err != nil {
+		log.Printf("Failed to write chunk of upload %s file %d at offset %d: %v", sess.ID, index, offset, err)
+		abortWithError(c, err, gin.H{"error": "Failed to write upload chunk", "uploadId": sess.ID, "fileIndex": index, "offset": current})
+		return
+	}
+	size := sess.Files[index].Size
+	c.JSON(http.StatusOK, gin.H{"uploadId": sess.ID, "fileIndex": index, "offset": current, "size": size, "complete": current == size})
+}
+
+// FinalizeUploadHandler 在所有文件接收完毕后通过 sync SEND 将它们推送到设备，成功后删除会话
+// 推送失败时会话保留，已推送的文件会被记录，再次调用时只推送剩余的文件
+func (h *Handler) FinalizeUploadHandler(c *gin.Context) {
+	deviceId := c.Param("deviceId")
+	if _, ok := h.lookupUpload(c); !ok {
+		return
+	}
+	sess, release, err := h.uploads.StartFinalize(c.Param("uploadId"))
+	if err != nil {
+		abortWithError(c, err, gin.H{"error": "Upload cannot be finalized", "uploadId": c.Param("uploadId")})
+		return
+	}
+	defer release()
+
+	ctx := c.Request.Context()
+	start := time.Now()
+	remotePaths := make([]string, len(sess.Files))
+	for i, f := range sess.Files {
+		remotePaths[i] = sess.RemotePath(i)
+		if f.Pushed {
+			continue
+		}
+		if err := h.pushUploadedFile(c, sess, i); err != nil {
+			log.Printf("Failed to push upload %s file %s to device %s: %v", sess.ID, remotePaths[i], deviceId, err)
+			abortWithError(c, err, gin.H{"error": "Failed to push file to device", "uploadId": sess.ID, "path": remotePaths[i]})
+			return
+		}
+		if err := h.uploads.MarkPushed(sess.ID, i); err != nil {
+			log.Printf("Failed to record pushed file %s of upload %s: %v", remotePaths[i], sess.ID, err)
+		}
+		if ctx.Err() != nil {
+			abortWithError(c, ctx.Err(), gin.H{"error": "Upload finalize canceled", "uploadId": sess.ID})
+			return
+		}
+	}
+
+	if err := h.uploads.Remove(sess.ID, true); err != nil {
+		log.Printf("Failed to remove finished upload %s: %v", sess.ID, err)
+	}
+	_, total := sess.Progress()
+	log.Printf("Upload %s pushed %d file(s), %d bytes to %s on device %s in %s",
+		sess.ID, len(sess.Files), total, sess.RemoteDir, deviceId, time.Since(start).Round(time.Millisecond))
+	c.JSON(http.StatusOK, gin.H{
+		"message":       "Files uploaded successfully to device",
+		"uploadId":      sess.ID,
+		"remoteDirPath": sess.RemoteDir,
+		"files":         remotePaths,
+	})
+}
+
+// pushUploadedFile 将会话中第 i 个文件的数据流式推送到设备
+func (h *Handler) pushUploadedFile(c *gin.Context, sess upload.Session, i int) error {
+	part, err := h.uploads.OpenPart(sess.ID, i)
+	if err != nil {
+		return err
+	}
+	defer part.Close()
+	return adb.PushStream(c.Request.Context(), h.adb, sess.DeviceID, part, sess.RemotePath(i), 0644, sess.Files[i].ModTime)
+}
+
+// DeleteUploadHandler 取消会话并删除已接收的数据
+func (h *Handler) DeleteUploadHandler(c *gin.Context) {
+	sess, ok := h.lookupUpload(c)
+	if !ok {
+		return
+	}
+	if err := h.uploads.Remove(sess.ID, false); err != nil {
+		abortWithError(c, err, gin.H{"error": "Failed to cancel upload", "uploadId": sess.ID})
+		return
+	}
+	log.Printf("Canceled upload %s for device %s", sess.ID, sess.DeviceID)
+	c.JSON(http.StatusOK, gin.H{"message": "Upload canceled", "uploadId": sess.ID})
+}
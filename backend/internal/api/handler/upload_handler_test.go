@@ -0,0 +1,47 @@
+package handler_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestUploadChunkConflicts(t *testing.T) {
+	f := newTestFake()
+	r := newTestRouter(t, f)
+	w := serve(r, "POST", "/api/files/uploads/emu-1", `{"remoteDirPath":"/sdcard/Download","files":[{"path":"a.txt","size":10}]}`)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("create upload = %d: %s", w.Code, w.Body)
+	}
+	var created struct {
+		ID string `json:"uploadId"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &created); err != nil || created.ID == "" {
+		t.Fatalf("create upload response %s: %v", w.Body, err)
+	}
+	url := "/api/files/uploads/emu-1/" + created.ID
+
+	patch := func(offset string, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("PATCH", url+"/0", strings.NewReader(body))
+		req.Header.Set("Upload-Offset", offset)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+	if w := patch("0", "hello"); w.Code != http.StatusOK {
+		t.Fatalf("first chunk = %d: %s", w.Code, w.Body)
+	}
+	w = patch("0", "hello")
+	if w.Code != http.StatusConflict || !strings.Contains(w.Body.String(), `"code":"offset_mismatch"`) {
+		t.Errorf("resent chunk = %d: %s, want 409 offset_mismatch", w.Code, w.Body)
+	}
+	if got := w.Header().Get("Upload-Offset"); got != "5" {
+		t.Errorf("Upload-Offset = %q, want 5", got)
+	}
+	w = serve(r, "POST", url+"/finalize", "")
+	if w.Code != http.StatusConflict || !strings.Contains(w.Body.String(), `"code":"upload_incomplete"`) {
+		t.Errorf("finalize incomplete upload = %d: %s, want 409 upload_incomplete", w.Code, w.Body)
+	}
+}
@@ -0,0 +1,153 @@
+package handler
+
+import (
+	"fishyinhe/backend/internal/adb"
+	"github.com/gin-gonic/gin"
+	"log"
+	"net/http"
+)
+
+// WirelessAddressRequest 是 connect/disconnect 请求体
+type WirelessAddressRequest struct {
+	Address string `json:"address" binding:"required"` // host:port，省略端口时使用 5555
+	Forget  bool   `json:"forget,omitempty"`           // 仅 disconnect: 同时从记住的设备中删除
+}
+
+// PairRequest 是配对请求体，地址与配对码来自设备"无线调试 > 使用配对码配对设备"页面
+type PairRequest struct {
+	Address string `json:"address" binding:"required"`
+	Code    string `json:"code" binding:"required"`
+}
+
+// TCPIPRequest 指定 adbd 监听的端口，省略时使用 5555
+type TCPIPRequest struct {
+	Port int `json:"port,omitempty"`
+}
+
+// remember 记录一次成功的无线连接，失败只记录日志
+func (h *Handler) remember(d adb.WirelessDevice) {
+	if h.wireless == nil {
+		return
+	}
+	if err := h.wireless.Remember(d); err != nil {
+		log.Printf("Failed to remember wireless device %s: %v", d.Address, err)
+	}
+}
+
+// ConnectDeviceHandler 连接无线调试设备，成功后记住该地址以便后端重启时重新连接
+func (h *Handler) ConnectDeviceHandler(c *gin.Context) {
+	var req WirelessAddressRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body: " + err.Error()})
+		return
+	}
+
+	log.Printf("ConnectDeviceHandler: Request to connect to %s", req.Address)
+	msg, err := adb.ConnectDevice(c.Request.Context(), h.adb, req.Address)
+	if err != nil {
+		abortWithError(c, err, gin.H{"error": "Failed to connect to device", "address": req.Address})
+		return
+	}
+	addr := adb.NormalizeAddr(req.Address)
+	h.remember(adb.WirelessDevice{Address: addr})
+	c.JSON(http.StatusOK, gin.H{"message": msg, "address": addr})
+}
+
+// DisconnectDeviceHandler 断开无线调试设备，forget 为 true 时同时删除记录
+func (h *Handler) DisconnectDeviceHandler(c *gin.Context) {
+	var req WirelessAddressRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body: " + err.Error()})
+		return
+	}
+	addr := adb.NormalizeAddr(req.Address)
+
+	log.Printf("DisconnectDeviceHandler: Request to disconnect %s (forget: %t)", addr, req.Forget)
+	if req.Forget && h.wireless != nil {
+		if err := h.wireless.Forget(addr); err != nil {
+			log.Printf("DisconnectDeviceHandler: Failed to forget %s: %v", addr, err)
+		}
+	}
+	msg, err := adb.DisconnectDevice(c.Request.Context(), h.adb, addr)
+	if err != nil {
+		abortWithError(c, err, gin.H{"error": "Failed to disconnect device", "address": addr})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": msg, "address": addr})
+}
+
+// PairDeviceHandler 使用配对码与设备配对，配对成功后仍需调用 connect 连接无线调试端口
+func (h *Handler) PairDeviceHandler(c *gin.Context) {
+	var req PairRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body: " + err.Error()})
+		return
+	}
+
+	log.Printf("PairDeviceHandler: Request to pair with %s", req.Address)
+	msg, err := adb.PairDevice(c.Request.Context(), h.adb, req.Address, req.Code)
+	if err != nil {
+		abortWithError(c, err, gin.H{"error": "Failed to pair with device", "address": req.Address})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": msg, "address": req.Address})
+}
+
+// EnableTCPIPHandler 让 USB 连接的设备开始监听无线调试连接 (adb tcpip)
+func (h *Handler) EnableTCPIPHandler(c *gin.Context) {
+	deviceId := c.Param("deviceId")
+	var req TCPIPRequest
+	// 请求体可以省略
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body: " + err.Error()})
+			return
+		}
+	}
+
+	log.Printf("EnableTCPIPHandler: Request to enable tcpip on device %s, port %d", deviceId, req.Port)
+	msg, err := adb.EnableTCPIP(c.Request.Context(), h.adb, deviceId, req.Port)
+	if err != nil {
+		abortWithError(c, err, gin.H{"error": "Failed to enable TCP/IP mode"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": msg, "deviceId": deviceId})
+}
+
+// SwitchToWirelessHandler 一键将 USB 设备切换为无线调试:
+// 读取 wlan0 地址、执行 tcpip 并连接，成功后记住该地址
+func (h *Handler) SwitchToWirelessHandler(c *gin.Context) {
+	deviceId := c.Param("deviceId")
+	var req TCPIPRequest
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body: " + err.Error()})
+			return
+		}
+	}
+
+	log.Printf("SwitchToWirelessHandler: Request to switch device %s to wireless debugging", deviceId)
+	addr, err := adb.SwitchToWireless(c.Request.Context(), h.adb, deviceId, req.Port)
+	if err != nil {
+		abortWithError(c, err, gin.H{"error": "Failed to switch device to wireless debugging"})
+		return
+	}
+
+	remembered := adb.WirelessDevice{Address: addr, Serial: deviceId}
+	if h.tracker != nil {
+		if d, ok := h.tracker.Device(deviceId); ok {
+			remembered.Model = d.Model
+		}
+	}
+	h.remember(remembered)
+	c.JSON(http.StatusOK, gin.H{"message": "Device is now connected over Wi-Fi", "deviceId": deviceId, "address": addr})
+}
+
+// ListWirelessDevicesHandler 返回记住的无线调试设备
+func (h *Handler) ListWirelessDevicesHandler(c *gin.Context) {
+	devices := []adb.WirelessDevice{}
+	if h.wireless != nil {
+		devices = h.wireless.List()
+	}
+	c.JSON(http.StatusOK, devices)
+}
@@ -0,0 +1,113 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"fishyinhe/backend/internal/adb"
+	"fishyinhe/backend/internal/apk"
+	"fishyinhe/backend/internal/apkrepo"
+	"fishyinhe/backend/internal/thumbnail"
+	"fishyinhe/backend/internal/upload"
+	"github.com/gin-gonic/gin"
+	"net/http"
+)
+
+// errorClass 描述一类错误对应的 HTTP 状态码与机器可读的 code
+type errorClass struct {
+	err    error
+	status int
+	code   string
+}
+
+// errorClasses 按顺序匹配，第一个满足 errors.Is 的类别生效
+var errorClasses = []errorClass{
+	{adb.ErrInvalidArgument, http.StatusBadRequest, "invalid_argument"},
+	{adb.ErrTimeout, http.StatusGatewayTimeout, "timeout"},
+	{adb.ErrDeviceNotFound, http.StatusNotFound, "device_not_found"},
+	{adb.ErrUnauthorized, http.StatusForbidden, "device_unauthorized"},
+	{adb.ErrOffline, http.StatusServiceUnavailable, "device_offline"},
+	{adb.ErrPathNotFound, http.StatusNotFound, "path_not_found"},
+	{adb.ErrPermissionDenied, http.StatusForbidden, "permission_denied"},
+	{adb.ErrInstallFailed, http.StatusUnprocessableEntity, "install_failed"},
+	{adb.ErrServerUnavailable, http.StatusServiceUnavailable, "adb_unavailable"},
+	{adb.ErrConnectFailed, http.StatusBadGateway, "connect_failed"},
+	{adb.ErrPairFailed, http.StatusUnprocessableEntity, "pair_failed"},
+	{adb.ErrNoWifiAddress, http.StatusConflict, "no_wifi_address"},
+	{adb.ErrArchiveTooLarge, http.StatusRequestEntityTooLarge, "archive_too_large"},
+	{adb.ErrAlreadyExists, http.StatusConflict, "already_exists"},
+	{adb.ErrFileTooLarge, http.StatusRequestEntityTooLarge, "file_too_large"},
+	{adb.ErrNotText, http.StatusUnsupportedMediaType, "not_text"},
+	{adb.ErrConflict, http.StatusConflict, "conflict"},
+	{adb.ErrNoThumbnail, http.StatusNotFound, "thumbnail_unavailable"},
+	{adb.ErrNotDebuggable, http.StatusForbidden, "not_debuggable"},
+	{adb.ErrPackageNotFound, http.StatusNotFound, "package_not_found"},
+	{apk.ErrInvalid, http.StatusUnprocessableEntity, "invalid_apk"},
+	{apk.ErrIncompatible, http.StatusUnprocessableEntity, "incompatible_apk"},
+	{apkrepo.ErrNotFound, http.StatusNotFound, "apk_not_found"},
+	{apkrepo.ErrNotRetained, http.StatusConflict, "apk_not_retained"},
+	{thumbnail.ErrUnsupported, http.StatusUnsupportedMediaType, "unsupported_media"},
+	{thumbnail.ErrTooLarge, http.StatusRequestEntityTooLarge, "image_too_large"},
+	{upload.ErrNotFound, http.StatusNotFound, "upload_not_found"},
+	{upload.ErrOffsetMismatch, http.StatusConflict, "offset_mismatch"},
+	{upload.ErrBusy, http.StatusConflict, "upload_busy"},
+	{upload.ErrIncomplete, http.StatusConflict, "upload_incomplete"},
+}
+
+// statusClientClosedRequest 是客户端在响应前断开连接时记录的状态码 (沿用 nginx 的 499)
+const statusClientClosedRequest = 499
+
+// ClassifyError 返回 err 对应的 HTTP 状态码与 code
+func ClassifyError(err error) (int, string) {
+	for _, class := range errorClasses {
+		if errors.Is(err, class.err) {
+			return class.status, class.code
+		}
+	}
+	if errors.Is(err, context.Canceled) {
+		return statusClientClosedRequest, "canceled"
+	}
+	var serverErr *adb.ServerError
+	if errors.As(err, &serverErr) {
+		return http.StatusBadGateway, "adb_error"
+	}
+	var shellErr *adb.ShellError
+	if errors.As(err, &shellErr) {
+		return http.StatusInternalServerError, "command_failed"
+	}
+	return http.StatusInternalServerError, "internal_error"
+}
+
+// ErrorEnvelope 将处理函数通过 c.Error 记录的错误转换为统一的 JSON 响应:
+//
+//	{"error": "Failed to list files", "code": "path_not_found", "details": "..."}
+//
+// 处理函数可以通过 Error.SetMeta(gin.H{...}) 提供 error 文本及其他字段，未提供 details 时使用 err.Error()
+// 安装失败或 APK 与设备不兼容时额外返回 reason (例如 INSTALL_FAILED_OLDER_SDK)
+func ErrorEnvelope() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Next()
+
+		if len(c.Errors) == 0 || c.Writer.Written() {
+			return
+		}
+		last := c.Errors.Last()
+		status, code := ClassifyError(last.Err)
+
+		body := gin.H{"error": http.StatusText(status), "details": last.Err.Error()}
+		if meta, ok := last.Meta.(gin.H); ok {
+			for k, v := range meta {
+				body[k] = v
+			}
+		}
+		body["code"] = code
+		var installErr *adb.InstallError
+		if errors.As(last.Err, &installErr) && installErr.Reason != "" {
+			body["reason"] = installErr.Reason
+		}
+		var incompatibleErr *apk.IncompatibleError
+		if errors.As(last.Err, &incompatibleErr) {
+			body["reason"] = incompatibleErr.Reason
+		}
+		c.JSON(status, body)
+	}
+}
@@ -1,17 +1,24 @@
 package api
 
 import (
+	"fishyinhe/backend/internal/adb"
 	"fishyinhe/backend/internal/api/handler"    // 确保模块路径正确
 	"fishyinhe/backend/internal/api/middleware" // 确保模块路径正确
 	"github.com/gin-gonic/gin"
 )
 
 // SetupRouter 创建、配置并返回 Gin 引擎实例
-func SetupRouter() *gin.Engine { // 函数签名：无参数，返回 *gin.Engine
+// runner 决定处理函数如何访问设备：生产环境传入 *adb.Client，测试时可传入 adbtest.Fake
+// tracker 提供设备注册表与事件流，调用者负责运行它
+// wireless 记录成功连接过的无线设备，opts 是处理函数的可配置限制
+func SetupRouter(runner adb.Runner, tracker *adb.Tracker, wireless *adb.WirelessRegistry, opts handler.Options) *gin.Engine {
 	router := gin.Default() // 在这里创建 Gin 引擎
+	h := handler.New(runner, tracker, wireless, opts)
 
 	// 应用 CORS 中间件 (可以全局应用，也可以只对 API 组应用)
 	router.Use(middleware.CORSMiddleware())
+	// 将处理函数记录的 adb 错误统一转换为带 code 字段的 JSON 响应
+	router.Use(middleware.ErrorEnvelope())
 
 	// API V1 路由组
 	apiV1 := router.Group("/api")
@@ -19,37 +26,81 @@ func SetupRouter() *gin.Engine { // 函数签名：无参数，返回 *gin.Engin
 	// apiV1.Use(middleware.CORSMiddleware())
 	{
 		apiV1.GET("/health", handler.HealthCheck)
-		apiV1.GET("/devices", handler.GetDevices)
-		apiV1.POST("/devices/:deviceId/gohome", handler.DeviceGoHomeHandler)
-		apiV1.POST("/devices/:deviceId/wakeup", handler.DeviceWakeUpHandler) // <--- 新增：唤醒屏幕路由
-		apiV1.GET("/screen/:deviceId", handler.ScreenMirrorWS)
+		apiV1.GET("/devices", h.GetDevices)
+		apiV1.GET("/devices/events", h.DeviceEventsHandler)
+		apiV1.GET("/devices/queues", h.DeviceQueuesHandler)
+		// 无线调试
+		apiV1.GET("/devices/wireless", h.ListWirelessDevicesHandler)
+		apiV1.POST("/devices/connect", h.ConnectDeviceHandler)
+		apiV1.POST("/devices/disconnect", h.DisconnectDeviceHandler)
+		apiV1.POST("/devices/pair", h.PairDeviceHandler)
+		apiV1.POST("/devices/:deviceId/tcpip", h.EnableTCPIPHandler)
+		apiV1.POST("/devices/:deviceId/wireless", h.SwitchToWirelessHandler)
+		apiV1.GET("/devices/:deviceId/properties", h.GetDevicePropertiesHandler)
+		apiV1.GET("/devices/:deviceId/storage", h.GetDeviceStorageHandler)
+		apiV1.POST("/devices/:deviceId/gohome", h.DeviceGoHomeHandler)
+		apiV1.POST("/devices/:deviceId/wakeup", h.DeviceWakeUpHandler) // <--- 新增：唤醒屏幕路由
+		apiV1.GET("/screen/:deviceId", h.ScreenMirrorWS)
 
 		// 文件相关路由组
 		deviceFiles := apiV1.Group("/files")
 		{
 			// 确保这里的路由与您之前的设计一致
-			deviceFiles.GET("/list/:deviceId", handler.ListFilesHandler)
-			deviceFiles.GET("/download/:deviceId", handler.DownloadFileHandler)
-			deviceFiles.POST("/upload/:deviceId", handler.UploadFileHandler)
+			deviceFiles.GET("/list/:deviceId", h.ListFilesHandler)
+			deviceFiles.GET("/download/:deviceId", h.DownloadFileHandler)
+			deviceFiles.GET("/archive/:deviceId", h.DownloadArchiveHandler)
+			deviceFiles.GET("/search/:deviceId", h.SearchFilesHandler)
+			deviceFiles.GET("/usage/:deviceId", h.DiskUsageHandler)
+			deviceFiles.GET("/thumbnail/:deviceId", h.ThumbnailHandler)
+			deviceFiles.GET("/content/:deviceId", h.GetTextContentHandler)
+			deviceFiles.PUT("/content/:deviceId", h.PutTextContentHandler)
+			deviceFiles.POST("/upload/:deviceId", h.UploadFileHandler)
+			// 分块、可续传的多文件上传
+			deviceFiles.POST("/uploads/:deviceId", h.CreateUploadHandler)
+			deviceFiles.GET("/uploads/:deviceId/:uploadId", h.GetUploadHandler)
+			deviceFiles.DELETE("/uploads/:deviceId/:uploadId", h.DeleteUploadHandler)
+			deviceFiles.POST("/uploads/:deviceId/:uploadId/finalize", h.FinalizeUploadHandler)
+			deviceFiles.HEAD("/uploads/:deviceId/:uploadId/:fileIndex", h.HeadUploadFileHandler)
+			deviceFiles.PATCH("/uploads/:deviceId/:uploadId/:fileIndex", h.PatchUploadFileHandler)
+			// 文件管理
+			deviceFiles.POST("/mkdir/:deviceId", h.MakeDirHandler)
+			deviceFiles.POST("/rm/:deviceId", h.RemoveHandler)
+			deviceFiles.POST("/mv/:deviceId", h.MoveHandler)
+			deviceFiles.POST("/cp/:deviceId", h.CopyHandler)
+			deviceFiles.POST("/chmod/:deviceId", h.ChmodHandler)
+			deviceFiles.POST("/touch/:deviceId", h.TouchHandler)
+			// 服务器目录与设备目录的增量同步
+			deviceFiles.POST("/sync/:deviceId", h.SyncDirHandler)
 		}
 
 		// APK 相关路由组
 		apkRoutes := apiV1.Group("/apk")
 		{
-			apkRoutes.POST("/install/:deviceId", handler.InstallLocalAPKHandler)
+			apkRoutes.POST("/inspect", h.InspectAPKHandler)
+			apkRoutes.POST("/install/:deviceId", h.InstallLocalAPKHandler)
+			apkRoutes.POST("/install-multiple/:deviceId", h.InstallBundleHandler)
+			// 把一个 APK 并行安装到多台设备，以 NDJSON/SSE 返回每台设备的进度与结果
+			apkRoutes.POST("/install-batch", h.InstallBatchHandler)
+			// 服务器端 APK 库: version 为 versionCode 或 latest
+			apkRoutes.GET("/library", h.ListLibraryHandler)
+			apkRoutes.POST("/library", h.AddToLibraryHandler)
+			apkRoutes.GET("/library/:package/:version", h.GetLibraryAPKHandler)
+			apkRoutes.DELETE("/library/:package/:version", h.DeleteLibraryAPKHandler)
+			apkRoutes.GET("/library/:package/:version/download", h.DownloadLibraryAPKHandler)
+			apkRoutes.POST("/library/:package/:version/install", h.InstallLibraryAPKHandler)
 		}
 
 		// 应用管理相关路由 (如果已添加)
 		appRoutes := apiV1.Group("/apps")
 		{
-			appRoutes.GET("/list/:deviceId", handler.ListInstalledAppsHandler)
-			appRoutes.POST("/uninstall/:deviceId", handler.UninstallAppHandler)
-			appRoutes.POST("/stop/:deviceId", handler.ForceStopAppHandler)
+			appRoutes.GET("/list/:deviceId", h.ListInstalledAppsHandler)
+			appRoutes.POST("/uninstall/:deviceId", h.UninstallAppHandler)
+			appRoutes.POST("/stop/:deviceId", h.ForceStopAppHandler)
 		}
 		logcatRoutes := apiV1.Group("/logcat")
 		{
-			logcatRoutes.POST("/clear/:deviceId", handler.ClearLogcatHandler)
-			logcatRoutes.GET("/download/:deviceId", handler.DownloadLogcatHandler)
+			logcatRoutes.POST("/clear/:deviceId", h.ClearLogcatHandler)
+			logcatRoutes.GET("/download/:deviceId", h.DownloadLogcatHandler)
 		}
 	}
 	return router // 返回创建并配置好的引擎
@@ -0,0 +1,378 @@
+// Package apk 解析 APK 中编译后的 AndroidManifest.xml 与 resources.arsc，
+// 在安装前获取包名、版本、SDK 要求、原生库 ABI、权限、应用名称与图标
+package apk
+
+import (
+	"archive/zip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strings"
+)
+
+// 解析与检查 APK 可能返回的错误类别，使用 errors.Is 判断
+var (
+	ErrInvalid      = errors.New("invalid APK")
+	ErrIncompatible = errors.New("APK is incompatible with the device")
+)
+
+func malformed(format string, args ...any) error {
+	return fmt.Errorf("%w: %s", ErrInvalid, fmt.Sprintf(format, args...))
+}
+
+// 读取 APK 中各文件的大小上限，防止压缩炸弹
+const (
+	maxManifestBytes = 8 << 20
+	maxTableBytes    = 128 << 20
+	maxIconBytes     = 2 << 20
+)
+
+// android 命名空间中属性的资源 ID (android.R.attr)，混淆后的属性名可能为空，因此按 ID 匹配
+const (
+	attrLabel            = 0x01010001
+	attrIcon             = 0x01010002
+	attrName             = 0x01010003
+	attrDebuggable       = 0x0101000f
+	attrMinSdkVersion    = 0x0101020c
+	attrVersionCode      = 0x0101021b
+	attrVersionName      = 0x0101021c
+	attrTargetSdkVersion = 0x01010270
+	attrMaxSdkVersion    = 0x01010271
+	attrDrawable         = 0x01010199
+	attrRoundIcon        = 0x0101052c
+	attrVersionCodeMajor = 0x01010576
+	attrIsFeatureSplit   = 0x0101055b
+)
+
+// Info 是从 APK 中解析出的元数据
+type Info struct {
+	PackageName string `json:"packageName"`
+	VersionCode int64  `json:"versionCode"`
+	VersionName string `json:"versionName"`
+	// Split 是拆分 APK 的名称 (例如 config.arm64_v8a)，基础 APK 为空
+	Split          string `json:"split,omitempty"`
+	IsFeatureSplit bool   `json:"isFeatureSplit,omitempty"`
+	MinSDK         int    `json:"minSdk"`
+	TargetSDK      int    `json:"targetSdk"`
+	MaxSDK         int    `json:"maxSdk,omitempty"`
+	// ABIs 是 lib/ 下原生库的 ABI，没有原生库时为空 (可以安装在任何 ABI 的设备上)
+	ABIs        []string `json:"abis"`
+	Permissions []string `json:"permissions"`
+	Label       string   `json:"label"`
+	Debuggable  bool     `json:"debuggable"`
+	// IconPath 是图标在 APK 中的路径，IconData 是其内容；只有位图图标 (PNG/WebP/JPEG) 会被读取
+	IconPath string `json:"iconPath,omitempty"`
+	IconData []byte `json:"-"`
+}
+
+// ParseFile 解析服务器上的 APK 文件
+func ParseFile(name string) (*Info, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	st, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	return Parse(f, st.Size())
+}
+
+// Parse 解析 APK (zip) 的元数据；不是 zip 或缺少 AndroidManifest.xml 时返回 ErrInvalid
+func Parse(r io.ReaderAt, size int64) (*Info, error) {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalid, err)
+	}
+	files := make(map[string]*zip.File, len(zr.File))
+	for _, f := range zr.File {
+		files[f.Name] = f
+	}
+	manifest, ok := files["AndroidManifest.xml"]
+	if !ok {
+		return nil, malformed("AndroidManifest.xml not found")
+	}
+	data, err := readZipFile(manifest, maxManifestBytes)
+	if err != nil {
+		return nil, err
+	}
+	elements, err := parseXML(data)
+	if err != nil {
+		return nil, err
+	}
+
+	// 拆分 APK 可能没有 resources.arsc，此时引用类型的属性无法解析
+	var table *resTable
+	if f, ok := files["resources.arsc"]; ok {
+		data, err := readZipFile(f, maxTableBytes)
+		if err != nil {
+			return nil, err
+		}
+		if table, err = parseTable(data); err != nil {
+			return nil, err
+		}
+	}
+
+	info := &Info{ABIs: nativeABIs(zr.File), Permissions: []string{}}
+	var iconID uint32
+	for i := range elements {
+		e := &elements[i]
+		switch {
+		case e.depth == 0 && e.name == "manifest":
+			if a := e.attr(0, "package"); a != nil {
+				info.PackageName = a.str()
+			}
+			if a := e.attr(0, "split"); a != nil {
+				info.Split = a.str()
+			}
+			if a := e.attr(attrIsFeatureSplit, "isFeatureSplit"); a != nil {
+				info.IsFeatureSplit = a.bool()
+			}
+			if a := e.attr(attrVersionCode, "versionCode"); a != nil {
+				v, _ := a.int()
+				info.VersionCode = int64(uint32(v))
+			}
+			if a := e.attr(attrVersionCodeMajor, "versionCodeMajor"); a != nil {
+				major, _ := a.int()
+				info.VersionCode |= major << 32
+			}
+			if a := e.attr(attrVersionName, "versionName"); a != nil {
+				info.VersionName = table.text(a)
+			}
+		case e.depth == 1 && e.name == "uses-sdk":
+			info.MinSDK = sdkVersion(e.attr(attrMinSdkVersion, "minSdkVersion"))
+			info.TargetSDK = sdkVersion(e.attr(attrTargetSdkVersion, "targetSdkVersion"))
+			info.MaxSDK = sdkVersion(e.attr(attrMaxSdkVersion, "maxSdkVersion"))
+		case e.depth == 1 && (e.name == "uses-permission" || e.name == "uses-permission-sdk-23"):
+			if a := e.attr(attrName, "name"); a != nil && a.str() != "" {
+				info.Permissions = append(info.Permissions, a.str())
+			}
+		case e.depth == 1 && e.name == "application":
+			if a := e.attr(attrLabel, "label"); a != nil {
+				info.Label = table.text(a)
+			}
+			if a := e.attr(attrDebuggable, "debuggable"); a != nil {
+				info.Debuggable = a.bool()
+			}
+			// 优先使用 icon，只有 roundIcon 时使用它
+			if a := e.attr(attrIcon, "icon"); a != nil && a.value.dataType == typeReference {
+				iconID = a.value.data
+			} else if a := e.attr(attrRoundIcon, "roundIcon"); a != nil && a.value.dataType == typeReference {
+				iconID = a.value.data
+			}
+		}
+	}
+	if info.PackageName == "" {
+		return nil, malformed("manifest has no package name")
+	}
+	// 与 PackageManager 的默认值一致: 未声明 minSdkVersion 时为 1，未声明 targetSdkVersion 时等于 minSdkVersion
+	if info.MinSDK == 0 {
+		info.MinSDK = 1
+	}
+	if info.TargetSDK == 0 {
+		info.TargetSDK = info.MinSDK
+	}
+	info.Permissions = uniqueSorted(info.Permissions)
+	if iconID != 0 && table != nil {
+		info.IconPath = findIcon(table, files, iconID)
+		if info.IconPath != "" {
+			info.IconData, _ = readZipFile(files[info.IconPath], maxIconBytes)
+		}
+	}
+	return info, nil
+}
+
+// readZipFile 读取 zip 中不超过 max 字节的文件
+func readZipFile(f *zip.File, max int64) ([]byte, error) {
+	if f.UncompressedSize64 > uint64(max) {
+		return nil, malformed("%s is too large (%d bytes)", f.Name, f.UncompressedSize64)
+	}
+	rc, err := f.Open()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s: %v", ErrInvalid, f.Name, err)
+	}
+	defer rc.Close()
+	data, err := io.ReadAll(io.LimitReader(rc, max+1))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s: %v", ErrInvalid, f.Name, err)
+	}
+	if int64(len(data)) > max {
+		return nil, malformed("%s is too large", f.Name)
+	}
+	return data, nil
+}
+
+// text 返回属性的文本值，引用字符串资源时从资源表中解析
+func (t *resTable) text(a *xmlAttr) string {
+	if a.value.dataType == typeReference && t != nil {
+		return t.defaultString(a.value.data)
+	}
+	return a.str()
+}
+
+// sdkVersion 解析 SDK 版本属性；预览版的代号 (例如 "VanillaIceCream") 按开发中版本 10000 处理
+func sdkVersion(a *xmlAttr) int {
+	if a == nil {
+		return 0
+	}
+	if n, ok := a.int(); ok {
+		return int(n)
+	}
+	if a.raw != "" {
+		return 10000
+	}
+	return 0
+}
+
+// nativeABIs 返回 lib/<abi>/ 下包含 .so 文件的 ABI
+func nativeABIs(files []*zip.File) []string {
+	var abis []string
+	for _, f := range files {
+		parts := strings.Split(f.Name, "/")
+		if len(parts) == 3 && parts[0] == "lib" && parts[1] != "" && strings.HasSuffix(parts[2], ".so") {
+			abis = append(abis, parts[1])
+		}
+	}
+	return uniqueSorted(abis)
+}
+
+func uniqueSorted(items []string) []string {
+	sort.Strings(items)
+	out := items[:0]
+	for _, s := range items {
+		if len(out) == 0 || s != out[len(out)-1] {
+			out = append(out, s)
+		}
+	}
+	if out == nil {
+		return []string{}
+	}
+	return out
+}
+
+// rasterIcon 判断文件是否为可以直接显示的位图
+func rasterIcon(name string) bool {
+	switch strings.ToLower(path.Ext(name)) {
+	case ".png", ".webp", ".jpg", ".jpeg":
+		return true
+	}
+	return false
+}
+
+// densityRank 返回选择图标时配置的优先级，密度越高越优先，anydpi 与 nodpi 排在最后
+func densityRank(d uint16) int {
+	switch d {
+	case densityDefault:
+		return densityMedium
+	case densityAny, densityNone:
+		return 0
+	}
+	return int(d)
+}
+
+// findIcon 返回图标资源中密度最高的位图文件
+// 只有自适应图标 (adaptive-icon XML) 时使用其前景图层，前景也是矢量图时返回空字符串
+func findIcon(t *resTable, files map[string]*zip.File, id uint32) string {
+	best, bestRank := "", -1
+	var xmlFiles []string
+	for _, e := range t.resolveValues(id) {
+		name, ok := t.stringValue(e.value)
+		if !ok || files[name] == nil {
+			continue
+		}
+		if !rasterIcon(name) {
+			if strings.HasSuffix(name, ".xml") {
+				xmlFiles = append(xmlFiles, name)
+			}
+			continue
+		}
+		if rank := densityRank(e.config.density); rank > bestRank {
+			best, bestRank = name, rank
+		}
+	}
+	if best != "" {
+		return best
+	}
+	for _, name := range xmlFiles {
+		data, err := readZipFile(files[name], maxManifestBytes)
+		if err != nil {
+			continue
+		}
+		elements, err := parseXML(data)
+		if err != nil {
+			continue
+		}
+		for i := range elements {
+			e := &elements[i]
+			if e.name != "foreground" {
+				continue
+			}
+			if a := e.attr(attrDrawable, "drawable"); a != nil && a.value.dataType == typeReference && a.value.data != id {
+				for _, v := range t.resolveValues(a.value.data) {
+					if s, ok := t.stringValue(v.value); ok && rasterIcon(s) && files[s] != nil {
+						if rank := densityRank(v.config.density); rank > bestRank {
+							best, bestRank = s, rank
+						}
+					}
+				}
+			}
+		}
+		if best != "" {
+			return best
+		}
+	}
+	return ""
+}
+
+// IncompatibleError 表示 APK 无法安装在目标设备上，Reason 为 pm 对应的错误码 (例如 INSTALL_FAILED_OLDER_SDK)
+type IncompatibleError struct {
+	Reason  string
+	Message string
+}
+
+func (e *IncompatibleError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Reason, e.Message)
+}
+
+// Is 使 errors.Is(err, ErrIncompatible) 成立
+func (e *IncompatibleError) Is(target error) bool {
+	return target == ErrIncompatible
+}
+
+// minTargetSDKOnAndroid14 是 Android 14 (API 34) 起允许安装的最低 targetSdkVersion
+const minTargetSDKOnAndroid14 = 23
+
+// CheckCompatibility 检查 APK 能否安装在 SDK 版本为 sdk、支持 abis (ro.product.cpu.abilist) 的设备上
+// 检查与 PackageManager 相同的条件，以便在推送之前给出明确的原因；sdk 为 0 或 abis 为空表示未知，跳过对应的检查
+func CheckCompatibility(info *Info, sdk int, abis []string) error {
+	if sdk > 0 && info.MinSDK > sdk {
+		return &IncompatibleError{
+			Reason:  "INSTALL_FAILED_OLDER_SDK",
+			Message: fmt.Sprintf("requires API level %d but the device runs API level %d", info.MinSDK, sdk),
+		}
+	}
+	if sdk >= 34 && info.TargetSDK < minTargetSDKOnAndroid14 {
+		return &IncompatibleError{
+			Reason:  "INSTALL_FAILED_DEPRECATED_SDK_VERSION",
+			Message: fmt.Sprintf("targets API level %d; Android 14 and later require at least %d", info.TargetSDK, minTargetSDKOnAndroid14),
+		}
+	}
+	if len(info.ABIs) > 0 && len(abis) > 0 {
+		for _, abi := range abis {
+			for _, have := range info.ABIs {
+				if abi == have {
+					return nil
+				}
+			}
+		}
+		return &IncompatibleError{
+			Reason:  "INSTALL_FAILED_NO_MATCHING_ABIS",
+			Message: fmt.Sprintf("contains native code for %s but the device supports %s", strings.Join(info.ABIs, ", "), strings.Join(abis, ", ")),
+		}
+	}
+	return nil
+}
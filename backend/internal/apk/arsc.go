@@ -0,0 +1,265 @@
+package apk
+
+import (
+	"sort"
+)
+
+// ResTable_type 与 ResTable_entry 的标志位
+const (
+	typeFlagSparse   = 0x01
+	typeFlagOffset16 = 0x02
+	entryFlagComplex = 0x0001
+	entryFlagCompact = 0x0008
+	noEntry          = 0xffffffff
+	noEntry16        = 0xffff
+)
+
+// 资源配置中的屏幕密度 (ResTable_config.density)
+const (
+	densityDefault = 0
+	densityMedium  = 160
+	densityAny     = 0xfffe
+	densityNone    = 0xffff
+)
+
+// resConfig 是资源配置中解析用到的部分
+type resConfig struct {
+	language string
+	country  string
+	density  uint16
+}
+
+// tableType 是一个 ResTable_type 块: 某个资源类型在一种配置下的全部条目
+type tableType struct {
+	pkgID  uint8
+	typeID uint8
+	config resConfig
+	chunk  chunk
+}
+
+// resTable 是解析后的 resources.arsc，条目在查询时才读取
+type resTable struct {
+	strings *stringPool
+	types   []tableType
+}
+
+// resEntry 是资源在一种配置下的值
+type resEntry struct {
+	config resConfig
+	value  resValue
+}
+
+func parseTable(b []byte) (*resTable, error) {
+	root, err := readChunk(b)
+	if err != nil {
+		return nil, err
+	}
+	if root.typ != resTableType {
+		return nil, malformed("not a resource table (chunk type 0x%04x)", root.typ)
+	}
+	t := &resTable{}
+	err = forEachChunk(root.data[root.headerSize:], func(c chunk) error {
+		switch c.typ {
+		case resStringPoolType:
+			if t.strings != nil {
+				return nil
+			}
+			p, err := parseStringPool(c)
+			if err != nil {
+				return err
+			}
+			t.strings = p
+		case resTablePackageType:
+			return t.parsePackage(c)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// parsePackage 记录 ResTable_package 中的 ResTable_type 块；类型名与键名字符串池不需要
+func (t *resTable) parsePackage(c chunk) error {
+	if len(c.data) < 12 {
+		return malformed("truncated package chunk")
+	}
+	pkgID := uint8(le.Uint32(c.data[8:]))
+	return forEachChunk(c.data[c.headerSize:], func(sub chunk) error {
+		if sub.typ != resTableTypeType {
+			return nil
+		}
+		if sub.headerSize < 20 || len(sub.data) < 20 {
+			return malformed("truncated type chunk")
+		}
+		tt := tableType{pkgID: pkgID, typeID: sub.data[8], chunk: sub}
+		// ResTable_config 从偏移 20 开始: size(4) mcc(2) mnc(2) language(2) country(2) orientation(1) touchscreen(1) density(2)
+		if cfg := sub.data[20:sub.headerSize]; len(cfg) >= 16 {
+			tt.config = resConfig{
+				language: unpackLocale(cfg[8], cfg[9], 'a'),
+				country:  unpackLocale(cfg[10], cfg[11], '0'),
+				density:  le.Uint16(cfg[14:]),
+			}
+		}
+		t.types = append(t.types, tt)
+		return nil
+	})
+}
+
+// unpackLocale 解码 ResTable_config 中的语言或地区代码，三个字母的代码以 5 位一组压缩在两个字节中
+func unpackLocale(b0, b1 byte, base byte) string {
+	if b0 == 0 {
+		return ""
+	}
+	if b0&0x80 == 0 {
+		return string([]byte{b0, b1})
+	}
+	first := b1 & 0x1f
+	second := (b1&0xe0)>>5 | (b0&0x03)<<3
+	third := (b0 & 0x7c) >> 2
+	return string([]byte{first + base, second + base, third + base})
+}
+
+// entry 读取块中第 idx 个条目的值，条目不存在或是复杂条目 (样式、数组等) 时返回 false
+func (tt *tableType) entry(idx uint16) (resValue, bool) {
+	data := tt.chunk.data
+	flags := data[9]
+	count := int(le.Uint32(data[12:]))
+	entriesStart := uint64(le.Uint32(data[16:]))
+	offsets := data[tt.chunk.headerSize:]
+
+	var offset uint64
+	switch {
+	case flags&typeFlagSparse != 0:
+		// 稀疏类型: (条目索引 uint16, 偏移/4 uint16) 按索引排序
+		if len(offsets) < count*4 {
+			return resValue{}, false
+		}
+		i := sort.Search(count, func(i int) bool { return le.Uint16(offsets[i*4:]) >= idx })
+		if i == count || le.Uint16(offsets[i*4:]) != idx {
+			return resValue{}, false
+		}
+		offset = uint64(le.Uint16(offsets[i*4+2:])) * 4
+	case flags&typeFlagOffset16 != 0:
+		if int(idx) >= count || len(offsets) < count*2 {
+			return resValue{}, false
+		}
+		off := le.Uint16(offsets[int(idx)*2:])
+		if off == noEntry16 {
+			return resValue{}, false
+		}
+		offset = uint64(off) * 4
+	default:
+		if int(idx) >= count || len(offsets) < count*4 {
+			return resValue{}, false
+		}
+		off := le.Uint32(offsets[int(idx)*4:])
+		if off == noEntry {
+			return resValue{}, false
+		}
+		offset = uint64(off)
+	}
+
+	pos := entriesStart + offset
+	if pos+8 > uint64(len(data)) {
+		return resValue{}, false
+	}
+	e := data[pos:]
+	size := uint64(le.Uint16(e))
+	eflags := le.Uint16(e[2:])
+	switch {
+	case eflags&entryFlagCompact != 0:
+		// 紧凑条目: key(2) flags(2，高 8 位为数据类型) data(4)
+		return resValue{dataType: uint8(eflags >> 8), data: le.Uint32(e[4:])}, true
+	case eflags&entryFlagComplex != 0:
+		return resValue{}, false
+	}
+	if size+8 > uint64(len(e)) {
+		return resValue{}, false
+	}
+	v := e[size:]
+	return resValue{dataType: v[3], data: le.Uint32(v[4:])}, true
+}
+
+// resolve 返回资源 ID 在各配置下的值
+func (t *resTable) resolve(id uint32) []resEntry {
+	if t == nil {
+		return nil
+	}
+	pkgID, typeID, idx := uint8(id>>24), uint8(id>>16), uint16(id)
+	var entries []resEntry
+	for i := range t.types {
+		tt := &t.types[i]
+		if tt.pkgID != pkgID || tt.typeID != typeID {
+			continue
+		}
+		if v, ok := tt.entry(idx); ok {
+			entries = append(entries, resEntry{config: tt.config, value: v})
+		}
+	}
+	return entries
+}
+
+// maxReferenceDepth 是解析资源引用链 (@string/a -> @string/b) 的最大深度
+const maxReferenceDepth = 8
+
+// maxResolvedValues 是 resolveValues 返回的值的数量上限，防止构造的资源表让结果无限增长
+const maxResolvedValues = 256
+
+// resolveValues 解析资源 ID，沿引用链展开，返回各配置下的最终值
+// 每个 ID 只展开一次，避免循环引用或多个配置指向同一资源时按配置数的指数级展开
+func (t *resTable) resolveValues(id uint32) []resEntry {
+	var out []resEntry
+	visited := make(map[uint32]bool)
+	var walk func(id uint32, depth int)
+	walk = func(id uint32, depth int) {
+		if visited[id] {
+			return
+		}
+		visited[id] = true
+		for _, e := range t.resolve(id) {
+			if len(out) >= maxResolvedValues {
+				return
+			}
+			if e.value.dataType == typeReference && depth < maxReferenceDepth {
+				walk(e.value.data, depth+1)
+				continue
+			}
+			out = append(out, e)
+		}
+	}
+	walk(id, 0)
+	return out
+}
+
+// stringValue 返回值对应的字符串，值不是字符串时返回 false
+func (t *resTable) stringValue(v resValue) (string, bool) {
+	if v.dataType != typeString {
+		return "", false
+	}
+	return t.strings.get(v.data), true
+}
+
+// defaultString 返回字符串资源在默认配置下的值，没有默认配置时依次使用英语与第一个配置的值
+func (t *resTable) defaultString(id uint32) string {
+	var english, first string
+	for _, e := range t.resolveValues(id) {
+		s, ok := t.stringValue(e.value)
+		if !ok {
+			continue
+		}
+		switch {
+		case e.config.language == "":
+			return s
+		case e.config.language == "en" && english == "":
+			english = s
+		case first == "":
+			first = s
+		}
+	}
+	if english != "" {
+		return english
+	}
+	return first
+}
@@ -0,0 +1,78 @@
+package apk
+
+import (
+	"testing"
+	"time"
+)
+
+// testTypeChunk 构造一个 ResTable_type 块，values[i] 以紧凑条目保存为第 i 个条目
+func testTypeChunk(typeID uint8, language string, values []resValue) tableType {
+	const headerSize = 20 + 16
+	entriesStart := headerSize + len(values)*4
+	data := make([]byte, entriesStart+len(values)*8)
+	le.PutUint16(data, resTableTypeType)
+	le.PutUint16(data[2:], headerSize)
+	le.PutUint32(data[4:], uint32(len(data)))
+	data[8] = typeID
+	le.PutUint32(data[12:], uint32(len(values)))
+	le.PutUint32(data[16:], uint32(entriesStart))
+	copy(data[28:30], language)
+	for i, v := range values {
+		le.PutUint32(data[headerSize+i*4:], uint32(i*8))
+		e := data[entriesStart+i*8:]
+		le.PutUint16(e, 8)
+		le.PutUint16(e[2:], entryFlagCompact|uint16(v.dataType)<<8)
+		le.PutUint32(e[4:], v.data)
+	}
+	return tableType{pkgID: 0x7f, typeID: typeID, config: resConfig{language: language}, chunk: chunk{typ: resTableTypeType, headerSize: headerSize, data: data}}
+}
+
+func testResID(typeID uint8, idx int) uint32 {
+	return 0x7f<<24 | uint32(typeID)<<16 | uint32(idx)
+}
+
+func TestResolveValuesFollowsReferences(t *testing.T) {
+	table := &resTable{types: []tableType{
+		testTypeChunk(1, "", []resValue{
+			{dataType: typeReference, data: testResID(1, 1)},
+			{dataType: typeString, data: 7},
+		}),
+		testTypeChunk(1, "de", []resValue{
+			{dataType: typeString, data: 8},
+			{dataType: typeString, data: 9},
+		}),
+	}}
+	got := table.resolveValues(testResID(1, 0))
+	want := []uint32{7, 9, 8}
+	if len(got) != len(want) {
+		t.Fatalf("resolveValues = %+v, want data %v", got, want)
+	}
+	for i := range want {
+		if got[i].value.dataType != typeString || got[i].value.data != want[i] {
+			t.Errorf("value %d = %+v, want string %d", i, got[i].value, want[i])
+		}
+	}
+}
+
+// TestResolveValuesCycles 中每个配置的每个条目都引用其他全部条目，逐层展开会产生 配置数^深度 个值
+func TestResolveValuesCycles(t *testing.T) {
+	const configs, entries = 32, 8
+	var table resTable
+	for c := 0; c < configs; c++ {
+		values := make([]resValue, entries)
+		for i := range values {
+			values[i] = resValue{dataType: typeReference, data: testResID(1, (i+c)%entries)}
+		}
+		table.types = append(table.types, testTypeChunk(1, string(rune('a'+c%26))+"x", values))
+	}
+	table.types = append(table.types, testTypeChunk(1, "", []resValue{{dataType: typeString, data: 1}}))
+
+	start := time.Now()
+	got := table.resolveValues(testResID(1, 0))
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("resolveValues took %s", elapsed)
+	}
+	if len(got) > maxResolvedValues {
+		t.Errorf("resolveValues returned %d values, want at most %d", len(got), maxResolvedValues)
+	}
+}
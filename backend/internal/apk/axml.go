@@ -0,0 +1,150 @@
+package apk
+
+import (
+	"strconv"
+)
+
+// noIndex 表示字符串池索引为空 (ResStringPool_ref 的 0xffffffff)
+const noIndex = 0xffffffff
+
+// xmlAttr 是二进制 XML 中元素的一个属性
+type xmlAttr struct {
+	name  string
+	resID uint32 // 属性名对应的资源 ID (例如 android:versionCode 为 0x0101021b)，没有时为 0
+	raw   string // 原始字符串值
+	value resValue
+}
+
+// str 返回属性的文本值，非字符串的值按类型格式化
+func (a *xmlAttr) str() string {
+	if a.raw != "" || a.value.dataType == typeString {
+		return a.raw
+	}
+	return a.value.format()
+}
+
+// int 返回属性的整数值，字符串形式的数字也会被解析
+func (a *xmlAttr) int() (int64, bool) {
+	switch a.value.dataType {
+	case typeIntDec, typeIntHex:
+		return int64(int32(a.value.data)), true
+	case typeString:
+		n, err := strconv.ParseInt(a.raw, 10, 64)
+		return n, err == nil
+	}
+	return 0, false
+}
+
+// bool 返回属性的布尔值
+func (a *xmlAttr) bool() bool {
+	if a.value.dataType == typeIntBoolean {
+		return a.value.data != 0
+	}
+	return a.raw == "true"
+}
+
+// xmlElement 是二进制 XML 中的一个元素，depth 为嵌套深度 (根元素为 0)
+type xmlElement struct {
+	name  string
+	depth int
+	attrs []xmlAttr
+}
+
+// attr 查找属性: 优先按资源 ID 匹配 (属性名可能被混淆)，没有资源 ID 的属性按名称匹配
+func (e *xmlElement) attr(resID uint32, name string) *xmlAttr {
+	for i := range e.attrs {
+		a := &e.attrs[i]
+		if resID != 0 && a.resID == resID {
+			return a
+		}
+	}
+	for i := range e.attrs {
+		a := &e.attrs[i]
+		if a.resID == 0 && a.name == name {
+			return a
+		}
+	}
+	return nil
+}
+
+// parseXML 解析编译后的二进制 XML (AndroidManifest.xml 与 res/ 下的 XML)，按文档顺序返回全部元素
+func parseXML(b []byte) ([]xmlElement, error) {
+	root, err := readChunk(b)
+	if err != nil {
+		return nil, err
+	}
+	if root.typ != resXMLType {
+		return nil, malformed("not a binary XML document (chunk type 0x%04x)", root.typ)
+	}
+	var pool *stringPool
+	var resMap []uint32
+	var elements []xmlElement
+	depth := 0
+	err = forEachChunk(root.data[root.headerSize:], func(c chunk) error {
+		switch c.typ {
+		case resStringPoolType:
+			p, err := parseStringPool(c)
+			if err != nil {
+				return err
+			}
+			pool = p
+		case resXMLResourceMapType:
+			body := c.data[c.headerSize:]
+			resMap = make([]uint32, len(body)/4)
+			for i := range resMap {
+				resMap[i] = le.Uint32(body[i*4:])
+			}
+		case resXMLStartElementType:
+			e, err := parseStartElement(c, pool, resMap)
+			if err != nil {
+				return err
+			}
+			e.depth = depth
+			depth++
+			elements = append(elements, e)
+		case resXMLEndElementType:
+			if depth > 0 {
+				depth--
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return elements, nil
+}
+
+// parseStartElement 解析 ResXMLTree_attrExt 及其后的属性
+func parseStartElement(c chunk, pool *stringPool, resMap []uint32) (xmlElement, error) {
+	if c.headerSize < xmlElementNodeHeaderSize || len(c.data) < c.headerSize+20 {
+		return xmlElement{}, malformed("truncated start element")
+	}
+	ext := c.data[c.headerSize:]
+	e := xmlElement{name: pool.get(le.Uint32(ext[4:]))}
+	attrStart := int(le.Uint16(ext[8:]))
+	attrSize := int(le.Uint16(ext[10:]))
+	attrCount := int(le.Uint16(ext[12:]))
+	if attrSize < 20 || attrStart+attrSize*attrCount > len(ext) {
+		return xmlElement{}, malformed("element %q has invalid attributes", e.name)
+	}
+	e.attrs = make([]xmlAttr, attrCount)
+	for i := range e.attrs {
+		a := ext[attrStart+i*attrSize:]
+		nameIdx := le.Uint32(a[4:])
+		attr := xmlAttr{
+			name:  pool.get(nameIdx),
+			value: resValue{dataType: a[15], data: le.Uint32(a[16:])},
+		}
+		if uint64(nameIdx) < uint64(len(resMap)) {
+			attr.resID = resMap[nameIdx]
+		}
+		if raw := le.Uint32(a[8:]); raw != noIndex {
+			attr.raw = pool.get(raw)
+		} else if attr.value.dataType == typeString {
+			attr.raw = pool.get(attr.value.data)
+		}
+		e.attrs[i] = attr
+	}
+	return e, nil
+}
@@ -0,0 +1,520 @@
+package apk
+
+import (
+	"archive/zip"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// maxBundleBytes 是从一个安装包中解压出的文件总大小上限 (OBB 扩展文件可能有数 GB)
+const maxBundleBytes = 8 << 30
+
+// BundleFile 是安装集合中的一个文件，Name 是上传的文件名或在安装包中的路径，Path 是服务器上的文件
+type BundleFile struct {
+	Name string `json:"name"`
+	Path string `json:"-"`
+	Size int64  `json:"size"`
+}
+
+// BundleAPK 是安装集合中的一个 APK 及其元数据
+type BundleAPK struct {
+	BundleFile
+	*Info
+	// variant 是 .apks 的 toc.pb 中记录的变体，没有 toc.pb 时为 nil
+	variant *tocVariant
+}
+
+// Bundle 是一次安装所用的一组文件: 基础 APK、拆分 APK 与 OBB 扩展文件
+// 文件来自上传的多个 APK，或从 .apks (bundletool)、.xapk 与 zip 安装包中解压
+type Bundle struct {
+	APKs []*BundleAPK  `json:"apks"`
+	OBBs []*BundleFile `json:"obbs"`
+	dir  string
+	seq  int
+}
+
+// NewBundle 创建空的安装集合，解压出的文件保存在 dir 中，由调用者在使用后删除
+func NewBundle(dir string) *Bundle {
+	return &Bundle{APKs: []*BundleAPK{}, OBBs: []*BundleFile{}, dir: dir}
+}
+
+// Add 将服务器上的文件加入集合: .apk 被解析，.obb 作为扩展文件，.apks、.xapk 与 .zip 解压后逐个加入
+func (b *Bundle) Add(name string, localPath string) error {
+	switch strings.ToLower(path.Ext(name)) {
+	case ".apk":
+		return b.addAPK(name, localPath)
+	case ".obb":
+		return b.addOBB(name, localPath)
+	case ".apks", ".xapk", ".zip":
+		return b.extract(name, localPath)
+	}
+	return malformed("%s: unsupported file type (expected .apk, .apks, .xapk, .zip or .obb)", name)
+}
+
+func (b *Bundle) addAPK(name string, localPath string) error {
+	st, err := os.Stat(localPath)
+	if err != nil {
+		return err
+	}
+	info, err := ParseFile(localPath)
+	if err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	b.APKs = append(b.APKs, &BundleAPK{BundleFile: BundleFile{Name: name, Path: localPath, Size: st.Size()}, Info: info})
+	return nil
+}
+
+func (b *Bundle) addOBB(name string, localPath string) error {
+	st, err := os.Stat(localPath)
+	if err != nil {
+		return err
+	}
+	b.OBBs = append(b.OBBs, &BundleFile{Name: name, Path: localPath, Size: st.Size()})
+	return nil
+}
+
+// extract 解压安装包中的 .apk 与 .obb 文件，bundletool 的 toc.pb 用于划分变体 (manifest.json、图标等被忽略)
+// 解压出的文件按序号命名，不使用包内的路径，避免路径穿越
+func (b *Bundle) extract(name string, localPath string) error {
+	zr, err := zip.OpenReader(localPath)
+	if err != nil {
+		return fmt.Errorf("%w: %s: %v", ErrInvalid, name, err)
+	}
+	defer zr.Close()
+	var toc map[string]tocVariant
+	for _, f := range zr.File {
+		if f.Name != "toc.pb" {
+			continue
+		}
+		// toc.pb 无法读取时 Select 按各基础 APK 的 minSdk 划分变体
+		if data, err := readZipFile(f, maxTOCBytes); err == nil {
+			if toc, err = parseTOC(data); err != nil {
+				toc = nil
+			}
+		}
+	}
+	var total uint64
+	found := false
+	for _, f := range zr.File {
+		ext := strings.ToLower(path.Ext(f.Name))
+		if f.FileInfo().IsDir() || (ext != ".apk" && ext != ".obb") {
+			continue
+		}
+		total += f.UncompressedSize64
+		if total > maxBundleBytes {
+			return malformed("%s is too large when extracted", name)
+		}
+		dst, err := b.extractFile(f, ext)
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		if ext == ".apk" {
+			err = b.addAPK(f.Name, dst)
+			if v, ok := toc[f.Name]; ok && err == nil {
+				b.APKs[len(b.APKs)-1].variant = &v
+			}
+		} else {
+			err = b.addOBB(f.Name, dst)
+		}
+		if err != nil {
+			return err
+		}
+		found = true
+	}
+	if !found {
+		return malformed("%s contains no APK files", name)
+	}
+	return nil
+}
+
+func (b *Bundle) extractFile(f *zip.File, ext string) (string, error) {
+	b.seq++
+	dst := filepath.Join(b.dir, fmt.Sprintf("bundle_%03d%s", b.seq, ext))
+	rc, err := f.Open()
+	if err != nil {
+		return "", fmt.Errorf("%w: %s: %v", ErrInvalid, f.Name, err)
+	}
+	defer rc.Close()
+	out, err := os.Create(dst)
+	if err != nil {
+		return "", err
+	}
+	n, err := io.Copy(out, io.LimitReader(rc, int64(f.UncompressedSize64)+1))
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return "", fmt.Errorf("%w: %s: %v", ErrInvalid, f.Name, err)
+	}
+	if uint64(n) != f.UncompressedSize64 {
+		return "", malformed("%s: size does not match the zip header", f.Name)
+	}
+	return dst, nil
+}
+
+// Selection 是为设备选中的 APK，Base 同时也是 APKs 的第一个
+type Selection struct {
+	Base    *BundleAPK   `json:"base"`
+	APKs    []*BundleAPK `json:"apks"`
+	Skipped []*BundleAPK `json:"skipped"`
+}
+
+// 配置拆分 (config.<qualifier>) 的限定符
+var (
+	splitABIs = map[string]string{
+		"armeabi":     "armeabi",
+		"armeabi_v7a": "armeabi-v7a",
+		"arm64_v8a":   "arm64-v8a",
+		"x86":         "x86",
+		"x86_64":      "x86_64",
+		"mips":        "mips",
+		"mips64":      "mips64",
+		"riscv64":     "riscv64",
+	}
+	splitDensities = map[string]int{
+		"ldpi":    120,
+		"mdpi":    160,
+		"tvdpi":   213,
+		"hdpi":    240,
+		"xhdpi":   320,
+		"xxhdpi":  480,
+		"xxxhdpi": 640,
+	}
+	splitLanguage = regexp.MustCompile(`^[a-z]{2,3}$`)
+)
+
+// bundlePackageDirs 是 .apks 中只在没有拆分 APK 时使用的目录: 独立 APK (Android 5.0 之前) 与免安装应用
+var bundlePackageDirs = []string{"standalones/", "instant/"}
+
+// Select 按设备的 SDK 版本、ABI (按优先级)、屏幕密度与语言区域选择要安装的 APK，规则与 bundletool install-apks 一致:
+// .apks 中有多个变体 (每个变体一套基础 APK 与拆分) 时先选择 min SDK 不高于设备的最高变体；
+// 基础 APK 与功能拆分全部安装；每个基础/功能拆分的 ABI 拆分选择设备最优先的 ABI，密度拆分选择最接近的密度，语言拆分选择设备语言
+// 其他配置拆分 (例如纹理格式) 全部安装；设备信息未知 (0 或空) 时对应类别的拆分全部安装
+func (b *Bundle) Select(sdk int, abis []string, density int, locales []string) (*Selection, error) {
+	hasSplits := false
+	for _, a := range b.APKs {
+		if a.Split != "" {
+			hasSplits = true
+		}
+	}
+	sel := &Selection{APKs: []*BundleAPK{}, Skipped: []*BundleAPK{}}
+	var bases, splits []*BundleAPK
+	for _, a := range b.APKs {
+		switch {
+		case hasSplits && inPackageDir(a.Name):
+			sel.Skipped = append(sel.Skipped, a)
+		case a.Split == "":
+			bases = append(bases, a)
+		default:
+			splits = append(splits, a)
+		}
+	}
+
+	if hasSplits && len(bases) > 1 {
+		chosenBases, chosenSplits, others, err := chooseVariant(bases, splits, sdk)
+		if err != nil {
+			return nil, err
+		}
+		bases, splits = chosenBases, chosenSplits
+		sel.Skipped = append(sel.Skipped, others...)
+	}
+
+	switch {
+	case len(bases) == 0 && len(splits) > 0:
+		return nil, malformed("no base APK among %d split APKs", len(splits))
+	case len(bases) == 0:
+		return nil, malformed("no APK files")
+	case len(bases) > 1 && (hasSplits || !allInPackageDir(bases)):
+		return nil, malformed("more than one base APK (%s, %s)", bases[0].Name, bases[1].Name)
+	}
+	base, err := chooseBase(bases, sdk, abis)
+	if err != nil {
+		return nil, err
+	}
+	for _, a := range bases {
+		if a != base {
+			sel.Skipped = append(sel.Skipped, a)
+		}
+	}
+	if err := CheckCompatibility(base.Info, sdk, abis); err != nil {
+		return nil, err
+	}
+	sel.Base = base
+	sel.APKs = append(sel.APKs, base)
+
+	names := map[string]bool{}
+	groups := map[string][]*BundleAPK{}
+	var groupKeys []string
+	for _, a := range splits {
+		if a.PackageName != base.PackageName || a.VersionCode != base.VersionCode {
+			return nil, malformed("split %s is for %s (version %d), but the base APK is %s (version %d)", a.Name, a.PackageName, a.VersionCode, base.PackageName, base.VersionCode)
+		}
+		if names[a.Split] {
+			return nil, malformed("more than one APK for split %s", a.Split)
+		}
+		names[a.Split] = true
+		parent, qualifier, ok := configSplit(a.Split)
+		if !ok {
+			sel.APKs = append(sel.APKs, a)
+			continue
+		}
+		kind := "other"
+		switch {
+		case splitABIs[qualifier] != "":
+			kind = "abi"
+		case splitDensities[qualifier] != 0:
+			kind = "density"
+		case splitLanguage.MatchString(qualifier):
+			kind = "language"
+		}
+		key := parent + "\x00" + kind
+		if groups[key] == nil {
+			groupKeys = append(groupKeys, key)
+		}
+		groups[key] = append(groups[key], a)
+	}
+
+	languages := map[string]bool{}
+	for _, l := range locales {
+		// zh-CN、zh-Hans-CN 与 zh_CN 的语言都是 zh
+		if lang, _, _ := strings.Cut(strings.ReplaceAll(l, "_", "-"), "-"); lang != "" {
+			languages[strings.ToLower(lang)] = true
+		}
+	}
+	for _, key := range groupKeys {
+		group := groups[key]
+		var chosen []*BundleAPK
+		switch kind := key[strings.IndexByte(key, 0)+1:]; {
+		case kind == "abi" && len(abis) > 0:
+			a := bestABISplit(group, abis)
+			if a == nil {
+				var have []string
+				for _, g := range group {
+					_, q, _ := configSplit(g.Split)
+					have = append(have, splitABIs[q])
+				}
+				return nil, &IncompatibleError{
+					Reason:  "INSTALL_FAILED_NO_MATCHING_ABIS",
+					Message: fmt.Sprintf("split APKs contain native code for %s but the device supports %s", strings.Join(uniqueSorted(have), ", "), strings.Join(abis, ", ")),
+				}
+			}
+			chosen = []*BundleAPK{a}
+		case kind == "density" && density > 0:
+			chosen = []*BundleAPK{bestDensitySplit(group, density)}
+		case kind == "language" && len(languages) > 0:
+			for _, a := range group {
+				if _, q, _ := configSplit(a.Split); languages[q] {
+					chosen = append(chosen, a)
+				}
+			}
+		default:
+			chosen = group
+		}
+		for _, a := range group {
+			if containsAPK(chosen, a) {
+				sel.APKs = append(sel.APKs, a)
+			} else {
+				sel.Skipped = append(sel.Skipped, a)
+			}
+		}
+	}
+	splitAPKs := sel.APKs[1:]
+	sort.SliceStable(splitAPKs, func(i, j int) bool { return splitAPKs[i].Split < splitAPKs[j].Split })
+	return sel, nil
+}
+
+func inPackageDir(name string) bool {
+	for _, dir := range bundlePackageDirs {
+		if strings.HasPrefix(name, dir) {
+			return true
+		}
+	}
+	return false
+}
+
+func allInPackageDir(apks []*BundleAPK) bool {
+	for _, a := range apks {
+		if !inPackageDir(a.Name) {
+			return false
+		}
+	}
+	return true
+}
+
+// apkVariant 是 bundletool 生成的一个变体: 一个基础 APK 及其拆分
+type apkVariant struct {
+	key    int // toc.pb 中的变体编号，没有 toc.pb 时为 minSdk
+	minSDK int
+	apks   []*BundleAPK
+}
+
+// chooseVariant 在多个变体中选择 min SDK 不高于设备 SDK 的最高变体 (同一 min SDK 时选择编号较大的)，
+// 返回其基础 APK 与拆分，以及其他变体的全部 APK；没有可用的变体时选择 min SDK 最低的，由兼容性检查报告原因
+// 变体按 toc.pb 划分；没有 toc.pb 时按 minSdk 划分，bundletool 把变体的最低版本写入其中每个 APK 的清单
+func chooseVariant(bases []*BundleAPK, splits []*BundleAPK, sdk int) (chosenBases []*BundleAPK, chosenSplits []*BundleAPK, others []*BundleAPK, err error) {
+	useTOC := true
+	for _, a := range append(append([]*BundleAPK(nil), bases...), splits...) {
+		if a.variant == nil {
+			useTOC = false
+		}
+	}
+	keyOf := func(a *BundleAPK) int {
+		if useTOC {
+			return a.variant.number
+		}
+		return a.MinSDK
+	}
+
+	variants := map[int]*apkVariant{}
+	for _, a := range bases {
+		key := keyOf(a)
+		if v := variants[key]; v != nil {
+			return nil, nil, nil, malformed("more than one base APK (%s, %s)", v.apks[0].Name, a.Name)
+		}
+		v := &apkVariant{key: key, minSDK: a.MinSDK, apks: []*BundleAPK{a}}
+		if useTOC && a.variant.minSDK > 0 {
+			v.minSDK = a.variant.minSDK
+		}
+		variants[key] = v
+	}
+	for _, a := range splits {
+		v := variants[keyOf(a)]
+		if v == nil {
+			return nil, nil, nil, malformed("split %s does not belong to any base APK (min SDK %d)", a.Name, a.MinSDK)
+		}
+		v.apks = append(v.apks, a)
+	}
+
+	var best, lowest *apkVariant
+	for _, v := range variants {
+		if lowest == nil || v.minSDK < lowest.minSDK || (v.minSDK == lowest.minSDK && v.key < lowest.key) {
+			lowest = v
+		}
+		if sdk > 0 && v.minSDK > sdk {
+			continue
+		}
+		if best == nil || v.minSDK > best.minSDK || (v.minSDK == best.minSDK && v.key > best.key) {
+			best = v
+		}
+	}
+	if best == nil || sdk <= 0 {
+		// 设备版本未知时选择兼容性最好的变体
+		best = lowest
+	}
+	for _, a := range append(append([]*BundleAPK(nil), bases...), splits...) {
+		switch {
+		case !containsAPK(best.apks, a):
+			others = append(others, a)
+		case a.Split == "":
+			chosenBases = append(chosenBases, a)
+		default:
+			chosenSplits = append(chosenSplits, a)
+		}
+	}
+	return chosenBases, chosenSplits, others, nil
+}
+
+// chooseBase 在 .apks 的多个独立 APK 中选择能安装在设备上、ABI 最优先的一个；都不兼容时返回第一个 APK 的不兼容原因
+func chooseBase(bases []*BundleAPK, sdk int, abis []string) (*BundleAPK, error) {
+	if len(bases) == 1 {
+		return bases[0], nil
+	}
+	var best *BundleAPK
+	bestRank := -1
+	var firstErr error
+	for _, a := range bases {
+		if err := CheckCompatibility(a.Info, sdk, abis); err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		// 没有原生库的 APK 排在所有 ABI 匹配的 APK 之后
+		rank := len(abis)
+		for i, abi := range abis {
+			if containsString(a.ABIs, abi) {
+				rank = i
+				break
+			}
+		}
+		if best == nil || rank < bestRank {
+			best, bestRank = a, rank
+		}
+	}
+	if best == nil {
+		return nil, firstErr
+	}
+	return best, nil
+}
+
+// configSplit 解析配置拆分的名称: config.xxhdpi 属于基础 APK，feature.config.arm64_v8a 属于功能拆分 feature
+func configSplit(split string) (parent string, qualifier string, ok bool) {
+	if q, found := strings.CutPrefix(split, "config."); found {
+		return "", q, true
+	}
+	if i := strings.LastIndex(split, ".config."); i >= 0 {
+		return split[:i], split[i+len(".config."):], true
+	}
+	return "", "", false
+}
+
+// bestABISplit 返回 ABI 在设备 abis 中最靠前的拆分，没有匹配时返回 nil
+func bestABISplit(group []*BundleAPK, abis []string) *BundleAPK {
+	for _, abi := range abis {
+		for _, a := range group {
+			if _, q, _ := configSplit(a.Split); splitABIs[q] == abi {
+				return a
+			}
+		}
+	}
+	return nil
+}
+
+// bestDensitySplit 返回与设备密度最接近的拆分: 优先选择不低于设备密度中最低的，都低于设备密度时选择最高的
+func bestDensitySplit(group []*BundleAPK, density int) *BundleAPK {
+	var above, below *BundleAPK
+	for _, a := range group {
+		_, q, _ := configSplit(a.Split)
+		d := splitDensities[q]
+		if d >= density {
+			if above == nil || d < densityOf(above) {
+				above = a
+			}
+		} else if below == nil || d > densityOf(below) {
+			below = a
+		}
+	}
+	if above != nil {
+		return above
+	}
+	return below
+}
+
+func densityOf(a *BundleAPK) int {
+	_, q, _ := configSplit(a.Split)
+	return splitDensities[q]
+}
+
+func containsAPK(list []*BundleAPK, a *BundleAPK) bool {
+	for _, x := range list {
+		if x == a {
+			return true
+		}
+	}
+	return false
+}
+
+func containsString(list []string, s string) bool {
+	for _, x := range list {
+		if x == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,364 @@
+package apk
+
+import (
+	"encoding/binary"
+	"errors"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestConfigSplit(t *testing.T) {
+	tests := []struct {
+		split     string
+		parent    string
+		qualifier string
+		ok        bool
+	}{
+		{"config.arm64_v8a", "", "arm64_v8a", true},
+		{"config.xxhdpi", "", "xxhdpi", true},
+		{"config.zh", "", "zh", true},
+		{"camera.config.x86_64", "camera", "x86_64", true},
+		{"camera.config.de", "camera", "de", true},
+		{"feature.with.dots.config.hdpi", "feature.with.dots", "hdpi", true},
+		{"camera", "", "", false},
+		{"configurator", "", "", false},
+		{"", "", "", false},
+	}
+	for _, tt := range tests {
+		parent, qualifier, ok := configSplit(tt.split)
+		if parent != tt.parent || qualifier != tt.qualifier || ok != tt.ok {
+			t.Errorf("configSplit(%q) = %q, %q, %v; want %q, %q, %v", tt.split, parent, qualifier, ok, tt.parent, tt.qualifier, tt.ok)
+		}
+	}
+}
+
+// testAPK 构造 Select 使用的 APK，不需要真实的文件
+func testAPK(name, split string, minSDK int, abis ...string) *BundleAPK {
+	return &BundleAPK{
+		BundleFile: BundleFile{Name: name},
+		Info: &Info{
+			PackageName: "com.example",
+			VersionCode: 7,
+			Split:       split,
+			MinSDK:      minSDK,
+			TargetSDK:   34,
+			ABIs:        abis,
+		},
+	}
+}
+
+// inVariant 设置 APK 在 toc.pb 中的变体
+func inVariant(a *BundleAPK, number, minSDK int) *BundleAPK {
+	a.variant = &tocVariant{number: number, minSDK: minSDK}
+	return a
+}
+
+func apkNames(apks []*BundleAPK) []string {
+	names := []string{}
+	for _, a := range apks {
+		names = append(names, a.Name)
+	}
+	return names
+}
+
+func TestSelect(t *testing.T) {
+	splitSet := func() []*BundleAPK {
+		return []*BundleAPK{
+			testAPK("splits/base-master.apk", "", 21),
+			testAPK("splits/base-arm64_v8a.apk", "config.arm64_v8a", 21, "arm64-v8a"),
+			testAPK("splits/base-armeabi_v7a.apk", "config.armeabi_v7a", 21, "armeabi-v7a"),
+			testAPK("splits/base-x86_64.apk", "config.x86_64", 21, "x86_64"),
+			testAPK("splits/base-mdpi.apk", "config.mdpi", 21),
+			testAPK("splits/base-xhdpi.apk", "config.xhdpi", 21),
+			testAPK("splits/base-xxxhdpi.apk", "config.xxxhdpi", 21),
+			testAPK("splits/base-de.apk", "config.de", 21),
+			testAPK("splits/base-zh.apk", "config.zh", 21),
+			testAPK("splits/base-etc2.apk", "config.etc2", 21),
+			testAPK("splits/camera-master.apk", "camera", 21),
+			testAPK("splits/camera-x86_64.apk", "camera.config.x86_64", 21, "x86_64"),
+			testAPK("splits/camera-arm64_v8a.apk", "camera.config.arm64_v8a", 21, "arm64-v8a"),
+			testAPK("splits/camera-de.apk", "camera.config.de", 21),
+			testAPK("standalones/standalone-arm64_v8a_xhdpi.apk", "", 19, "arm64-v8a"),
+		}
+	}
+	// multiVariant 是 bundletool 为 minSdk 21 的应用生成的两个变体: 21+ 与 31+ (例如未压缩的原生库)
+	multiVariant := func(toc bool) []*BundleAPK {
+		apks := []*BundleAPK{
+			testAPK("splits/base-master.apk", "", 21),
+			testAPK("splits/base-arm64_v8a.apk", "config.arm64_v8a", 21, "arm64-v8a"),
+			testAPK("splits/base-xhdpi.apk", "config.xhdpi", 21),
+			testAPK("splits/base-master_2.apk", "", 31),
+			testAPK("splits/base-arm64_v8a_2.apk", "config.arm64_v8a", 31, "arm64-v8a"),
+			testAPK("splits/base-xhdpi_2.apk", "config.xhdpi", 31),
+			testAPK("standalones/standalone-arm64_v8a_xhdpi.apk", "", 21, "arm64-v8a"),
+		}
+		if toc {
+			for i, a := range apks {
+				switch {
+				case i < 3:
+					inVariant(a, 1, 21)
+				case i < 6:
+					inVariant(a, 2, 31)
+				default:
+					inVariant(a, 0, 0)
+				}
+			}
+		}
+		return apks
+	}
+
+	tests := []struct {
+		name    string
+		apks    []*BundleAPK
+		sdk     int
+		abis    []string
+		density int
+		locales []string
+		want    []string
+		skipped int
+		err     error
+	}{
+		{
+			name:    "abi density and language",
+			apks:    splitSet(),
+			sdk:     34,
+			abis:    []string{"arm64-v8a", "armeabi-v7a"},
+			density: 420,
+			locales: []string{"de-DE"},
+			want: []string{
+				"splits/base-master.apk",
+				"splits/camera-master.apk",
+				"splits/camera-arm64_v8a.apk",
+				"splits/camera-de.apk",
+				"splits/base-arm64_v8a.apk",
+				"splits/base-de.apk",
+				"splits/base-etc2.apk",
+				"splits/base-xxxhdpi.apk",
+			},
+			skipped: 7,
+		},
+		{
+			name:    "second abi nearest lower density and no matching language",
+			apks:    append(splitSet(), testAPK("splits/camera-armeabi_v7a.apk", "camera.config.armeabi_v7a", 21, "armeabi-v7a")),
+			sdk:     30,
+			abis:    []string{"armeabi-v7a"},
+			density: 800,
+			locales: []string{"zh_CN"},
+			want: []string{
+				"splits/base-master.apk",
+				"splits/camera-master.apk",
+				"splits/camera-armeabi_v7a.apk",
+				"splits/base-armeabi_v7a.apk",
+				"splits/base-etc2.apk",
+				"splits/base-xxxhdpi.apk",
+				"splits/base-zh.apk",
+			},
+			skipped: 9,
+		},
+		{
+			name: "feature split without a matching abi",
+			apks: splitSet(),
+			sdk:  30,
+			abis: []string{"armeabi-v7a"},
+			err:  ErrIncompatible,
+		},
+		{
+			name: "unknown device installs every split",
+			apks: splitSet(),
+			want: []string{
+				"splits/base-master.apk",
+				"splits/camera-master.apk",
+				"splits/camera-arm64_v8a.apk",
+				"splits/camera-de.apk",
+				"splits/camera-x86_64.apk",
+				"splits/base-arm64_v8a.apk",
+				"splits/base-armeabi_v7a.apk",
+				"splits/base-de.apk",
+				"splits/base-etc2.apk",
+				"splits/base-mdpi.apk",
+				"splits/base-x86_64.apk",
+				"splits/base-xhdpi.apk",
+				"splits/base-xxxhdpi.apk",
+				"splits/base-zh.apk",
+			},
+			skipped: 1,
+		},
+		{
+			name: "standalones without splits",
+			apks: []*BundleAPK{
+				testAPK("standalones/standalone-armeabi_v7a.apk", "", 19, "armeabi-v7a"),
+				testAPK("standalones/standalone-arm64_v8a.apk", "", 19, "arm64-v8a"),
+				testAPK("standalones/standalone-x86.apk", "", 19, "x86"),
+			},
+			sdk:     20,
+			abis:    []string{"arm64-v8a", "armeabi-v7a"},
+			want:    []string{"standalones/standalone-arm64_v8a.apk"},
+			skipped: 2,
+		},
+		{
+			name:    "variants from toc on new device",
+			apks:    multiVariant(true),
+			sdk:     33,
+			abis:    []string{"arm64-v8a"},
+			density: 320,
+			want:    []string{"splits/base-master_2.apk", "splits/base-arm64_v8a_2.apk", "splits/base-xhdpi_2.apk"},
+			skipped: 4,
+		},
+		{
+			name:    "variants from toc on old device",
+			apks:    multiVariant(true),
+			sdk:     28,
+			abis:    []string{"arm64-v8a"},
+			density: 320,
+			want:    []string{"splits/base-master.apk", "splits/base-arm64_v8a.apk", "splits/base-xhdpi.apk"},
+			skipped: 4,
+		},
+		{
+			name:    "variants from minSdk without toc",
+			apks:    multiVariant(false),
+			sdk:     31,
+			abis:    []string{"arm64-v8a"},
+			density: 320,
+			want:    []string{"splits/base-master_2.apk", "splits/base-arm64_v8a_2.apk", "splits/base-xhdpi_2.apk"},
+			skipped: 4,
+		},
+		{
+			name:    "unknown sdk chooses the lowest variant",
+			apks:    multiVariant(false),
+			abis:    []string{"arm64-v8a"},
+			density: 320,
+			want:    []string{"splits/base-master.apk", "splits/base-arm64_v8a.apk", "splits/base-xhdpi.apk"},
+			skipped: 4,
+		},
+		{
+			name: "device older than every variant",
+			apks: multiVariant(true),
+			sdk:  19,
+			err:  ErrIncompatible,
+		},
+		{
+			name: "two bases for the same variant",
+			apks: []*BundleAPK{
+				testAPK("splits/base-master.apk", "", 21),
+				testAPK("splits/other-master.apk", "", 21),
+				testAPK("splits/base-xhdpi.apk", "config.xhdpi", 21),
+			},
+			sdk: 30,
+			err: ErrInvalid,
+		},
+		{
+			name: "split without a base in its variant",
+			apks: []*BundleAPK{
+				testAPK("splits/base-master.apk", "", 21),
+				testAPK("splits/base-master_2.apk", "", 31),
+				testAPK("splits/base-xhdpi.apk", "config.xhdpi", 26),
+			},
+			sdk: 30,
+			err: ErrInvalid,
+		},
+		{
+			name: "duplicate config split",
+			apks: []*BundleAPK{
+				testAPK("splits/base-master.apk", "", 21),
+				testAPK("splits/base-xhdpi.apk", "config.xhdpi", 21),
+				testAPK("splits/base-xhdpi_2.apk", "config.xhdpi", 21),
+			},
+			sdk: 30,
+			err: ErrInvalid,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sel, err := (&Bundle{APKs: tt.apks}).Select(tt.sdk, tt.abis, tt.density, tt.locales)
+			if tt.err != nil {
+				if !errors.Is(err, tt.err) {
+					t.Fatalf("Select error = %v, want %v", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Select: %v", err)
+			}
+			if got := apkNames(sel.APKs); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("APKs = %v, want %v", got, tt.want)
+			}
+			if sel.Base != sel.APKs[0] {
+				t.Errorf("Base = %s, want %s", sel.Base.Name, sel.APKs[0].Name)
+			}
+			if len(sel.Skipped) != tt.skipped {
+				t.Errorf("Skipped = %v, want %d APKs", apkNames(sel.Skipped), tt.skipped)
+			}
+			// 每个 APK 要么被选中要么被跳过
+			all := append(apkNames(sel.APKs), apkNames(sel.Skipped)...)
+			sort.Strings(all)
+			input := apkNames(tt.apks)
+			sort.Strings(input)
+			if !reflect.DeepEqual(all, input) {
+				t.Errorf("selected and skipped APKs = %v, want %v", all, input)
+			}
+		})
+	}
+}
+
+// protoBytes 与 protoVarint 按 protobuf 线格式编码一个字段
+func protoBytes(num int, fields ...[]byte) []byte {
+	var b []byte
+	for _, f := range fields {
+		b = append(b, f...)
+	}
+	out := binary.AppendUvarint(nil, uint64(num)<<3|2)
+	out = binary.AppendUvarint(out, uint64(len(b)))
+	return append(out, b...)
+}
+
+func protoVarint(num int, v uint64) []byte {
+	return binary.AppendUvarint(binary.AppendUvarint(nil, uint64(num)<<3), v)
+}
+
+func TestParseTOC(t *testing.T) {
+	variant := func(number, minSDK int, paths ...string) []byte {
+		apkSet := protoBytes(1, protoBytes(1, []byte("base")))
+		for _, p := range paths {
+			apkSet = append(apkSet, protoBytes(2, protoBytes(2, []byte(p)), protoVarint(3, 1))...)
+		}
+		var targeting []byte
+		if minSDK > 0 {
+			targeting = protoBytes(1, protoBytes(1, protoBytes(1, protoVarint(1, uint64(minSDK)))))
+		}
+		return protoBytes(1,
+			protoBytes(1, targeting),
+			protoBytes(2, apkSet),
+			protoVarint(3, uint64(number)),
+		)
+	}
+	data := append(variant(1, 21, "splits/base-master.apk", "splits/base-xhdpi.apk"), variant(2, 31, "splits/base-master_2.apk")...)
+	data = append(data, variant(0, 0, "standalones/standalone.apk")...)
+	// 未知的字段 (例如 bundletool 版本) 与定长字段被跳过
+	data = append(data, protoBytes(2, []byte("1.15.6"))...)
+	data = append(data, 0x3d, 1, 2, 3, 4)
+
+	got, err := parseTOC(data)
+	if err != nil {
+		t.Fatalf("parseTOC: %v", err)
+	}
+	want := map[string]tocVariant{
+		"splits/base-master.apk":     {number: 1, minSDK: 21},
+		"splits/base-xhdpi.apk":      {number: 1, minSDK: 21},
+		"splits/base-master_2.apk":   {number: 2, minSDK: 31},
+		"standalones/standalone.apk": {number: 0, minSDK: 0},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("parseTOC = %v, want %v", got, want)
+	}
+
+	for _, bad := range [][]byte{
+		{0x0a, 0x05, 0x01},
+		{0x08},
+		{0x0b},
+	} {
+		if _, err := parseTOC(bad); !errors.Is(err, ErrInvalid) {
+			t.Errorf("parseTOC(% x) error = %v, want ErrInvalid", bad, err)
+		}
+	}
+}
@@ -0,0 +1,205 @@
+package apk
+
+import (
+	"encoding/binary"
+	"fmt"
+	"unicode/utf16"
+)
+
+// Android 二进制资源格式 (ResourceTypes.h) 中的块类型
+const (
+	resStringPoolType        = 0x0001
+	resTableType             = 0x0002
+	resXMLType               = 0x0003
+	resXMLStartElementType   = 0x0102
+	resXMLEndElementType     = 0x0103
+	resXMLResourceMapType    = 0x0180
+	resTablePackageType      = 0x0200
+	resTableTypeType         = 0x0201
+	resChunkHeaderSize       = 8
+	stringPoolUTF8Flag       = 1 << 8
+	stringPoolMinHeaderSize  = 28
+	xmlElementNodeHeaderSize = 16
+)
+
+// Res_value 的数据类型
+const (
+	typeNull             = 0x00
+	typeReference        = 0x01
+	typeString           = 0x03
+	typeFloat            = 0x04
+	typeDynamicReference = 0x07
+	typeIntDec           = 0x10
+	typeIntHex           = 0x11
+	typeIntBoolean       = 0x12
+)
+
+var le = binary.LittleEndian
+
+// chunk 是一个资源块，data 包含块头
+type chunk struct {
+	typ        uint16
+	headerSize int
+	data       []byte
+}
+
+// readChunk 读取 b 开头的块，块头声明的大小超出 b 时返回错误
+func readChunk(b []byte) (chunk, error) {
+	if len(b) < resChunkHeaderSize {
+		return chunk{}, malformed("truncated chunk header")
+	}
+	c := chunk{typ: le.Uint16(b), headerSize: int(le.Uint16(b[2:]))}
+	size := le.Uint32(b[4:])
+	if c.headerSize < resChunkHeaderSize || uint64(size) < uint64(c.headerSize) || uint64(size) > uint64(len(b)) {
+		return chunk{}, malformed("chunk 0x%04x has invalid size %d", c.typ, size)
+	}
+	c.data = b[:size]
+	return c, nil
+}
+
+// forEachChunk 依次处理 b 中连续的块，末尾不足一个块头的填充字节被忽略
+func forEachChunk(b []byte, fn func(chunk) error) error {
+	for len(b) >= resChunkHeaderSize {
+		c, err := readChunk(b)
+		if err != nil {
+			return err
+		}
+		if err := fn(c); err != nil {
+			return err
+		}
+		b = b[len(c.data):]
+	}
+	return nil
+}
+
+// stringPool 是 ResStringPool，字符串在使用时才解码
+type stringPool struct {
+	data    []byte
+	offsets []uint32
+	start   uint32
+	utf8    bool
+	cache   map[uint32]string
+}
+
+func parseStringPool(c chunk) (*stringPool, error) {
+	if c.headerSize < stringPoolMinHeaderSize {
+		return nil, malformed("string pool header too short")
+	}
+	count := le.Uint32(c.data[8:])
+	flags := le.Uint32(c.data[16:])
+	start := le.Uint32(c.data[20:])
+	if uint64(c.headerSize)+uint64(count)*4 > uint64(len(c.data)) || uint64(start) > uint64(len(c.data)) {
+		return nil, malformed("string pool has %d strings but only %d bytes", count, len(c.data))
+	}
+	offsets := make([]uint32, count)
+	for i := range offsets {
+		offsets[i] = le.Uint32(c.data[c.headerSize+i*4:])
+	}
+	return &stringPool{
+		data:    c.data,
+		offsets: offsets,
+		start:   start,
+		utf8:    flags&stringPoolUTF8Flag != 0,
+		cache:   make(map[uint32]string),
+	}, nil
+}
+
+// get 返回第 i 个字符串，索引或数据越界时返回空字符串
+func (p *stringPool) get(i uint32) string {
+	if p == nil || uint64(i) >= uint64(len(p.offsets)) {
+		return ""
+	}
+	if s, ok := p.cache[i]; ok {
+		return s
+	}
+	pos := uint64(p.start) + uint64(p.offsets[i])
+	if pos >= uint64(len(p.data)) {
+		return ""
+	}
+	var s string
+	if p.utf8 {
+		s = decodeUTF8Entry(p.data[pos:])
+	} else {
+		s = decodeUTF16Entry(p.data[pos:])
+	}
+	p.cache[i] = s
+	return s
+}
+
+// decodeUTF8Entry 解码 UTF-8 字符串: UTF-16 长度与 UTF-8 字节数各占 1 或 2 字节，最高位表示使用 2 字节
+func decodeUTF8Entry(b []byte) string {
+	length := func() (int, bool) {
+		if len(b) < 1 {
+			return 0, false
+		}
+		n := int(b[0])
+		if n&0x80 == 0 {
+			b = b[1:]
+			return n, true
+		}
+		if len(b) < 2 {
+			return 0, false
+		}
+		n = (n&0x7f)<<8 | int(b[1])
+		b = b[2:]
+		return n, true
+	}
+	if _, ok := length(); !ok {
+		return ""
+	}
+	n, ok := length()
+	if !ok || n > len(b) {
+		return ""
+	}
+	return string(b[:n])
+}
+
+// decodeUTF16Entry 解码 UTF-16 字符串: 长度占 1 或 2 个 uint16，最高位表示使用 2 个
+func decodeUTF16Entry(b []byte) string {
+	if len(b) < 2 {
+		return ""
+	}
+	n := int(le.Uint16(b))
+	b = b[2:]
+	if n&0x8000 != 0 {
+		if len(b) < 2 {
+			return ""
+		}
+		n = (n&0x7fff)<<16 | int(le.Uint16(b))
+		b = b[2:]
+	}
+	if n*2 > len(b) {
+		return ""
+	}
+	units := make([]uint16, n)
+	for i := range units {
+		units[i] = le.Uint16(b[i*2:])
+	}
+	return string(utf16.Decode(units))
+}
+
+// resValue 是 Res_value 中的类型与数据
+type resValue struct {
+	dataType uint8
+	data     uint32
+}
+
+// format 将非字符串的值格式化为文本，与 aapt dump 的输出一致
+func (v resValue) format() string {
+	switch v.dataType {
+	case typeIntDec:
+		return fmt.Sprint(int32(v.data))
+	case typeIntHex:
+		return fmt.Sprintf("0x%x", v.data)
+	case typeIntBoolean:
+		if v.data != 0 {
+			return "true"
+		}
+		return "false"
+	case typeReference, typeDynamicReference:
+		return fmt.Sprintf("@0x%08x", v.data)
+	case typeNull:
+		return ""
+	}
+	return fmt.Sprintf("0x%08x", v.data)
+}
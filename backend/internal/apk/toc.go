@@ -0,0 +1,140 @@
+package apk
+
+import "encoding/binary"
+
+// maxTOCBytes 是 .apks 中 toc.pb 的大小上限
+const maxTOCBytes = 16 << 20
+
+// toc.pb 是 bundletool 写入 .apks 的 BuildApksResult (protobuf)，记录每个 APK 所属的变体
+// 这里只读取划分变体所需的字段，字段编号来自 bundletool 的 commands.proto 与 targeting.proto:
+//
+//	BuildApksResult     { repeated Variant variant = 1; }
+//	Variant             { VariantTargeting targeting = 1; repeated ApkSet apk_set = 2; uint32 variant_number = 3; }
+//	VariantTargeting    { SdkVersionTargeting sdk_version_targeting = 1; }
+//	SdkVersionTargeting { repeated SdkVersion value = 1; }
+//	SdkVersion          { google.protobuf.Int32Value min = 1; }  // Int32Value { int32 value = 1; }
+//	ApkSet              { repeated ApkDescription apk_description = 2; }
+//	ApkDescription      { string path = 2; }
+
+// tocVariant 是 toc.pb 中一个变体的编号与最低 SDK 版本 (没有 SDK 定位时为 0)
+type tocVariant struct {
+	number int
+	minSDK int
+}
+
+// parseTOC 返回 toc.pb 中每个 APK 路径所属的变体
+func parseTOC(data []byte) (map[string]tocVariant, error) {
+	result := make(map[string]tocVariant)
+	err := protoFields(data, func(num int, _ uint64, variant []byte) error {
+		if num != 1 {
+			return nil
+		}
+		var v tocVariant
+		var paths []string
+		err := protoFields(variant, func(num int, n uint64, b []byte) error {
+			switch num {
+			case 1:
+				sdk, err := parseVariantSDK(b)
+				v.minSDK = sdk
+				return err
+			case 2:
+				return protoFields(b, func(num int, _ uint64, desc []byte) error {
+					if num != 2 {
+						return nil
+					}
+					return protoFields(desc, func(num int, _ uint64, p []byte) error {
+						if num == 2 {
+							paths = append(paths, string(p))
+						}
+						return nil
+					})
+				})
+			case 3:
+				v.number = int(n)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, p := range paths {
+			result[p] = v
+		}
+		return nil
+	})
+	return result, err
+}
+
+// parseVariantSDK 返回 VariantTargeting 中最低的 SDK 版本
+func parseVariantSDK(targeting []byte) (int, error) {
+	minSDK := 0
+	err := protoFields(targeting, func(num int, _ uint64, sdkTargeting []byte) error {
+		if num != 1 {
+			return nil
+		}
+		return protoFields(sdkTargeting, func(num int, _ uint64, version []byte) error {
+			if num != 1 {
+				return nil
+			}
+			return protoFields(version, func(num int, _ uint64, value []byte) error {
+				if num != 1 {
+					return nil
+				}
+				return protoFields(value, func(num int, n uint64, _ []byte) error {
+					if num == 1 && (minSDK == 0 || int(int32(n)) < minSDK) {
+						minSDK = int(int32(n))
+					}
+					return nil
+				})
+			})
+		})
+	})
+	return minSDK, err
+}
+
+// protoFields 依次对消息中的每个字段调用 fn: varint 字段的值在 n 中，长度分隔字段 (子消息、字符串) 的内容在 b 中
+// 定长字段被跳过；不支持已废弃的 group 类型
+func protoFields(data []byte, fn func(num int, n uint64, b []byte) error) error {
+	for len(data) > 0 {
+		key, k := binary.Uvarint(data)
+		if k <= 0 {
+			return malformed("toc.pb: invalid field key")
+		}
+		data = data[k:]
+		num := int(key >> 3)
+		var n uint64
+		var b []byte
+		switch key & 7 {
+		case 0:
+			n, k = binary.Uvarint(data)
+			if k <= 0 {
+				return malformed("toc.pb: invalid varint in field %d", num)
+			}
+			data = data[k:]
+		case 1:
+			if len(data) < 8 {
+				return malformed("toc.pb: truncated field %d", num)
+			}
+			data = data[8:]
+			continue
+		case 2:
+			size, k := binary.Uvarint(data)
+			if k <= 0 || size > uint64(len(data)-k) {
+				return malformed("toc.pb: truncated field %d", num)
+			}
+			b, data = data[k:k+int(size)], data[k+int(size):]
+		case 5:
+			if len(data) < 4 {
+				return malformed("toc.pb: truncated field %d", num)
+			}
+			data = data[4:]
+			continue
+		default:
+			return malformed("toc.pb: unsupported wire type %d in field %d", key&7, num)
+		}
+		if err := fn(num, n, b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,412 @@
+// Package apkrepo 在服务器上保存上传过的 APK，按包名与 versionCode 索引，
+// 同一个构建可以反复安装到多台设备而不必每次重新上传
+//
+// APK 保存在 <dir>/<包名>/<versionCode>.apk，元数据 (解析结果、SHA-256、上传时间) 保存在 <dir>/index.json，
+// 正在安装的 APK 的硬链接保存在 <dir>/.pinned 下
+package apkrepo
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fishyinhe/backend/internal/adb"
+	"fishyinhe/backend/internal/apk"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// 库操作可能返回的错误类别，使用 errors.Is 判断
+var (
+	// ErrNotFound 表示库中没有指定的包或版本
+	ErrNotFound = errors.New("APK not found in the library")
+	// ErrNotRetained 表示添加的版本比保留规则保留的版本都旧，添加后会被立即清理
+	ErrNotRetained = errors.New("APK is older than the versions kept in the library")
+)
+
+// pruneInterval 是 RunPrune 按保留规则检查旧版本的间隔
+const pruneInterval = time.Hour
+
+// pinnedDir 是 Pin 建立的硬链接所在的子目录
+const pinnedDir = ".pinned"
+
+// Entry 是库中的一个 APK
+type Entry struct {
+	*apk.Info
+	// Filename 是上传时的文件名
+	Filename string    `json:"filename"`
+	Size     int64     `json:"size"`
+	SHA256   string    `json:"sha256"`
+	Uploaded time.Time `json:"uploaded"`
+}
+
+// Retention 是旧版本的保留规则，每个包最新的版本总是保留
+type Retention struct {
+	// KeepVersions 是每个包保留的版本数，0 表示不限制
+	KeepVersions int `yaml:"keepVersions"`
+	// MaxAge 是旧版本在上传后保留的时间，0 表示一直保留
+	MaxAge time.Duration `yaml:"maxAge"`
+}
+
+// Repo 管理保存在一个目录下的 APK，可以被多个请求并发使用
+type Repo struct {
+	dir       string
+	retention Retention
+	mu        sync.Mutex
+	entries   map[string]*Entry
+}
+
+// New 创建把 APK 保存在 dir 下的库，目录在第一次添加 APK 时才建立
+// 需要读取之前保存的 APK 时调用 Load
+func New(dir string, retention Retention) *Repo {
+	return &Repo{dir: dir, retention: retention, entries: make(map[string]*Entry)}
+}
+
+func key(pkg string, versionCode int64) string {
+	return pkg + "/" + strconv.FormatInt(versionCode, 10)
+}
+
+// Path 返回 APK 在服务器上的文件路径
+func (r *Repo) Path(e Entry) string {
+	return filepath.Join(r.dir, e.PackageName, strconv.FormatInt(e.VersionCode, 10)+".apk")
+}
+
+// Load 读取 index.json，文件已经不存在的条目会被丢弃；上次运行中未释放的 Pin 链接会被删除
+func (r *Repo) Load() error {
+	os.RemoveAll(filepath.Join(r.dir, pinnedDir))
+	data, err := os.ReadFile(filepath.Join(r.dir, "index.json"))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("apkrepo: reading index: %w", err)
+	}
+	var entries []*Entry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return fmt.Errorf("apkrepo: parsing index: %w", err)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, e := range entries {
+		if e.Info == nil || adb.ValidatePackageName(e.PackageName) != nil {
+			continue
+		}
+		if _, err := os.Stat(r.Path(*e)); err != nil {
+			log.Printf("apkrepo: Dropping %s %d from the index: %v", e.PackageName, e.VersionCode, err)
+			continue
+		}
+		r.entries[key(e.PackageName, e.VersionCode)] = e
+	}
+	if len(r.entries) > 0 {
+		log.Printf("apkrepo: Loaded %d APK(s) from %s", len(r.entries), r.dir)
+	}
+	return nil
+}
+
+// Add 解析服务器上的 APK 并将其移动到库中，filename 是上传时的文件名
+// 包名与 versionCode 相同的 APK 已经存在时: 内容相同则保留原来的条目并删除 localPath，内容不同则替换 (未更新 versionCode 的重新构建)
+// 返回的 bool 表示是否添加或替换了 APK；添加后按保留规则删除该包的旧版本
+// 库中已有 KeepVersions 个更新的版本时返回 ErrNotRetained，不会添加一个随即被清理的条目
+func (r *Repo) Add(localPath string, filename string) (Entry, bool, error) {
+	info, err := apk.ParseFile(localPath)
+	if err != nil {
+		return Entry{}, false, err
+	}
+	if info.Split != "" {
+		return Entry{}, false, fmt.Errorf("%w: split APK %s cannot be stored on its own", apk.ErrInvalid, info.Split)
+	}
+	if err := adb.ValidatePackageName(info.PackageName); err != nil {
+		return Entry{}, false, fmt.Errorf("%w: %v", apk.ErrInvalid, err)
+	}
+	sum, size, err := hashFile(localPath)
+	if err != nil {
+		return Entry{}, false, err
+	}
+	info.IconData = nil
+	e := &Entry{Info: info, Filename: filepath.Base(filename), Size: size, SHA256: sum, Uploaded: time.Now().UTC()}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	k := key(info.PackageName, info.VersionCode)
+	if old, ok := r.entries[k]; ok && old.SHA256 == sum {
+		os.Remove(localPath)
+		return *old, false, nil
+	}
+	if newer := r.newerVersionsLocked(info.PackageName, info.VersionCode); r.retention.KeepVersions > 0 && newer >= r.retention.KeepVersions {
+		return Entry{}, false, fmt.Errorf("%w: %s %d, the library keeps the %d newest versions", ErrNotRetained, info.PackageName, info.VersionCode, r.retention.KeepVersions)
+	}
+	dst := r.Path(*e)
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return Entry{}, false, fmt.Errorf("apkrepo: %w", err)
+	}
+	if err := moveFile(localPath, dst); err != nil {
+		return Entry{}, false, fmt.Errorf("apkrepo: storing %s: %w", filename, err)
+	}
+	if _, ok := r.entries[k]; ok {
+		log.Printf("apkrepo: Replacing %s %d with a different build (sha256 %s)", info.PackageName, info.VersionCode, sum)
+	}
+	r.entries[k] = e
+	r.pruneLocked(info.PackageName, time.Now())
+	if err := r.saveLocked(); err != nil {
+		return Entry{}, false, err
+	}
+	return *e, true, nil
+}
+
+// newerVersionsLocked 返回库中包 pkg 比 versionCode 新的版本数
+func (r *Repo) newerVersionsLocked(pkg string, versionCode int64) int {
+	n := 0
+	for _, e := range r.entries {
+		if e.PackageName == pkg && e.VersionCode > versionCode {
+			n++
+		}
+	}
+	return n
+}
+
+// Pin 为条目的 APK 建立一个硬链接并返回其路径，安装期间条目即使被删除、替换或按保留规则清理，链接指向的文件也保持不变
+// 使用结束后必须调用返回的 release；条目已不在库中或已被替换为其他构建时返回 ErrNotFound
+func (r *Repo) Pin(e Entry) (string, func(), error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	cur, ok := r.entries[key(e.PackageName, e.VersionCode)]
+	if !ok || cur.SHA256 != e.SHA256 {
+		return "", nil, fmt.Errorf("%w: %s version %d", ErrNotFound, e.PackageName, e.VersionCode)
+	}
+	if err := os.MkdirAll(filepath.Join(r.dir, pinnedDir), 0755); err != nil {
+		return "", nil, fmt.Errorf("apkrepo: %w", err)
+	}
+	dir, err := os.MkdirTemp(filepath.Join(r.dir, pinnedDir), "pin-*")
+	if err != nil {
+		return "", nil, fmt.Errorf("apkrepo: %w", err)
+	}
+	release := func() { os.RemoveAll(dir) }
+	link := filepath.Join(dir, fmt.Sprintf("%s-%d.apk", e.PackageName, e.VersionCode))
+	if err := os.Link(r.Path(*cur), link); err != nil {
+		// 文件系统不支持硬链接时复制一份
+		if err := copyFile(r.Path(*cur), link); err != nil {
+			release()
+			return "", nil, fmt.Errorf("apkrepo: pinning %s %d: %w", e.PackageName, e.VersionCode, err)
+		}
+	}
+	return link, release, nil
+}
+
+// List 返回库中的 APK，pkg 非空时只返回该包的版本；按包名排序，同一个包的新版本在前
+func (r *Repo) List(pkg string) []Entry {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	list := []Entry{}
+	for _, e := range r.entries {
+		if pkg == "" || e.PackageName == pkg {
+			list = append(list, *e)
+		}
+	}
+	sortEntries(list)
+	return list
+}
+
+func sortEntries(list []Entry) {
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].PackageName != list[j].PackageName {
+			return list[i].PackageName < list[j].PackageName
+		}
+		return list[i].VersionCode > list[j].VersionCode
+	})
+}
+
+// Get 返回包 pkg 的版本 versionCode
+func (r *Repo) Get(pkg string, versionCode int64) (Entry, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	e, ok := r.entries[key(pkg, versionCode)]
+	if !ok {
+		return Entry{}, fmt.Errorf("%w: %s version %d", ErrNotFound, pkg, versionCode)
+	}
+	return *e, nil
+}
+
+// Latest 返回包 pkg 的最新版本 (versionCode 最大)
+func (r *Repo) Latest(pkg string) (Entry, error) {
+	list := r.List(pkg)
+	if len(list) == 0 {
+		return Entry{}, fmt.Errorf("%w: %s", ErrNotFound, pkg)
+	}
+	return list[0], nil
+}
+
+// Delete 删除包 pkg 的版本 versionCode
+func (r *Repo) Delete(pkg string, versionCode int64) (Entry, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	k := key(pkg, versionCode)
+	e, ok := r.entries[k]
+	if !ok {
+		return Entry{}, fmt.Errorf("%w: %s version %d", ErrNotFound, pkg, versionCode)
+	}
+	r.removeLocked(k)
+	return *e, r.saveLocked()
+}
+
+func (r *Repo) removeLocked(k string) {
+	e := r.entries[k]
+	delete(r.entries, k)
+	if err := os.Remove(r.Path(*e)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		log.Printf("apkrepo: Failed to remove %s: %v", r.Path(*e), err)
+	}
+	// 包的最后一个版本被删除时目录为空，删除失败说明还有其他版本
+	os.Remove(filepath.Dir(r.Path(*e)))
+}
+
+// Prune 按保留规则删除所有包的旧版本，返回删除的条目
+func (r *Repo) Prune() ([]Entry, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	packages := make(map[string]bool)
+	for _, e := range r.entries {
+		packages[e.PackageName] = true
+	}
+	var removed []Entry
+	now := time.Now()
+	for pkg := range packages {
+		removed = append(removed, r.pruneLocked(pkg, now)...)
+	}
+	if len(removed) == 0 {
+		return nil, nil
+	}
+	return removed, r.saveLocked()
+}
+
+// pruneLocked 删除包 pkg 超出保留规则的版本: 超过 KeepVersions 个的旧版本与上传时间早于 MaxAge 的旧版本
+func (r *Repo) pruneLocked(pkg string, now time.Time) []Entry {
+	var versions []Entry
+	for _, e := range r.entries {
+		if e.PackageName == pkg {
+			versions = append(versions, *e)
+		}
+	}
+	sortEntries(versions)
+	var removed []Entry
+	for i, e := range versions {
+		if i == 0 {
+			continue
+		}
+		tooMany := r.retention.KeepVersions > 0 && i >= r.retention.KeepVersions
+		tooOld := r.retention.MaxAge > 0 && now.Sub(e.Uploaded) > r.retention.MaxAge
+		if tooMany || tooOld {
+			r.removeLocked(key(e.PackageName, e.VersionCode))
+			removed = append(removed, e)
+			log.Printf("apkrepo: Removed old build %s %d (%s) by retention rules", e.PackageName, e.VersionCode, e.VersionName)
+		}
+	}
+	return removed
+}
+
+// RunPrune 定期按保留规则删除旧版本，直到 ctx 被取消；没有按时间保留的规则时只在启动时检查一次
+func (r *Repo) RunPrune(ctx context.Context) {
+	if _, err := r.Prune(); err != nil {
+		log.Printf("apkrepo: Prune failed: %v", err)
+	}
+	if r.retention.MaxAge <= 0 {
+		return
+	}
+	ticker := time.NewTicker(min(pruneInterval, r.retention.MaxAge))
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+		if _, err := r.Prune(); err != nil {
+			log.Printf("apkrepo: Prune failed: %v", err)
+		}
+	}
+}
+
+// saveLocked 先写入临时文件再重命名，避免进程中断时留下损坏的 JSON
+func (r *Repo) saveLocked() error {
+	list := make([]Entry, 0, len(r.entries))
+	for _, e := range r.entries {
+		list = append(list, *e)
+	}
+	sortEntries(list)
+	data, err := json.MarshalIndent(list, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(r.dir, 0755); err != nil {
+		return fmt.Errorf("apkrepo: saving index: %w", err)
+	}
+	dst := filepath.Join(r.dir, "index.json")
+	tmp := dst + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("apkrepo: saving index: %w", err)
+	}
+	if err := os.Rename(tmp, dst); err != nil {
+		return fmt.Errorf("apkrepo: saving index: %w", err)
+	}
+	return nil
+}
+
+func hashFile(name string) (string, int64, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return "", 0, err
+	}
+	defer f.Close()
+	h := sha256.New()
+	n, err := io.Copy(h, f)
+	if err != nil {
+		return "", 0, err
+	}
+	return hex.EncodeToString(h.Sum(nil)), n, nil
+}
+
+// moveFile 将文件移动到 dst；不在同一个文件系统上时复制后删除原文件
+func moveFile(src string, dst string) error {
+	if err := os.Rename(src, dst); err == nil {
+		return nil
+	}
+	if err := copyFile(src, dst); err != nil {
+		return err
+	}
+	os.Remove(src)
+	return nil
+}
+
+// copyFile 先复制到临时文件再重命名为 dst，避免留下不完整的文件
+func copyFile(src string, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	tmp := dst + ".tmp"
+	out, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, dst); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
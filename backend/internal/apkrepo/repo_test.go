@@ -0,0 +1,150 @@
+package apkrepo_test
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fishyinhe/backend/internal/apkrepo"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var le = binary.LittleEndian
+
+// testAPK 构造只包含二进制 AndroidManifest.xml 的最小 APK，manifest 只有 package 与 versionCode 两个属性
+func testAPK(pkg string, versionCode uint32) []byte {
+	strs := []string{"manifest", "package", "versionCode", pkg}
+	var data []byte
+	var offsets []byte
+	for _, s := range strs {
+		offsets = le.AppendUint32(offsets, uint32(len(data)))
+		data = append(data, byte(len(s)), byte(len(s)))
+		data = append(append(data, s...), 0)
+	}
+	for len(data)%4 != 0 {
+		data = append(data, 0)
+	}
+	const poolHeader = 28
+	pool := le.AppendUint16(nil, 0x0001)
+	pool = le.AppendUint16(pool, poolHeader)
+	pool = le.AppendUint32(pool, uint32(poolHeader+len(offsets)+len(data)))
+	pool = le.AppendUint32(pool, uint32(len(strs)))
+	pool = le.AppendUint32(pool, 0)
+	pool = le.AppendUint32(pool, 1<<8) // UTF-8
+	pool = le.AppendUint32(pool, uint32(poolHeader+len(offsets)))
+	pool = le.AppendUint32(pool, 0)
+	pool = append(append(pool, offsets...), data...)
+
+	attr := func(b []byte, name uint32, raw uint32, dataType byte, value uint32) []byte {
+		b = le.AppendUint32(b, 0xffffffff)
+		b = le.AppendUint32(b, name)
+		b = le.AppendUint32(b, raw)
+		b = le.AppendUint16(b, 8)
+		b = append(b, 0, dataType)
+		return le.AppendUint32(b, value)
+	}
+	start := le.AppendUint16(nil, 0x0102)
+	start = le.AppendUint16(start, 16)
+	start = le.AppendUint32(start, 16+20+2*20)
+	start = le.AppendUint32(start, 1)
+	start = le.AppendUint32(start, 0xffffffff)
+	start = le.AppendUint32(start, 0xffffffff)
+	start = le.AppendUint32(start, 0)
+	start = le.AppendUint16(start, 20)
+	start = le.AppendUint16(start, 20)
+	start = le.AppendUint16(start, 2)
+	start = append(start, make([]byte, 6)...)
+	start = attr(start, 1, 3, 0x03, 3)
+	start = attr(start, 2, 0xffffffff, 0x10, versionCode)
+
+	end := le.AppendUint16(nil, 0x0103)
+	end = le.AppendUint16(end, 16)
+	end = le.AppendUint32(end, 24)
+	end = le.AppendUint32(end, 1)
+	end = le.AppendUint32(end, 0xffffffff)
+	end = le.AppendUint32(end, 0xffffffff)
+	end = le.AppendUint32(end, 0)
+
+	body := append(append(pool, start...), end...)
+	manifest := le.AppendUint16(nil, 0x0003)
+	manifest = le.AppendUint16(manifest, 8)
+	manifest = le.AppendUint32(manifest, uint32(8+len(body)))
+	manifest = append(manifest, body...)
+
+	var b bytes.Buffer
+	zw := zip.NewWriter(&b)
+	w, _ := zw.Create("AndroidManifest.xml")
+	w.Write(manifest)
+	zw.Close()
+	return b.Bytes()
+}
+
+// add 把版本 versionCode 的测试 APK 写入临时文件后添加到库中
+func add(t *testing.T, r *apkrepo.Repo, versionCode uint32) (apkrepo.Entry, bool, error) {
+	t.Helper()
+	tmp := filepath.Join(t.TempDir(), "upload.apk")
+	if err := os.WriteFile(tmp, testAPK("com.example.app", versionCode), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return r.Add(tmp, "app.apk")
+}
+
+func TestAddRejectsVersionsOutsideRetention(t *testing.T) {
+	r := apkrepo.New(t.TempDir(), apkrepo.Retention{KeepVersions: 2})
+	for _, code := range []uint32{10, 20} {
+		if _, added, err := add(t, r, code); err != nil || !added {
+			t.Fatalf("Add(%d) = %v, %v", code, added, err)
+		}
+	}
+
+	// 比保留的两个版本都旧，添加后会被立即清理
+	_, added, err := add(t, r, 5)
+	if !errors.Is(err, apkrepo.ErrNotRetained) || added {
+		t.Fatalf("Add(5) = %v, %v, want ErrNotRetained", added, err)
+	}
+	if _, err := r.Get("com.example.app", 5); !errors.Is(err, apkrepo.ErrNotFound) {
+		t.Errorf("Get(5) = %v, want ErrNotFound", err)
+	}
+
+	// 介于两者之间的版本会替换最旧的版本
+	e, added, err := add(t, r, 15)
+	if err != nil || !added {
+		t.Fatalf("Add(15) = %v, %v", added, err)
+	}
+	if _, err := os.Stat(r.Path(e)); err != nil {
+		t.Errorf("added APK is missing: %v", err)
+	}
+	if _, err := r.Get("com.example.app", 10); !errors.Is(err, apkrepo.ErrNotFound) {
+		t.Errorf("Get(10) = %v, want it pruned", err)
+	}
+}
+
+func TestPinKeepsFileUntilReleased(t *testing.T) {
+	r := apkrepo.New(t.TempDir(), apkrepo.Retention{})
+	e, _, err := add(t, r, 1)
+	if err != nil {
+		t.Fatalf("Add: %v", err)
+	}
+	want, _ := os.ReadFile(r.Path(e))
+
+	path, release, err := r.Pin(e)
+	if err != nil {
+		t.Fatalf("Pin: %v", err)
+	}
+	if _, err := r.Delete(e.PackageName, e.VersionCode); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if got, err := os.ReadFile(path); err != nil || !bytes.Equal(got, want) {
+		t.Fatalf("pinned file after delete: %d bytes, %v", len(got), err)
+	}
+	release()
+	if _, err := os.Stat(path); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("pinned file still exists after release: %v", err)
+	}
+
+	if _, _, err := r.Pin(e); !errors.Is(err, apkrepo.ErrNotFound) {
+		t.Errorf("Pin(deleted) = %v, want ErrNotFound", err)
+	}
+}
@@ -0,0 +1,105 @@
+// Package config 负责加载后端的 config.yaml
+package config
+
+import (
+	"errors"
+	"fishyinhe/backend/internal/adb"
+	"fishyinhe/backend/internal/apkrepo"
+	"fmt"
+	"os"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Config 对应 config.yaml 的结构，未填写的字段使用默认值
+type Config struct {
+	ADB   ADBConfig   `yaml:"adb"`
+	Files FilesConfig `yaml:"files"`
+	APK   APKConfig   `yaml:"apk"`
+}
+
+// ADBConfig 是 adb 相关的配置
+type ADBConfig struct {
+	// ServerAddr 是 adb server 的地址，默认 localhost:5037
+	ServerAddr string `yaml:"server"`
+	// Timeouts 是各类 adb 操作的默认超时，例如 shell: 30s
+	Timeouts adb.Timeouts `yaml:"timeouts"`
+	// Concurrency 是每台设备同时执行的命令数上限，超出的命令按优先级排队；交互输入另有一个不计入上限的预留名额
+	Concurrency int `yaml:"concurrency"`
+	// ShellSessions 是每台设备保持的常驻 shell 会话数，点击、按键等短命令复用这些会话执行
+	ShellSessions int `yaml:"shellSessions"`
+	// WirelessStore 是记住的无线调试设备的保存位置，启动时会尝试重新连接其中的设备；为空时不保存
+	WirelessStore string `yaml:"wirelessStore"`
+}
+
+// FilesConfig 是文件管理相关的配置
+type FilesConfig struct {
+	// ArchiveMaxSizeMB 是目录打包下载 (zip/tar.gz) 的总大小上限，单位 MiB
+	ArchiveMaxSizeMB int64 `yaml:"archiveMaxSizeMB"`
+	// TextMaxSizeKB 是在线预览与编辑的文本文件大小上限，单位 KiB
+	TextMaxSizeKB int64 `yaml:"textMaxSizeKB"`
+	// UploadDir 保存分块上传中已接收的数据，后端重启后未完成的上传可以继续
+	UploadDir string `yaml:"uploadDir"`
+	// UploadExpiry 是未完成的上传在没有任何进展后保留的时间，0 表示一直保留
+	UploadExpiry time.Duration `yaml:"uploadExpiry"`
+	// ThumbnailDir 是缩略图缓存的保存位置
+	ThumbnailDir string `yaml:"thumbnailDir"`
+	// ThumbnailCacheMB 是缩略图缓存的大小上限，单位 MiB，超过时删除最久未使用的缩略图
+	ThumbnailCacheMB int64 `yaml:"thumbnailCacheMB"`
+	// SyncRoot 是目录同步时服务器一侧的根目录，请求中的目录都相对于它
+	SyncRoot string `yaml:"syncRoot"`
+}
+
+// APKConfig 是服务器端 APK 库的配置
+type APKConfig struct {
+	// LibraryDir 是 APK 库的保存位置，上传的构建按包名与 versionCode 保存在这里
+	LibraryDir string `yaml:"libraryDir"`
+	// Retention 是旧版本的保留规则，每个包最新的版本总是保留
+	Retention apkrepo.Retention `yaml:"retention"`
+}
+
+// Default 返回默认配置
+func Default() *Config {
+	return &Config{
+		ADB: ADBConfig{
+			ServerAddr:    adb.DefaultServerAddr,
+			Timeouts:      adb.DefaultTimeouts,
+			Concurrency:   adb.DefaultConcurrency,
+			ShellSessions: adb.DefaultShellPoolSize,
+			WirelessStore: "data/wireless_devices.json",
+		},
+		Files: FilesConfig{
+			ArchiveMaxSizeMB: 4096,
+			TextMaxSizeKB:    2048,
+			UploadDir:        "data/uploads",
+			UploadExpiry:     24 * time.Hour,
+			ThumbnailDir:     "data/thumbnails",
+			ThumbnailCacheMB: 256,
+			SyncRoot:         "data/sync",
+		},
+		APK: APKConfig{
+			LibraryDir: "data/apks",
+			Retention: apkrepo.Retention{
+				KeepVersions: 10,
+				MaxAge:       90 * 24 * time.Hour,
+			},
+		},
+	}
+}
+
+// Load 读取 path 指定的 YAML 配置文件，文件不存在或为空时返回默认配置
+func Load(path string) (*Config, error) {
+	cfg := Default()
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return cfg, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("config: reading %s: %w", path, err)
+	}
+	if err := yaml.Unmarshal(data, cfg); err != nil {
+		return nil, fmt.Errorf("config: parsing %s: %w", path, err)
+	}
+	return cfg, nil
+}
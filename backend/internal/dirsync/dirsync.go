@@ -0,0 +1,422 @@
+// Package dirsync 同步服务器上的目录与设备上的目录，只传输有差异的文件
+//
+// 同步分两步: Compare 比较两边的文件并生成计划 (可以只查看计划而不执行，即 dry run)，
+// Apply 按计划逐个传输或删除文件并报告每个文件的结果
+// 文件的大小不同、或修改时间不同 (精确到秒) 时视为有差异；启用 Checksum 时大小相同的文件再比较 MD5，
+// 内容相同的不会被传输。传输后目标文件的修改时间与来源相同，因此下一次同步可以直接跳过
+package dirsync
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"fishyinhe/backend/internal/adb"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Direction 是同步的方向
+type Direction string
+
+const (
+	Push Direction = "push" // 服务器 -> 设备
+	Pull Direction = "pull" // 设备 -> 服务器
+)
+
+// Options 控制同步的方式
+type Options struct {
+	Direction Direction
+	// Checksum 为 true 时，大小相同但修改时间不同的文件再比较 MD5 (设备上使用 md5sum)
+	Checksum bool
+	// Delete 为 true 时删除目标中来源没有的文件与目录
+	Delete bool
+}
+
+// 计划中的操作
+const (
+	ActionMkdir  = "mkdir"  // 创建来源中的空目录 (有文件的目录随文件自动创建)
+	ActionCreate = "create" // 目标中没有该文件
+	ActionUpdate = "update" // 目标中的文件与来源不同
+	ActionDelete = "delete" // 来源中没有，且启用了 Delete
+	// ActionConflict 表示一边是文件、另一边是目录，不会自动处理
+	ActionConflict = "conflict"
+)
+
+// 文件被判定为不同的原因
+const (
+	ReasonSize     = "size"
+	ReasonModTime  = "mtime"
+	ReasonChecksum = "checksum"
+)
+
+// Change 是计划中的一项操作
+type Change struct {
+	// Path 是相对于同步根目录、以 / 分隔的路径
+	Path   string `json:"path"`
+	Action string `json:"action"`
+	Reason string `json:"reason,omitempty"`
+	IsDir  bool   `json:"isDir,omitempty"`
+	// Size 与 ModTime 是来源文件的大小与修改时间，删除时为目标的
+	Size    int64       `json:"size"`
+	ModTime time.Time   `json:"modTime"`
+	mode    os.FileMode // 推送时使用的权限
+}
+
+// Plan 是 Compare 的结果
+type Plan struct {
+	Direction Direction `json:"direction"`
+	Changes   []Change  `json:"changes"`
+	// Unchanged 是两边相同、不需要传输的文件数
+	Unchanged int `json:"unchanged"`
+	// Extra 是目标中来源没有、且因为未启用 Delete 而保留的条目数
+	Extra int `json:"extra"`
+	// Bytes 是需要传输的总字节数
+	Bytes int64 `json:"bytes"`
+}
+
+// entry 是一边的一个文件或目录
+type entry struct {
+	isDir   bool
+	size    int64
+	modTime time.Time
+	mode    os.FileMode
+}
+
+// listLocal 列出本地目录下的普通文件与目录，符号链接等其他类型被忽略；目录不存在时返回 nil
+func listLocal(root string) (map[string]entry, error) {
+	info, err := os.Stat(root)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("%w: %s is not a directory", adb.ErrInvalidArgument, root)
+	}
+	entries := make(map[string]entry)
+	err = filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if p == root || !(d.IsDir() || d.Type().IsRegular()) {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(root, p)
+		entries[filepath.ToSlash(rel)] = entry{isDir: d.IsDir(), size: info.Size(), modTime: info.ModTime(), mode: info.Mode().Perm()}
+		return nil
+	})
+	return entries, err
+}
+
+// listRemote 列出设备目录下的普通文件与目录，符号链接等其他类型被忽略；目录不存在时返回 nil
+func listRemote(ctx context.Context, r adb.Runner, deviceId string, root string) (map[string]entry, error) {
+	entries := make(map[string]entry)
+	err := adb.WalkFiles(ctx, r, deviceId, root, func(rel string, item adb.AdbFileItem) error {
+		if item.IsLink || !(item.IsDir || strings.HasPrefix(item.Mode, "-")) {
+			return nil
+		}
+		entries[rel] = entry{isDir: item.IsDir, size: item.Size, modTime: item.ModTime}
+		return nil
+	})
+	if errors.Is(err, adb.ErrPathNotFound) {
+		return nil, nil
+	}
+	return entries, err
+}
+
+// ambiguousModTime 报告设备上的修改时间是否可能因为 ls 不支持 --full-time (只精确到分钟) 而与本地不同
+func ambiguousModTime(remote time.Time, local time.Time) bool {
+	return remote.Second() == 0 && remote.Nanosecond() == 0 &&
+		!remote.Equal(local.Truncate(time.Second)) && remote.Equal(local.Truncate(time.Minute))
+}
+
+// hasChild 报告 entries 中是否有 dir 下的条目
+func hasChild(entries map[string]entry, dir string) bool {
+	for p := range entries {
+		if strings.HasPrefix(p, dir+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// Compare 比较服务器目录 localDir 与设备目录 remoteDir，生成把来源同步到目标的计划，不修改任何文件
+// 推送时 localDir 必须存在，remoteDir 不存在时视为空目录；拉取时反之
+func Compare(ctx context.Context, r adb.Runner, deviceId string, localDir string, remoteDir string, opts Options) (*Plan, error) {
+	if opts.Direction != Push && opts.Direction != Pull {
+		return nil, fmt.Errorf("%w: direction must be push or pull", adb.ErrInvalidArgument)
+	}
+	if err := adb.ValidateRemotePath(remoteDir); err != nil {
+		return nil, err
+	}
+	local, err := listLocal(localDir)
+	if err != nil {
+		return nil, fmt.Errorf("dirsync: listing %s: %w", localDir, err)
+	}
+	remote, err := listRemote(ctx, r, deviceId, remoteDir)
+	if err != nil {
+		return nil, err
+	}
+	src, dst := local, remote
+	if opts.Direction == Pull {
+		src, dst = remote, local
+	}
+	if src == nil {
+		if opts.Direction == Push {
+			return nil, fmt.Errorf("%w: %s does not exist on the server", adb.ErrPathNotFound, localDir)
+		}
+		return nil, fmt.Errorf("%w: %s", adb.ErrPathNotFound, remoteDir)
+	}
+
+	plan := &Plan{Direction: opts.Direction, Changes: []Change{}}
+	var checksum []string // 需要比较 MD5 的文件
+	for p, s := range src {
+		d, exists := dst[p]
+		change := Change{Path: p, IsDir: s.isDir, Size: s.size, ModTime: s.modTime, mode: s.mode}
+		switch {
+		case !exists && s.isDir:
+			if hasChild(src, p) {
+				continue
+			}
+			change.Action, change.Size = ActionMkdir, 0
+		case !exists:
+			change.Action = ActionCreate
+		case s.isDir != d.isDir:
+			change.Action = ActionConflict
+		case s.isDir:
+			continue
+		case s.size != d.size:
+			change.Action, change.Reason = ActionUpdate, ReasonSize
+		default:
+			rt, lt := remote[p].modTime, local[p].modTime
+			if ambiguousModTime(rt, lt) {
+				if st, err := adb.StatFile(ctx, r, deviceId, path.Join(remoteDir, p)); err == nil {
+					rt = st.ModTime
+				}
+			}
+			if rt.Truncate(time.Second).Equal(lt.Truncate(time.Second)) {
+				plan.Unchanged++
+				continue
+			}
+			if opts.Checksum {
+				checksum = append(checksum, p)
+				continue
+			}
+			change.Action, change.Reason = ActionUpdate, ReasonModTime
+		}
+		plan.Changes = append(plan.Changes, change)
+	}
+
+	if len(checksum) > 0 {
+		changed, err := compareChecksums(ctx, r, deviceId, localDir, remoteDir, checksum)
+		if err != nil {
+			return nil, err
+		}
+		for _, p := range checksum {
+			if !changed[p] {
+				plan.Unchanged++
+				continue
+			}
+			s := src[p]
+			plan.Changes = append(plan.Changes, Change{Path: p, Action: ActionUpdate, Reason: ReasonChecksum, Size: s.size, ModTime: s.modTime, mode: s.mode})
+		}
+	}
+
+	for p, d := range dst {
+		if _, ok := src[p]; ok {
+			continue
+		}
+		if !opts.Delete {
+			plan.Extra++
+			continue
+		}
+		// 父目录会被整个删除时不再单独列出；父目录在来源中是文件时属于冲突，不删除其中的条目
+		if parent := path.Dir(p); parent != "." {
+			if s, ok := src[parent]; !ok || !s.isDir {
+				continue
+			}
+		}
+		plan.Changes = append(plan.Changes, Change{Path: p, Action: ActionDelete, IsDir: d.isDir, Size: d.size, ModTime: d.modTime})
+	}
+
+	// 先传输再删除，同一类操作按路径排序
+	order := map[string]int{ActionConflict: 0, ActionMkdir: 1, ActionCreate: 2, ActionUpdate: 2, ActionDelete: 3}
+	sort.Slice(plan.Changes, func(i, j int) bool {
+		a, b := plan.Changes[i], plan.Changes[j]
+		if order[a.Action] != order[b.Action] {
+			return order[a.Action] < order[b.Action]
+		}
+		return a.Path < b.Path
+	})
+	for _, c := range plan.Changes {
+		if c.Action == ActionCreate || c.Action == ActionUpdate {
+			plan.Bytes += c.Size
+		}
+	}
+	return plan, nil
+}
+
+// compareChecksums 比较两边文件的 MD5，返回内容不同的文件；设备上无法读取的文件视为不同
+func compareChecksums(ctx context.Context, r adb.Runner, deviceId string, localDir string, remoteDir string, paths []string) (map[string]bool, error) {
+	remotePaths := make([]string, len(paths))
+	for i, p := range paths {
+		remotePaths[i] = path.Join(remoteDir, p)
+	}
+	remoteSums, err := adb.MD5Sums(ctx, r, deviceId, remotePaths)
+	if err != nil {
+		return nil, err
+	}
+	changed := make(map[string]bool)
+	for i, p := range paths {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		localSum, err := fileMD5(filepath.Join(localDir, filepath.FromSlash(p)))
+		if err != nil {
+			return nil, fmt.Errorf("dirsync: %w", err)
+		}
+		changed[p] = remoteSums[remotePaths[i]] != localSum
+	}
+	return changed, nil
+}
+
+func fileMD5(name string) (string, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := md5.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// Progress 是 Apply 每处理完一项操作后报告的结果
+type Progress struct {
+	Change
+	Index int    `json:"index"` // 从 1 开始
+	Total int    `json:"total"`
+	OK    bool   `json:"ok"`
+	Error string `json:"error,omitempty"`
+	// Transferred 是到目前为止已传输的总字节数
+	Transferred int64 `json:"transferred"`
+}
+
+// Result 是 Apply 的汇总
+type Result struct {
+	Applied   int   `json:"applied"`
+	Failed    int   `json:"failed"`
+	Conflicts int   `json:"conflicts"` // 未处理的冲突
+	Bytes     int64 `json:"bytes"`
+}
+
+// Apply 按 plan 同步 localDir 与 remoteDir，每项操作完成后调用 progress
+// 单个文件失败不影响其他文件；ctx 被取消时停止并返回 ctx 的错误
+func Apply(ctx context.Context, r adb.Runner, deviceId string, localDir string, remoteDir string, plan *Plan, progress func(Progress)) (*Result, error) {
+	result := &Result{}
+	total := 0
+	for _, c := range plan.Changes {
+		if c.Action != ActionConflict {
+			total++
+		}
+	}
+	index := 0
+	for _, c := range plan.Changes {
+		if c.Action == ActionConflict {
+			result.Conflicts++
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			return result, err
+		}
+		index++
+		localPath := filepath.Join(localDir, filepath.FromSlash(c.Path))
+		remotePath := path.Join(remoteDir, c.Path)
+		err := applyChange(ctx, r, deviceId, plan.Direction, c, localPath, remotePath)
+		p := Progress{Change: c, Index: index, Total: total, OK: err == nil}
+		if err != nil {
+			if ctx.Err() != nil {
+				return result, ctx.Err()
+			}
+			log.Printf("dirsync: Failed to %s %s on device %s: %v", c.Action, c.Path, deviceId, err)
+			p.Error = err.Error()
+			result.Failed++
+		} else {
+			result.Applied++
+			if c.Action == ActionCreate || c.Action == ActionUpdate {
+				result.Bytes += c.Size
+			}
+		}
+		p.Transferred = result.Bytes
+		progress(p)
+	}
+	return result, nil
+}
+
+// applyChange 执行一项操作
+func applyChange(ctx context.Context, r adb.Runner, deviceId string, dir Direction, c Change, localPath string, remotePath string) error {
+	switch {
+	case c.Action == ActionMkdir && dir == Push:
+		return adb.MakeDir(ctx, r, deviceId, remotePath)
+	case c.Action == ActionMkdir:
+		return os.MkdirAll(localPath, 0o755)
+	case c.Action == ActionDelete && dir == Push:
+		return adb.RemovePath(ctx, r, deviceId, remotePath, c.IsDir)
+	case c.Action == ActionDelete:
+		return os.RemoveAll(localPath)
+	case dir == Push:
+		f, err := os.Open(localPath)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		return adb.PushStream(ctx, r, deviceId, f, remotePath, c.mode, c.ModTime)
+	default:
+		return pullFile(ctx, r, deviceId, remotePath, localPath, c.ModTime)
+	}
+}
+
+// pullFile 将设备文件写入同一目录下的临时文件，完成后设置修改时间并替换 localPath
+func pullFile(ctx context.Context, r adb.Runner, deviceId string, remotePath string, localPath string, modTime time.Time) error {
+	if err := os.MkdirAll(filepath.Dir(localPath), 0o755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(localPath), "."+filepath.Base(localPath)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	err = adb.StreamFile(ctx, r, deviceId, remotePath, 0, -1, tmp)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		// CreateTemp 创建的文件只有所有者可读写
+		err = os.Chmod(tmp.Name(), 0o644)
+	}
+	if err == nil {
+		err = os.Chtimes(tmp.Name(), modTime, modTime)
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), localPath)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+	return err
+}
@@ -0,0 +1,291 @@
+package dirsync
+
+import (
+	"context"
+	"fishyinhe/backend/internal/adb/adbtest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const remoteRoot = "/sdcard/sync"
+
+// writeLocal 在 root 下写入文件并设置修改时间
+func writeLocal(t *testing.T, root string, rel string, data string, mtime time.Time) {
+	t.Helper()
+	p := filepath.Join(root, filepath.FromSlash(rel))
+	if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(p, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(p, mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// putRemote 以指定的修改时间在设备上创建文件
+func putRemote(t *testing.T, f *adbtest.Fake, rel string, data string, mtime time.Time) {
+	t.Helper()
+	if err := f.Push(context.Background(), "emu-1", strings.NewReader(data), remoteRoot+"/"+rel, 0o644, mtime); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// summary 把计划压缩为 "action path" 列表，便于比较
+func summary(plan *Plan) []string {
+	var out []string
+	for _, c := range plan.Changes {
+		s := c.Action + " " + c.Path
+		if c.Reason != "" {
+			s += " (" + c.Reason + ")"
+		}
+		out = append(out, s)
+	}
+	return out
+}
+
+func checkPlan(t *testing.T, plan *Plan, want ...string) {
+	t.Helper()
+	got := summary(plan)
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("plan =\n  %s\nwant\n  %s", strings.Join(got, "\n  "), strings.Join(want, "\n  "))
+	}
+}
+
+// sync 比较并执行，返回执行前的计划
+func sync(t *testing.T, f *adbtest.Fake, localDir string, opts Options) (*Plan, *Result) {
+	t.Helper()
+	plan, err := Compare(context.Background(), f, "emu-1", localDir, remoteRoot, opts)
+	if err != nil {
+		t.Fatalf("Compare: %v", err)
+	}
+	var reported []Progress
+	result, err := Apply(context.Background(), f, "emu-1", localDir, remoteRoot, plan, func(p Progress) {
+		reported = append(reported, p)
+	})
+	if err != nil {
+		t.Fatalf("Apply: %v", err)
+	}
+	for _, p := range reported {
+		if !p.OK {
+			t.Errorf("%s %s failed: %s", p.Action, p.Path, p.Error)
+		}
+	}
+	if result.Applied+result.Failed != len(reported) {
+		t.Errorf("Apply reported %d changes but applied %d and failed %d", len(reported), result.Applied, result.Failed)
+	}
+	return plan, result
+}
+
+func TestPushThenNoop(t *testing.T) {
+	mtime := time.Date(2024, 5, 13, 10, 0, 30, 0, time.UTC)
+	local := t.TempDir()
+	writeLocal(t, local, "a.txt", "alpha", mtime)
+	writeLocal(t, local, "docs/b.txt", "bravo", mtime)
+	writeLocal(t, local, "same.txt", "same", mtime)
+	writeLocal(t, local, "grown.txt", "grown", mtime)
+	writeLocal(t, local, "touched.txt", "touch", mtime)
+	if err := os.Mkdir(filepath.Join(local, "empty"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	f := adbtest.NewFake()
+	f.AddCannedDevice("emu-1")
+	putRemote(t, f, "same.txt", "same", mtime)
+	putRemote(t, f, "grown.txt", "grow", mtime)
+	putRemote(t, f, "touched.txt", "touch", mtime.Add(time.Hour))
+	putRemote(t, f, "old.txt", "stale", mtime)
+	putRemote(t, f, "gone/x.txt", "x", mtime)
+	putRemote(t, f, "gone/deeper/y.txt", "y", mtime)
+
+	plan, result := sync(t, f, local, Options{Direction: Push, Delete: true})
+	// gone 下的条目随 gone 一起删除，不单独列出
+	checkPlan(t, plan,
+		"mkdir empty",
+		"create a.txt",
+		"create docs/b.txt",
+		"update grown.txt (size)",
+		"update touched.txt (mtime)",
+		"delete gone",
+		"delete old.txt",
+	)
+	if plan.Unchanged != 1 {
+		t.Errorf("Unchanged = %d, want 1", plan.Unchanged)
+	}
+	if want := int64(len("alpha") + len("bravo") + len("grown") + len("touch")); plan.Bytes != want || result.Bytes != want {
+		t.Errorf("plan.Bytes = %d, result.Bytes = %d, want %d", plan.Bytes, result.Bytes, want)
+	}
+	for rel, want := range map[string]string{"a.txt": "alpha", "docs/b.txt": "bravo", "grown.txt": "grown"} {
+		if got, ok := f.ReadFile("emu-1", remoteRoot+"/"+rel); !ok || string(got) != want {
+			t.Errorf("device %s = %q, %v, want %q", rel, got, ok, want)
+		}
+	}
+	for _, rel := range []string{"old.txt", "gone/x.txt", "gone/deeper/y.txt"} {
+		if _, ok := f.ReadFile("emu-1", remoteRoot+"/"+rel); ok {
+			t.Errorf("device %s was not deleted", rel)
+		}
+	}
+
+	plan, _ = sync(t, f, local, Options{Direction: Push, Delete: true})
+	checkPlan(t, plan)
+	if plan.Unchanged != 5 {
+		t.Errorf("second run Unchanged = %d, want 5", plan.Unchanged)
+	}
+}
+
+func TestPullThenNoop(t *testing.T) {
+	mtime := time.Date(2024, 5, 13, 10, 0, 30, 0, time.UTC)
+	f := adbtest.NewFake()
+	f.AddCannedDevice("emu-1")
+	putRemote(t, f, "a.txt", "alpha", mtime)
+	putRemote(t, f, "DCIM/b.jpg", "bravo", mtime.Add(time.Minute))
+	putRemote(t, f, "kept.txt", "kept", mtime)
+
+	local := t.TempDir()
+	writeLocal(t, local, "kept.txt", "kept", mtime)
+	writeLocal(t, local, "extra.txt", "extra", mtime)
+	writeLocal(t, local, "extra-dir/c.txt", "c", mtime)
+
+	// 不启用 Delete 时只统计多出的条目
+	plan, err := Compare(context.Background(), f, "emu-1", local, remoteRoot, Options{Direction: Pull})
+	if err != nil {
+		t.Fatalf("Compare: %v", err)
+	}
+	checkPlan(t, plan, "create DCIM/b.jpg", "create a.txt")
+	if plan.Extra != 3 {
+		t.Errorf("Extra = %d, want 3", plan.Extra)
+	}
+
+	plan, _ = sync(t, f, local, Options{Direction: Pull, Delete: true})
+	checkPlan(t, plan, "create DCIM/b.jpg", "create a.txt", "delete extra-dir", "delete extra.txt")
+	pulled := []struct {
+		rel   string
+		data  string
+		mtime time.Time
+	}{
+		{"a.txt", "alpha", mtime},
+		{"DCIM/b.jpg", "bravo", mtime.Add(time.Minute)},
+	}
+	for _, want := range pulled {
+		p := filepath.Join(local, filepath.FromSlash(want.rel))
+		data, err := os.ReadFile(p)
+		if err != nil || string(data) != want.data {
+			t.Errorf("local %s = %q, %v, want %q", want.rel, data, err, want.data)
+		}
+		if info, err := os.Stat(p); err == nil && !info.ModTime().Equal(want.mtime) {
+			t.Errorf("local %s mtime = %s, want %s", want.rel, info.ModTime(), want.mtime)
+		}
+	}
+	for _, rel := range []string{"extra.txt", "extra-dir"} {
+		if _, err := os.Stat(filepath.Join(local, rel)); !os.IsNotExist(err) {
+			t.Errorf("local %s was not deleted: %v", rel, err)
+		}
+	}
+
+	plan, _ = sync(t, f, local, Options{Direction: Pull, Delete: true})
+	checkPlan(t, plan)
+	if plan.Unchanged != 3 {
+		t.Errorf("second run Unchanged = %d, want 3", plan.Unchanged)
+	}
+}
+
+func TestCompareConflict(t *testing.T) {
+	mtime := time.Date(2024, 5, 13, 10, 0, 30, 0, time.UTC)
+	local := t.TempDir()
+	writeLocal(t, local, "notes", "a file on the server", mtime)
+	writeLocal(t, local, "a.txt", "alpha", mtime)
+	f := adbtest.NewFake()
+	f.AddCannedDevice("emu-1")
+	putRemote(t, f, "notes/inside.txt", "a directory on the device", mtime)
+
+	plan, result := sync(t, f, local, Options{Direction: Push, Delete: true})
+	checkPlan(t, plan, "conflict notes", "create a.txt")
+	if result.Conflicts != 1 || result.Applied != 1 {
+		t.Errorf("result = %+v, want 1 conflict and 1 applied", result)
+	}
+	if _, ok := f.ReadFile("emu-1", remoteRoot+"/notes/inside.txt"); !ok {
+		t.Error("conflicting directory was modified")
+	}
+}
+
+func TestCompareChecksum(t *testing.T) {
+	mtime := time.Date(2024, 5, 13, 10, 0, 30, 0, time.UTC)
+	local := t.TempDir()
+	writeLocal(t, local, "same.txt", "same content", mtime)
+	writeLocal(t, local, "edited.txt", "new content!", mtime)
+	f := adbtest.NewFake()
+	f.AddCannedDevice("emu-1")
+	// 大小相同、修改时间不同
+	putRemote(t, f, "same.txt", "same content", mtime.Add(time.Hour))
+	putRemote(t, f, "edited.txt", "old content!", mtime.Add(time.Hour))
+
+	plan, err := Compare(context.Background(), f, "emu-1", local, remoteRoot, Options{Direction: Push})
+	if err != nil {
+		t.Fatalf("Compare: %v", err)
+	}
+	checkPlan(t, plan, "update edited.txt (mtime)", "update same.txt (mtime)")
+
+	plan, err = Compare(context.Background(), f, "emu-1", local, remoteRoot, Options{Direction: Push, Checksum: true})
+	if err != nil {
+		t.Fatalf("Compare with checksums: %v", err)
+	}
+	checkPlan(t, plan, "update edited.txt (checksum)")
+	if plan.Unchanged != 1 {
+		t.Errorf("Unchanged = %d, want 1", plan.Unchanged)
+	}
+}
+
+func TestCompareMinutePrecisionListing(t *testing.T) {
+	mtime := time.Date(2024, 5, 13, 10, 0, 30, 0, time.UTC)
+	local := t.TempDir()
+	writeLocal(t, local, "a.txt", "alpha", mtime)
+	writeLocal(t, local, "b.txt", "bravo", mtime)
+	f := adbtest.NewFake()
+	f.AddCannedDevice("emu-1")
+	putRemote(t, f, "a.txt", "alpha", mtime)
+	putRemote(t, f, "b.txt", "bravo", mtime.Add(-20*time.Second))
+	// 旧版 toybox 不支持 --full-time，ls 的时间只精确到分钟
+	f.HandleShell("emu-1", "ls -la --full-time "+remoteRoot+"/", adbtest.Response{Stderr: "ls: Unknown option 'full-time'\n", ExitCode: 1})
+	f.HandleShell("emu-1", "ls -la "+remoteRoot+"/", adbtest.Response{Stdout: "total 2\n" +
+		"-rw-rw---- 1 root sdcard_rw 5 2024-05-13 10:00 a.txt\n" +
+		"-rw-rw---- 1 root sdcard_rw 5 2024-05-13 10:00 b.txt\n"})
+
+	plan, err := Compare(context.Background(), f, "emu-1", local, remoteRoot, Options{Direction: Push})
+	if err != nil {
+		t.Fatalf("Compare: %v", err)
+	}
+	// 两个文件在 ls 中都显示为 10:00，通过 STAT 区分
+	checkPlan(t, plan, "update b.txt (mtime)")
+	if plan.Unchanged != 1 {
+		t.Errorf("Unchanged = %d, want 1", plan.Unchanged)
+	}
+}
+
+func TestAmbiguousModTime(t *testing.T) {
+	local := time.Date(2024, 5, 13, 10, 0, 30, 500, time.UTC)
+	tests := []struct {
+		remote time.Time
+		want   bool
+	}{
+		{time.Date(2024, 5, 13, 10, 0, 0, 0, time.UTC), true},
+		// 精确到秒的时间不需要再次确认
+		{time.Date(2024, 5, 13, 10, 0, 30, 0, time.UTC), false},
+		{time.Date(2024, 5, 13, 10, 0, 29, 0, time.UTC), false},
+		// 分钟不同，确实被修改过
+		{time.Date(2024, 5, 13, 10, 1, 0, 0, time.UTC), false},
+	}
+	for _, tt := range tests {
+		if got := ambiguousModTime(tt.remote, local); got != tt.want {
+			t.Errorf("ambiguousModTime(%s, %s) = %v, want %v", tt.remote, local, got, tt.want)
+		}
+	}
+	// 本地时间恰好在整分钟时两边相同，不需要确认
+	minute := time.Date(2024, 5, 13, 10, 0, 0, 0, time.UTC)
+	if ambiguousModTime(minute, minute) {
+		t.Error("ambiguousModTime reported identical times as ambiguous")
+	}
+}
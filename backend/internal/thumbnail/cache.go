@@ -0,0 +1,193 @@
+package thumbnail
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// extensions 是缓存文件扩展名与 Content-Type 的对应关系
+var extensions = map[string]string{
+	".jpg": "image/jpeg",
+	".png": "image/png",
+}
+
+// Key 返回缓存键；修改时间是键的一部分，原文件被修改后旧的缩略图不会再被使用，最终被淘汰
+func Key(deviceId string, remotePath string, size int, modTime time.Time) string {
+	sum := sha256.Sum256([]byte(deviceId + "\x00" + remotePath + "\x00" + strconv.Itoa(size) + "\x00" + strconv.FormatInt(modTime.UnixNano(), 10)))
+	return hex.EncodeToString(sum[:])
+}
+
+// cacheEntry 是缓存中的一个缩略图文件
+type cacheEntry struct {
+	key  string
+	name string // 文件名: <key><扩展名>
+	size int64
+}
+
+// Cache 是保存在一个目录下、总大小有上限的缩略图缓存，超过上限时淘汰最久未使用的条目
+// 使用顺序通过文件的修改时间保存，后端重启后仍然有效
+type Cache struct {
+	dir      string
+	maxBytes int64
+	mu       sync.Mutex
+	entries  map[string]*list.Element // 元素的值为 *cacheEntry
+	lru      *list.List               // 最近使用的在前
+	total    int64
+}
+
+// NewCache 创建把缩略图保存在 dir 下、总大小不超过 maxBytes 的缓存，目录在第一次写入时才建立
+// 需要使用之前保存的缩略图时调用 Load
+func NewCache(dir string, maxBytes int64) *Cache {
+	return &Cache{dir: dir, maxBytes: maxBytes, entries: make(map[string]*list.Element), lru: list.New()}
+}
+
+// Load 读取 dir 中已有的缩略图，超过大小上限的部分按使用顺序淘汰
+func (c *Cache) Load() error {
+	files, err := os.ReadDir(c.dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("thumbnail: reading %s: %w", c.dir, err)
+	}
+	type loaded struct {
+		entry   *cacheEntry
+		modTime time.Time
+	}
+	var found []loaded
+	for _, f := range files {
+		ext := filepath.Ext(f.Name())
+		if _, ok := extensions[ext]; !ok || f.IsDir() {
+			// 包括写入中断留下的临时文件
+			os.Remove(filepath.Join(c.dir, f.Name()))
+			continue
+		}
+		info, err := f.Info()
+		if err != nil {
+			continue
+		}
+		found = append(found, loaded{
+			entry:   &cacheEntry{key: strings.TrimSuffix(f.Name(), ext), name: f.Name(), size: info.Size()},
+			modTime: info.ModTime(),
+		})
+	}
+	sort.Slice(found, func(i, j int) bool { return found[i].modTime.After(found[j].modTime) })
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, l := range found {
+		if _, ok := c.entries[l.entry.key]; ok {
+			continue
+		}
+		c.entries[l.entry.key] = c.lru.PushBack(l.entry)
+		c.total += l.entry.size
+	}
+	c.evictLocked()
+	log.Printf("thumbnail: Loaded %d cached thumbnails (%d bytes) from %s", len(c.entries), c.total, c.dir)
+	return nil
+}
+
+// Get 返回缓存的缩略图及其 Content-Type
+func (c *Cache) Get(key string) ([]byte, string, bool) {
+	c.mu.Lock()
+	el, ok := c.entries[key]
+	if ok {
+		c.lru.MoveToFront(el)
+	}
+	c.mu.Unlock()
+	if !ok {
+		return nil, "", false
+	}
+	name := el.Value.(*cacheEntry).name
+	p := filepath.Join(c.dir, name)
+	data, err := os.ReadFile(p)
+	if err != nil {
+		// 文件被外部删除
+		c.mu.Lock()
+		c.removeLocked(key)
+		c.mu.Unlock()
+		return nil, "", false
+	}
+	now := time.Now()
+	os.Chtimes(p, now, now)
+	return data, extensions[filepath.Ext(name)], true
+}
+
+// Put 保存缩略图，contentType 为 image/jpeg 或 image/png
+func (c *Cache) Put(key string, data []byte, contentType string) error {
+	ext := ".jpg"
+	if contentType == "image/png" {
+		ext = ".png"
+	}
+	if err := os.MkdirAll(c.dir, 0o755); err != nil {
+		return fmt.Errorf("thumbnail: %w", err)
+	}
+	name := key + ext
+	tmp, err := os.CreateTemp(c.dir, name+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("thumbnail: %w", err)
+	}
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), filepath.Join(c.dir, name))
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("thumbnail: %w", err)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.entries[key]; ok {
+		old := el.Value.(*cacheEntry)
+		c.total -= old.size
+		if old.name != name {
+			os.Remove(filepath.Join(c.dir, old.name))
+		}
+		old.name, old.size = name, int64(len(data))
+		c.total += old.size
+		c.lru.MoveToFront(el)
+	} else {
+		c.entries[key] = c.lru.PushFront(&cacheEntry{key: key, name: name, size: int64(len(data))})
+		c.total += int64(len(data))
+	}
+	c.evictLocked()
+	return nil
+}
+
+// removeLocked 从索引中删除 key 对应的条目，调用者持有 c.mu
+func (c *Cache) removeLocked(key string) {
+	el, ok := c.entries[key]
+	if !ok {
+		return
+	}
+	e := el.Value.(*cacheEntry)
+	c.lru.Remove(el)
+	delete(c.entries, key)
+	c.total -= e.size
+}
+
+// evictLocked 删除最久未使用的条目直到总大小不超过上限，调用者持有 c.mu
+func (c *Cache) evictLocked() {
+	for c.total > c.maxBytes && c.lru.Len() > 0 {
+		e := c.lru.Back().Value.(*cacheEntry)
+		c.removeLocked(e.key)
+		if err := os.Remove(filepath.Join(c.dir, e.name)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			log.Printf("thumbnail: Failed to evict %s: %v", e.name, err)
+		}
+	}
+}
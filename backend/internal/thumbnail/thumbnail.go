@@ -0,0 +1,197 @@
+// Package thumbnail 生成图片缩略图并把结果保存在有大小上限的磁盘 LRU 缓存中
+//
+// 支持 JPEG、PNG、GIF (第一帧)、WebP 与 BMP；JPEG 的 EXIF 方向会被应用，使手机拍摄的照片方向正确
+package thumbnail
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	_ "golang.org/x/image/bmp" // 注册 BMP 解码器
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp" // 注册 WebP 解码器
+	"image"
+	_ "image/gif" // 注册 GIF 解码器
+	"image/jpeg"
+	"image/png"
+	"io"
+)
+
+// 生成缩略图可能返回的错误类别，使用 errors.Is 判断
+var (
+	ErrUnsupported = errors.New("unsupported image format")
+	ErrTooLarge    = errors.New("image too large")
+)
+
+// 生成缩略图的限制
+const (
+	// MaxSourceBytes 是原图文件的大小上限
+	MaxSourceBytes = 64 << 20
+	// maxPixels 是原图的像素数上限: 解码为 RGBA 时每像素 4 字节，5000 万像素约 200 MB (16 位 PNG 加倍)，
+	// JPEG 解码为 YCbCr 4:2:0 约 75 MB；最多同时解码 decodeSlots 张
+	maxPixels = 50_000_000
+	// jpegQuality 是不透明缩略图的 JPEG 质量
+	jpegQuality = 80
+)
+
+// decodeSlots 限制同时解码的图片数，浏览目录时会同时请求大量缩略图，逐个解码可以控制内存占用
+var decodeSlots = make(chan struct{}, 2)
+
+// Generate 解码 src 中的图片，按比例缩小到长边不超过 size 像素 (不放大)，返回编码后的缩略图与其 Content-Type
+// 不透明的图片编码为 JPEG，带透明度的编码为 PNG
+func Generate(src []byte, size int) ([]byte, string, error) {
+	cfg, format, err := image.DecodeConfig(bytes.NewReader(src))
+	if err != nil {
+		return nil, "", fmt.Errorf("%w: %v", ErrUnsupported, err)
+	}
+	if int64(cfg.Width)*int64(cfg.Height) > maxPixels {
+		return nil, "", fmt.Errorf("%w: %dx%d pixels", ErrTooLarge, cfg.Width, cfg.Height)
+	}
+
+	decodeSlots <- struct{}{}
+	img, _, err := image.Decode(bytes.NewReader(src))
+	if err == nil {
+		img = scale(img, size)
+	}
+	<-decodeSlots
+	if err != nil {
+		return nil, "", fmt.Errorf("decoding %s: %w", format, err)
+	}
+	if format == "jpeg" {
+		img = orient(img, jpegOrientation(src))
+	}
+
+	var buf bytes.Buffer
+	if opaque, ok := img.(interface{ Opaque() bool }); ok && opaque.Opaque() {
+		err = jpeg.Encode(&buf, img, &jpeg.Options{Quality: jpegQuality})
+		return buf.Bytes(), "image/jpeg", err
+	}
+	err = (&png.Encoder{CompressionLevel: png.BestSpeed}).Encode(&buf, img)
+	return buf.Bytes(), "image/png", err
+}
+
+// scale 将 img 缩小到长边不超过 size，结果总是 *image.RGBA
+func scale(img image.Image, size int) image.Image {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if w > size || h > size {
+		if w >= h {
+			w, h = size, max(1, h*size/w)
+		} else {
+			w, h = max(1, w*size/h), size
+		}
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	// BiLinear 的核会随缩小比例展开，缩小很多倍时也不会出现锯齿
+	draw.BiLinear.Scale(dst, dst.Bounds(), img, b, draw.Src, nil)
+	return dst
+}
+
+// orient 按 EXIF 方向 (1-8) 翻转或旋转 img，使其按正确的方向显示
+func orient(img image.Image, orientation int) image.Image {
+	if orientation < 2 || orientation > 8 {
+		return img
+	}
+	src, ok := img.(*image.RGBA)
+	if !ok {
+		return img
+	}
+	w, h := src.Rect.Dx(), src.Rect.Dy()
+	// 5-8 需要交换宽高
+	dw, dh := w, h
+	if orientation >= 5 {
+		dw, dh = h, w
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			var dx, dy int
+			switch orientation {
+			case 2: // 水平翻转
+				dx, dy = w-1-x, y
+			case 3: // 旋转 180°
+				dx, dy = w-1-x, h-1-y
+			case 4: // 垂直翻转
+				dx, dy = x, h-1-y
+			case 5: // 沿左上-右下对角线翻转
+				dx, dy = y, x
+			case 6: // 顺时针旋转 90°
+				dx, dy = h-1-y, x
+			case 7: // 沿右上-左下对角线翻转
+				dx, dy = h-1-y, w-1-x
+			case 8: // 逆时针旋转 90°
+				dx, dy = y, w-1-x
+			}
+			i := src.PixOffset(src.Rect.Min.X+x, src.Rect.Min.Y+y)
+			j := dst.PixOffset(dx, dy)
+			copy(dst.Pix[j:j+4], src.Pix[i:i+4])
+		}
+	}
+	return dst
+}
+
+// jpegOrientation 从 JPEG 的 APP1 Exif 段读取方向标签 (0x0112)，没有时返回 1
+func jpegOrientation(data []byte) int {
+	r := bytes.NewReader(data)
+	var marker [2]byte
+	if _, err := io.ReadFull(r, marker[:]); err != nil || marker != [2]byte{0xFF, 0xD8} {
+		return 1
+	}
+	for {
+		var hdr [4]byte
+		if _, err := io.ReadFull(r, hdr[:]); err != nil || hdr[0] != 0xFF {
+			return 1
+		}
+		length := int(binary.BigEndian.Uint16(hdr[2:])) - 2
+		if length < 0 {
+			return 1
+		}
+		// 方向标签总是在图像数据 (SOS) 之前
+		if hdr[1] == 0xDA {
+			return 1
+		}
+		if hdr[1] != 0xE1 {
+			r.Seek(int64(length), io.SeekCurrent)
+			continue
+		}
+		seg := make([]byte, length)
+		if _, err := io.ReadFull(r, seg); err != nil {
+			return 1
+		}
+		if bytes.HasPrefix(seg, []byte("Exif\x00\x00")) {
+			return exifOrientation(seg[6:])
+		}
+	}
+}
+
+// exifOrientation 在 TIFF 结构的 IFD0 中查找方向标签
+func exifOrientation(tiff []byte) int {
+	if len(tiff) < 8 {
+		return 1
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 1
+	}
+	ifd := int(order.Uint32(tiff[4:]))
+	if ifd < 8 || ifd+2 > len(tiff) {
+		return 1
+	}
+	count := int(order.Uint16(tiff[ifd:]))
+	for i := 0; i < count; i++ {
+		e := ifd + 2 + i*12
+		if e+12 > len(tiff) {
+			return 1
+		}
+		if order.Uint16(tiff[e:]) == 0x0112 {
+			return int(order.Uint16(tiff[e+8:]))
+		}
+	}
+	return 1
+}
@@ -0,0 +1,453 @@
+// Package upload 保存分块上传的会话与已接收的数据，使中断的上传可以从已接收的位置继续
+//
+// 一个会话对应一批要推送到设备同一目录下的文件 (可以包含子目录)，流程类似 tus:
+// 创建会话 -> 按偏移量逐块追加每个文件的数据 -> 全部接收完后推送到设备并删除会话
+// 数据保存在 <dir>/<id>/<index>.part，会话信息保存在 <dir>/<id>/session.json，后端重启后仍可继续
+package upload
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fishyinhe/backend/internal/adb"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 上传会话操作可能返回的错误类别，使用 errors.Is 判断
+var (
+	ErrNotFound       = errors.New("upload not found")
+	ErrOffsetMismatch = errors.New("upload offset mismatch")
+	ErrBusy           = errors.New("upload is busy")
+	ErrIncomplete     = errors.New("upload incomplete")
+)
+
+// MaxFiles 是一个会话中的文件数上限
+const MaxFiles = 10000
+
+// cleanupInterval 是 RunCleanup 检查过期会话的间隔
+const cleanupInterval = 10 * time.Minute
+
+// File 是会话中的一个文件
+type File struct {
+	// Path 是相对于 Session.RemoteDir 的路径，以 / 分隔，可以包含子目录
+	Path    string    `json:"path"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"modTime"` // 为零时推送使用当前时间
+	// Offset 是已经接收的字节数，等于 Size 时文件接收完毕
+	Offset int64 `json:"offset"`
+	// Pushed 表示文件已经推送到设备，重试推送时会跳过
+	Pushed bool `json:"pushed"`
+}
+
+// Session 是一次分块上传
+type Session struct {
+	ID        string    `json:"id"`
+	DeviceID  string    `json:"deviceId"`
+	RemoteDir string    `json:"remoteDir"`
+	Files     []File    `json:"files"`
+	Created   time.Time `json:"created"`
+	Updated   time.Time `json:"updated"`
+}
+
+// Complete 报告所有文件是否都已接收完毕
+func (s *Session) Complete() bool {
+	for _, f := range s.Files {
+		if f.Offset < f.Size {
+			return false
+		}
+	}
+	return true
+}
+
+// Progress 返回已接收与总共的字节数
+func (s *Session) Progress() (received int64, total int64) {
+	for _, f := range s.Files {
+		received += f.Offset
+		total += f.Size
+	}
+	return received, total
+}
+
+// RemotePath 返回第 i 个文件在设备上的路径
+func (s *Session) RemotePath(i int) string {
+	return path.Join(s.RemoteDir, s.Files[i].Path)
+}
+
+// entry 是内存中的会话及其正在进行的操作
+type entry struct {
+	session    Session
+	writing    map[int]bool // 正在接收数据的文件
+	finalizing bool
+}
+
+func (e *entry) busy() bool {
+	return e.finalizing || len(e.writing) > 0
+}
+
+// Store 管理保存在一个目录下的上传会话，可以被多个请求并发使用
+type Store struct {
+	dir      string
+	mu       sync.Mutex
+	sessions map[string]*entry
+}
+
+// NewStore 创建把数据保存在 dir 下的 Store，目录在第一次创建会话时才建立
+// 需要恢复之前未完成的上传时调用 Load
+func NewStore(dir string) *Store {
+	return &Store{dir: dir, sessions: make(map[string]*entry)}
+}
+
+// Load 读取 dir 中已有的会话，已接收的字节数以磁盘上的数据为准
+// 无法解析的会话会被跳过并记录日志
+func (s *Store) Load() error {
+	dirs, err := os.ReadDir(s.dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("upload: reading %s: %w", s.dir, err)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, d := range dirs {
+		if !d.IsDir() {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(s.dir, d.Name(), "session.json"))
+		if err != nil {
+			log.Printf("upload: Skipping session directory %s: %v", d.Name(), err)
+			continue
+		}
+		var sess Session
+		if err := json.Unmarshal(data, &sess); err != nil || sess.ID != d.Name() {
+			log.Printf("upload: Skipping invalid session %s: %v", d.Name(), err)
+			continue
+		}
+		for i := range sess.Files {
+			sess.Files[i].Offset = 0
+			if info, err := os.Stat(s.partPath(sess.ID, i)); err == nil {
+				sess.Files[i].Offset = min(info.Size(), sess.Files[i].Size)
+			}
+		}
+		s.sessions[sess.ID] = &entry{session: sess, writing: make(map[int]bool)}
+	}
+	if len(s.sessions) > 0 {
+		log.Printf("upload: Loaded %d unfinished upload(s) from %s", len(s.sessions), s.dir)
+	}
+	return nil
+}
+
+func (s *Store) sessionDir(id string) string {
+	return filepath.Join(s.dir, id)
+}
+
+func (s *Store) partPath(id string, index int) string {
+	return filepath.Join(s.sessionDir(id), strconv.Itoa(index)+".part")
+}
+
+// validateFiles 检查相对路径与大小，并确认拼接后的远程路径合法
+func validateFiles(remoteDir string, files []File) error {
+	if len(files) == 0 {
+		return fmt.Errorf("%w: no files to upload", adb.ErrInvalidArgument)
+	}
+	if len(files) > MaxFiles {
+		return fmt.Errorf("%w: too many files (%d > %d)", adb.ErrInvalidArgument, len(files), MaxFiles)
+	}
+	if err := adb.ValidateRemotePath(remoteDir); err != nil {
+		return err
+	}
+	seen := make(map[string]bool, len(files))
+	for _, f := range files {
+		rel := f.Path
+		if rel == "" || strings.HasPrefix(rel, "/") || path.Clean(rel) != rel || rel == ".." || strings.HasPrefix(rel, "../") {
+			return fmt.Errorf("%w: invalid relative path %q", adb.ErrInvalidArgument, rel)
+		}
+		if f.Size < 0 {
+			return fmt.Errorf("%w: negative size for %q", adb.ErrInvalidArgument, rel)
+		}
+		if seen[rel] {
+			return fmt.Errorf("%w: duplicate path %q", adb.ErrInvalidArgument, rel)
+		}
+		seen[rel] = true
+		if err := adb.ValidateRemotePath(path.Join(remoteDir, rel)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Create 创建一个新会话，files 中只使用 Path、Size 与 ModTime
+func (s *Store) Create(deviceId string, remoteDir string, files []File) (Session, error) {
+	if err := validateFiles(remoteDir, files); err != nil {
+		return Session{}, err
+	}
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return Session{}, err
+	}
+	now := time.Now()
+	sess := Session{
+		ID:        hex.EncodeToString(b[:]),
+		DeviceID:  deviceId,
+		RemoteDir: remoteDir,
+		Files:     make([]File, len(files)),
+		Created:   now,
+		Updated:   now,
+	}
+	for i, f := range files {
+		sess.Files[i] = File{Path: f.Path, Size: f.Size, ModTime: f.ModTime}
+	}
+	if err := os.MkdirAll(s.sessionDir(sess.ID), 0700); err != nil {
+		return Session{}, fmt.Errorf("upload: creating session: %w", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e := &entry{session: sess, writing: make(map[int]bool)}
+	s.sessions[sess.ID] = e
+	if err := s.saveLocked(e); err != nil {
+		delete(s.sessions, sess.ID)
+		os.RemoveAll(s.sessionDir(sess.ID))
+		return Session{}, err
+	}
+	return copySession(sess), nil
+}
+
+// copySession 返回不与 Store 共享 Files 的副本
+func copySession(sess Session) Session {
+	sess.Files = append([]File(nil), sess.Files...)
+	return sess
+}
+
+// Get 返回会话的当前状态
+func (s *Store) Get(id string) (Session, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, ok := s.sessions[id]
+	if !ok {
+		return Session{}, fmt.Errorf("%w: %s", ErrNotFound, id)
+	}
+	return copySession(e.session), nil
+}
+
+// lookupFileLocked 返回会话与文件，调用者必须持有 s.mu
+func (s *Store) lookupFileLocked(id string, index int) (*entry, *File, error) {
+	e, ok := s.sessions[id]
+	if !ok {
+		return nil, nil, fmt.Errorf("%w: %s", ErrNotFound, id)
+	}
+	if index < 0 || index >= len(e.session.Files) {
+		return nil, nil, fmt.Errorf("%w: %s has no file %d", ErrNotFound, id, index)
+	}
+	return e, &e.session.Files[index], nil
+}
+
+// WriteChunk 从 offset 处追加第 index 个文件的数据，返回之后已接收的字节数
+// offset 必须等于已接收的字节数，否则返回 ErrOffsetMismatch 以及当前的字节数，客户端应从该位置重新发送
+// 读取 r 中途失败 (例如客户端断开) 时已写入的数据仍然保留，下一次从新的位置继续
+func (s *Store) WriteChunk(id string, index int, offset int64, r io.Reader) (int64, error) {
+	s.mu.Lock()
+	e, f, err := s.lookupFileLocked(id, index)
+	if err != nil {
+		s.mu.Unlock()
+		return 0, err
+	}
+	current, size := f.Offset, f.Size
+	switch {
+	case e.finalizing || e.writing[index]:
+		s.mu.Unlock()
+		return current, fmt.Errorf("%w: %s file %d", ErrBusy, id, index)
+	case offset != current:
+		s.mu.Unlock()
+		return current, fmt.Errorf("%w: got %d, have %d", ErrOffsetMismatch, offset, current)
+	}
+	e.writing[index] = true
+	s.mu.Unlock()
+
+	n, writeErr := s.appendPart(id, index, current, size-current, r)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(e.writing, index)
+	f.Offset += n
+	e.session.Updated = time.Now()
+	current = f.Offset
+	if _, ok := s.sessions[id]; !ok {
+		// 会话在接收期间被取消
+		os.RemoveAll(s.sessionDir(id))
+		return current, fmt.Errorf("%w: %s", ErrNotFound, id)
+	}
+	if err := s.saveLocked(e); err != nil && writeErr == nil {
+		writeErr = err
+	}
+	return current, writeErr
+}
+
+// appendPart 将 r 中最多 remaining 字节追加到数据文件，r 中还有更多数据时丢弃整个数据块并返回错误
+func (s *Store) appendPart(id string, index int, offset int64, remaining int64, r io.Reader) (int64, error) {
+	part, err := os.OpenFile(s.partPath(id, index), os.O_WRONLY|os.O_CREATE, 0600)
+	if err != nil {
+		return 0, fmt.Errorf("upload: opening part: %w", err)
+	}
+	defer part.Close()
+	// 丢弃上一次写入失败时可能残留在末尾的数据
+	if err := part.Truncate(offset); err != nil {
+		return 0, fmt.Errorf("upload: truncating part: %w", err)
+	}
+	if _, err := part.Seek(offset, io.SeekStart); err != nil {
+		return 0, fmt.Errorf("upload: seeking part: %w", err)
+	}
+	n, err := io.Copy(part, io.LimitReader(r, remaining))
+	if err != nil {
+		return n, err
+	}
+	if n == remaining {
+		var extra [1]byte
+		if m, _ := r.Read(extra[:]); m > 0 {
+			// 整个数据块作废，客户端可以从原来的位置重新发送
+			if err := part.Truncate(offset); err != nil {
+				return n, fmt.Errorf("upload: truncating part: %w", err)
+			}
+			return 0, fmt.Errorf("%w: chunk exceeds declared file size %d", adb.ErrInvalidArgument, offset+remaining)
+		}
+	}
+	return n, nil
+}
+
+// OpenPart 打开第 index 个文件已接收的数据
+func (s *Store) OpenPart(id string, index int) (*os.File, error) {
+	s.mu.Lock()
+	_, _, err := s.lookupFileLocked(id, index)
+	s.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(s.partPath(id, index))
+	if errors.Is(err, os.ErrNotExist) {
+		// 空文件不会收到任何数据块
+		return os.Open(os.DevNull)
+	}
+	return f, err
+}
+
+// StartFinalize 在所有文件接收完毕后锁定会话以推送到设备，推送结束后必须调用 release
+// 推送期间新的数据块、取消与另一次推送都会返回 ErrBusy
+func (s *Store) StartFinalize(id string) (Session, func(), error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, ok := s.sessions[id]
+	if !ok {
+		return Session{}, nil, fmt.Errorf("%w: %s", ErrNotFound, id)
+	}
+	if e.busy() {
+		return Session{}, nil, fmt.Errorf("%w: %s", ErrBusy, id)
+	}
+	if !e.session.Complete() {
+		received, total := e.session.Progress()
+		return Session{}, nil, fmt.Errorf("%w: received %d of %d bytes", ErrIncomplete, received, total)
+	}
+	e.finalizing = true
+	release := func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		e.finalizing = false
+	}
+	return copySession(e.session), release, nil
+}
+
+// MarkPushed 记录第 index 个文件已经推送到设备
+func (s *Store) MarkPushed(id string, index int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, f, err := s.lookupFileLocked(id, index)
+	if err != nil {
+		return err
+	}
+	f.Pushed = true
+	e.session.Updated = time.Now()
+	return s.saveLocked(e)
+}
+
+// Remove 删除会话及其数据，force 为 false 时正在接收数据或推送的会话返回 ErrBusy
+// 推送完成后调用者持有 StartFinalize 的锁，此时应传入 force
+func (s *Store) Remove(id string, force bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, ok := s.sessions[id]
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrNotFound, id)
+	}
+	if !force && e.busy() {
+		return fmt.Errorf("%w: %s", ErrBusy, id)
+	}
+	delete(s.sessions, id)
+	if err := os.RemoveAll(s.sessionDir(id)); err != nil {
+		return fmt.Errorf("upload: removing %s: %w", id, err)
+	}
+	return nil
+}
+
+// Expire 删除超过 maxAge 没有任何进展的会话，返回删除的数量
+func (s *Store) Expire(maxAge time.Duration) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	removed := 0
+	for id, e := range s.sessions {
+		if e.busy() || time.Since(e.session.Updated) < maxAge {
+			continue
+		}
+		delete(s.sessions, id)
+		if err := os.RemoveAll(s.sessionDir(id)); err != nil {
+			log.Printf("upload: Failed to remove expired session %s: %v", id, err)
+		}
+		removed++
+	}
+	return removed
+}
+
+// RunCleanup 定期删除过期的会话，直到 ctx 结束；maxAge <= 0 时不清理
+func (s *Store) RunCleanup(ctx context.Context, maxAge time.Duration) {
+	if maxAge <= 0 {
+		return
+	}
+	ticker := time.NewTicker(min(cleanupInterval, maxAge))
+	defer ticker.Stop()
+	for {
+		if n := s.Expire(maxAge); n > 0 {
+			log.Printf("upload: Removed %d expired upload(s)", n)
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// saveLocked 先写入临时文件再重命名，避免进程中断时留下损坏的 JSON
+func (s *Store) saveLocked(e *entry) error {
+	data, err := json.MarshalIndent(e.session, "", "  ")
+	if err != nil {
+		return err
+	}
+	dst := filepath.Join(s.sessionDir(e.session.ID), "session.json")
+	tmp := dst + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return fmt.Errorf("upload: saving session: %w", err)
+	}
+	if err := os.Rename(tmp, dst); err != nil {
+		return fmt.Errorf("upload: saving session: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,198 @@
+package upload_test
+
+import (
+	"errors"
+	"fishyinhe/backend/internal/adb"
+	"fishyinhe/backend/internal/upload"
+	"io"
+	"strings"
+	"testing"
+)
+
+func newTestSession(t *testing.T, s *upload.Store) upload.Session {
+	t.Helper()
+	sess, err := s.Create("emu-1", "/sdcard/Download", []upload.File{
+		{Path: "a.txt", Size: 10},
+		{Path: "photos/b.jpg", Size: 4},
+		{Path: "empty", Size: 0},
+	})
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	return sess
+}
+
+func readPart(t *testing.T, s *upload.Store, id string, index int) string {
+	t.Helper()
+	f, err := s.OpenPart(id, index)
+	if err != nil {
+		t.Fatalf("OpenPart(%d): %v", index, err)
+	}
+	defer f.Close()
+	data, err := io.ReadAll(f)
+	if err != nil {
+		t.Fatalf("reading part %d: %v", index, err)
+	}
+	return string(data)
+}
+
+func TestWriteChunkOffsetMismatch(t *testing.T) {
+	s := upload.NewStore(t.TempDir())
+	sess := newTestSession(t, s)
+
+	if n, err := s.WriteChunk(sess.ID, 0, 0, strings.NewReader("hello")); err != nil || n != 5 {
+		t.Fatalf("WriteChunk = %d, %v, want 5", n, err)
+	}
+	// 重发已经接收的数据块或跳过一段数据都会返回当前位置
+	for _, offset := range []int64{0, 7} {
+		n, err := s.WriteChunk(sess.ID, 0, offset, strings.NewReader("world"))
+		if !errors.Is(err, upload.ErrOffsetMismatch) {
+			t.Errorf("WriteChunk at %d = %v, want ErrOffsetMismatch", offset, err)
+		}
+		if n != 5 {
+			t.Errorf("WriteChunk at %d reported offset %d, want 5", offset, n)
+		}
+	}
+	if n, err := s.WriteChunk(sess.ID, 0, 5, strings.NewReader("world")); err != nil || n != 10 {
+		t.Fatalf("WriteChunk = %d, %v, want 10", n, err)
+	}
+	if got := readPart(t, s, sess.ID, 0); got != "helloworld" {
+		t.Errorf("part = %q, want %q", got, "helloworld")
+	}
+	if _, err := s.WriteChunk(sess.ID, 3, 0, strings.NewReader("x")); !errors.Is(err, upload.ErrNotFound) {
+		t.Errorf("WriteChunk on a missing file = %v, want ErrNotFound", err)
+	}
+}
+
+func TestWriteChunkOversized(t *testing.T) {
+	s := upload.NewStore(t.TempDir())
+	sess := newTestSession(t, s)
+
+	if _, err := s.WriteChunk(sess.ID, 1, 0, strings.NewReader("ab")); err != nil {
+		t.Fatalf("WriteChunk: %v", err)
+	}
+	n, err := s.WriteChunk(sess.ID, 1, 2, strings.NewReader("cde"))
+	if !errors.Is(err, adb.ErrInvalidArgument) {
+		t.Fatalf("WriteChunk past the declared size = %v, want ErrInvalidArgument", err)
+	}
+	if n != 2 {
+		t.Errorf("offset after the rejected chunk = %d, want 2", n)
+	}
+	if got := readPart(t, s, sess.ID, 1); got != "ab" {
+		t.Errorf("part = %q, want the rejected chunk discarded", got)
+	}
+	// 被拒绝的数据块可以按正确的长度重新发送
+	if n, err := s.WriteChunk(sess.ID, 1, 2, strings.NewReader("cd")); err != nil || n != 4 {
+		t.Fatalf("WriteChunk = %d, %v, want 4", n, err)
+	}
+}
+
+func TestStoreResumeAfterLoad(t *testing.T) {
+	dir := t.TempDir()
+	s := upload.NewStore(dir)
+	sess := newTestSession(t, s)
+	if _, err := s.WriteChunk(sess.ID, 0, 0, strings.NewReader("hello")); err != nil {
+		t.Fatalf("WriteChunk: %v", err)
+	}
+
+	// 后端重启
+	s = upload.NewStore(dir)
+	if err := s.Load(); err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	loaded, err := s.Get(sess.ID)
+	if err != nil {
+		t.Fatalf("Get after Load: %v", err)
+	}
+	if loaded.DeviceID != "emu-1" || loaded.RemoteDir != "/sdcard/Download" || len(loaded.Files) != 3 {
+		t.Fatalf("loaded session = %+v", loaded)
+	}
+	if received, total := loaded.Progress(); received != 5 || total != 14 {
+		t.Errorf("Progress = %d/%d, want 5/14", received, total)
+	}
+	if got := loaded.RemotePath(1); got != "/sdcard/Download/photos/b.jpg" {
+		t.Errorf("RemotePath(1) = %q", got)
+	}
+	if _, err := s.WriteChunk(sess.ID, 0, 0, strings.NewReader("hello")); !errors.Is(err, upload.ErrOffsetMismatch) {
+		t.Errorf("WriteChunk from the start after Load = %v, want ErrOffsetMismatch", err)
+	}
+	if n, err := s.WriteChunk(sess.ID, 0, 5, strings.NewReader("world")); err != nil || n != 10 {
+		t.Fatalf("WriteChunk after Load = %d, %v, want 10", n, err)
+	}
+	if got := readPart(t, s, sess.ID, 0); got != "helloworld" {
+		t.Errorf("part = %q, want %q", got, "helloworld")
+	}
+}
+
+func TestStartFinalize(t *testing.T) {
+	s := upload.NewStore(t.TempDir())
+	sess := newTestSession(t, s)
+	if _, err := s.WriteChunk(sess.ID, 0, 0, strings.NewReader("helloworld")); err != nil {
+		t.Fatalf("WriteChunk: %v", err)
+	}
+	if _, _, err := s.StartFinalize(sess.ID); !errors.Is(err, upload.ErrIncomplete) {
+		t.Fatalf("StartFinalize on an incomplete upload = %v, want ErrIncomplete", err)
+	}
+	if _, err := s.WriteChunk(sess.ID, 1, 0, strings.NewReader("abcd")); err != nil {
+		t.Fatalf("WriteChunk: %v", err)
+	}
+
+	final, release, err := s.StartFinalize(sess.ID)
+	if err != nil {
+		t.Fatalf("StartFinalize: %v", err)
+	}
+	if !final.Complete() {
+		t.Errorf("finalized session is not complete: %+v", final)
+	}
+	if got := readPart(t, s, sess.ID, 2); got != "" {
+		t.Errorf("empty file part = %q", got)
+	}
+	if _, _, err := s.StartFinalize(sess.ID); !errors.Is(err, upload.ErrBusy) {
+		t.Errorf("second StartFinalize = %v, want ErrBusy", err)
+	}
+	if err := s.Remove(sess.ID, false); !errors.Is(err, upload.ErrBusy) {
+		t.Errorf("Remove while finalizing = %v, want ErrBusy", err)
+	}
+	if err := s.MarkPushed(sess.ID, 0); err != nil {
+		t.Fatalf("MarkPushed: %v", err)
+	}
+	release()
+
+	// 推送失败后重试时已推送的文件保持标记
+	final, release, err = s.StartFinalize(sess.ID)
+	if err != nil {
+		t.Fatalf("StartFinalize after release: %v", err)
+	}
+	if !final.Files[0].Pushed || final.Files[1].Pushed {
+		t.Errorf("Pushed = %v, %v, want true, false", final.Files[0].Pushed, final.Files[1].Pushed)
+	}
+	if err := s.Remove(sess.ID, true); err != nil {
+		t.Fatalf("Remove: %v", err)
+	}
+	release()
+	if _, err := s.Get(sess.ID); !errors.Is(err, upload.ErrNotFound) {
+		t.Errorf("Get after Remove = %v, want ErrNotFound", err)
+	}
+}
+
+func TestCreateValidation(t *testing.T) {
+	s := upload.NewStore(t.TempDir())
+	tests := []struct {
+		name  string
+		dir   string
+		files []upload.File
+	}{
+		{"no files", "/sdcard", nil},
+		{"absolute path", "/sdcard", []upload.File{{Path: "/etc/passwd", Size: 1}}},
+		{"parent segment", "/sdcard", []upload.File{{Path: "../data/x", Size: 1}}},
+		{"unclean path", "/sdcard", []upload.File{{Path: "a//b", Size: 1}}},
+		{"negative size", "/sdcard", []upload.File{{Path: "a", Size: -1}}},
+		{"duplicate path", "/sdcard", []upload.File{{Path: "a", Size: 1}, {Path: "a", Size: 2}}},
+		{"relative remote dir", "sdcard", []upload.File{{Path: "a", Size: 1}}},
+	}
+	for _, tt := range tests {
+		if _, err := s.Create("emu-1", tt.dir, tt.files); !errors.Is(err, adb.ErrInvalidArgument) {
+			t.Errorf("%s: Create = %v, want ErrInvalidArgument", tt.name, err)
+		}
+	}
+}